@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/RakhmanovTimur/bookings/internal/driver"
+	"github.com/RakhmanovTimur/bookings/internal/health"
+)
+
+// readinessChecks returns what has to work for the application to serve requests
+func readinessChecks(db *driver.DB) []health.Check {
+	return []health.Check{
+		{Name: "database", Run: db.Ping},
+		{Name: "templates", Run: templatesLoaded},
+		{Name: "mail", Run: pingMailServer},
+	}
+}
+
+// templatesLoaded checks the template cache has been built
+func templatesLoaded(ctx context.Context) error {
+	if len(app.TemplateCache) == 0 {
+		return errors.New("template cache is empty")
+	}
+	return nil
+}
@@ -4,39 +4,52 @@ import (
 	"encoding/gob"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"time"
+	_ "time/tzdata"
 
+	"github.com/RakhmanovTimur/bookings/internal/channels"
 	"github.com/RakhmanovTimur/bookings/internal/config"
 	"github.com/RakhmanovTimur/bookings/internal/driver"
 	"github.com/RakhmanovTimur/bookings/internal/handlers"
 	"github.com/RakhmanovTimur/bookings/internal/helpers"
+	"github.com/RakhmanovTimur/bookings/internal/metrics"
 	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/RakhmanovTimur/bookings/internal/payments"
 	"github.com/RakhmanovTimur/bookings/internal/render"
+	"github.com/RakhmanovTimur/bookings/internal/ttlcache"
 	"github.com/alexedwards/scs/v2"
 )
 
 const portNumber = ":8080"
 
+// metricsAddr is the address metrics are served on, apart from the site so they aren't public
+var metricsAddr string
+
 var app config.AppConfig
 var session *scs.SessionManager
-var infoLog *log.Logger
-var errLog *log.Logger
 
 // main is the main application function
 func main() {
 	db, err := run()
 	if err != nil {
-		log.Fatal(err)
+		app.Logger.Error("starting application", "error", err)
+		os.Exit(1)
 	}
 	defer db.SQL.Close()
 
 	// Close mail channel when application stops
 	defer close(app.MailChan)
 	listenForMail()
-	fmt.Println(fmt.Sprintf("Starting application on port %s", portNumber))
+	defer close(app.WebhookChan)
+	listenForWebhooks()
+	retryWebhooks()
+	sweepHolds()
+	syncChannels()
+	serveMetrics()
+	app.Logger.Info("starting application", "port", portNumber)
 
 	srv := &http.Server{
 		Addr:    portNumber,
@@ -44,7 +57,8 @@ func main() {
 	}
 	err = srv.ListenAndServe()
 	if err != nil {
-		log.Fatal(err)
+		app.Logger.Error("serving", "error", err)
+		os.Exit(1)
 	}
 }
 
@@ -55,6 +69,7 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
+	gob.Register(models.GroupBooking{})
 
 	// read flags
 	inProduction := flag.Bool("production", true, "Application is in production")
@@ -65,26 +80,43 @@ func run() (*driver.DB, error) {
 	dbPass := flag.String("dbpassword", "", "Database password")
 	dbPort := flag.String("dbport", "5432", "Database port")
 	dbSSL := flag.String("dbssl", "disable", "Database ssl settings (disable, prefer, require)")
+	paymentSecret := flag.String("paymentsecret", "", "Secret payment gateway webhooks are signed with")
+	logLevel := flag.String("loglevel", "info", "Lowest level logged (debug, info, warn, error)")
+	flag.StringVar(&metricsAddr, "metricsaddr", "127.0.0.1:9091", "Address metrics are served on, empty to not serve them")
 
 	flag.Parse()
 	if *dbName == "" || *dbUser == "" {
 		fmt.Println("Missing required flags")
 		os.Exit(1)
 	}
-	// Set up the mail channel
-	mailChan := make(chan models.MailData)
+
+	var level slog.Level
+	err := level.UnmarshalText([]byte(*logLevel))
+	if err != nil {
+		fmt.Println("Invalid log level")
+		os.Exit(1)
+	}
+
+	// log as JSON, and send what packages log with the standard logger the same way
+	app.Logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+	slog.SetDefault(app.Logger)
+
+	app.Metrics = metrics.New()
+
+	// Set up the mail channel, buffered so requests don't wait on the mail server
+	mailChan := make(chan models.MailData, 100)
 	app.MailChan = mailChan
+	app.Metrics.WatchMailQueue(func() int { return len(app.MailChan) })
+
+	// Set up the webhook channel, buffered so a burst of events is still sent right away rather
+	// than left to the retries
+	webhookChan := make(chan models.WebhookEvent, 100)
+	app.WebhookChan = webhookChan
 
 	// change this to true when in production
 	app.InProduction = *inProduction
 	app.UseCache = *cache
 
-	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errLog
-
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
 	session.Cookie.Persist = true
@@ -93,23 +125,32 @@ func run() (*driver.DB, error) {
 
 	app.Session = session
 
+	app.AvailabilityCache = ttlcache.New(10 * time.Minute)
+
+	// payments go through the local fake gateway until a real provider is configured
+	app.Payments = payments.NewFake(*paymentSecret)
+
+	// booking platforms rooms are also sold on are added here as they are integrated
+	app.Channels = []channels.Channel{}
+
 	// Connect to database
-	log.Println("Connecting to database...")
+	app.Logger.Info("connecting to database")
 	connectionSettings := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s",
 		*dbHost, *dbPort, *dbName, *dbUser, *dbPass, *dbSSL)
 	db, err := driver.ConnectSQL(connectionSettings)
 	if err != nil {
-		log.Fatal("Cannot connect to database! Dying...")
+		return nil, fmt.Errorf("connecting to database: %w", err)
 	}
-	log.Println("Connected to database")
+	app.Logger.Info("connected to database")
+	app.Metrics.WatchDB(db.SQL.Stats)
 
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
-		log.Fatal(err)
 		return nil, err
 	}
 
 	app.TemplateCache = tc
+	app.Readiness = readinessChecks(db)
 
 	repo := handlers.NewRepo(&app, db)
 	handlers.NewHandlers(repo)
@@ -118,3 +159,23 @@ func run() (*driver.DB, error) {
 	helpers.NewHelpers(&app)
 	return db, err
 }
+
+// sweepHolds releases the holds of guests who didn't finish booking in time, checking every
+// minute until the application stops
+func sweepHolds() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			handlers.Repo.ReleaseExpiredHolds()
+		}
+	}()
+}
+
+// syncChannels syncs the properties with the booking platforms their rooms are also sold on,
+// every five minutes until the application stops
+func syncChannels() {
+	go func() {
+		for range time.Tick(5 * time.Minute) {
+			handlers.Repo.SyncChannels()
+		}
+	}()
+}
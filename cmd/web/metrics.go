@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+)
+
+// serveMetrics serves the metrics of the application to Prometheus at /metrics on metricsAddr,
+// which is kept off the public port and away from the session and CSRF middleware
+func serveMetrics() {
+	if metricsAddr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", app.Metrics.Registry.Handler())
+
+	go func() {
+		app.Logger.Info("serving metrics", "addr", metricsAddr)
+		err := http.ListenAndServe(metricsAddr, mux)
+		if err != nil {
+			app.Logger.Error("serving metrics", "addr", metricsAddr, "error", err)
+		}
+	}()
+}
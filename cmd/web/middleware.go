@@ -1,23 +1,129 @@
 package main
 
 import (
-	"fmt"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/RakhmanovTimur/bookings/internal/handlers"
 	"github.com/RakhmanovTimur/bookings/internal/helpers"
+	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 	"github.com/justinas/nosurf"
 )
 
-func WriteToConsole(next http.Handler) http.Handler {
+// Health answers the health and readiness checks of load balancers ahead of the other
+// middleware, so they don't load a session, need a CSRF token or get logged every few seconds
+func Health(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("Hit the page")
+		if r.Method == "GET" || r.Method == "HEAD" {
+			switch r.URL.Path {
+			case "/healthz":
+				handlers.Repo.Healthz(w, r)
+				return
+			case "/readyz":
+				handlers.Repo.Readyz(w, r)
+				return
+			}
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-// NoSurf adds CSRF protection to all POST requests
+// RequestIDHeader is the header the id of a request is read from and sent back in
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID matches the request ids a proxy in front of the application may have set
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID gives every request an id, the one a proxy in front of the application set if there
+// is one, which is sent back in the response and tags what is logged while handling it
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(helpers.WithRequestID(r.Context(), id)))
+	})
+}
+
+// newRequestID returns a random request id
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AccessLog logs every request once it has been handled, with the status it was answered with
+// and how long that took
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		// PropertyLoad strips the property prefix off the path, so it is kept as asked for
+		path := r.URL.Path
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		helpers.Logger(r).Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
+
+// RecordMetrics counts every request and how long it took by the pattern of the route that
+// handled it, so ids in paths don't make a series each
+func RecordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		app.Metrics.HTTPRequests.Inc(r.Method, route, strconv.Itoa(status))
+		app.Metrics.HTTPRequestDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+// NoSurf adds CSRF protection to all POST requests, except webhooks which are signed instead
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
+	csrfHandler.ExemptPath("/payments/webhook")
 
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
@@ -43,3 +149,100 @@ func Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// GuestAuth lets only guests logged in to their account through
+func GuestAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if helpers.GuestAccountID(r) == 0 {
+			session.Put(r.Context(), "error", "Log in first!")
+			http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PropertyLoad scopes the request to a property, picked by the /p/{slug} path prefix,
+// the hostname, the property last visited in this session or the default property, in that order
+func PropertyLoad(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		db := handlers.Repo.DB
+
+		var property models.Property
+		var err error
+
+		if strings.HasPrefix(r.URL.Path, "/p/") {
+			exploded := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/p/"), "/", 2)
+			property, err = db.GetPropertyBySlug(exploded[0])
+			if err != nil {
+				helpers.ClientError(w, r, http.StatusNotFound)
+				return
+			}
+
+			// strip the prefix so the rest of the router sees the usual paths
+			prefix := "/p/" + exploded[0]
+			r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+			if r.URL.Path == "" {
+				r.URL.Path = "/"
+			}
+			r.URL.RawPath = ""
+			r.RequestURI = strings.TrimPrefix(r.RequestURI, prefix)
+			session.Put(r.Context(), "property_id", property.ID)
+		} else {
+			hostname, _, splitErr := net.SplitHostPort(r.Host)
+			if splitErr != nil {
+				hostname = r.Host
+			}
+
+			property, err = db.GetPropertyByHostname(hostname)
+			if err != nil && session.Exists(r.Context(), "property_id") {
+				property, err = db.GetPropertyByID(session.GetInt(r.Context(), "property_id"))
+			}
+			if err != nil {
+				property, err = db.GetDefaultProperty()
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				helpers.ClientError(w, r, http.StatusNotFound)
+				return
+			}
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.WithProperty(r.Context(), property)))
+	})
+}
+
+// AdminProperty scopes admin requests to the property the logged in user has selected,
+// falling back to the first one they have been granted access to
+func AdminProperty(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := session.GetInt(r.Context(), "user_id")
+
+		properties, err := handlers.Repo.DB.GetPropertiesForUser(userID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		if len(properties) == 0 {
+			session.Put(r.Context(), "error", "You don't have access to any property")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		property := properties[0]
+		selected := session.GetInt(r.Context(), "admin_property_id")
+		for _, p := range properties {
+			if p.ID == selected {
+				property = p
+			}
+		}
+
+		ctx := helpers.WithProperty(r.Context(), property)
+		ctx = helpers.WithUserProperties(ctx, properties)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
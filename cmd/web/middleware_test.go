@@ -1,9 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/RakhmanovTimur/bookings/internal/helpers"
+	"github.com/go-chi/chi"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -29,3 +36,133 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.Handler but is %T", v))
 	}
 }
+
+func TestPropertyLoad(t *testing.T) {
+	var myH myHandler
+	h := PropertyLoad(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http.Handler but is %T", v))
+	}
+}
+
+func TestAdminProperty(t *testing.T) {
+	var myH myHandler
+	h := AdminProperty(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http.Handler but is %T", v))
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var id string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id = helpers.RequestID(r)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if id == "" || rr.Header().Get(RequestIDHeader) != id {
+		t.Errorf("expected the request id %q to be sent back, got %q", id, rr.Header().Get(RequestIDHeader))
+	}
+
+	// an id set by a proxy is kept, unless it isn't one
+	for header, kept := range map[string]bool{"abc-123": true, "not an id": false} {
+		req = httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(RequestIDHeader, header)
+		rr = httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if (id == header) != kept {
+			t.Errorf("failed %q: got request id %q", header, id)
+		}
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := app.Logger
+	app.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+	defer func() { app.Logger = logger }()
+
+	h := RequestID(AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = "/stripped"
+		w.WriteHeader(http.StatusTeapot)
+	})))
+
+	req := httptest.NewRequest("GET", "/p/seaside/about", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	var line map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line["msg"] != "request" || line["status"] != float64(http.StatusTeapot) || line["path"] != "/p/seaside/about" ||
+		line["request_id"] != rr.Header().Get(RequestIDHeader) {
+		t.Errorf("unexpected access log %s", buf.String())
+	}
+	if _, ok := line["duration_ms"]; !ok {
+		t.Errorf("expected the access log to have the duration, got %s", buf.String())
+	}
+}
+
+func TestRecordMetrics(t *testing.T) {
+	mux := chi.NewRouter()
+	mux.Use(RecordMetrics)
+	mux.Get("/rooms/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, path := range []string{"/rooms/1", "/rooms/2", "/nowhere"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if n := app.Metrics.HTTPRequests.Value("GET", "/rooms/{id}", "200"); n != 2 {
+		t.Errorf("expected 2 requests counted by their route pattern, got %v", n)
+	}
+	if n := app.Metrics.HTTPRequests.Value("GET", "unmatched", "404"); n != 1 {
+		t.Errorf("expected 1 unmatched request, got %v", n)
+	}
+	if n := app.Metrics.HTTPRequestDuration.Count("GET", "/rooms/{id}"); n != 2 {
+		t.Errorf("expected 2 durations observed, got %d", n)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	var passed bool
+	h := Health(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passed = true
+	}))
+
+	tests := []struct {
+		method string
+		path   string
+		passed bool
+	}{
+		{"GET", "/healthz", false},
+		{"HEAD", "/healthz", false},
+		{"GET", "/readyz", false},
+		{"POST", "/healthz", true},
+		{"GET", "/about", true},
+	}
+
+	for _, e := range tests {
+		passed = false
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(e.method, e.path, nil))
+
+		if passed != e.passed {
+			t.Errorf("failed %s %s: expected the request to be passed on to be %v", e.method, e.path, e.passed)
+		}
+		if !e.passed && (rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json") {
+			t.Errorf("failed %s %s: expected a JSON ok, got %d %s", e.method, e.path, rr.Code, rr.Header().Get("Content-Type"))
+		}
+	}
+}
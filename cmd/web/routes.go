@@ -12,9 +12,14 @@ import (
 func routes(app *config.AppConfig) http.Handler {
 	mux := chi.NewRouter()
 
+	mux.Use(Health)
+	mux.Use(RequestID)
+	mux.Use(AccessLog)
+	mux.Use(RecordMetrics)
 	mux.Use(middleware.Recoverer)
 	mux.Use(NoSurf)
 	mux.Use(SessionLoad)
+	mux.Use(PropertyLoad)
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
 	mux.Get("/traveler-room", handlers.Repo.TravelerRoom)
@@ -22,7 +27,9 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
+	mux.Get("/search-availability/flexible", handlers.Repo.FlexibleAvailability)
 	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+	mux.Get("/rooms/{id}/availability", handlers.Repo.RoomAvailabilityJSON)
 	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
 	mux.Get("/book-room", handlers.Repo.BookRoom)
 
@@ -31,26 +38,96 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/make-reservation", handlers.Repo.MakeReservation)
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+	mux.Get("/checkout", handlers.Repo.Checkout)
+	mux.Post("/checkout", handlers.Repo.PostCheckout)
+	mux.Post("/payments/webhook", handlers.Repo.PaymentWebhook)
+	mux.Get("/waitlist", handlers.Repo.Waitlist)
+	mux.Post("/waitlist", handlers.Repo.PostWaitlist)
+	mux.Get("/waitlist/{token}", handlers.Repo.WaitlistOffer)
+	mux.Get("/group", handlers.Repo.Group)
+	mux.Post("/group", handlers.Repo.PostGroup)
+	mux.Post("/group/add", handlers.Repo.PostGroupAdd)
+	mux.Post("/group/remove", handlers.Repo.PostGroupRemove)
+	mux.Get("/group/checkout", handlers.Repo.GroupCheckout)
+	mux.Post("/group/checkout", handlers.Repo.PostGroupCheckout)
+	mux.Get("/group/summary", handlers.Repo.GroupSummary)
+	mux.Get("/reservations/{token}/cancel", handlers.Repo.CancelReservation)
+	mux.Post("/reservations/{token}/cancel", handlers.Repo.PostCancelReservation)
 
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
 
+	mux.Get("/account/login", handlers.Repo.AccountLogin)
+	mux.Post("/account/login", handlers.Repo.PostAccountLogin)
+	mux.Post("/account/login-link", handlers.Repo.PostAccountLoginLink)
+	mux.Get("/account/login/{token}", handlers.Repo.AccountLoginWithToken)
+	mux.Get("/account/register", handlers.Repo.AccountRegister)
+	mux.Post("/account/register", handlers.Repo.PostAccountRegister)
+	mux.With(GuestAuth).Get("/account", handlers.Repo.Account)
+	mux.With(GuestAuth).Post("/account", handlers.Repo.PostAccount)
+
 	mux.Route("/admin", func(mux chi.Router) {
-		// mux.Use(Auth)
+		mux.Use(Auth)
+		mux.Use(AdminProperty)
 
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+		mux.Get("/properties/{id}/select", handlers.Repo.AdminSelectProperty)
 		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
 		mux.Get("/reservations-calender", handlers.Repo.AdminReservationsCalender)
 		mux.Post("/reservations-calender", handlers.Repo.AdminPostReservationsCalender)
+		mux.Get("/reservations-timeline", handlers.Repo.AdminReservationsTimeline)
+		mux.Get("/reservations-timeline/json", handlers.Repo.AdminRestrictionsJSON)
+		mux.Post("/reservations-timeline/reservation", handlers.Repo.AdminMoveReservationJSON)
+		mux.Post("/reservations-timeline/block", handlers.Repo.AdminSaveBlockJSON)
+		mux.Post("/reservations-timeline/block/delete", handlers.Repo.AdminDeleteBlockJSON)
+		mux.Get("/stay-rules", handlers.Repo.AdminStayRules)
+		mux.Post("/stay-rules", handlers.Repo.AdminPostStayRules)
+		mux.Get("/stay-rules/{id}/delete", handlers.Repo.AdminDeleteStayRule)
+		mux.Get("/cancellation-policies", handlers.Repo.AdminCancellationPolicies)
+		mux.Post("/cancellation-policies", handlers.Repo.AdminPostCancellationPolicies)
+		mux.Post("/cancellation-policies/rooms", handlers.Repo.AdminPostRoomCancellationPolicy)
+		mux.Get("/cancellation-policies/{id}/delete", handlers.Repo.AdminDeleteCancellationPolicy)
+		mux.Get("/taxes", handlers.Repo.AdminTaxes)
+		mux.Post("/taxes", handlers.Repo.AdminPostTaxes)
+		mux.Get("/taxes/{id}/delete", handlers.Repo.AdminDeleteTax)
+		mux.Get("/promo-codes", handlers.Repo.AdminPromoCodes)
+		mux.Post("/promo-codes", handlers.Repo.AdminPostPromoCodes)
+		mux.Get("/promo-codes/{id}/delete", handlers.Repo.AdminDeletePromoCode)
+		mux.Get("/extras", handlers.Repo.AdminExtras)
+		mux.Post("/extras", handlers.Repo.AdminPostExtras)
+		mux.Get("/extras/{id}/delete", handlers.Repo.AdminDeleteExtra)
+		mux.Get("/reservations-export", handlers.Repo.AdminExportReservations)
+		mux.Get("/waitlist", handlers.Repo.AdminWaitlist)
+		mux.Get("/waitlist/{id}/offer", handlers.Repo.AdminOfferWaitlist)
+		mux.Get("/waitlist/{id}/remove", handlers.Repo.AdminRemoveWaitlist)
+		mux.Get("/guests", handlers.Repo.AdminGuests)
+		mux.Get("/guests/{id}", handlers.Repo.AdminShowGuest)
+		mux.Post("/guests/{id}", handlers.Repo.AdminPostGuest)
+		mux.Post("/guests/{id}/merge", handlers.Repo.AdminMergeGuest)
+		mux.Get("/group-bookings", handlers.Repo.AdminGroupBookings)
+		mux.Get("/group-bookings/{id}", handlers.Repo.AdminShowGroupBooking)
+		mux.Get("/webhooks", handlers.Repo.AdminWebhooks)
+		mux.Post("/webhooks", handlers.Repo.AdminPostWebhooks)
+		mux.Get("/webhooks/{id}", handlers.Repo.AdminShowWebhook)
+		mux.Post("/webhooks/{id}", handlers.Repo.AdminPostWebhook)
+		mux.Get("/webhooks/{id}/delete", handlers.Repo.AdminDeleteWebhook)
+		mux.Get("/webhooks/deliveries/{id}/replay", handlers.Repo.AdminReplayWebhookDelivery)
+		mux.Get("/channels", handlers.Repo.AdminChannels)
+		mux.Post("/channels/{channel}/mappings", handlers.Repo.AdminPostChannelMappings)
+		mux.Get("/channels/{channel}/sync", handlers.Repo.AdminSyncChannel)
 		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
 		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
+		mux.Get("/cancel-reservation/{src}/{id}/do", handlers.Repo.AdminCancelReservation)
 		mux.Get("/add-todo/{task}", handlers.Repo.AddToDo)
 		mux.Get("/delete-todo/{id}", handlers.Repo.DeleteToDo)
 
 		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}/show", handlers.Repo.AdminPostShowReservation)
+		mux.Get("/reservations/{src}/{id}/invoice", handlers.Repo.AdminReservationInvoice)
+		mux.Get("/reservations/{src}/{id}/invoice/send", handlers.Repo.AdminSendInvoice)
+		mux.Post("/reservations/{src}/{id}/extras", handlers.Repo.AdminPostReservationExtras)
 	})
 
 	fileServer := http.FileServer(http.Dir("./static/"))
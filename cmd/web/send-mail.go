@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"net"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,6 +14,12 @@ import (
 	mail "github.com/xhit/go-simple-mail"
 )
 
+// The mail server emails are sent through
+const (
+	mailHost = "localhost"
+	mailPort = 1025
+)
+
 func listenForMail() {
 	go func() {
 		for {
@@ -22,15 +31,15 @@ func listenForMail() {
 
 func sendMsg(m models.MailData) {
 	server := mail.NewSMTPClient()
-	server.Host = "localhost"
-	server.Port = 1025
+	server.Host = mailHost
+	server.Port = mailPort
 	server.KeepAlive = false
 	server.ConnectTimeout = 10 * time.Second
 	server.SendTimeout = 10 * time.Second
 
 	client, err := server.Connect()
 	if err != nil {
-		log.Println(err)
+		app.Logger.Error("connecting to mail server", "error", err)
 	}
 
 	email := mail.NewMSG()
@@ -40,7 +49,7 @@ func sendMsg(m models.MailData) {
 	} else {
 		data, err := ioutil.ReadFile(fmt.Sprintf("./email-templates/%s", m.Template))
 		if err != nil {
-			app.ErrorLog.Println(err)
+			app.Logger.Error("reading email template", "template", m.Template, "error", err)
 		}
 
 		mailTemplate := string(data)
@@ -48,10 +57,26 @@ func sendMsg(m models.MailData) {
 		email.SetBody(mail.TextHTML, msgToSend)
 	}
 
+	for _, a := range m.Attachments {
+		email.AddAttachmentBase64(base64.StdEncoding.EncodeToString(a.Data), a.Name)
+	}
+
 	err = email.Send(client)
 	if err != nil {
-		log.Println(err)
+		app.Metrics.MailFailures.Inc()
+		app.Logger.Error("sending mail", "to", m.To, "subject", m.Subject, "error", err)
 	} else {
-		log.Println("Mail sent!")
+		app.Metrics.MailSent.Inc()
+		app.Logger.Info("mail sent", "to", m.To, "subject", m.Subject)
+	}
+}
+
+// pingMailServer checks the mail server can be connected to
+func pingMailServer(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(mailHost, strconv.Itoa(mailPort)))
+	if err != nil {
+		return err
 	}
+	return conn.Close()
 }
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/handlers"
+)
+
+// listenForWebhooks delivers the events handlers emit to the webhook subscriptions that want them
+func listenForWebhooks() {
+	go func() {
+		for e := range app.WebhookChan {
+			handlers.Repo.DeliverWebhookEvent(e)
+		}
+	}()
+}
+
+// retryWebhooks attempts the webhook deliveries that failed again once they are due, checking
+// every minute until the application stops
+func retryWebhooks() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			handlers.Repo.RetryWebhookDeliveries()
+		}
+	}()
+}
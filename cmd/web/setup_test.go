@@ -1,12 +1,23 @@
 package main
 
 import (
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"testing"
+
+	"github.com/RakhmanovTimur/bookings/internal/handlers"
+	"github.com/RakhmanovTimur/bookings/internal/helpers"
+	"github.com/RakhmanovTimur/bookings/internal/metrics"
 )
 
 func TestMain(m *testing.M) {
+	app.Logger = slog.New(slog.NewJSONHandler(io.Discard, nil))
+	app.Metrics = metrics.New()
+	helpers.NewHelpers(&app)
+	handlers.NewHandlers(handlers.NewTestRepo(&app))
+
 	os.Exit(m.Run())
 }
 
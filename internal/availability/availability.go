@@ -0,0 +1,178 @@
+package availability
+
+import (
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/dates"
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+// Grid holds the number of free units per room and night over a range of nights
+type Grid struct {
+	Nights []time.Time
+	Rooms  []models.Room
+	free   map[int][]int
+}
+
+// Window is a stay of the requested length and the rooms free for all of it
+type Window struct {
+	StartDate time.Time
+	EndDate   time.Time
+	Rooms     []models.Room
+}
+
+// Segment is one part of a split stay
+type Segment struct {
+	Room      models.Room
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// Split is a stay spread over two rooms, moving on the first segment's end date
+type Split struct {
+	First  Segment
+	Second Segment
+}
+
+// NewGrid builds a grid for the nights from start up to end, nights not in rows count as full
+func NewGrid(start, end time.Time, rows []models.RoomNight) Grid {
+	g := Grid{free: make(map[int][]int)}
+
+	start = dates.Normalize(start)
+	for d := start; d.Before(dates.Normalize(end)); d = d.AddDate(0, 0, 1) {
+		g.Nights = append(g.Nights, d)
+	}
+
+	for _, x := range rows {
+		free, ok := g.free[x.Room.ID]
+		if !ok {
+			free = make([]int, len(g.Nights))
+			g.free[x.Room.ID] = free
+			g.Rooms = append(g.Rooms, x.Room)
+		}
+
+		i := dates.Nights(start, x.Night)
+		if i >= 0 && i < len(free) {
+			free[i] = x.Free
+		}
+	}
+
+	return g
+}
+
+// Free returns the number of free units of a room on a night
+func (g Grid) Free(roomID int, night time.Time) int {
+	free, ok := g.free[roomID]
+	if !ok || len(g.Nights) == 0 {
+		return 0
+	}
+
+	i := dates.Nights(g.Nights[0], night)
+	if i < 0 || i >= len(free) {
+		return 0
+	}
+	return free[i]
+}
+
+// FreeFor returns true if the room has a free unit on every night from start up to end
+func (g Grid) FreeFor(roomID int, start, end time.Time) bool {
+	if !end.After(start) {
+		return false
+	}
+
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		if g.Free(roomID, d) <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RoomsFreeFor returns the rooms that have a free unit on every night from start up to end
+func (g Grid) RoomsFreeFor(start, end time.Time) []models.Room {
+	var rooms []models.Room
+	for _, room := range g.Rooms {
+		if g.FreeFor(room.ID, start, end) {
+			rooms = append(rooms, room)
+		}
+	}
+	return rooms
+}
+
+// NearestWindows returns up to limit stays of the same length as start to end, moved by at most
+// radius days either way, nearest first and earlier first on a tie
+func (g Grid) NearestWindows(start, end time.Time, radius, limit int) []Window {
+	var windows []Window
+
+	for shift := 1; shift <= radius && len(windows) < limit; shift++ {
+		for _, offset := range []int{-shift, shift} {
+			s := start.AddDate(0, 0, offset)
+			e := end.AddDate(0, 0, offset)
+
+			rooms := g.RoomsFreeFor(s, e)
+			if len(rooms) > 0 && len(windows) < limit {
+				windows = append(windows, Window{StartDate: s, EndDate: e, Rooms: rooms})
+			}
+		}
+	}
+
+	return windows
+}
+
+// SplitStays returns up to limit ways to cover start to end by moving once between two different
+// rooms, with the move as close to the middle of the stay as possible
+func (g Grid) SplitStays(start, end time.Time, limit int) []Split {
+	var splits []Split
+
+	nights := dates.Nights(start, end)
+	for _, n := range moveNights(nights) {
+		if len(splits) >= limit {
+			break
+		}
+
+		move := start.AddDate(0, 0, n)
+		for _, first := range g.RoomsFreeFor(start, move) {
+			second, ok := g.nextRoom(first.ID, move, end)
+			if !ok {
+				continue
+			}
+
+			splits = append(splits, Split{
+				First:  Segment{Room: first, StartDate: start, EndDate: move},
+				Second: Segment{Room: second, StartDate: move, EndDate: end},
+			})
+			break
+		}
+	}
+
+	return splits
+}
+
+// nextRoom returns the first room other than roomID that is free from start up to end
+func (g Grid) nextRoom(roomID int, start, end time.Time) (models.Room, bool) {
+	for _, room := range g.RoomsFreeFor(start, end) {
+		if room.ID != roomID {
+			return room, true
+		}
+	}
+	return models.Room{}, false
+}
+
+// moveNights returns the nights of a stay a guest could move rooms on, middle first
+func moveNights(nights int) []int {
+	var out []int
+
+	mid := nights / 2
+	if mid > 0 {
+		out = append(out, mid)
+	}
+
+	for shift := 1; shift < nights; shift++ {
+		for _, n := range []int{mid - shift, mid + shift} {
+			if n > 0 && n < nights {
+				out = append(out, n)
+			}
+		}
+	}
+	return out
+}
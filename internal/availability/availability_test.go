@@ -0,0 +1,150 @@
+package availability
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/dates"
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+var (
+	hq    = models.Room{ID: 1, RoomName: "Secret HQ"}
+	world = models.Room{ID: 2, RoomName: "New World"}
+)
+
+// testGrid builds a grid from one string per room, one character per night starting on
+// 2050-01-01, where a digit is the number of free units
+func testGrid(rooms map[int]string) Grid {
+	start := day("2050-01-01")
+
+	var rows []models.RoomNight
+	var end time.Time
+	for _, room := range []models.Room{hq, world} {
+		nights, ok := rooms[room.ID]
+		if !ok {
+			continue
+		}
+		for i, c := range nights {
+			night := start.AddDate(0, 0, i)
+			rows = append(rows, models.RoomNight{Room: room, Night: night, Free: int(c - '0')})
+			if !night.Before(end) {
+				end = night.AddDate(0, 0, 1)
+			}
+		}
+	}
+
+	return NewGrid(start, end, rows)
+}
+
+func day(s string) time.Time {
+	d, _ := dates.Parse(s)
+	return d
+}
+
+func TestGrid_Free(t *testing.T) {
+	g := testGrid(map[int]string{hq.ID: "2010"})
+
+	if g.Free(hq.ID, day("2050-01-01")) != 2 {
+		t.Error("expected two free units on the first night")
+	}
+	if g.Free(hq.ID, day("2050-01-02")) != 0 {
+		t.Error("expected no free units on the second night")
+	}
+	if g.Free(hq.ID, day("2049-12-31")) != 0 || g.Free(hq.ID, day("2050-01-05")) != 0 {
+		t.Error("nights outside of the grid should be full")
+	}
+	if g.Free(world.ID, day("2050-01-01")) != 0 {
+		t.Error("unknown rooms should be full")
+	}
+}
+
+var roomsFreeForTests = []struct {
+	name     string
+	start    string
+	end      string
+	expected int
+}{
+	{"both-rooms", "2050-01-01", "2050-01-03", 2},
+	{"one-room", "2050-01-03", "2050-01-05", 1},
+	{"none", "2050-01-04", "2050-01-06", 0},
+	{"no-nights", "2050-01-01", "2050-01-01", 0},
+	{"outside-grid", "2050-01-06", "2050-01-09", 0},
+}
+
+func TestGrid_RoomsFreeFor(t *testing.T) {
+	g := testGrid(map[int]string{hq.ID: "11110", world.ID: "11100"})
+
+	for _, e := range roomsFreeForTests {
+		rooms := g.RoomsFreeFor(day(e.start), day(e.end))
+		if len(rooms) != e.expected {
+			t.Errorf("failed %s: expected %d rooms, got %d", e.name, e.expected, len(rooms))
+		}
+	}
+}
+
+func TestGrid_NearestWindows(t *testing.T) {
+	// the requested stay of the 6th to the 8th is full, three days earlier and four days later are free
+	g := testGrid(map[int]string{hq.ID: "0011000001100"})
+
+	windows := g.NearestWindows(day("2050-01-06"), day("2050-01-08"), 7, 3)
+	if len(windows) != 2 {
+		t.Fatalf("expected 2 windows, got %d", len(windows))
+	}
+	if dates.Format(windows[0].StartDate) != "2050-01-03" {
+		t.Errorf("expected nearest window to start on 2050-01-03, got %s", dates.Format(windows[0].StartDate))
+	}
+	if dates.Format(windows[1].StartDate) != "2050-01-10" || dates.Format(windows[1].EndDate) != "2050-01-12" {
+		t.Errorf("expected second window 2050-01-10 to 2050-01-12, got %s to %s",
+			dates.Format(windows[1].StartDate), dates.Format(windows[1].EndDate))
+	}
+
+	windows = g.NearestWindows(day("2050-01-06"), day("2050-01-08"), 7, 1)
+	if len(windows) != 1 {
+		t.Errorf("expected limit of 1 window, got %d", len(windows))
+	}
+
+	windows = g.NearestWindows(day("2050-01-06"), day("2050-01-08"), 2, 3)
+	if len(windows) != 0 {
+		t.Errorf("expected no windows within 2 days, got %d", len(windows))
+	}
+}
+
+func TestGrid_SplitStays(t *testing.T) {
+	// neither room is free for the whole of the 1st to the 5th, but the guest can move on the 3rd
+	g := testGrid(map[int]string{hq.ID: "1100", world.ID: "0011"})
+
+	splits := g.SplitStays(day("2050-01-01"), day("2050-01-05"), 3)
+	if len(splits) != 1 {
+		t.Fatalf("expected 1 split, got %d", len(splits))
+	}
+	s := splits[0]
+	if s.First.Room.ID != hq.ID || s.Second.Room.ID != world.ID {
+		t.Errorf("expected a move from %s to %s", hq.RoomName, world.RoomName)
+	}
+	if dates.Format(s.First.EndDate) != "2050-01-03" || dates.Format(s.Second.StartDate) != "2050-01-03" {
+		t.Errorf("expected the move on 2050-01-03, got %s", dates.Format(s.First.EndDate))
+	}
+
+	// a single room can't be split with itself
+	g = testGrid(map[int]string{hq.ID: "1111"})
+	if len(g.SplitStays(day("2050-01-01"), day("2050-01-05"), 3)) != 0 {
+		t.Error("expected no split stays with a single room")
+	}
+}
+
+func TestMoveNights(t *testing.T) {
+	for nights, expected := range map[int][]int{1: nil, 2: {1}, 3: {1, 2}, 4: {2, 1, 3}} {
+		got := moveNights(nights)
+		if len(got) != len(expected) {
+			t.Errorf("for %d nights expected %v, got %v", nights, expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Errorf("for %d nights expected %v, got %v", nights, expected, got)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package cancellation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/dates"
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+// Who cancelled a reservation, the system cancels reservations that weren't paid for in time
+const (
+	ByGuest  = "guest"
+	ByAdmin  = "admin"
+	BySystem = "system"
+)
+
+// Quote is what cancelling a reservation costs, amounts are in cents
+type Quote struct {
+	Fee    int
+	Refund int
+}
+
+// Calculate works out the fee and refund of cancelling, on today, a stay arriving on arrival that
+// costs total and of which paid has been paid so far. The fee is never more than the total and
+// the refund never more than what was paid.
+func Calculate(policy models.CancellationPolicy, arrival, today time.Time, total, paid int) Quote {
+	var q Quote
+
+	switch {
+	case policy.NonRefundable:
+		q.Fee = total
+	case dates.Nights(today, arrival) > 0 && dates.Nights(today, arrival) >= policy.FreeDays:
+		// cancelling on or after the day of arrival is never free
+		q.Fee = 0
+	default:
+		q.Fee = (total*policy.FeePercent + 99) / 100
+	}
+
+	if q.Fee > total {
+		q.Fee = total
+	}
+
+	q.Refund = paid - q.Fee
+	if q.Refund < 0 {
+		q.Refund = 0
+	}
+	return q
+}
+
+// Describe explains a policy to guests
+func Describe(policy models.CancellationPolicy) string {
+	switch {
+	case policy.NonRefundable:
+		return "Non-refundable: the full price is charged if you cancel."
+	case policy.FeePercent == 0:
+		return "Free cancellation."
+	case policy.FreeDays == 0:
+		return fmt.Sprintf("Free cancellation until the day before arrival, then %d%% of the total is charged.", policy.FeePercent)
+	}
+	return fmt.Sprintf("Free cancellation until %d day(s) before arrival, then %d%% of the total is charged.",
+		policy.FreeDays, policy.FeePercent)
+}
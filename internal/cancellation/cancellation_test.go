@@ -0,0 +1,58 @@
+package cancellation
+
+import (
+	"testing"
+
+	"github.com/RakhmanovTimur/bookings/internal/dates"
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+var (
+	flexible      = models.CancellationPolicy{Name: "Flexible", FreeDays: 7, FeePercent: 50}
+	sameDay       = models.CancellationPolicy{Name: "Same day", FeePercent: 100}
+	nonRefundable = models.CancellationPolicy{Name: "Non-refundable", NonRefundable: true}
+)
+
+var calculateTests = []struct {
+	name           string
+	policy         models.CancellationPolicy
+	today          string
+	paid           int
+	expectedFee    int
+	expectedRefund int
+}{
+	{"no-policy", models.CancellationPolicy{}, "2050-06-10", 10000, 0, 10000},
+	{"free-period", flexible, "2050-06-01", 10000, 0, 10000},
+	{"last-free-day", flexible, "2050-06-03", 10000, 0, 10000},
+	{"after-free-period", flexible, "2050-06-04", 10000, 5000, 5000},
+	{"fee-above-deposit", flexible, "2050-06-04", 3000, 5000, 0},
+	{"unpaid", flexible, "2050-06-04", 0, 5000, 0},
+	{"day-before", sameDay, "2050-06-09", 10000, 0, 10000},
+	{"on-the-day", sameDay, "2050-06-10", 10000, 10000, 0},
+	{"after-arrival", sameDay, "2050-06-12", 10000, 10000, 0},
+	{"non-refundable", nonRefundable, "2050-01-01", 10000, 10000, 0},
+}
+
+func TestCalculate(t *testing.T) {
+	arrival, _ := dates.Parse("2050-06-10")
+
+	for _, e := range calculateTests {
+		today, _ := dates.Parse(e.today)
+		q := Calculate(e.policy, arrival, today, 10000, e.paid)
+		if q.Fee != e.expectedFee || q.Refund != e.expectedRefund {
+			t.Errorf("failed %s: expected fee %d and refund %d, got %d and %d",
+				e.name, e.expectedFee, e.expectedRefund, q.Fee, q.Refund)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	for _, p := range []models.CancellationPolicy{{}, flexible, sameDay, nonRefundable} {
+		if Describe(p) == "" {
+			t.Errorf("no description for %+v", p)
+		}
+	}
+	if Describe(flexible) != "Free cancellation until 7 day(s) before arrival, then 50% of the total is charged." {
+		t.Errorf("unexpected description %q", Describe(flexible))
+	}
+}
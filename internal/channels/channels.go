@@ -0,0 +1,115 @@
+package channels
+
+import (
+	"errors"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+// Directions of a sync with a channel
+const (
+	DirectionPush = "push"
+	DirectionPull = "pull"
+)
+
+// Statuses of sync log entries. A conflict is a reservation a channel took for nights none of
+// the units of its room are free, which staff have to sort out with the guest.
+const (
+	StatusOK       = "ok"
+	StatusConflict = "conflict"
+	StatusError    = "error"
+)
+
+// HorizonDays is how many nights ahead availability is pushed to channels
+const HorizonDays = 365
+
+// PullOverlap is how long before the last pull reservations are asked for again, so those a
+// channel took while the last pull ran aren't missed. Ones already imported are skipped.
+const PullOverlap = 10 * time.Minute
+
+// Availability is how many units of a room are free on a night and what a night costs, in
+// cents, as a channel knows the room
+type Availability struct {
+	ExternalRoomID string
+	Night          time.Time
+	Free           int
+	Price          int
+}
+
+// Reservation is a stay booked on a channel, Reference is the channel's id of the booking and
+// Total is in cents
+type Reservation struct {
+	Reference      string
+	ExternalRoomID string
+	FirstName      string
+	LastName       string
+	Email          string
+	Phone          string
+	StartDate      time.Time
+	EndDate        time.Time
+	Adults         int
+	Children       int
+	Total          int
+	BookedAt       time.Time
+}
+
+// Channel is a booking platform rooms are also sold on. Availability is pushed to it so it
+// stops selling rooms that are taken, and the reservations it took are pulled from it.
+type Channel interface {
+	Name() string
+	PushAvailability(updates []Availability) error
+	PullReservations(since time.Time) ([]Reservation, error)
+}
+
+// Updates returns the availability to push to a channel for the nights of the rooms mapped to it
+func Updates(mappings []models.ChannelMapping, nights []models.RoomNight) []Availability {
+	external := make(map[int]string)
+	for _, m := range mappings {
+		external[m.RoomID] = m.ExternalRoomID
+	}
+
+	var updates []Availability
+	for _, n := range nights {
+		id, ok := external[n.Room.ID]
+		if !ok {
+			continue
+		}
+		free := n.Free
+		if free < 0 {
+			free = 0
+		}
+		updates = append(updates, Availability{
+			ExternalRoomID: id,
+			Night:          n.Night,
+			Free:           free,
+			Price:          n.Room.Price,
+		})
+	}
+	return updates
+}
+
+// RoomFor returns the id of the room a channel knows as externalRoomID
+func RoomFor(mappings []models.ChannelMapping, externalRoomID string) (int, bool) {
+	for _, m := range mappings {
+		if m.ExternalRoomID == externalRoomID {
+			return m.RoomID, true
+		}
+	}
+	return 0, false
+}
+
+// Check returns an error when a reservation pulled from a channel can't be imported as it is
+func Check(r Reservation) error {
+	switch {
+	case r.Reference == "":
+		return errors.New("reservation has no reference")
+	case r.StartDate.IsZero() || !r.EndDate.After(r.StartDate):
+		return errors.New("reservation has invalid dates")
+	case r.Adults < 1 || r.Children < 0:
+		return errors.New("reservation has invalid guests")
+	case r.LastName == "" && r.FirstName == "":
+		return errors.New("reservation has no guest name")
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package channels
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+var mappings = []models.ChannelMapping{
+	{RoomID: 1, ExternalRoomID: "ext-1"},
+	{RoomID: 2, ExternalRoomID: "ext-2"},
+}
+
+func night(day int) time.Time {
+	return time.Date(2050, 6, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestUpdates(t *testing.T) {
+	nights := []models.RoomNight{
+		{Room: models.Room{ID: 1, Price: 10000}, Night: night(10), Free: 2},
+		{Room: models.Room{ID: 2, Price: 15000}, Night: night(10), Free: -1}, // overbooked
+		{Room: models.Room{ID: 3, Price: 20000}, Night: night(10), Free: 1},  // not mapped
+	}
+
+	updates := Updates(mappings, nights)
+	if len(updates) != 2 {
+		t.Fatalf("expected updates for the 2 mapped rooms, got %+v", updates)
+	}
+	if updates[0] != (Availability{ExternalRoomID: "ext-1", Night: night(10), Free: 2, Price: 10000}) {
+		t.Errorf("unexpected update %+v", updates[0])
+	}
+	if updates[1].ExternalRoomID != "ext-2" || updates[1].Free != 0 {
+		t.Errorf("expected an overbooked room to have nothing free, got %+v", updates[1])
+	}
+}
+
+func TestRoomFor(t *testing.T) {
+	if id, ok := RoomFor(mappings, "ext-2"); !ok || id != 2 {
+		t.Errorf("expected ext-2 to be room 2, got %d %v", id, ok)
+	}
+	if _, ok := RoomFor(mappings, "ext-9"); ok {
+		t.Error("expected ext-9 not to be mapped")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	valid := Reservation{Reference: "BK-1", LastName: "Smith", StartDate: night(10), EndDate: night(12), Adults: 2}
+	if err := Check(valid); err != nil {
+		t.Errorf("expected a valid reservation, got %v", err)
+	}
+
+	tests := map[string]func(r *Reservation){
+		"no reference":   func(r *Reservation) { r.Reference = "" },
+		"no nights":      func(r *Reservation) { r.EndDate = r.StartDate },
+		"no adults":      func(r *Reservation) { r.Adults = 0 },
+		"no guest name":  func(r *Reservation) { r.LastName = "" },
+		"negative child": func(r *Reservation) { r.Children = -1 },
+	}
+	for name, change := range tests {
+		r := valid
+		change(&r)
+		if Check(r) == nil {
+			t.Errorf("failed %s: expected an error", name)
+		}
+	}
+}
+
+func TestFake(t *testing.T) {
+	f := NewFake("fake")
+	if f.Name() != "fake" {
+		t.Errorf("expected the fake to be called fake, got %s", f.Name())
+	}
+
+	err := f.PushAvailability([]Availability{{ExternalRoomID: "ext-1", Night: night(10), Free: 1, Price: 10000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, ok := f.Availability("ext-1", night(10)); !ok || a.Free != 1 {
+		t.Errorf("expected the pushed availability to be kept, got %+v %v", a, ok)
+	}
+	if _, ok := f.Availability("ext-1", night(11)); ok {
+		t.Error("expected no availability for a night that wasn't pushed")
+	}
+
+	since := time.Date(2050, 6, 1, 12, 0, 0, 0, time.UTC)
+	f.Book(Reservation{Reference: "BK-1", BookedAt: since.Add(-time.Hour)})
+	f.Book(Reservation{Reference: "BK-2", BookedAt: since.Add(time.Hour)})
+
+	pulled, err := f.PullReservations(since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulled) != 1 || pulled[0].Reference != "BK-2" {
+		t.Errorf("expected only BK-2 to be pulled, got %+v", pulled)
+	}
+
+	f.SetDown(true)
+	if _, err := f.PullReservations(since); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected a channel that is down to be unavailable, got %v", err)
+	}
+	if err := f.PushAvailability(nil); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("expected a channel that is down to be unavailable, got %v", err)
+	}
+}
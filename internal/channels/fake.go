@@ -0,0 +1,101 @@
+package channels
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/dates"
+)
+
+// ErrUnavailable is returned by channels that can't be reached
+var ErrUnavailable = errors.New("channel unavailable")
+
+// Fake is an in-memory channel for development and tests. Guests book on it with Book, and it
+// remembers the availability last pushed to it.
+type Fake struct {
+	mu           sync.Mutex
+	name         string
+	down         bool
+	availability map[string]Availability
+	reservations []Reservation
+}
+
+// NewFake returns a fake channel called name
+func NewFake(name string) *Fake {
+	return &Fake{
+		name:         name,
+		availability: make(map[string]Availability),
+	}
+}
+
+// Name returns the name of the channel
+func (f *Fake) Name() string {
+	return f.name
+}
+
+// availabilityKey is the key the fake keeps the availability of a room on a night under
+func availabilityKey(externalRoomID string, night time.Time) string {
+	return externalRoomID + "/" + night.Format(dates.Layout)
+}
+
+// PushAvailability remembers the availability of the nights in updates
+func (f *Fake) PushAvailability(updates []Availability) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.down {
+		return ErrUnavailable
+	}
+	for _, u := range updates {
+		f.availability[availabilityKey(u.ExternalRoomID, u.Night)] = u
+	}
+	return nil
+}
+
+// PullReservations returns the reservations booked on the channel after since
+func (f *Fake) PullReservations(since time.Time) ([]Reservation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.down {
+		return nil, ErrUnavailable
+	}
+
+	var pulled []Reservation
+	for _, r := range f.reservations {
+		if r.BookedAt.After(since) {
+			pulled = append(pulled, r)
+		}
+	}
+	return pulled, nil
+}
+
+// Book books a stay on the channel, as a guest of the booking platform would. The channel
+// takes it whatever availability was pushed, like a platform that hasn't caught up yet would.
+func (f *Fake) Book(r Reservation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.BookedAt.IsZero() {
+		r.BookedAt = time.Now()
+	}
+	f.reservations = append(f.reservations, r)
+}
+
+// Availability returns the availability last pushed for a room on a night
+func (f *Fake) Availability(externalRoomID string, night time.Time) (Availability, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	a, ok := f.availability[availabilityKey(externalRoomID, night)]
+	return a, ok
+}
+
+// SetDown makes the channel fail every call until it is set up again
+func (f *Fake) SetDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.down = down
+}
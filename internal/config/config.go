@@ -2,19 +2,29 @@ package config
 
 import (
 	"html/template"
-	"log"
+	"log/slog"
 
+	"github.com/RakhmanovTimur/bookings/internal/channels"
+	"github.com/RakhmanovTimur/bookings/internal/health"
+	"github.com/RakhmanovTimur/bookings/internal/metrics"
 	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/RakhmanovTimur/bookings/internal/payments"
+	"github.com/RakhmanovTimur/bookings/internal/ttlcache"
 	"github.com/alexedwards/scs/v2"
 )
 
 // AppConfig holds the application config
 type AppConfig struct {
-	UseCache      bool
-	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	UseCache          bool
+	TemplateCache     map[string]*template.Template
+	Logger            *slog.Logger
+	Metrics           *metrics.Metrics
+	InProduction      bool
+	Session           *scs.SessionManager
+	MailChan          chan models.MailData
+	WebhookChan       chan models.WebhookEvent
+	AvailabilityCache *ttlcache.Cache
+	Payments          payments.Gateway
+	Channels          []channels.Channel
+	Readiness         []health.Check
 }
@@ -0,0 +1,53 @@
+package dates
+
+import (
+	"time"
+)
+
+// Layout is the layout used for dates in forms, urls and json
+const Layout = "2006-01-02"
+
+// Parse parses a yyyy-mm-dd string into a date at UTC midnight
+func Parse(s string) (time.Time, error) {
+	return time.ParseInLocation(Layout, s, time.UTC)
+}
+
+// Format formats a date as yyyy-mm-dd
+func Format(t time.Time) string {
+	return t.Format(Layout)
+}
+
+// Normalize drops the time of day from t, keeping the calendar date t has in its own location,
+// and returns it at UTC midnight
+func Normalize(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Location loads the named time zone, falling back to UTC when it is empty or unknown
+func Location(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Today returns the current date in the given time zone at UTC midnight
+func Today(loc *time.Location) time.Time {
+	return Normalize(time.Now().In(loc))
+}
+
+// Nights returns the number of nights between arrival and departure
+func Nights(start, end time.Time) int {
+	return int(Normalize(end).Sub(Normalize(start)).Hours() / 24)
+}
+
+// FirstOfMonth returns the first day of the month t falls in
+func FirstOfMonth(t time.Time) time.Time {
+	y, m, _ := t.Date()
+	return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,69 @@
+package dates
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	d, err := Parse("2050-01-02")
+	if err != nil {
+		t.Error(err)
+	}
+	if d.Location() != time.UTC || d.Hour() != 0 || d.Day() != 2 {
+		t.Errorf("expected 2050-01-02 at UTC midnight, got %s", d)
+	}
+
+	_, err = Parse("invalid")
+	if err == nil {
+		t.Error("parsed an invalid date")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	// late evening in Los Angeles is already the next day in UTC
+	loc := Location("America/Los_Angeles")
+	evening := time.Date(2050, 1, 2, 22, 0, 0, 0, loc)
+
+	d := Normalize(evening)
+	if Format(d) != "2050-01-02" {
+		t.Errorf("expected 2050-01-02, got %s", Format(d))
+	}
+	if d.Location() != time.UTC {
+		t.Error("normalized date is not in UTC")
+	}
+}
+
+func TestLocation(t *testing.T) {
+	if Location("") != time.UTC {
+		t.Error("expected UTC for empty time zone")
+	}
+	if Location("Not/AZone") != time.UTC {
+		t.Error("expected UTC for unknown time zone")
+	}
+	if Location("Europe/Berlin").String() != "Europe/Berlin" {
+		t.Error("can't load Europe/Berlin")
+	}
+}
+
+func TestNights(t *testing.T) {
+	start, _ := Parse("2050-03-30")
+	end, _ := Parse("2050-04-02")
+
+	if n := Nights(start, end); n != 3 {
+		t.Errorf("expected 3 nights, got %d", n)
+	}
+
+	// a daylight saving change must not shorten the stay
+	loc := Location("Europe/Berlin")
+	if n := Nights(time.Date(2050, 3, 26, 0, 0, 0, 0, loc), time.Date(2050, 3, 28, 0, 0, 0, 0, loc)); n != 2 {
+		t.Errorf("expected 2 nights across DST change, got %d", n)
+	}
+}
+
+func TestFirstOfMonth(t *testing.T) {
+	d, _ := Parse("2050-02-17")
+	if Format(FirstOfMonth(d)) != "2050-02-01" {
+		t.Errorf("expected 2050-02-01, got %s", Format(FirstOfMonth(d)))
+	}
+}
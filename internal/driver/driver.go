@@ -1,6 +1,7 @@
 package driver
 
 import (
+	"context"
 	"database/sql"
 	"time"
 
@@ -39,6 +40,11 @@ func ConnectSQL(dsn string) (*DB, error) {
 	return dbConn, nil
 }
 
+// Ping checks the database can still be reached
+func (d *DB) Ping(ctx context.Context) error {
+	return d.SQL.PingContext(ctx)
+}
+
 // Tries to ping the database
 func testDb(d *sql.DB) error {
 	err := d.Ping()
@@ -0,0 +1,135 @@
+package extras
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/RakhmanovTimur/bookings/internal/pricing"
+)
+
+// How extras are priced
+const (
+	PerStay       = "per_stay"
+	PerNight      = "per_night"
+	PerGuest      = "per_guest"
+	PerGuestNight = "per_guest_night"
+)
+
+// Kinds lists the ways extras are priced with a description for forms
+var Kinds = []struct {
+	Kind        string
+	Description string
+}{
+	{PerStay, "Per stay"},
+	{PerNight, "Per night"},
+	{PerGuest, "Per guest"},
+	{PerGuestNight, "Per guest per night"},
+}
+
+// ValidKind reports whether kind is one of the ways extras are priced
+func ValidKind(kind string) bool {
+	for _, k := range Kinds {
+		if k.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Error is a quantity of an extra that can't be booked, the message is shown to guests
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Check returns an *Error if quantity of the extra can't be added to a stay of guests, when
+// booked of it are already taken on the busiest night of the stay. Per guest extras are taken
+// by at most every guest.
+func Check(e models.Extra, quantity, guests, booked int) error {
+	switch {
+	case quantity < 0:
+		return &Error{fmt.Sprintf("Invalid quantity of %s", e.Name)}
+	case quantity == 0:
+		return nil
+	case (e.Pricing == PerGuest || e.Pricing == PerGuestNight) && quantity > guests:
+		return &Error{fmt.Sprintf("%s can be taken by at most %d guest(s)", e.Name, guests)}
+	case e.Stock > 0 && booked+quantity > e.Stock:
+		left := e.Stock - booked
+		if left <= 0 {
+			return &Error{fmt.Sprintf("%s is sold out for these dates", e.Name)}
+		}
+		return &Error{fmt.Sprintf("Only %d of %s left for these dates", left, e.Name)}
+	}
+	return nil
+}
+
+// Price works out the line item of quantity of the extra on a stay of nights, at its current price
+func Price(e models.Extra, quantity, nights int) models.ReservationExtra {
+	units := quantity
+	if e.Pricing == PerNight || e.Pricing == PerGuestNight {
+		units *= nights
+	}
+
+	return models.ReservationExtra{
+		ExtraID:   e.ID,
+		Name:      e.Name,
+		Pricing:   e.Pricing,
+		Quantity:  quantity,
+		Nights:    nights,
+		UnitPrice: e.Price,
+		Amount:    units * e.Price,
+	}
+}
+
+// Line returns the line a reservation extra adds to a quote
+func Line(item models.ReservationExtra) pricing.Line {
+	l := pricing.Line{Description: item.Name, Quantity: item.Quantity, UnitPrice: item.UnitPrice, Amount: item.Amount}
+
+	switch item.Pricing {
+	case PerNight:
+		l.Description = fmt.Sprintf("%s, %d x %d night(s)", item.Name, item.Quantity, item.Nights)
+		l.Quantity = item.Quantity * item.Nights
+	case PerGuest:
+		l.Description = fmt.Sprintf("%s, %d guest(s)", item.Name, item.Quantity)
+	case PerGuestNight:
+		l.Description = fmt.Sprintf("%s, %d guest(s) x %d night(s)", item.Name, item.Quantity, item.Nights)
+		l.Quantity = item.Quantity * item.Nights
+	default:
+		if item.Quantity > 1 {
+			l.Description = fmt.Sprintf("%s x %d", item.Name, item.Quantity)
+		}
+	}
+	return l
+}
+
+// Lines returns the lines reservation extras add to a quote
+func Lines(items []models.ReservationExtra) []pricing.Line {
+	var lines []pricing.Line
+	for _, item := range items {
+		lines = append(lines, Line(item))
+	}
+	return lines
+}
+
+// Total returns what reservation extras cost together
+func Total(items []models.ReservationExtra) int {
+	total := 0
+	for _, item := range items {
+		total += item.Amount
+	}
+	return total
+}
+
+// Describe explains how an extra is priced, e.g. "12.50 per guest per night"
+func Describe(e models.Extra) string {
+	for _, k := range Kinds {
+		if k.Kind == e.Pricing {
+			return fmt.Sprintf("%s %s", pricing.FormatMoney(e.Price), strings.ToLower(k.Description))
+		}
+	}
+	return pricing.FormatMoney(e.Price)
+}
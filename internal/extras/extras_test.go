@@ -0,0 +1,92 @@
+package extras
+
+import (
+	"testing"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+var (
+	parking      = models.Extra{ID: 1, Name: "Parking", Pricing: PerNight, Price: 1500, Stock: 4}
+	breakfast    = models.Extra{ID: 2, Name: "Breakfast", Pricing: PerGuestNight, Price: 1200}
+	lateCheckout = models.Extra{ID: 3, Name: "Late checkout", Pricing: PerStay, Price: 3000, Stock: 1}
+	welcomePack  = models.Extra{ID: 4, Name: "Welcome pack", Pricing: PerGuest, Price: 500}
+)
+
+var checkTests = []struct {
+	name     string
+	extra    models.Extra
+	quantity int
+	booked   int
+	valid    bool
+}{
+	{"none", parking, 0, 4, true},
+	{"negative", parking, -1, 0, false},
+	{"in-stock", parking, 2, 2, true},
+	{"out-of-stock", parking, 3, 2, false},
+	{"sold-out", lateCheckout, 1, 1, false},
+	{"unlimited", breakfast, 2, 100, true},
+	{"every-guest", breakfast, 2, 0, true},
+	{"more-than-guests", breakfast, 3, 0, false},
+	{"more-than-guests-per-stay", welcomePack, 3, 0, false},
+}
+
+func TestCheck(t *testing.T) {
+	for _, e := range checkTests {
+		// every stay is for 2 guests
+		err := Check(e.extra, e.quantity, 2, e.booked)
+		if e.valid && err != nil {
+			t.Errorf("failed %s: expected no error, got %s", e.name, err)
+		}
+		if !e.valid && err == nil {
+			t.Errorf("failed %s: expected an error", e.name)
+		}
+	}
+
+	err := Check(parking, 3, 2, 2)
+	if err.Error() != "Only 2 of Parking left for these dates" {
+		t.Errorf("unexpected message %s", err)
+	}
+}
+
+func TestPrice(t *testing.T) {
+	tests := []struct {
+		extra       models.Extra
+		quantity    int
+		expected    int
+		description string
+	}{
+		{parking, 2, 9000, "Parking, 2 x 3 night(s)"},
+		{breakfast, 2, 7200, "Breakfast, 2 guest(s) x 3 night(s)"},
+		{lateCheckout, 1, 3000, "Late checkout"},
+		{welcomePack, 2, 1000, "Welcome pack, 2 guest(s)"},
+	}
+
+	var items []models.ReservationExtra
+	for _, e := range tests {
+		// every stay is 3 nights
+		item := Price(e.extra, e.quantity, 3)
+		if item.Amount != e.expected || item.ExtraID != e.extra.ID {
+			t.Errorf("expected %s to cost %d, got %+v", e.extra.Name, e.expected, item)
+		}
+
+		l := Line(item)
+		if l.Description != e.description || l.Quantity*l.UnitPrice != l.Amount {
+			t.Errorf("unexpected line %+v", l)
+		}
+		items = append(items, item)
+	}
+
+	if Total(items) != 20200 || len(Lines(items)) != 4 {
+		t.Errorf("expected a total of 20200, got %d", Total(items))
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	if s := Describe(breakfast); s != "12.00 per guest per night" {
+		t.Errorf("unexpected description %s", s)
+	}
+	if !ValidKind(PerStay) || ValidKind("per_room") {
+		t.Error("unexpected kinds")
+	}
+}
@@ -3,6 +3,7 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/asaskevich/govalidator"
@@ -63,3 +64,13 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid Email Address")
 	}
 }
+
+// IntRange checks that a field is a whole number between min and max inclusive
+func (f *Form) IntRange(field string, min, max int) bool {
+	x, err := strconv.Atoi(strings.TrimSpace(f.Get(field)))
+	if err != nil || x < min || x > max {
+		f.Errors.Add(field, fmt.Sprintf("This field must be a number between %d and %d.", min, max))
+		return false
+	}
+	return true
+}
@@ -98,3 +98,25 @@ func TestForm_IsEmail(t *testing.T) {
 		t.Error("Expected invalid email, but got valid instead")
 	}
 }
+
+func TestForm_IntRange(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("adults", "2")
+	form := New(postedData)
+	if !form.IntRange("adults", 1, 4) {
+		t.Error("Expected 2 to be in range, but it was not")
+	}
+
+	for _, v := range []string{"0", "5", "two", ""} {
+		postedData = url.Values{}
+		postedData.Add("adults", v)
+		form = New(postedData)
+		form.IntRange("adults", 1, 4)
+		if form.Valid() {
+			t.Errorf("Expected %q to be out of range, but got valid instead", v)
+		}
+		if form.Errors.Get("adults") == "" {
+			t.Errorf("Should have an error for %q, but did not get one", v)
+		}
+	}
+}
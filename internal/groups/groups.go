@@ -0,0 +1,52 @@
+package groups
+
+import (
+	"crypto/rand"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/RakhmanovTimur/bookings/internal/payments"
+)
+
+// MaxStays is how many room stays guests can book together in one group booking
+const MaxStays = 10
+
+// referenceChars leaves out letters and digits that are easily mistaken for each other, so
+// guests can read their reference out over the phone
+const referenceChars = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewReference returns a random reference for a group booking, such as G-7KQ3XP9M
+func NewReference() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range b {
+		b[i] = referenceChars[int(b[i])%len(referenceChars)]
+	}
+	return "G-" + string(b), nil
+}
+
+// Total returns what the stays of a group booking cost together, leaving out cancelled stays
+func Total(stays []models.Reservation) int {
+	total := 0
+	for _, s := range stays {
+		if s.CancelledAt.IsZero() {
+			total += s.Total
+		}
+	}
+	return total
+}
+
+// AmountDue returns what is paid at checkout for the stays of a group booking. Each stay's share
+// is worked out as it would be for a reservation of its own, so it can be refunded on its own.
+func AmountDue(policy string, depositPercent int, stays []models.Reservation) int {
+	due := 0
+	for _, s := range stays {
+		if s.CancelledAt.IsZero() {
+			due += payments.AmountDue(policy, depositPercent, s.Total)
+		}
+	}
+	return due
+}
@@ -0,0 +1,66 @@
+package groups
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/RakhmanovTimur/bookings/internal/payments"
+)
+
+var stays = []models.Reservation{
+	{ID: 1, Total: 1001},
+	{ID: 2, Total: 1001},
+	{ID: 3, Total: 5000, CancelledAt: time.Date(2050, 6, 1, 0, 0, 0, 0, time.UTC)},
+}
+
+func TestNewReference(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		ref, err := NewReference()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ref) != 10 || !strings.HasPrefix(ref, "G-") {
+			t.Errorf("unexpected reference %s", ref)
+		}
+		for _, c := range ref[2:] {
+			if !strings.ContainsRune(referenceChars, c) {
+				t.Errorf("reference %s has unexpected character %c", ref, c)
+			}
+		}
+		if seen[ref] {
+			t.Errorf("reference %s handed out twice", ref)
+		}
+		seen[ref] = true
+	}
+}
+
+func TestTotal(t *testing.T) {
+	if got := Total(stays); got != 2002 {
+		t.Errorf("expected 2002, got %d", got)
+	}
+	if got := Total(nil); got != 0 {
+		t.Errorf("expected 0 for no stays, got %d", got)
+	}
+}
+
+func TestAmountDue(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		percent  int
+		expected int
+	}{
+		{"full", payments.PolicyFull, 0, 2002},
+		// each stay's deposit is rounded up on its own
+		{"deposit", payments.PolicyDeposit, 10, 202},
+	}
+
+	for _, e := range tests {
+		if got := AmountDue(e.policy, e.percent, stays); got != e.expected {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package guests
+
+import (
+	"strings"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+// Tags admins put on guests that the site knows the meaning of
+const (
+	TagVIP         = "VIP"
+	TagDoNotRebook = "do-not-rebook"
+)
+
+// Tags are the tags offered on the guest page, others can be typed in
+var Tags = []string{TagVIP, TagDoNotRebook}
+
+// NormalizeEmail returns an email address the way guests are told apart by it
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// ParseTags splits comma separated tags, dropping empty and repeated ones
+func ParseTags(s string) []string {
+	return MergeTags(strings.Split(s, ","))
+}
+
+// MergeTags returns the tags of every list once, in the order they first appear
+func MergeTags(lists ...[]string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, list := range lists {
+		for _, t := range list {
+			t = strings.TrimSpace(t)
+			if t == "" || seen[strings.ToLower(t)] {
+				continue
+			}
+			seen[strings.ToLower(t)] = true
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// HasTag reports whether the guest has tag
+func HasTag(g models.Guest, tag string) bool {
+	for _, t := range g.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// MergeNotes joins the notes of two guests being merged
+func MergeNotes(keep, merged string) string {
+	keep, merged = strings.TrimSpace(keep), strings.TrimSpace(merged)
+	switch {
+	case merged == "" || merged == keep:
+		return keep
+	case keep == "":
+		return merged
+	}
+	return keep + "\n\n" + merged
+}
+
+// Spend returns what a guest has spent on reservations, the total of those that went ahead and
+// the cancellation fee of those that were cancelled
+func Spend(reservations []models.Reservation) int {
+	spend := 0
+	for _, r := range reservations {
+		if r.CancelledAt.IsZero() {
+			spend += r.Total
+		} else {
+			spend += r.CancellationFee
+		}
+	}
+	return spend
+}
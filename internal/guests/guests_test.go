@@ -0,0 +1,64 @@
+package guests
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	if NormalizeEmail(" John@Smith.com ") != "john@smith.com" {
+		t.Errorf("unexpected email %s", NormalizeEmail(" John@Smith.com "))
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags := ParseTags(" VIP, ,do-not-rebook,vip, Regular")
+	if !reflect.DeepEqual(tags, []string{"VIP", "do-not-rebook", "Regular"}) {
+		t.Errorf("unexpected tags %q", tags)
+	}
+	if ParseTags("") != nil {
+		t.Error("expected no tags")
+	}
+
+	merged := MergeTags([]string{"VIP"}, []string{"Regular", "vip"})
+	if !reflect.DeepEqual(merged, []string{"VIP", "Regular"}) {
+		t.Errorf("unexpected merged tags %q", merged)
+	}
+}
+
+func TestHasTag(t *testing.T) {
+	g := models.Guest{Tags: []string{"vip"}}
+	if !HasTag(g, TagVIP) || HasTag(g, TagDoNotRebook) {
+		t.Errorf("unexpected tags %q", g.Tags)
+	}
+}
+
+func TestMergeNotes(t *testing.T) {
+	tests := []struct {
+		keep, merged, expected string
+	}{
+		{"Likes the quiet room", "", "Likes the quiet room"},
+		{"", "Allergic to nuts", "Allergic to nuts"},
+		{"Likes the quiet room", "Likes the quiet room", "Likes the quiet room"},
+		{"Likes the quiet room", "Allergic to nuts", "Likes the quiet room\n\nAllergic to nuts"},
+	}
+	for _, e := range tests {
+		if got := MergeNotes(e.keep, e.merged); got != e.expected {
+			t.Errorf("expected %q, got %q", e.expected, got)
+		}
+	}
+}
+
+func TestSpend(t *testing.T) {
+	reservations := []models.Reservation{
+		{Total: 23000},
+		{Total: 10000, CancelledAt: time.Date(2050, 6, 1, 0, 0, 0, 0, time.UTC), CancellationFee: 5000},
+		{Total: 12000},
+	}
+	if Spend(reservations) != 40000 {
+		t.Errorf("expected a spend of 40000, got %d", Spend(reservations))
+	}
+}
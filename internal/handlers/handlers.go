@@ -1,22 +1,47 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"html"
+	"io"
+	"math"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/RakhmanovTimur/bookings/internal/availability"
+	"github.com/RakhmanovTimur/bookings/internal/cancellation"
+	"github.com/RakhmanovTimur/bookings/internal/channels"
 	"github.com/RakhmanovTimur/bookings/internal/config"
+	"github.com/RakhmanovTimur/bookings/internal/dates"
 	"github.com/RakhmanovTimur/bookings/internal/driver"
+	"github.com/RakhmanovTimur/bookings/internal/extras"
 	"github.com/RakhmanovTimur/bookings/internal/forms"
+	"github.com/RakhmanovTimur/bookings/internal/groups"
+	"github.com/RakhmanovTimur/bookings/internal/guests"
+	"github.com/RakhmanovTimur/bookings/internal/health"
 	"github.com/RakhmanovTimur/bookings/internal/helpers"
+	"github.com/RakhmanovTimur/bookings/internal/holds"
+	"github.com/RakhmanovTimur/bookings/internal/invoices"
 	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/RakhmanovTimur/bookings/internal/payments"
+	"github.com/RakhmanovTimur/bookings/internal/pricing"
+	"github.com/RakhmanovTimur/bookings/internal/promos"
 	"github.com/RakhmanovTimur/bookings/internal/render"
 	"github.com/RakhmanovTimur/bookings/internal/repository"
 	"github.com/RakhmanovTimur/bookings/internal/repository/dbrepo"
+	"github.com/RakhmanovTimur/bookings/internal/stayrules"
+	"github.com/RakhmanovTimur/bookings/internal/waitlist"
+	"github.com/RakhmanovTimur/bookings/internal/webhooks"
 	"github.com/go-chi/chi"
 )
 
@@ -88,48 +113,246 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 	start := r.Form.Get("start")
 	end := r.Form.Get("end")
 
-	layout := "2006-01-02"
-	startDate, err := time.Parse(layout, start)
+	startDate, err := dates.Parse(start)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't parse start date!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-	endDate, err := time.Parse(layout, end)
+	endDate, err := dates.Parse(end)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't parse end date!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	adults, children, err := parseGuests(r.Form.Get("adults"), r.Form.Get("children"))
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", "invalid number of guests!")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
 
-	if len(rooms) == 0 {
-		// no availability
-		m.App.Session.Put(r.Context(), "error", "No available rooms")
+	property := helpers.CurrentProperty(r)
+	today := dates.Today(dates.Location(property.Timezone))
+
+	err = stayrules.Check(nil, startDate, endDate, today)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", err.Error())
 		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
 
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(property.ID, adults+children, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if len(rooms) > 0 {
+		rules, err := m.DB.GetStayRulesForArrival(property.ID, startDate)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		// drop the rooms whose stay rules don't allow these dates
+		var allowed []models.Room
+		var broken error
+		for _, room := range rooms {
+			if err := stayrules.Check(stayrules.ForRoom(rules, room.ID), startDate, endDate, today); err != nil {
+				broken = err
+				continue
+			}
+			allowed = append(allowed, room)
+		}
+
+		if len(allowed) == 0 {
+			m.App.Metrics.EmptySearches.Inc()
+			m.App.Session.Put(r.Context(), "error", broken.Error())
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+		rooms = allowed
+	}
+
+	if len(rooms) == 0 {
+		m.App.Metrics.EmptySearches.Inc()
+
+		// no availability for these dates, look for nearby dates and split stays instead
+		from := startDate.AddDate(0, 0, -suggestionDays)
+		if from.Before(today) {
+			from = today
+		}
+		to := endDate.AddDate(0, 0, suggestionDays)
+
+		grid, err := m.nightlyGrid(property.ID, adults+children, from, to)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		windows := grid.NearestWindows(startDate, endDate, suggestionDays, maxSuggestions)
+		splits := grid.SplitStays(startDate, endDate, maxSuggestions)
+
+		if len(windows) == 0 && len(splits) == 0 {
+			m.App.Session.Put(r.Context(), "warning", "No rooms are free on your dates, join the waitlist and we'll email you if one frees up")
+			http.Redirect(w, r, fmt.Sprintf("/waitlist?s=%s&e=%s&a=%d&c=%d",
+				startDate.Format(dates.Layout), endDate.Format(dates.Layout), adults, children), http.StatusSeeOther)
+			return
+		}
+
+		data := make(map[string]interface{})
+		data["windows"] = windows
+		data["splits"] = splits
+
+		stringMap := make(map[string]string)
+		stringMap["start_date"] = start
+		stringMap["end_date"] = end
+
+		intMap := make(map[string]int)
+		intMap["adults"] = adults
+		intMap["children"] = children
+
+		render.Template(w, r, "availability-suggestions.page.tmpl", &models.TemplateData{
+			Data:      data,
+			StringMap: stringMap,
+			IntMap:    intMap,
+		})
+		return
+	}
+
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
 
 	res := models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
+		Adults:    adults,
+		Children:  children,
 	}
 
 	m.App.Session.Put(r.Context(), "reservation", res)
 
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = startDate.Format(dates.Layout)
+	stringMap["end_date"] = endDate.Format(dates.Layout)
+
+	intMap := make(map[string]int)
+	intMap["adults"] = adults
+	intMap["children"] = children
+
 	render.Template(w, r, "choose-room.page.tmpl", &models.TemplateData{
-		Data: data,
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+	})
+}
+
+// suggestionDays is how far either side of the requested dates alternatives are searched for
+const suggestionDays = 7
+
+// maxSuggestions is the most alternative windows or split stays offered
+const maxSuggestions = 3
+
+type flexibleCell struct {
+	Free      int
+	StartDate string
+	EndDate   string
+}
+
+type flexibleRow struct {
+	Room  models.Room
+	Cells []flexibleCell
+}
+
+// FlexibleAvailability renders a month grid of free units per room and night
+func (m *Repository) FlexibleAvailability(w http.ResponseWriter, r *http.Request) {
+	property := helpers.CurrentProperty(r)
+
+	month := dates.FirstOfMonth(dates.Today(dates.Location(property.Timezone)))
+	if r.URL.Query().Get("m") != "" {
+		t, err := time.ParseInLocation("2006-01", r.URL.Query().Get("m"), time.UTC)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't parse month!")
+			http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+			return
+		}
+		month = t
+	}
+
+	adults, children, err := parseGuests(r.URL.Query().Get("adults"), r.URL.Query().Get("children"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "invalid number of guests!")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	grid, err := m.nightlyGrid(property.ID, adults+children, month, month.AddDate(0, 1, 0))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	var rows []flexibleRow
+	for _, room := range grid.Rooms {
+		row := flexibleRow{Room: room}
+		for _, night := range grid.Nights {
+			row.Cells = append(row.Cells, flexibleCell{
+				Free:      grid.Free(room.ID, night),
+				StartDate: night.Format(dates.Layout),
+				EndDate:   night.AddDate(0, 0, 1).Format(dates.Layout),
+			})
+		}
+		rows = append(rows, row)
+	}
+
+	data := make(map[string]interface{})
+	data["month"] = month
+	data["rows"] = rows
+
+	stringMap := make(map[string]string)
+	stringMap["last_month"] = month.AddDate(0, -1, 0).Format("2006-01")
+	stringMap["next_month"] = month.AddDate(0, 1, 0).Format("2006-01")
+
+	intMap := make(map[string]int)
+	intMap["days_in_month"] = len(grid.Nights)
+	intMap["adults"] = adults
+	intMap["children"] = children
+
+	render.Template(w, r, "availability-grid.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
 	})
 }
 
+// parseGuests reads the adults and children counts of a form, blank adults
+// means one adult and blank children means none
+func parseGuests(adults, children string) (int, int, error) {
+	a, c := 1, 0
+	var err error
+
+	if strings.TrimSpace(adults) != "" {
+		a, err = strconv.Atoi(strings.TrimSpace(adults))
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	if strings.TrimSpace(children) != "" {
+		c, err = strconv.Atoi(strings.TrimSpace(children))
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if a < 1 || c < 0 {
+		return 0, 0, errors.New("invalid number of guests")
+	}
+
+	return a, c, nil
+}
+
 type jsonResponse struct {
 	OK        bool   `json:"ok"`
 	Message   string `json:"message"`
@@ -156,21 +379,18 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	sd := r.Form.Get("start")
 	ed := r.Form.Get("end")
 
-	layout := "2006-01-02"
-
-	start_date, err := time.Parse(layout, sd)
+	start_date, err := dates.Parse(sd)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 	}
-	end_date, err := time.Parse(layout, ed)
+	end_date, err := dates.Parse(ed)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 	}
 
 	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
-	log.Println(roomID)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 	}
 
 	available, err := m.DB.SearchAvailabilityByDatesByRoomID(roomID, start_date, end_date)
@@ -184,9 +404,26 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 		w.Write(out)
 		return
 	}
+
+	message := ""
+	if available {
+		property := helpers.CurrentProperty(r)
+		rules, err := m.DB.GetStayRulesForArrival(property.ID, start_date)
+		if err != nil {
+			writeJSON(w, jsonResponse{OK: false, Message: "Error Querying Database"})
+			return
+		}
+
+		err = stayrules.Check(stayrules.ForRoom(rules, roomID), start_date, end_date, dates.Today(dates.Location(property.Timezone)))
+		if err != nil {
+			available = false
+			message = err.Error()
+		}
+	}
+
 	resp := jsonResponse{
 		OK:        available,
-		Message:   "",
+		Message:   message,
 		StartDate: sd,
 		EndDate:   ed,
 		RoomID:    strconv.Itoa(roomID),
@@ -197,6 +434,115 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	w.Write(out)
 }
 
+// maxCalendarNights is the longest range the room availability calendar returns at once
+const maxCalendarNights = 366
+
+// nightlyGrid returns the free units per room and night of a property, from the availability
+// cache when the same range was asked for since the last change to reservations or blocks
+func (m *Repository) nightlyGrid(propertyID, guests int, start, end time.Time) (availability.Grid, error) {
+	key := fmt.Sprintf("grid:%d:%d:%s:%s", propertyID, guests, start.Format(dates.Layout), end.Format(dates.Layout))
+	if x, ok := m.App.AvailabilityCache.Get(key); ok {
+		return x.(availability.Grid), nil
+	}
+
+	nights, err := m.DB.GetNightlyAvailability(propertyID, guests, start, end)
+	if err != nil {
+		return availability.Grid{}, err
+	}
+
+	grid := availability.NewGrid(start, end, nights)
+	m.App.AvailabilityCache.Set(key, grid)
+	return grid, nil
+}
+
+// restrictionsChanged drops cached availability after reservations or blocks change
+func (m *Repository) restrictionsChanged() {
+	m.App.AvailabilityCache.Flush()
+}
+
+type roomNightJSON struct {
+	Date      string `json:"date"`
+	Available bool   `json:"available"`
+	Price     int    `json:"price,omitempty"`
+}
+
+type roomAvailabilityResponse struct {
+	OK        bool            `json:"ok"`
+	Message   string          `json:"message"`
+	RoomID    int             `json:"room_id"`
+	StartDate string          `json:"start_date"`
+	EndDate   string          `json:"end_date"`
+	Nights    []roomNightJSON `json:"nights"`
+}
+
+// RoomAvailabilityJSON returns whether a room can be booked on each night of a range, with
+// its nightly price, so date pickers can grey out booked nights
+func (m *Repository) RoomAvailabilityJSON(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, roomAvailabilityResponse{OK: false, Message: "Invalid room"})
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil || room.PropertyID != property.ID {
+		writeJSON(w, roomAvailabilityResponse{OK: false, Message: "Can't find room"})
+		return
+	}
+
+	startDate := dates.Today(dates.Location(property.Timezone))
+	if r.URL.Query().Get("start") != "" {
+		startDate, err = dates.Parse(r.URL.Query().Get("start"))
+		if err != nil {
+			writeJSON(w, roomAvailabilityResponse{OK: false, Message: "Invalid start date"})
+			return
+		}
+	}
+
+	endDate := startDate.AddDate(0, 1, 0)
+	if r.URL.Query().Get("end") != "" {
+		endDate, err = dates.Parse(r.URL.Query().Get("end"))
+		if err != nil {
+			writeJSON(w, roomAvailabilityResponse{OK: false, Message: "Invalid end date"})
+			return
+		}
+	}
+
+	if nights := dates.Nights(startDate, endDate); nights <= 0 || nights > maxCalendarNights {
+		writeJSON(w, roomAvailabilityResponse{
+			OK:      false,
+			Message: fmt.Sprintf("End date must be after start date and at most %d nights later", maxCalendarNights),
+		})
+		return
+	}
+
+	grid, err := m.nightlyGrid(property.ID, 0, startDate, endDate)
+	if err != nil {
+		writeJSON(w, roomAvailabilityResponse{OK: false, Message: "Error Querying Database"})
+		return
+	}
+
+	resp := roomAvailabilityResponse{
+		OK:        true,
+		RoomID:    room.ID,
+		StartDate: startDate.Format(dates.Layout),
+		EndDate:   endDate.Format(dates.Layout),
+		Nights:    []roomNightJSON{},
+	}
+
+	for _, night := range grid.Nights {
+		resp.Nights = append(resp.Nights, roomNightJSON{
+			Date:      night.Format(dates.Layout),
+			Available: grid.Free(room.ID, night) > 0,
+			Price:     room.Price,
+		})
+	}
+
+	writeJSON(w, resp)
+}
+
 // Contact renders the contact page
 func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
@@ -212,27 +558,74 @@ func (m *Repository) MakeReservation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	room, err := m.DB.GetRoomByID(res.RoomID)
-	if err != nil {
+	if err != nil || room.PropertyID != helpers.CurrentProperty(r).ID {
 		m.App.Session.Put(r.Context(), "error", "can't get find room!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	res.Room.RoomName = room.RoomName
+	if res.Adults == 0 {
+		res.Adults = 1
+	}
+
+	// guests logged in to their account don't have to type in their details again
+	if accountID := helpers.GuestAccountID(r); accountID > 0 && res.Email == "" {
+		user, err := m.DB.GetUserByID(accountID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		res.FirstName = user.FirstName
+		res.LastName = user.LastName
+		res.Email = user.Email
+		res.Phone = user.Phone
+	}
+
+	res.Room = room
+	quote, err := m.quoteFor(room.PropertyID, res)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get taxes from database")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	res.Total = quote.Total
+
+	// keep a unit of the room for the guest while they fill in the form
+	hold, err := m.holdRoom(r, res)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't hold the room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if hold.ID == 0 {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room is no longer available for your dates")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "reservation", res)
-	layout := "2006-01-02"
-	sd := res.StartDate.Format(layout)
-	ed := res.EndDate.Format(layout)
+	sd := res.StartDate.Format(dates.Layout)
+	ed := res.EndDate.Format(dates.Layout)
 
 	stringMap := make(map[string]string)
 	stringMap["start_date"] = sd
 	stringMap["end_date"] = ed
+	stringMap["cancellation_policy"] = cancellation.Describe(room.CancellationPolicy)
+	intMap := make(map[string]int)
+	intMap["hold_seconds"] = int(holds.Remaining(hold, time.Now()).Seconds())
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["quote"] = quote
+	err = m.addExtras(data, room.PropertyID, nil)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 		Form:      forms.New(nil),
 		Data:      data,
 		StringMap: stringMap,
+		IntMap:    intMap,
 	})
 }
 
@@ -250,16 +643,14 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 
 	// 2020-01-01 -- 01/02 03:04:05PM '06 -0700
 
-	layout := "2006-01-02"
-
-	startDate, err := time.Parse(layout, sd)
+	startDate, err := dates.Parse(sd)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't parse start date")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	endDate, err := time.Parse(layout, ed)
+	endDate, err := dates.Parse(ed)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't get parse end date")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -267,8 +658,8 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	stringMap := make(map[string]string)
-	stringMap["start_date"] = startDate.Format(layout)
-	stringMap["end_date"] = endDate.Format(layout)
+	stringMap["start_date"] = startDate.Format(dates.Layout)
+	stringMap["end_date"] = endDate.Format(dates.Layout)
 
 	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
 	if err != nil {
@@ -277,76 +668,542 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	property := helpers.CurrentProperty(r)
+
 	room, err := m.DB.GetRoomByID(roomID)
-	if err != nil {
+	if err != nil || room.PropertyID != property.ID {
 		m.App.Session.Put(r.Context(), "error", "cannot get room id")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	reservation := models.Reservation{
-		FirstName: r.Form.Get("first_name"),
-		LastName:  r.Form.Get("last_name"),
-		Phone:     r.Form.Get("phone"),
-		Email:     r.Form.Get("email"),
+	form := forms.New(r.PostForm)
+
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	if form.Has("adults") {
+		form.IntRange("adults", 1, room.MaxOccupancy)
+	}
+	if form.Has("children") {
+		form.IntRange("children", 0, room.MaxOccupancy)
+	}
+
+	adults, children, err := parseGuests(r.Form.Get("adults"), r.Form.Get("children"))
+	if err == nil && adults+children > room.MaxOccupancy {
+		form.Errors.Add("children", fmt.Sprintf("This room sleeps at most %d guests.", room.MaxOccupancy))
+	}
+
+	rules, err := m.DB.GetStayRulesForArrival(property.ID, startDate)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get stay rules from database")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	err = stayrules.Check(stayrules.ForRoom(rules, roomID), startDate, endDate, dates.Today(dates.Location(property.Timezone)))
+	var ruleErr *stayrules.Error
+	if errors.As(err, &ruleErr) {
+		form.Errors.Add(ruleErr.Field, ruleErr.Message)
+	}
+
+	taxes, err := m.DB.AllTaxFees(property.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get taxes from database")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	items, err := m.extrasFromForm(r, property.ID, models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
-		RoomID:    roomID,
+		Adults:    adults,
+		Children:  children,
+	})
+	var extraErr *extras.Error
+	switch {
+	case errors.As(err, &extraErr):
+		form.Errors.Add("extras", extraErr.Message)
+	case err != nil:
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	quote := pricing.ForStay(pricing.Stay{
 		Room:      room,
+		StartDate: startDate,
+		EndDate:   endDate,
+		Adults:    adults,
+		Children:  children,
+		Taxes:     taxes,
+		Extras:    extras.Lines(items),
+	})
+
+	// an invalid promo code is sent back to the guest like any other mistake on the form
+	var promo models.PromoCode
+	discount := 0
+	if form.Has("promo_code") {
+		promo, err = m.promoCode(property, r.Form.Get("promo_code"), r.Form.Get("email"), promos.Booking{
+			Today:     dates.Today(dates.Location(property.Timezone)),
+			StartDate: startDate,
+			EndDate:   endDate,
+			RoomID:    roomID,
+		})
+		var promoErr *promos.Error
+		switch {
+		case errors.As(err, &promoErr):
+			form.Errors.Add("promo_code", promoErr.Message)
+		case err != nil:
+			helpers.ServerError(w, r, err)
+			return
+		default:
+			discount = promos.Discount(promo, quote.Total)
+			quote.ApplyDiscount("Promo code "+promo.Code, discount)
+		}
 	}
 
-	form := forms.New(r.PostForm)
+	reservation := models.Reservation{
+		FirstName:   r.Form.Get("first_name"),
+		LastName:    r.Form.Get("last_name"),
+		Phone:       r.Form.Get("phone"),
+		Email:       r.Form.Get("email"),
+		StartDate:   startDate,
+		EndDate:     endDate,
+		RoomID:      roomID,
+		Adults:      adults,
+		Children:    children,
+		Total:       quote.Total,
+		Room:        room,
+		PromoCodeID: promo.ID,
+		PromoCode:   promo.Code,
+		Discount:    discount,
+		Extras:      items,
+
+		// later changes to the room's policy don't apply to reservations already made
+		CancellationPolicy: room.CancellationPolicy,
+	}
 
-	form.Required("first_name", "last_name", "email")
-	form.MinLength("first_name", 3)
-	form.IsEmail("email")
+	hold, held := m.currentHold(r)
+	held = held && holds.Covers(hold, roomID, startDate, endDate, time.Now())
 
 	if !form.Valid() {
+		stringMap["cancellation_policy"] = cancellation.Describe(room.CancellationPolicy)
+		intMap := make(map[string]int)
+		if held {
+			intMap["hold_seconds"] = int(holds.Remaining(hold, time.Now()).Seconds())
+		}
 		data := make(map[string]interface{})
 		data["reservation"] = reservation
+		data["quote"] = quote
+		err = m.addExtras(data, property.ID, nil)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
 		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 			Form:      form,
 			Data:      data,
 			StringMap: stringMap,
+			IntMap:    intMap,
 		})
 		return
 	}
-	var newReservationID int
-	newReservationID, err = m.DB.InsertReservation(reservation)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert reservation into database")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	// the unit held for the guest is theirs, otherwise pick a free unit of the room type for
+	// the whole stay
+	unitID := hold.RoomUnitID
+	if !held {
+		m.releaseHold(r)
+		unitID, err = m.DB.FindAvailableUnit(roomID, startDate, endDate)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't assign a room")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	}
+	if unitID == 0 {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room is no longer available for your dates")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
+	reservation.RoomUnitID = unitID
 
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		ReservationID: newReservationID,
-		RoomID:        reservation.RoomID,
-		RestrictionID: 1,
+	reservation.ManageToken, err = newToken()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
 	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	// guests are told apart by email, a returning guest's details are updated to the latest
+	reservation.GuestID, err = m.DB.UpsertGuest(models.Guest{
+		PropertyID: property.ID,
+		FirstName:  reservation.FirstName,
+		LastName:   reservation.LastName,
+		Email:      reservation.Email,
+		Phone:      reservation.Phone,
+	})
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert room restriction into database")
+		m.App.Session.Put(r.Context(), "error", "can't save guest into database")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	// send notifications - first to guest
+	// a reservation with something to pay only keeps its unit while the guest pays, and is
+	// confirmed once the payment is taken
+	due := payments.AmountDue(property.PaymentPolicy, property.DepositPercent, reservation.Total)
+	if due > 0 {
+		reservation.PendingUntil = time.Now().Add(holds.PaymentDuration)
+	}
 
-	htmlMessageToGuest := fmt.Sprintf(`
-	<strong>Reservation Confirmation</strong> <br>
-	Dear %s,
-	
-	Thank you for your reservation. 
-	This is confirmation email. 
-	Your reservation is set from %s to %s.`, reservation.FirstName, reservation.StartDate, reservation.EndDate)
+	// the unit is only given to the reservation if nobody else took it since it was picked, the
+	// guest's hold on it is swapped for the reservation
+	holdID := 0
+	if held {
+		holdID = hold.ID
+	}
 
-	msgToGuest := models.MailData{
-		To:       reservation.Email,
-		From:     "reservationservice@sc.com",
+	var newReservationID int
+	newReservationID, err = m.DB.InsertReservationWithRestriction(reservation, holdID)
+	if errors.Is(err, repository.ErrUnavailable) {
+		m.releaseHold(r)
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room is no longer available for your dates")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't insert reservation into database")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	m.releaseHold(r)
+	m.restrictionsChanged()
+
+	reservation.ID = newReservationID
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+	m.waitlistBooked(r, newReservationID)
+
+	// rooms without a price have nothing to pay, so skip the checkout
+	if due <= 0 {
+		m.sendConfirmation(property, reservation, 0)
+		http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/checkout", http.StatusSeeOther)
+}
+
+// holdRoom holds a unit of the reservation's room for the guest while they fill in the
+// reservation form. A hold the guest already has for the same stay is kept as it is, otherwise
+// it is released and a new one is made. The hold has a zero id if every unit is taken.
+func (m *Repository) holdRoom(r *http.Request, res models.Reservation) (models.RoomRestriction, error) {
+	now := time.Now()
+	hold, ok := m.currentHold(r)
+	if ok && holds.Covers(hold, res.RoomID, res.StartDate, res.EndDate, now) {
+		return hold, nil
+	}
+	m.releaseHold(r)
+
+	unitID, err := m.DB.FindAvailableUnit(res.RoomID, res.StartDate, res.EndDate)
+	if err != nil || unitID == 0 {
+		return models.RoomRestriction{}, err
+	}
+
+	hold = models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomID:        res.RoomID,
+		RoomUnitID:    unitID,
+		RestrictionID: models.RestrictionHold,
+		ExpiresAt:     now.Add(holds.Duration),
+	}
+	// someone else may have taken the unit since it was found
+	hold.ID, err = m.DB.InsertHold(hold)
+	if errors.Is(err, repository.ErrUnavailable) {
+		return models.RoomRestriction{}, nil
+	}
+	if err != nil {
+		return models.RoomRestriction{}, err
+	}
+	m.restrictionsChanged()
+
+	m.App.Session.Put(r.Context(), "hold_id", hold.ID)
+	return hold, nil
+}
+
+// currentHold returns the hold kept in the guest's session, and false if they have none or it
+// has already been released
+func (m *Repository) currentHold(r *http.Request) (models.RoomRestriction, bool) {
+	id := m.App.Session.GetInt(r.Context(), "hold_id")
+	if id == 0 {
+		return models.RoomRestriction{}, false
+	}
+
+	hold, err := m.DB.GetRoomRestrictionByID(id)
+	if err != nil {
+		return models.RoomRestriction{}, false
+	}
+	return hold, true
+}
+
+// releaseHold releases the hold kept in the guest's session, if any
+func (m *Repository) releaseHold(r *http.Request) {
+	id := m.App.Session.PopInt(r.Context(), "hold_id")
+	if id == 0 {
+		return
+	}
+
+	err := m.DB.ReleaseHold(id)
+	if err != nil {
+		helpers.Logger(r).Error("releasing hold", "hold_id", id, "error", err)
+		return
+	}
+	m.restrictionsChanged()
+}
+
+// ReleaseExpiredHolds releases the holds of guests who didn't book in time and cancels the
+// reservations that weren't paid for in time, and offers the nights they free up to the
+// waitlists of their properties
+func (m *Repository) ReleaseExpiredHolds() {
+	propertyIDs, err := m.DB.ReleaseExpiredHolds(time.Now())
+	if err != nil {
+		m.App.Logger.Error("releasing expired holds", "error", err)
+		return
+	}
+	if len(propertyIDs) == 0 {
+		return
+	}
+	m.restrictionsChanged()
+
+	for _, id := range propertyIDs {
+		property, err := m.DB.GetPropertyByID(id)
+		if err != nil {
+			m.App.Logger.Error("getting property to notify waitlist", "property_id", id, "error", err)
+			continue
+		}
+		m.notifyWaitlist(property)
+	}
+}
+
+// quoteFor prices the stay of a reservation in its room with the taxes and fees of the property
+func (m *Repository) quoteFor(propertyID int, res models.Reservation) (pricing.Quote, error) {
+	taxes, err := m.DB.AllTaxFees(propertyID)
+	if err != nil {
+		return pricing.Quote{}, err
+	}
+
+	quote := pricing.ForStay(pricing.Stay{
+		Room:      res.Room,
+		StartDate: res.StartDate,
+		EndDate:   res.EndDate,
+		Adults:    res.Adults,
+		Children:  res.Children,
+		Taxes:     taxes,
+		Extras:    extras.Lines(res.Extras),
+	})
+	quote.ApplyDiscount("Promo code "+res.PromoCode, res.Discount)
+
+	return quote, nil
+}
+
+// repriceStay prices a reservation again after its dates or room changed, at the room's current
+// rates. Extras charged by the night are charged for the new nights at the price they were booked
+// at, and the promo code discount stays as it was given.
+func (m *Repository) repriceStay(propertyID int, res models.Reservation) (models.Reservation, error) {
+	room, err := m.DB.GetRoomByID(res.RoomID)
+	if err != nil {
+		return res, err
+	}
+
+	nights := dates.Nights(res.StartDate, res.EndDate)
+	items := make([]models.ReservationExtra, 0, len(res.Extras))
+	for _, item := range res.Extras {
+		if item.Pricing == extras.PerNight || item.Pricing == extras.PerGuestNight {
+			item.Nights = nights
+			item.Amount = item.Quantity * nights * item.UnitPrice
+		}
+		items = append(items, item)
+	}
+
+	priced := res
+	priced.Room.Price = room.Price
+	priced.Room.IncludedGuests = room.IncludedGuests
+	priced.Room.ExtraGuestPrice = room.ExtraGuestPrice
+	priced.Extras = items
+
+	quote, err := m.quoteFor(propertyID, priced)
+	if err != nil {
+		return res, err
+	}
+
+	res.Extras = items
+	res.Total = quote.Total
+	return res, nil
+}
+
+// addExtras adds the extras the property sells to the data of a page, with how each is priced
+// and how many of each are booked
+func (m *Repository) addExtras(data map[string]interface{}, propertyID int, booked []models.ReservationExtra) error {
+	list, err := m.DB.AllExtras(propertyID)
+	if err != nil {
+		return err
+	}
+
+	prices := make(map[int]string)
+	for _, e := range list {
+		prices[e.ID] = extras.Describe(e)
+	}
+
+	quantities := make(map[int]int)
+	for _, item := range booked {
+		quantities[item.ExtraID] += item.Quantity
+	}
+
+	data["extras"] = list
+	data["extra_prices"] = prices
+	data["extra_quantities"] = quantities
+	return nil
+}
+
+// extrasFromForm prices the extras chosen on a form for the stay of res, the quantity of each
+// is posted as extra_<id>. Stock booked by res itself doesn't count against it, and extras it
+// already has at the same quantity keep the price they were booked at. It returns an
+// *extras.Error for the first extra that can't be booked.
+func (m *Repository) extrasFromForm(r *http.Request, propertyID int, res models.Reservation) ([]models.ReservationExtra, error) {
+	list, err := m.DB.AllExtras(propertyID)
+	if err != nil {
+		return nil, err
+	}
+
+	nights := dates.Nights(res.StartDate, res.EndDate)
+	var items []models.ReservationExtra
+
+	for _, e := range list {
+		field := strings.TrimSpace(r.Form.Get(fmt.Sprintf("extra_%d", e.ID)))
+		if field == "" {
+			continue
+		}
+
+		quantity, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, &extras.Error{Message: "Invalid quantity of " + e.Name}
+		}
+		if quantity == 0 {
+			continue
+		}
+
+		booked, err := m.DB.ExtraBooked(e.ID, res.StartDate, res.EndDate, res.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		err = extras.Check(e, quantity, res.Adults+res.Children, booked)
+		if err != nil {
+			return nil, err
+		}
+
+		item := extras.Price(e, quantity, nights)
+		for _, old := range res.Extras {
+			if old.ExtraID == e.ID && old.Quantity == quantity && old.Nights == nights {
+				item = old
+			}
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// promoCode looks up the promo code a guest entered and checks it can be used on the booking,
+// it returns a *promos.Error when it can't
+func (m *Repository) promoCode(property models.Property, code, email string, b promos.Booking) (models.PromoCode, error) {
+	promo, err := m.DB.GetPromoCode(property.ID, promos.Normalize(code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return promo, promos.ErrUnknown
+	}
+	if err != nil {
+		return promo, err
+	}
+
+	b.Uses, b.EmailUses, err = m.DB.PromoCodeUses(promo.ID, email)
+	if err != nil {
+		return promo, err
+	}
+
+	return promo, promos.Check(promo, b)
+}
+
+// newToken returns a random token for the links emailed to guests, to manage their reservation
+// or log in with
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken returns the hash of a token that is stored in its place
+func hashToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// siteURL returns the absolute link to path on the site of a property, for emails
+func (m *Repository) siteURL(property models.Property, path string) string {
+	scheme := "http"
+	if m.App.InProduction {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, property.Hostname, path)
+}
+
+// manageURL returns the link guests cancel their reservation with
+func (m *Repository) manageURL(property models.Property, token string) string {
+	return m.siteURL(property, "/reservations/"+token+"/cancel")
+}
+
+// reservationSourceDirect is where reservations guests booked on the site itself are counted
+// as created from, those imported from channels are counted under the name of the channel
+const reservationSourceDirect = "direct"
+
+// sendConfirmation emails the guest and the property owner once a reservation has been paid for
+func (m *Repository) sendConfirmation(property models.Property, reservation models.Reservation, paid int) {
+	m.App.Metrics.ReservationsCreated.Inc(reservationSourceDirect)
+	m.emitWebhook(property.ID, webhooks.EventReservationCreated, webhooks.ReservationData(reservation))
+
+	// the breakdown is a courtesy, so a confirmation still goes out without it
+	breakdown := ""
+	quote, err := m.quoteFor(property.ID, reservation)
+	if err != nil {
+		m.App.Logger.Warn("quoting confirmation breakdown", "reservation_id", reservation.ID, "error", err)
+	} else {
+		breakdown = quoteHTML(quote)
+	}
+
+	// send notifications - first to guest
+	htmlMessageToGuest := fmt.Sprintf(`
+	<strong>Reservation Confirmation</strong> <br>
+	Dear %s,
+	
+	Thank you for your reservation. 
+	This is confirmation email. 
+	Your reservation is set from %s (check-in from %s) to %s (check-out until %s)
+	for %d adult(s) and %d child(ren). The total for your stay is %s, 
+	of which you have paid %s and %s is due on arrival.<br>
+	%s
+	%s You can cancel your reservation at <a href="%s">%s</a>`,
+		reservation.FirstName,
+		reservation.StartDate.Format(dates.Layout), property.CheckInTime,
+		reservation.EndDate.Format(dates.Layout), property.CheckOutTime,
+		reservation.Adults, reservation.Children, pricing.FormatMoney(reservation.Total),
+		pricing.FormatMoney(paid), pricing.FormatMoney(reservation.Total-paid), breakdown,
+		cancellation.Describe(reservation.CancellationPolicy),
+		m.manageURL(property, reservation.ManageToken), m.manageURL(property, reservation.ManageToken))
+
+	msgToGuest := models.MailData{
+		To:       reservation.Email,
+		From:     property.SenderEmail,
 		Subject:  "Reservation Confirmation",
 		Content:  htmlMessageToGuest,
 		Template: "basic.html",
@@ -363,520 +1220,5051 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	<li>Email Address: %s,</li>
 	<li>Phone Number: %s,</li>
 	<li>Starting date: %s</li>
-	<li>Ending date: %s<li>
+	<li>Ending date: %s</li>
+	<li>Guests: %d adult(s), %d child(ren)</li>
+	<li>Total: %s</li>
+	<li>Paid: %s</li>
 	</ol>
 
-	`, reservation.FirstName, reservation.LastName, reservation.Room.RoomName, reservation.Email, reservation.Phone, reservation.StartDate, reservation.EndDate)
+	`, reservation.FirstName, reservation.LastName, reservation.Room.RoomName, reservation.Email, reservation.Phone,
+		reservation.StartDate.Format(dates.Layout), reservation.EndDate.Format(dates.Layout),
+		reservation.Adults, reservation.Children, pricing.FormatMoney(reservation.Total), pricing.FormatMoney(paid))
 	msgToOwner := models.MailData{
-		To:      "owner@sc.com",
-		From:    "reservationservice@sc.com",
+		To:      property.NotificationEmail,
+		From:    property.SenderEmail,
 		Subject: "New Reservation Confirmation",
 		Content: htmlMessageToOwner,
 	}
 
 	m.App.MailChan <- msgToOwner
+}
 
-	m.App.Session.Put(r.Context(), "reservation", reservation)
+// quoteHTML lists the lines of a quote, and the taxes included in them, for an email
+func quoteHTML(q pricing.Quote) string {
+	var b strings.Builder
+	b.WriteString("<ul>")
+	for _, l := range q.Lines {
+		fmt.Fprintf(&b, "<li>%s: %s</li>", html.EscapeString(l.Description), pricing.FormatMoney(l.Amount))
+	}
+	for _, l := range q.Included {
+		fmt.Fprintf(&b, "<li>includes %s: %s</li>", html.EscapeString(l.Description), pricing.FormatMoney(l.Amount))
+	}
+	b.WriteString("</ul>")
+	return b.String()
+}
 
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+// amountPaid returns the sum of the captured payments less what has been refunded
+func amountPaid(ps []models.Payment) int {
+	paid := 0
+	for _, p := range ps {
+		switch {
+		case p.Kind == payments.KindRefund && p.Status == payments.StatusRefunded:
+			paid -= p.Amount
+		case p.Kind != payments.KindRefund && p.Status == payments.StatusCaptured:
+			paid += p.Amount
+		}
+	}
+	return paid
 }
 
-// Displays a reservation summary page
-func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
-	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
-		m.App.ErrorLog.Println("Can't get reservation from session")
-		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+// Checkout renders the payment step between making a reservation and its summary
+func (m *Repository) Checkout(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok || res.ID == 0 {
+		m.App.Session.Put(r.Context(), "error", "can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	paid, err := m.DB.GetPaymentsForReservation(res.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if amountPaid(paid) > 0 {
+		http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+		return
+	}
+	if holds.Lapsed(res, time.Now()) {
+		m.reservationLapsed(w, r, lapsedMessage)
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+	due := payments.AmountDue(property.PaymentPolicy, property.DepositPercent, res.Total)
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format(dates.Layout)
+	stringMap["end_date"] = res.EndDate.Format(dates.Layout)
+	stringMap["gateway"] = m.App.Payments.Name()
+
+	intMap := make(map[string]int)
+	intMap["due"] = due
+	intMap["balance"] = res.Total - due
+	if !res.PendingUntil.IsZero() {
+		intMap["pending_minutes"] = int(math.Ceil(time.Until(res.PendingUntil).Minutes()))
+	}
+
+	render.Template(w, r, "checkout.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+	})
+}
+
+// PostCheckout takes the payment due for the reservation in the session and confirms it
+func (m *Repository) PostCheckout(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/checkout", http.StatusSeeOther)
+		return
+	}
+
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok || res.ID == 0 {
+		m.App.Session.Put(r.Context(), "error", "can't get reservation from session")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	m.App.Session.Remove(r.Context(), "reservation")
-	data := make(map[string]interface{})
-	data["reservation"] = reservation
+	paid, err := m.DB.GetPaymentsForReservation(res.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if amountPaid(paid) > 0 {
+		http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+		return
+	}
+	if holds.Lapsed(res, time.Now()) {
+		m.reservationLapsed(w, r, lapsedMessage)
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+	due := payments.AmountDue(property.PaymentPolicy, property.DepositPercent, res.Total)
+	gateway := m.App.Payments
+
+	payment := models.Payment{
+		ReservationID: res.ID,
+		Kind:          payments.Kind(due, res.Total),
+		Amount:        due,
+		Gateway:       gateway.Name(),
+	}
+
+	// the payment is recorded before the guest is charged, and a reservation only ever has one
+	// payment in progress, so submitting the form twice doesn't charge the guest twice
+	payment.ID, err = m.DB.StartPayment(payment)
+	if errors.Is(err, repository.ErrPaymentInProgress) {
+		m.App.Session.Put(r.Context(), "warning", "Your payment is already being taken")
+		http.Redirect(w, r, "/checkout", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	payment.Reference, err = m.takePayment(r, payments.Charge{
+		Amount:      due,
+		Token:       r.Form.Get("payment_token"),
+		Description: fmt.Sprintf("%s reservation %d", property.Name, res.ID),
+	})
+	payment.Status = payments.StatusCaptured
+	if err != nil {
+		payment.Status = payments.StatusFailed
+	}
+
+	// failed attempts are kept too, so they show on the admin reservation page
+	updateErr := m.DB.UpdatePayment(payment)
+	if updateErr != nil {
+		helpers.ServerError(w, r, updateErr)
+		return
+	}
+
+	if err != nil {
+		helpers.Logger(r).Error("taking payment", "error", err)
+		message := "We couldn't take your payment, please try again"
+		if errors.Is(err, payments.ErrDeclined) {
+			message = "Your payment was declined, please try another card"
+		}
+		m.App.Session.Put(r.Context(), "error", message)
+		http.Redirect(w, r, "/checkout", http.StatusSeeOther)
+		return
+	}
+
+	// the reservation may have run out while the payment was being taken, in which case the
+	// payment is given back
+	if !res.PendingUntil.IsZero() {
+		confirmed, err := m.DB.ConfirmReservation(res.ID, time.Now())
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		if !confirmed {
+			err = m.refund(res.ID, []models.Payment{payment}, due)
+			if err != nil {
+				helpers.Logger(r).Error("refunding lapsed reservation", "reservation_id", res.ID, "error", err)
+			}
+			m.reservationLapsed(w, r, "Sorry, your reservation ran out while your payment was being taken. "+
+				"Your payment has been refunded, please book again")
+			return
+		}
+		res.PendingUntil = time.Time{}
+		m.App.Session.Put(r.Context(), "reservation", res)
+	}
+
+	m.sendConfirmation(property, res, due)
+
+	m.App.Session.Put(r.Context(), "flash", "Payment received, your reservation is confirmed")
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
+
+// takePayment authorizes and captures a charge, returning the gateway's reference of the
+// payment. An authorization that can't be captured is voided, so the guest's money isn't held
+// and the payment can be tried again.
+func (m *Repository) takePayment(r *http.Request, c payments.Charge) (string, error) {
+	gateway := m.App.Payments
+
+	auth, err := gateway.Authorize(c)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = gateway.Capture(auth.Reference, c.Amount)
+	if err != nil {
+		_, voidErr := gateway.Void(auth.Reference)
+		if voidErr != nil {
+			helpers.Logger(r).Error("voiding uncaptured payment", "reference", auth.Reference, "error", voidErr)
+		}
+		return auth.Reference, err
+	}
+	return auth.Reference, nil
+}
+
+// lapsedMessage is what a guest is told when their reservation ran out before it was paid for
+const lapsedMessage = "Sorry, your reservation wasn't paid for in time and the room has been released, please book again"
+
+// reservationLapsed sends a guest whose reservation ran out before it was paid for back to book
+// again, with message telling them what happened
+func (m *Repository) reservationLapsed(w http.ResponseWriter, r *http.Request, message string) {
+	m.App.Session.Remove(r.Context(), "reservation")
+	m.App.Session.Put(r.Context(), "error", message)
+	http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+}
+
+// PaymentWebhook applies the payment status changes the gateway reports, such as refunds
+// made from its dashboard
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	gateway := m.App.Payments
+	event, err := gateway.VerifyWebhook(payload, r.Header.Get("X-Payment-Signature"))
+	if err != nil {
+		helpers.Logger(r).Warn("verifying payment webhook", "error", err)
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	switch event.Status {
+	case payments.StatusAuthorized, payments.StatusCaptured, payments.StatusRefunded, payments.StatusFailed:
+	default:
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	found, err := m.DB.UpdatePaymentStatusByReference(gateway.Name(), event.Reference, event.Status)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if !found {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// errRefundFailed is returned when a reservation was cancelled but the refund couldn't be made
+var errRefundFailed = errors.New("refund failed")
+
+// cancellationToday returns the day it is at a property
+func cancellationToday(property models.Property) time.Time {
+	return dates.Today(dates.Location(property.Timezone))
+}
+
+// addCancellationQuote adds the cancellation policy of a reservation, and what cancelling it
+// today would cost, to the string and int maps of a page
+func addCancellationQuote(stringMap map[string]string, intMap map[string]int, property models.Property,
+	res models.Reservation, paid []models.Payment) {
+	quote := cancellation.Calculate(res.CancellationPolicy, res.StartDate, cancellationToday(property),
+		res.Total, amountPaid(paid))
+
+	stringMap["cancellation_policy"] = cancellation.Describe(res.CancellationPolicy)
+	intMap["cancellation_fee"] = quote.Fee
+	intMap["refund"] = quote.Refund
+}
+
+// cancelReservation cancels a reservation under the policy it was made with, refunds what was
+// paid beyond the fee and lets the guest know. The cancellation stands when the refund fails, in
+// which case an error wrapping errRefundFailed is returned.
+func (m *Repository) cancelReservation(property models.Property, res models.Reservation, by string) (models.Reservation, error) {
+	paid, err := m.DB.GetPaymentsForReservation(res.ID)
+	if err != nil {
+		return res, err
+	}
+
+	quote := cancellation.Calculate(res.CancellationPolicy, res.StartDate, cancellationToday(property),
+		res.Total, amountPaid(paid))
+
+	res.CancelledAt = time.Now()
+	res.CancelledBy = by
+	res.CancellationFee = quote.Fee
+	res.Refund = quote.Refund
+
+	err = m.DB.CancelReservation(res)
+	if err != nil {
+		return res, err
+	}
+	m.restrictionsChanged()
+	m.notifyWaitlist(property)
+
+	refundErr := m.refund(res.ID, paid, quote.Refund)
+	m.emitWebhook(property.ID, webhooks.EventReservationCancelled, webhooks.ReservationData(res))
+
+	htmlMessage := fmt.Sprintf(`
+	<strong>Reservation Cancelled</strong> <br>
+	Dear %s,<br>
+	Your reservation from %s to %s has been cancelled.<br>
+	Cancellation fee: %s<br>
+	Refund: %s`, res.FirstName,
+		res.StartDate.Format(dates.Layout), res.EndDate.Format(dates.Layout),
+		pricing.FormatMoney(res.CancellationFee), pricing.FormatMoney(res.Refund))
+
+	m.App.MailChan <- models.MailData{
+		To:       res.Email,
+		From:     property.SenderEmail,
+		Subject:  "Reservation Cancelled",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+
+	if refundErr != nil {
+		return res, fmt.Errorf("%w: %v", errRefundFailed, refundErr)
+	}
+	return res, nil
+}
+
+// refund gives amount back to the guest out of the captured payments of a reservation, newest
+// first, and records each refund as a payment
+func (m *Repository) refund(reservationID int, paid []models.Payment, amount int) error {
+	gateway := m.App.Payments
+
+	refunded := make(map[string]int)
+	for _, p := range paid {
+		if p.Kind == payments.KindRefund && p.Status == payments.StatusRefunded {
+			refunded[p.Reference] += p.Amount
+		}
+	}
+
+	for i := len(paid) - 1; i >= 0 && amount > 0; i-- {
+		p := paid[i]
+		if p.Kind == payments.KindRefund || p.Status != payments.StatusCaptured || p.Gateway != gateway.Name() {
+			continue
+		}
+
+		n := p.Amount - refunded[p.Reference]
+		if n > amount {
+			n = amount
+		}
+		if n <= 0 {
+			continue
+		}
+
+		_, err := gateway.Refund(p.Reference, n)
+		if err != nil {
+			return err
+		}
+
+		_, err = m.DB.InsertPayment(models.Payment{
+			ReservationID: reservationID,
+			Kind:          payments.KindRefund,
+			Amount:        n,
+			Status:        payments.StatusRefunded,
+			Gateway:       gateway.Name(),
+			Reference:     p.Reference,
+		})
+		if err != nil {
+			return err
+		}
+		amount -= n
+	}
+
+	if amount > 0 {
+		return fmt.Errorf("%s of the refund is left to pay", pricing.FormatMoney(amount))
+	}
+	return nil
+}
+
+// reservationForToken returns the reservation of the current property a guest manages with the
+// token in the url, or responds with not found
+func (m *Repository) reservationForToken(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	res, err := m.DB.GetReservationByToken(chi.URLParam(r, "token"))
+	if err != nil || res.Room.PropertyID != helpers.CurrentProperty(r).ID {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return res, false
+	}
+	return res, true
+}
+
+// CancelReservation shows guests what cancelling their reservation costs, or that it is cancelled
+func (m *Repository) CancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationForToken(w, r)
+	if !ok {
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+
+	paid, err := m.DB.GetPaymentsForReservation(res.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format(dates.Layout)
+	stringMap["end_date"] = res.EndDate.Format(dates.Layout)
+
+	intMap := make(map[string]int)
+	intMap["paid"] = amountPaid(paid)
+	addCancellationQuote(stringMap, intMap, property, res, paid)
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["cancelled"] = !res.CancelledAt.IsZero()
+	data["arrived"] = !cancellationToday(property).Before(res.StartDate)
+
+	render.Template(w, r, "cancel-reservation.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+	})
+}
+
+// PostCancelReservation cancels a reservation for its guest, up to the day before arrival
+func (m *Repository) PostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationForToken(w, r)
+	if !ok {
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+	back := fmt.Sprintf("/reservations/%s/cancel", res.ManageToken)
+
+	if !res.CancelledAt.IsZero() {
+		m.App.Session.Put(r.Context(), "error", "This reservation is already cancelled")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if !cancellationToday(property).Before(res.StartDate) {
+		m.App.Session.Put(r.Context(), "error", "Reservations can't be cancelled online from the day of arrival, please contact us")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	_, err := m.cancelReservation(property, res, cancellation.ByGuest)
+	if errors.Is(err, errRefundFailed) {
+		helpers.Logger(r).Error("refunding cancelled reservation", "reservation_id", res.ID, "error", err)
+		m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled, we'll be in touch about your refund")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// Displays a reservation summary page
+func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		helpers.Logger(r).Warn("can't get reservation from session")
+		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Remove(r.Context(), "reservation")
+
+	paid, err := m.DB.GetPaymentsForReservation(reservation.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	quote, err := m.quoteFor(reservation.Room.PropertyID, reservation)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+	data["quote"] = quote
+
+	sd := reservation.StartDate.Format(dates.Layout)
+	ed := reservation.EndDate.Format(dates.Layout)
+
+	stringMap := make(map[string]string)
+
+	stringMap["start_date"] = sd
+	stringMap["end_date"] = ed
+	stringMap["cancellation_policy"] = cancellation.Describe(reservation.CancellationPolicy)
+
+	intMap := make(map[string]int)
+	intMap["paid"] = amountPaid(paid)
+	intMap["balance"] = reservation.Total - intMap["paid"]
+
+	render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+	})
+}
+
+// Displays a list of available rooms
+func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[2])
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	res.RoomID = roomID
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// BookRoom takes URL parameters, builds a sessional variable and takes use to make-reservation screen
+func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+	}
+	sd := r.URL.Query().Get("s")
+
+	ed := r.URL.Query().Get("e")
+
+	var res models.Reservation
+
+	res.RoomID = roomID
+	startDate, err := dates.Parse(sd)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+	}
+	endDate, err := dates.Parse(ed)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+	}
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil || room.PropertyID != helpers.CurrentProperty(r).ID {
+		m.App.Session.Put(r.Context(), "error", "Can't get room from db!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	adults, children, err := parseGuests(r.URL.Query().Get("a"), r.URL.Query().Get("c"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "invalid number of guests!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	res.StartDate = startDate
+	res.EndDate = endDate
+	res.Adults = adults
+	res.Children = children
+	res.Room.RoomName = room.RoomName
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// ShowLogin renders the login page
+func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostShowLogin handles logging the user in
+func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.RenewToken(r.Context())
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.Logger(r).Warn("parsing login form", "error", err)
+		return
+	}
+
+	email := r.Form.Get("email")
+	password := r.Form.Get("password")
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+	if !form.Valid() {
+		// Take user back to the login page
+		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	id, _, err := m.DB.Authenticate(email, password)
+	if err != nil {
+		helpers.Logger(r).Info("failed login", "email", email, "error", err)
+
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	// guest accounts log in to their account, not the admin tool
+	user, err := m.DB.GetUserByID(id)
+	if err != nil || user.AccessLevel < models.AccessLevelStaff {
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	m.logIn(r, user)
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+
+}
+
+// logIn starts the session of a user, whose access level decides whether they get into the admin
+// tool or their guest account
+func (m *Repository) logIn(r *http.Request, u models.User) {
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "user_id", u.ID)
+	m.App.Session.Put(r.Context(), "access_level", u.AccessLevel)
+}
+
+// Logout logs the user out, staff and guests alike
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	loginURL := "/user/login"
+	if helpers.GuestAccountID(r) > 0 {
+		loginURL = "/account/login"
+	}
+
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(w, r, loginURL, http.StatusSeeOther)
+}
+
+func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	total_reservations, err := m.DB.GetTotalReservations()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+	}
+	revenue_total := 0
+	room_ids, err := m.DB.GetRoomIDs()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+	}
+	for id := range room_ids {
+		var revenue int
+		revenue, err = m.DB.GetRevenueByRoom(id)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+		}
+		revenue_total += revenue
+	}
+	var todos []models.TodoData
+	todos, err = m.DB.GetToDoList()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+	}
+
+	data := make(map[string]interface{})
+	data["count"] = total_reservations
+	data["revenue"] = revenue_total
+	data["todos"] = todos
+
+	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// Shows all reservations in admin tool
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.AllReservations(helpers.CurrentProperty(r).ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+
+	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+
+}
+
+// Shows new reservations in admin tool
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.AllNewReservations(helpers.CurrentProperty(r).ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+
+}
+
+// AdminSelectProperty switches the property managed in the admin tool
+func (m *Repository) AdminSelectProperty(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	for _, p := range helpers.UserProperties(r) {
+		if p.ID == id {
+			m.App.Session.Put(r.Context(), "admin_property_id", id)
+			m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Now managing %s", p.Name))
+			http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "error", "You don't have access to this property")
+	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+}
+
+// AdminShowReservation shows the reservation in the admin tool
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	src := exploded[3]
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	stringMap["year"] = year
+	stringMap["month"] = month
+	property := helpers.CurrentProperty(r)
+
+	// Get reservation from the database
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if res.Room.PropertyID != property.ID {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	rooms, err := m.roomsWithUnits(property.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	paid, err := m.DB.GetPaymentsForReservation(res.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	stringMap["start_date"] = res.StartDate.Format(dates.Layout)
+	stringMap["end_date"] = res.EndDate.Format(dates.Layout)
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["rooms"] = rooms
+	data["payments"] = paid
+
+	if res.GuestID > 0 {
+		g, err := m.DB.GetGuestByID(res.GuestID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		data["guest"] = g
+		data["do_not_rebook"] = guests.HasTag(g, guests.TagDoNotRebook)
+	}
+	if res.GroupBookingID > 0 {
+		g, err := m.DB.GetGroupBookingByID(res.GroupBookingID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		data["group"] = g
+	}
+	err = m.addExtras(data, property.ID, res.Extras)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	intMap := make(map[string]int)
+	intMap["paid"] = amountPaid(paid)
+	intMap["balance"] = res.Total - intMap["paid"]
+	addCancellationQuote(stringMap, intMap, property, res, paid)
+
+	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		IntMap:    intMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostShowReservation posts the modified reservation in the admin tool
+func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	src := exploded[3]
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	year := r.Form.Get("year")
+	month := r.Form.Get("month")
+
+	stringMap["year"] = year
+	stringMap["month"] = month
+
+	property := helpers.CurrentProperty(r)
+
+	// Get reservation from the database
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if res.Room.PropertyID != property.ID {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if !res.CancelledAt.IsZero() {
+		m.App.Session.Put(r.Context(), "error", "This reservation has been cancelled")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", src, res.ID), http.StatusSeeOther)
+		return
+	}
+
+	oldStartDate := res.StartDate
+	oldEndDate := res.EndDate
+	oldUnitID := res.RoomUnitID
+
+	res.FirstName = r.Form.Get("first_name")
+	res.LastName = r.Form.Get("last_name")
+	res.Email = r.Form.Get("email")
+	res.Phone = r.Form.Get("phone")
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "start_date", "end_date", "room_unit_id")
+	form.IsEmail("email")
+
+	stringMap["start_date"] = r.Form.Get("start_date")
+	stringMap["end_date"] = r.Form.Get("end_date")
+
+	startDate, err := dates.Parse(r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid arrival date")
+	}
+	endDate, err := dates.Parse(r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Invalid departure date")
+	}
+	unitID, err := strconv.Atoi(r.Form.Get("room_unit_id"))
+	if err != nil {
+		form.Errors.Add("room_unit_id", "Invalid room")
+	}
+
+	if form.Valid() && !endDate.After(startDate) {
+		form.Errors.Add("end_date", "Departure must be after arrival")
+	}
+
+	stayChanged := !startDate.Equal(oldStartDate) || !endDate.Equal(oldEndDate) || unitID != oldUnitID
+
+	var unit models.RoomUnit
+	if form.Valid() && stayChanged {
+		unit, err = m.DB.GetRoomUnitByID(unitID)
+		if err != nil || unit.Room.PropertyID != property.ID {
+			form.Errors.Add("room_unit_id", "Invalid room")
+		}
+	}
+
+	if form.Valid() && stayChanged {
+		// make sure the new stay does not overlap other reservations or blocks on the unit
+		available, err := m.DB.SearchAvailabilityByDatesByUnitIDExcludingReservation(unitID, res.ID, startDate, endDate)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		if !available {
+			form.Errors.Add("start_date", "The room is not available for these dates")
+		}
+	}
+
+	if !form.Valid() {
+		rooms, err := m.roomsWithUnits(property.ID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		paid, err := m.DB.GetPaymentsForReservation(res.ID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		data := make(map[string]interface{})
+		data["reservation"] = res
+		data["rooms"] = rooms
+		data["payments"] = paid
+		err = m.addExtras(data, property.ID, res.Extras)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		intMap := make(map[string]int)
+		intMap["paid"] = amountPaid(paid)
+		intMap["balance"] = res.Total - intMap["paid"]
+		addCancellationQuote(stringMap, intMap, property, res, paid)
+
+		render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+			StringMap: stringMap,
+			Data:      data,
+			IntMap:    intMap,
+			Form:      form,
+		})
+		return
+	}
+
+	if stayChanged {
+		repriced := !startDate.Equal(oldStartDate) || !endDate.Equal(oldEndDate) || unit.RoomID != res.RoomID
+
+		res.StartDate = startDate
+		res.EndDate = endDate
+		res.RoomID = unit.RoomID
+		res.RoomUnitID = unit.ID
+		res.Room = unit.Room
+		res.RoomUnit = unit
+
+		if repriced {
+			res, err = m.repriceStay(property.ID, res)
+			if err != nil {
+				helpers.ServerError(w, r, err)
+				return
+			}
+		}
+	}
+
+	err = m.DB.UpdateReservation(res)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.restrictionsChanged()
+	m.notifyWaitlist(helpers.CurrentProperty(r))
+	m.emitWebhook(property.ID, webhooks.EventReservationUpdated, webhooks.ReservationData(res))
+
+	if stayChanged && form.Has("notify_guest") {
+		htmlMessage := fmt.Sprintf(`
+		<strong>Reservation Changed</strong> <br>
+		Dear %s,<br>
+		Your reservation has been changed.<br>
+		Room: %s<br>
+		Arrival: %s (check-in from %s)<br>
+		Departure: %s (check-out until %s)`, res.FirstName, res.Room.RoomName,
+			res.StartDate.Format(dates.Layout), property.CheckInTime,
+			res.EndDate.Format(dates.Layout), property.CheckOutTime)
+
+		msg := models.MailData{
+			To:       res.Email,
+			From:     property.SenderEmail,
+			Subject:  "Reservation Changed",
+			Content:  htmlMessage,
+			Template: "basic.html",
+		}
+
+		m.App.MailChan <- msg
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes Saved")
+
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calender?y=%s&m=%s", year, month), http.StatusSeeOther)
+
+	}
+}
+
+// roomsWithUnits returns the rooms of a property with their units filled in
+func (m *Repository) roomsWithUnits(propertyID int) ([]models.Room, error) {
+	rooms, err := m.DB.AllRooms(propertyID)
+	if err != nil {
+		return rooms, err
+	}
+
+	for i := range rooms {
+		rooms[i].Units, err = m.DB.GetUnitsByRoomID(rooms[i].ID)
+		if err != nil {
+			return rooms, err
+		}
+	}
+	return rooms, nil
+}
+
+// AdminReservationsCalender displays the reservation calender
+func (m *Repository) AdminReservationsCalender(w http.ResponseWriter, r *http.Request) {
+	// assume there is no month and year in the url parameters, and start from
+	// the current month in the property's time zone
+	loc := dates.Location(helpers.CurrentProperty(r).Timezone)
+	now := dates.FirstOfMonth(dates.Today(loc))
+
+	if r.URL.Query().Get("y") != "" {
+		year, err := strconv.Atoi(r.URL.Query().Get("y"))
+		if err != nil {
+			helpers.ServerError(w, r, err)
+		}
+		month, err := strconv.Atoi(r.URL.Query().Get("m"))
+		if err != nil {
+			helpers.ServerError(w, r, err)
+		}
+		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	data := make(map[string]interface{})
+	data["now"] = now
+
+	next := now.AddDate(0, 1, 0)
+	last := now.AddDate(0, -1, 0)
+
+	nextMonth := next.Format("01")
+	nextMonthYear := next.Format("2006")
+
+	lastMonth := last.Format("01")
+	lastMonthYear := last.Format("2006")
+
+	stringMap := make(map[string]string)
+	stringMap["next_month"] = nextMonth
+	stringMap["next_month_year"] = nextMonthYear
+
+	stringMap["last_month"] = lastMonth
+	stringMap["last_month_year"] = lastMonthYear
+
+	stringMap["this_month"] = now.Format("01")
+	stringMap["this_month_year"] = now.Format("2006")
+
+	// get the first and last days of the month
+	firstOfMonth := dates.FirstOfMonth(now)
+	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+	intMap := make(map[string]int)
+	intMap["days_in_month"] = lastOfMonth.Day()
+
+	rooms, err := m.roomsWithUnits(helpers.CurrentProperty(r).ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	data["rooms"] = rooms
+
+	for _, room := range rooms {
+		for _, x := range room.Units {
+			// create maps
+			reservationMap := make(map[string]int)
+			blockMap := make(map[string]int)
+
+			for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
+				reservationMap[d.Format("2006-01-2")] = 0
+				blockMap[d.Format("2006-01-2")] = 0
+			}
+
+			// get all the restrictions for the current unit
+			restrictions, err := m.DB.GetRestrictionsForUnitByDate(x.ID, firstOfMonth, lastOfMonth)
+			if err != nil {
+				helpers.ServerError(w, r, err)
+				return
+			}
+
+			for _, y := range restrictions {
+				if y.ReservationID > 0 {
+					// if it's a reservation, mark every night of the stay; the departure day is free
+
+					for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+						reservationMap[d.Format("2006-01-2")] = y.ReservationID
+					}
+
+				} else if y.RestrictionID != models.RestrictionHold {
+					// if it's a block; holds of guests booking online run out on their own
+					blockMap[y.StartDate.Format("2006-01-2")] = y.ID
+				}
+			}
+			data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
+			data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+
+			m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+		}
+	}
+
+	render.Template(w, r, "admin-reservations-calender.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		IntMap:    intMap,
+	})
+
+}
+
+// AdminPostReservationsCalender handles post of reservation calender
+func (m *Repository) AdminPostReservationsCalender(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	year, _ := strconv.Atoi(r.Form.Get("y"))
+	month, _ := strconv.Atoi(r.Form.Get("m"))
+
+	// process blocks
+	rooms, err := m.roomsWithUnits(helpers.CurrentProperty(r).ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	units := make(map[int]bool)
+
+	for _, room := range rooms {
+		for _, x := range room.Units {
+			units[x.ID] = true
+
+			// get the block map from the session
+			// loop through entire map, if there is an entry in the map that does not exist
+			// in a posted data and the restriction id > 0 then it is a block needed to be removed
+			curMap, ok := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
+			if !ok {
+				continue
+			}
+			for name, value := range curMap {
+				// ok will be false if the value is not in the map
+				if val, ok := curMap[name]; ok {
+					// only pay attention to values > 0, and that are not in the form post
+					// the rest are just placeholders for days without blocks
+					if val > 0 {
+						if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
+							// delete restriction by id, after getting its dates for the webhook
+							block, err := m.DB.GetRoomRestrictionByID(value)
+							if err == nil {
+								err = m.DB.DeleteBlockByID(value)
+							}
+							if err != nil {
+								helpers.ServerError(w, r, err)
+								continue
+							}
+							m.emitWebhook(helpers.CurrentProperty(r).ID, webhooks.EventBlockDeleted,
+								webhooks.BlockData(value, x.ID, block.StartDate, block.EndDate))
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// handle new blocks, posted as add_block_{unitID}_{date}
+	for name, _ := range r.PostForm {
+		if strings.HasPrefix(name, "add_block") {
+			exploded := strings.Split(name, "_")
+			unitID, _ := strconv.Atoi(exploded[2])
+			if !units[unitID] {
+				continue
+			}
+			time, _ := time.ParseInLocation("2006-01-2", exploded[3], time.UTC)
+			// insert a new block
+			blockID, err := m.DB.InsertBlockForUnit(unitID, time)
+			if err != nil {
+				helpers.ServerError(w, r, err)
+				continue
+			}
+			m.emitWebhook(helpers.CurrentProperty(r).ID, webhooks.EventBlockCreated,
+				webhooks.BlockData(blockID, unitID, time, time.AddDate(0, 0, 1)))
+		}
+	}
+
+	m.restrictionsChanged()
+	m.notifyWaitlist(helpers.CurrentProperty(r))
+
+	m.App.Session.Put(r.Context(), "flash", "Changes Saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calender?y=%d&m=%d", year, month), http.StatusSeeOther)
+}
+
+// AdminReservationsTimeline displays the interactive reservation timeline
+func (m *Repository) AdminReservationsTimeline(w http.ResponseWriter, r *http.Request) {
+	start := dates.Today(dates.Location(helpers.CurrentProperty(r).Timezone))
+	if r.URL.Query().Get("start") != "" {
+		s, err := dates.Parse(r.URL.Query().Get("start"))
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't parse start date, showing today instead")
+			http.Redirect(w, r, "/admin/reservations-timeline", http.StatusSeeOther)
+			return
+		}
+		start = s
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["start"] = start.Format(dates.Layout)
+
+	render.Template(w, r, "admin-reservations-timeline.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+	})
+}
+
+type timelineUnit struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type timelineRoom struct {
+	ID       int            `json:"id"`
+	RoomName string         `json:"room_name"`
+	Units    []timelineUnit `json:"units"`
+}
+
+type timelineRestriction struct {
+	ID            int    `json:"id"`
+	RoomID        int    `json:"room_id"`
+	RoomUnitID    int    `json:"room_unit_id"`
+	ReservationID int    `json:"reservation_id"`
+	RestrictionID int    `json:"restriction_id"`
+	Kind          string `json:"kind"`
+	Label         string `json:"label"`
+	StartDate     string `json:"start_date"`
+	EndDate       string `json:"end_date"`
+}
+
+type timelineResponse struct {
+	OK           bool                  `json:"ok"`
+	Message      string                `json:"message"`
+	StartDate    string                `json:"start_date"`
+	EndDate      string                `json:"end_date"`
+	Rooms        []timelineRoom        `json:"rooms"`
+	Restrictions []timelineRestriction `json:"restrictions"`
+}
+
+// writeJSON writes resp to the response as indented json
+func writeJSON(w http.ResponseWriter, resp interface{}) {
+	out, _ := json.MarshalIndent(resp, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// AdminRestrictionsJSON returns all restrictions for all rooms within a date window as json
+func (m *Repository) AdminRestrictionsJSON(w http.ResponseWriter, r *http.Request) {
+	startDate, err := dates.Parse(r.URL.Query().Get("start"))
+	if err != nil {
+		writeJSON(w, timelineResponse{OK: false, Message: "Invalid start date"})
+		return
+	}
+	endDate, err := dates.Parse(r.URL.Query().Get("end"))
+	if err != nil || endDate.Before(startDate) {
+		writeJSON(w, timelineResponse{OK: false, Message: "Invalid end date"})
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+
+	rooms, err := m.roomsWithUnits(property.ID)
+	if err != nil {
+		writeJSON(w, timelineResponse{OK: false, Message: "Error Querying Database"})
+		return
+	}
+
+	restrictions, err := m.DB.GetRestrictionsByDate(property.ID, startDate, endDate)
+	if err != nil {
+		writeJSON(w, timelineResponse{OK: false, Message: "Error Querying Database"})
+		return
+	}
+
+	resp := timelineResponse{
+		OK:           true,
+		StartDate:    startDate.Format(dates.Layout),
+		EndDate:      endDate.Format(dates.Layout),
+		Rooms:        []timelineRoom{},
+		Restrictions: []timelineRestriction{},
+	}
+
+	for _, x := range rooms {
+		room := timelineRoom{ID: x.ID, RoomName: x.RoomName, Units: []timelineUnit{}}
+		for _, u := range x.Units {
+			room.Units = append(room.Units, timelineUnit{ID: u.ID, Name: u.Name})
+		}
+		resp.Rooms = append(resp.Rooms, room)
+	}
+
+	for _, x := range restrictions {
+		item := timelineRestriction{
+			ID:            x.ID,
+			RoomID:        x.RoomID,
+			RoomUnitID:    x.RoomUnitID,
+			ReservationID: x.ReservationID,
+			RestrictionID: x.RestrictionID,
+			StartDate:     x.StartDate.Format(dates.Layout),
+			EndDate:       x.EndDate.Format(dates.Layout),
+		}
+		if x.ReservationID > 0 {
+			item.Kind = "reservation"
+			item.Label = fmt.Sprintf("%s %s", x.Reservation.FirstName, x.Reservation.LastName)
+		} else if x.RestrictionID == models.RestrictionHold {
+			item.Kind = "hold"
+			item.Label = x.Restriction.RestrictionName
+		} else {
+			item.Kind = "block"
+			item.Label = x.Restriction.RestrictionName
+		}
+		resp.Restrictions = append(resp.Restrictions, item)
+	}
+
+	writeJSON(w, resp)
+}
+
+// parseTimelineChange reads the room unit and dates posted by the timeline view
+func parseTimelineChange(r *http.Request) (int, time.Time, time.Time, error) {
+	unitID, err := strconv.Atoi(r.Form.Get("room_unit_id"))
+	if err != nil {
+		return 0, time.Time{}, time.Time{}, errors.New("Invalid room")
+	}
+	startDate, err := dates.Parse(r.Form.Get("start"))
+	if err != nil {
+		return 0, time.Time{}, time.Time{}, errors.New("Invalid start date")
+	}
+	endDate, err := dates.Parse(r.Form.Get("end"))
+	if err != nil {
+		return 0, time.Time{}, time.Time{}, errors.New("Invalid end date")
+	}
+	if !endDate.After(startDate) {
+		return 0, time.Time{}, time.Time{}, errors.New("End date must be after start date")
+	}
+	return unitID, startDate, endDate, nil
+}
+
+// AdminMoveReservationJSON moves a reservation to another room and/or dates from the timeline
+func (m *Repository) AdminMoveReservationJSON(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeJSON(w, jsonResponse{OK: false, Message: "Internal Server Error"})
+		return
+	}
+
+	id, err := strconv.Atoi(r.Form.Get("reservation_id"))
+	if err != nil {
+		writeJSON(w, jsonResponse{OK: false, Message: "Invalid reservation"})
+		return
+	}
+
+	unitID, startDate, endDate, err := parseTimelineChange(r)
+	if err != nil {
+		writeJSON(w, jsonResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil || res.Room.PropertyID != property.ID {
+		writeJSON(w, jsonResponse{OK: false, Message: "Can't find reservation"})
+		return
+	}
+	if !res.CancelledAt.IsZero() {
+		writeJSON(w, jsonResponse{OK: false, Message: "This reservation has been cancelled"})
+		return
+	}
+
+	unit, err := m.DB.GetRoomUnitByID(unitID)
+	if err != nil || unit.Room.PropertyID != property.ID {
+		writeJSON(w, jsonResponse{OK: false, Message: "Can't find room"})
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesByUnitIDExcludingReservation(unitID, res.ID, startDate, endDate)
+	if err != nil {
+		writeJSON(w, jsonResponse{OK: false, Message: "Error Querying Database"})
+		return
+	}
+	if !available {
+		writeJSON(w, jsonResponse{OK: false, Message: "The room is not available for these dates"})
+		return
+	}
+
+	repriced := !startDate.Equal(res.StartDate) || !endDate.Equal(res.EndDate) || unit.RoomID != res.RoomID
+
+	res.RoomID = unit.RoomID
+	res.RoomUnitID = unit.ID
+	res.Room = unit.Room
+	res.RoomUnit = unit
+	res.StartDate = startDate
+	res.EndDate = endDate
+
+	if repriced {
+		res, err = m.repriceStay(property.ID, res)
+		if err != nil {
+			writeJSON(w, jsonResponse{OK: false, Message: "Error Pricing Reservation"})
+			return
+		}
+	}
+
+	err = m.DB.UpdateReservation(res)
+	if err != nil {
+		writeJSON(w, jsonResponse{OK: false, Message: "Error Updating Reservation"})
+		return
+	}
+	m.restrictionsChanged()
+	m.notifyWaitlist(property)
+	m.emitWebhook(property.ID, webhooks.EventReservationUpdated, webhooks.ReservationData(res))
+
+	writeJSON(w, jsonResponse{
+		OK:        true,
+		Message:   "Reservation moved",
+		RoomID:    strconv.Itoa(unit.RoomID),
+		StartDate: r.Form.Get("start"),
+		EndDate:   r.Form.Get("end"),
+	})
+}
+
+// AdminSaveBlockJSON creates a new block, or moves/resizes an existing one, from the timeline
+func (m *Repository) AdminSaveBlockJSON(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeJSON(w, jsonResponse{OK: false, Message: "Internal Server Error"})
+		return
+	}
+
+	unitID, startDate, endDate, err := parseTimelineChange(r)
+	if err != nil {
+		writeJSON(w, jsonResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	blockID := 0
+	if r.Form.Get("block_id") != "" {
+		blockID, err = strconv.Atoi(r.Form.Get("block_id"))
+		if err != nil {
+			writeJSON(w, jsonResponse{OK: false, Message: "Invalid block"})
+			return
+		}
+	}
+
+	property := helpers.CurrentProperty(r)
+
+	unit, err := m.DB.GetRoomUnitByID(unitID)
+	if err != nil || unit.Room.PropertyID != property.ID {
+		writeJSON(w, jsonResponse{OK: false, Message: "Can't find room"})
+		return
+	}
+
+	if blockID > 0 {
+		block, err := m.DB.GetRoomRestrictionByID(blockID)
+		if err != nil || block.ReservationID > 0 || block.RestrictionID == models.RestrictionHold {
+			writeJSON(w, jsonResponse{OK: false, Message: "Can't find block"})
+			return
+		}
+		blockRoom, err := m.DB.GetRoomByID(block.RoomID)
+		if err != nil || blockRoom.PropertyID != property.ID {
+			writeJSON(w, jsonResponse{OK: false, Message: "Can't find block"})
+			return
+		}
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesByUnitIDExcludingRestriction(unitID, blockID, startDate, endDate)
+	if err != nil {
+		writeJSON(w, jsonResponse{OK: false, Message: "Error Querying Database"})
+		return
+	}
+	if !available {
+		writeJSON(w, jsonResponse{OK: false, Message: "The room is not available for these dates"})
+		return
+	}
+
+	created := blockID == 0
+	if created {
+		blockID, err = m.DB.InsertBlockForUnitByDates(unitID, startDate, endDate)
+	} else {
+		err = m.DB.UpdateBlockByID(blockID, unitID, startDate, endDate)
+	}
+	if err != nil {
+		writeJSON(w, jsonResponse{OK: false, Message: "Error Saving Block"})
+		return
+	}
+	m.restrictionsChanged()
+	event := webhooks.EventBlockUpdated
+	if created {
+		event = webhooks.EventBlockCreated
+	}
+	m.emitWebhook(property.ID, event, webhooks.BlockData(blockID, unitID, startDate, endDate))
+
+	writeJSON(w, jsonResponse{
+		OK:        true,
+		Message:   "Block saved",
+		RoomID:    strconv.Itoa(unit.RoomID),
+		StartDate: r.Form.Get("start"),
+		EndDate:   r.Form.Get("end"),
+	})
+}
+
+// AdminDeleteBlockJSON removes a block from the timeline
+func (m *Repository) AdminDeleteBlockJSON(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeJSON(w, jsonResponse{OK: false, Message: "Internal Server Error"})
+		return
+	}
+
+	blockID, err := strconv.Atoi(r.Form.Get("block_id"))
+	if err != nil {
+		writeJSON(w, jsonResponse{OK: false, Message: "Invalid block"})
+		return
+	}
+
+	block, err := m.DB.GetRoomRestrictionByID(blockID)
+	if err != nil || block.ReservationID > 0 || block.RestrictionID == models.RestrictionHold {
+		writeJSON(w, jsonResponse{OK: false, Message: "Can't find block"})
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(block.RoomID)
+	if err != nil || room.PropertyID != helpers.CurrentProperty(r).ID {
+		writeJSON(w, jsonResponse{OK: false, Message: "Can't find block"})
+		return
+	}
+
+	err = m.DB.DeleteBlockByID(blockID)
+	if err != nil {
+		writeJSON(w, jsonResponse{OK: false, Message: "Error Deleting Block"})
+		return
+	}
+	m.restrictionsChanged()
+	m.notifyWaitlist(helpers.CurrentProperty(r))
+	m.emitWebhook(room.PropertyID, webhooks.EventBlockDeleted,
+		webhooks.BlockData(block.ID, block.RoomUnitID, block.StartDate, block.EndDate))
+
+	writeJSON(w, jsonResponse{OK: true, Message: "Block removed"})
+}
+
+// AdminProcessReservation marks a reservation as processed
+func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+
+	src := chi.URLParam(r, "src")
+
+	_ = m.DB.UpdateProcessedForReservation(res.ID, 1)
+
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation Updated")
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calender?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+}
+
+// AdminDeleteReservation deletes a reservation
+func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+
+	src := chi.URLParam(r, "src")
+
+	err := m.DB.DeleteReservation(res.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.restrictionsChanged()
+	m.notifyWaitlist(helpers.CurrentProperty(r))
+
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation Deleted")
+
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calender?y=%s&m=%s", year, month), http.StatusSeeOther)
+
+	}
+}
+
+// adminReservation returns the reservation of the current property in the url of an admin
+// page, or responds with an error
+func (m *Repository) adminReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return res, false
+	}
+	if res.Room.PropertyID != helpers.CurrentProperty(r).ID {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return res, false
+	}
+	return res, true
+}
+
+// invoiceFor returns the invoice of a reservation, issuing it with the next invoice number of
+// the property the first time it is asked for. When the amount billed has changed since, the
+// invoice is cancelled by a credit note and a new one issued, the credit note is returned too
+func (m *Repository) invoiceFor(property models.Property, res models.Reservation) (models.Invoice, models.Invoice, error) {
+	var credit models.Invoice
+
+	stored, err := m.DB.GetInvoiceForReservation(res.ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return stored, credit, err
+	}
+
+	room, err := m.DB.GetRoomByID(res.RoomID)
+	if err != nil {
+		return stored, credit, err
+	}
+
+	taxes, err := m.DB.AllTaxFees(property.ID)
+	if err != nil {
+		return stored, credit, err
+	}
+
+	paid, err := m.DB.GetPaymentsForReservation(res.ID)
+	if err != nil {
+		return stored, credit, err
+	}
+
+	built := invoices.Build(property, res, room, taxes, paid, amountPaid(paid))
+	if stored.ID != 0 && stored.Total == built.Total {
+		return stored, credit, nil
+	}
+	built.IssuedAt = dates.Today(dates.Location(property.Timezone))
+
+	inv := models.Invoice{
+		PropertyID:    property.ID,
+		ReservationID: res.ID,
+		Total:         built.Total,
+	}
+	render := func(number int) []byte {
+		built.Number = number
+		return invoices.Render(built)
+	}
+
+	if stored.ID == 0 {
+		inv, err = m.DB.InsertInvoice(inv, render)
+		return inv, credit, err
+	}
+
+	note := invoices.CreditNote(property, res, stored.Number, stored.Total)
+	note.IssuedAt = built.IssuedAt
+
+	credit, inv, err = m.DB.ReissueInvoice(models.Invoice{
+		PropertyID:       property.ID,
+		ReservationID:    res.ID,
+		Total:            note.Total,
+		CreditsInvoiceID: stored.ID,
+	}, func(number int) []byte {
+		note.Number = number
+		return invoices.Render(note)
+	}, inv, render)
+	return inv, credit, err
+}
+
+// AdminReservationInvoice downloads the invoice of a reservation as a PDF
+func (m *Repository) AdminReservationInvoice(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+
+	inv, _, err := m.invoiceFor(helpers.CurrentProperty(r), res)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, invoices.FileName(inv.Number)))
+	w.Write(inv.PDF)
+}
+
+// AdminSendInvoice emails the invoice of a reservation to the guest
+func (m *Repository) AdminSendInvoice(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+
+	inv, credit, err := m.invoiceFor(property, res)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	htmlMessage := fmt.Sprintf(`
+	<strong>Invoice %s</strong> <br>
+	Dear %s,<br>
+	Please find attached the invoice for your stay from %s to %s.`,
+		invoices.FormatNumber(inv.Number), res.FirstName,
+		res.StartDate.Format(dates.Layout), res.EndDate.Format(dates.Layout))
+
+	attachments := []models.Attachment{{Name: invoices.FileName(inv.Number), Data: inv.PDF}}
+	if credit.ID != 0 {
+		htmlMessage += fmt.Sprintf(`<br>
+	It replaces the invoice issued before, which the attached credit note %s cancels.`,
+			invoices.FormatNumber(credit.Number))
+		attachments = append(attachments, models.Attachment{Name: invoices.CreditNoteFileName(credit.Number), Data: credit.PDF})
+	}
+
+	m.App.MailChan <- models.MailData{
+		To:          res.Email,
+		From:        property.SenderEmail,
+		Subject:     fmt.Sprintf("Invoice %s from %s", invoices.FormatNumber(inv.Number), property.Name),
+		Content:     htmlMessage,
+		Template:    "basic.html",
+		Attachments: attachments,
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Invoice "+invoices.FormatNumber(inv.Number)+" sent")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", chi.URLParam(r, "src"), res.ID), http.StatusSeeOther)
+}
+
+type stayRuleRow struct {
+	Rule          models.StayRule
+	ArrivalDays   string
+	DepartureDays string
+}
+
+// weekdayOption is a day of the week offered as a checkbox in the stay rules form
+type weekdayOption struct {
+	Value int
+	Name  string
+}
+
+// AdminStayRules lists the stay rules of the current property with a form to add one
+func (m *Repository) AdminStayRules(w http.ResponseWriter, r *http.Request) {
+	m.renderStayRules(w, r, forms.New(nil))
+}
+
+// renderStayRules renders the stay rules page with the given add rule form
+func (m *Repository) renderStayRules(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	property := helpers.CurrentProperty(r)
+
+	rules, err := m.DB.AllStayRules(property.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(property.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	var rows []stayRuleRow
+	for _, rule := range rules {
+		rows = append(rows, stayRuleRow{
+			Rule:          rule,
+			ArrivalDays:   stayrules.Weekdays(rule.ArrivalDays).String(),
+			DepartureDays: stayrules.Weekdays(rule.DepartureDays).String(),
+		})
+	}
+
+	var weekdays []weekdayOption
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		weekdays = append(weekdays, weekdayOption{Value: int(d), Name: d.String()})
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = rows
+	data["rooms"] = rooms
+	data["weekdays"] = weekdays
+
+	render.Template(w, r, "admin-stay-rules.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminPostStayRules adds a stay rule to a room of the current property
+func (m *Repository) AdminPostStayRules(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "start_date", "end_date")
+
+	rule := models.StayRule{ClosedToArrival: form.Has("closed_to_arrival")}
+
+	rule.RoomID, err = strconv.Atoi(r.Form.Get("room_id"))
+	if form.Has("room_id") {
+		room, roomErr := m.DB.GetRoomByID(rule.RoomID)
+		if err != nil || roomErr != nil || room.PropertyID != property.ID {
+			form.Errors.Add("room_id", "Invalid room")
+		}
+	}
+
+	rule.StartDate, err = dates.Parse(r.Form.Get("start_date"))
+	if form.Has("start_date") && err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+	rule.EndDate, err = dates.Parse(r.Form.Get("end_date"))
+	if form.Has("end_date") && err != nil {
+		form.Errors.Add("end_date", "Invalid date")
+	}
+	if form.Valid() && !rule.EndDate.After(rule.StartDate) {
+		form.Errors.Add("end_date", "The last arrival date must be after the first")
+	}
+
+	limits := map[string]*int{
+		"min_nights":       &rule.MinNights,
+		"max_nights":       &rule.MaxNights,
+		"min_advance_days": &rule.MinAdvanceDays,
+		"max_advance_days": &rule.MaxAdvanceDays,
+	}
+	for field, limit := range limits {
+		if form.Has(field) && form.IntRange(field, 0, 3650) {
+			*limit, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get(field)))
+		}
+	}
+	if rule.MaxNights > 0 && rule.MaxNights < rule.MinNights {
+		form.Errors.Add("max_nights", "The maximum stay can't be shorter than the minimum")
+	}
+	if rule.MaxAdvanceDays > 0 && rule.MaxAdvanceDays < rule.MinAdvanceDays {
+		form.Errors.Add("max_advance_days", "The booking horizon can't be shorter than the minimum advance")
+	}
+
+	rule.ArrivalDays = int(parseWeekdays(r.Form["arrival_days"]))
+	rule.DepartureDays = int(parseWeekdays(r.Form["departure_days"]))
+
+	if !form.Valid() {
+		m.renderStayRules(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertStayRule(rule)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule added")
+	http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+// parseWeekdays reads the checked days of the week of a form field
+func parseWeekdays(values []string) stayrules.Weekdays {
+	var days []time.Weekday
+	for _, v := range values {
+		d, err := strconv.Atoi(v)
+		if err == nil && d >= int(time.Sunday) && d <= int(time.Saturday) {
+			days = append(days, time.Weekday(d))
+		}
+	}
+	return stayrules.WeekdaysOf(days...)
+}
+
+// AdminDeleteStayRule removes a stay rule of the current property
+func (m *Repository) AdminDeleteStayRule(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteStayRule(helpers.CurrentProperty(r).ID, id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule deleted")
+	http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+// AdminCancelReservation cancels a reservation under the policy it was made with and refunds the guest
+func (m *Repository) AdminCancelReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	src := chi.URLParam(r, "src")
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	property := helpers.CurrentProperty(r)
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if res.Room.PropertyID != property.ID {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	if !res.CancelledAt.IsZero() {
+		m.App.Session.Put(r.Context(), "error", "Reservation is already cancelled")
+	} else {
+		res, err = m.cancelReservation(property, res, cancellation.ByAdmin)
+		switch {
+		case errors.Is(err, errRefundFailed):
+			helpers.Logger(r).Error("refunding cancelled reservation", "reservation_id", res.ID, "error", err)
+			m.App.Session.Put(r.Context(), "error",
+				fmt.Sprintf("Reservation cancelled, but the refund of %s failed and has to be made by hand", pricing.FormatMoney(res.Refund)))
+		case err != nil:
+			helpers.ServerError(w, r, err)
+			return
+		default:
+			m.App.Session.Put(r.Context(), "flash", "Reservation Cancelled")
+		}
+	}
+
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calender?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+}
+
+// AdminCancellationPolicies lists the cancellation policies of the current property, with the
+// policy of each room and a form to add a policy
+func (m *Repository) AdminCancellationPolicies(w http.ResponseWriter, r *http.Request) {
+	m.renderCancellationPolicies(w, r, forms.New(nil))
+}
+
+// renderCancellationPolicies renders the cancellation policies page with the given add policy form
+func (m *Repository) renderCancellationPolicies(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	property := helpers.CurrentProperty(r)
+
+	policies, err := m.DB.AllCancellationPolicies(property.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(property.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	descriptions := make(map[int]string)
+	for _, p := range policies {
+		descriptions[p.ID] = cancellation.Describe(p)
+	}
+
+	data := make(map[string]interface{})
+	data["policies"] = policies
+	data["descriptions"] = descriptions
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-cancellation-policies.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminPostCancellationPolicies adds a cancellation policy to the current property
+func (m *Repository) AdminPostCancellationPolicies(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+
+	policy := models.CancellationPolicy{
+		PropertyID:    helpers.CurrentProperty(r).ID,
+		Name:          strings.TrimSpace(r.Form.Get("name")),
+		NonRefundable: form.Has("non_refundable"),
+	}
+
+	if form.Has("free_days") && form.IntRange("free_days", 0, 365) {
+		policy.FreeDays, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get("free_days")))
+	}
+	if form.Has("fee_percent") && form.IntRange("fee_percent", 0, 100) {
+		policy.FeePercent, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get("fee_percent")))
+	}
+
+	if !form.Valid() {
+		m.renderCancellationPolicies(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertCancellationPolicy(policy)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Cancellation policy added")
+	http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// AdminDeleteCancellationPolicy removes a cancellation policy of the current property, reservations
+// already made keep the copy they were made with
+func (m *Repository) AdminDeleteCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteCancellationPolicy(helpers.CurrentProperty(r).ID, id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Cancellation policy deleted")
+	http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// AdminPostRoomCancellationPolicy sets the cancellation policy new reservations of a room are made with
+func (m *Repository) AdminPostRoomCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	policyID, err := strconv.Atoi(r.Form.Get("policy_id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil || room.PropertyID != helpers.CurrentProperty(r).ID {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.SetRoomCancellationPolicy(roomID, policyID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't set the cancellation policy of this room")
+		http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Cancellation policy of "+room.RoomName+" saved")
+	http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+}
+
+// AdminTaxes lists the taxes and fees of the current property with a form to add one
+func (m *Repository) AdminTaxes(w http.ResponseWriter, r *http.Request) {
+	m.renderTaxes(w, r, forms.New(nil))
+}
+
+// renderTaxes renders the taxes and fees page with the given add tax form
+func (m *Repository) renderTaxes(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	taxes, err := m.DB.AllTaxFees(helpers.CurrentProperty(r).ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	amounts := make(map[int]string)
+	for _, t := range taxes {
+		if t.Kind == pricing.TaxPercent {
+			amounts[t.ID] = pricing.FormatPercent(t.Amount) + "%"
+		} else {
+			amounts[t.ID] = pricing.FormatMoney(t.Amount)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["taxes"] = taxes
+	data["amounts"] = amounts
+	data["kinds"] = pricing.TaxKinds
+
+	render.Template(w, r, "admin-taxes.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminPostTaxes adds a tax or fee to the current property
+func (m *Repository) AdminPostTaxes(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "kind", "amount")
+
+	tax := models.TaxFee{
+		PropertyID: helpers.CurrentProperty(r).ID,
+		Name:       strings.TrimSpace(r.Form.Get("name")),
+		Kind:       r.Form.Get("kind"),
+		Inclusive:  form.Has("inclusive"),
+	}
+
+	if form.Has("kind") && !pricing.ValidTaxKind(tax.Kind) {
+		form.Errors.Add("kind", "Invalid kind")
+	}
+
+	tax.Amount, err = pricing.ParseMoney(r.Form.Get("amount"))
+	if form.Has("amount") && err != nil {
+		form.Errors.Add("amount", "Invalid amount")
+	}
+	if tax.Kind == pricing.TaxPercent && tax.Amount > 10000 {
+		form.Errors.Add("amount", "A percentage can't be more than 100")
+	}
+
+	// both dates are optional, leaving the range open on that side
+	if form.Has("start_date") {
+		tax.StartDate, err = dates.Parse(r.Form.Get("start_date"))
+		if err != nil {
+			form.Errors.Add("start_date", "Invalid date")
+		}
+	}
+	if form.Has("end_date") {
+		tax.EndDate, err = dates.Parse(r.Form.Get("end_date"))
+		if err != nil {
+			form.Errors.Add("end_date", "Invalid date")
+		}
+	}
+	if !tax.StartDate.IsZero() && !tax.EndDate.IsZero() && !tax.EndDate.After(tax.StartDate) {
+		form.Errors.Add("end_date", "The end date must be after the start date")
+	}
+
+	if !form.Valid() {
+		m.renderTaxes(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertTaxFee(tax)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Tax added")
+	http.Redirect(w, r, "/admin/taxes", http.StatusSeeOther)
+}
+
+// AdminDeleteTax removes a tax or fee of the current property, reservations already made keep
+// the total they were booked at
+func (m *Repository) AdminDeleteTax(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteTaxFee(helpers.CurrentProperty(r).ID, id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Tax deleted")
+	http.Redirect(w, r, "/admin/taxes", http.StatusSeeOther)
+}
+
+// AdminPromoCodes lists the promo codes of the current property, with how much they have been
+// used, and a form to add one
+func (m *Repository) AdminPromoCodes(w http.ResponseWriter, r *http.Request) {
+	m.renderPromoCodes(w, r, forms.New(nil))
+}
+
+// renderPromoCodes renders the promo codes page with the given add promo code form
+func (m *Repository) renderPromoCodes(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	property := helpers.CurrentProperty(r)
+
+	codes, err := m.DB.AllPromoCodes(property.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(property.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	descriptions := make(map[int]string)
+	windows := make(map[int]string)
+	for _, p := range codes {
+		descriptions[p.ID] = promos.Describe(p)
+		windows[p.ID] = promos.Window(p)
+	}
+
+	data := make(map[string]interface{})
+	data["codes"] = codes
+	data["descriptions"] = descriptions
+	data["windows"] = windows
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-promo-codes.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminPostPromoCodes adds a promo code to the current property
+func (m *Repository) AdminPostPromoCodes(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+
+	form := forms.New(r.PostForm)
+	form.Required("code", "kind", "amount")
+
+	promo := models.PromoCode{
+		PropertyID: property.ID,
+		Code:       promos.Normalize(r.Form.Get("code")),
+		Kind:       r.Form.Get("kind"),
+	}
+
+	if form.Has("code") && strings.ContainsAny(promo.Code, " \t") {
+		form.Errors.Add("code", "Codes can't contain spaces")
+	}
+	if form.Has("kind") && promo.Kind != promos.KindPercent && promo.Kind != promos.KindFixed {
+		form.Errors.Add("kind", "Invalid kind")
+	}
+
+	promo.Amount, err = pricing.ParseMoney(r.Form.Get("amount"))
+	if form.Has("amount") && (err != nil || promo.Amount == 0) {
+		form.Errors.Add("amount", "Invalid amount")
+	}
+	if promo.Kind == promos.KindPercent && promo.Amount > 10000 {
+		form.Errors.Add("amount", "A percentage can't be more than 100")
+	}
+
+	// every date is optional, leaving the window open on that side
+	windows := map[string]*time.Time{
+		"valid_from":  &promo.ValidFrom,
+		"valid_until": &promo.ValidUntil,
+		"stay_from":   &promo.StayFrom,
+		"stay_until":  &promo.StayUntil,
+	}
+	for field, d := range windows {
+		if form.Has(field) {
+			*d, err = dates.Parse(r.Form.Get(field))
+			if err != nil {
+				form.Errors.Add(field, "Invalid date")
+			}
+		}
+	}
+	if !promo.ValidFrom.IsZero() && !promo.ValidUntil.IsZero() && !promo.ValidUntil.After(promo.ValidFrom) {
+		form.Errors.Add("valid_until", "The end of the booking window must be after its start")
+	}
+	if !promo.StayFrom.IsZero() && !promo.StayUntil.IsZero() && !promo.StayUntil.After(promo.StayFrom) {
+		form.Errors.Add("stay_until", "The end of the stay window must be after its start")
+	}
+
+	if form.Has("room_id") {
+		// a zero room id leaves the code valid for every room
+		promo.RoomID, err = strconv.Atoi(r.Form.Get("room_id"))
+		if err != nil {
+			form.Errors.Add("room_id", "Invalid room")
+		} else if promo.RoomID != 0 {
+			room, roomErr := m.DB.GetRoomByID(promo.RoomID)
+			if roomErr != nil || room.PropertyID != property.ID {
+				form.Errors.Add("room_id", "Invalid room")
+			}
+		}
+	}
+
+	limits := map[string]*int{
+		"max_uses":           &promo.MaxUses,
+		"max_uses_per_email": &promo.MaxUsesPerEmail,
+	}
+	for field, limit := range limits {
+		if form.Has(field) && form.IntRange(field, 0, 1000000) {
+			*limit, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get(field)))
+		}
+	}
+
+	if !form.Valid() {
+		m.renderPromoCodes(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertPromoCode(promo)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code "+promo.Code+" added")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// AdminDeletePromoCode removes a promo code of the current property, reservations made with it
+// keep their discount
+func (m *Repository) AdminDeletePromoCode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeletePromoCode(helpers.CurrentProperty(r).ID, id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code deleted")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// AdminPostReservationExtras changes the extras booked on a reservation and its total with them
+func (m *Repository) AdminPostReservationExtras(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.adminReservation(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	showURL := fmt.Sprintf("/admin/reservations/%s/%d/show", chi.URLParam(r, "src"), res.ID)
+
+	if !res.CancelledAt.IsZero() {
+		m.App.Session.Put(r.Context(), "error", "This reservation has been cancelled")
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	}
+
+	items, err := m.extrasFromForm(r, helpers.CurrentProperty(r).ID, res)
+	var extraErr *extras.Error
+	switch {
+	case errors.As(err, &extraErr):
+		m.App.Session.Put(r.Context(), "error", extraErr.Message)
+		http.Redirect(w, r, showURL, http.StatusSeeOther)
+		return
+	case err != nil:
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// extras taken off the catalogue since they were booked can't be changed, so they stay
+	for _, old := range res.Extras {
+		if old.ExtraID == 0 {
+			items = append(items, old)
+		}
+	}
+
+	total := res.Total - extras.Total(res.Extras) + extras.Total(items)
+
+	err = m.DB.SetReservationExtras(res.ID, total, items)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	res.Total = total
+	res.Extras = items
+	m.emitWebhook(helpers.CurrentProperty(r).ID, webhooks.EventReservationUpdated, webhooks.ReservationData(res))
+
+	m.App.Session.Put(r.Context(), "flash", "Extras saved, the total is now "+pricing.FormatMoney(total))
+	http.Redirect(w, r, showURL, http.StatusSeeOther)
+}
+
+// AdminExtras lists the extras the current property sells with a form to add one
+func (m *Repository) AdminExtras(w http.ResponseWriter, r *http.Request) {
+	m.renderExtras(w, r, forms.New(nil))
+}
+
+// renderExtras renders the extras page with the given add extra form
+func (m *Repository) renderExtras(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["kinds"] = extras.Kinds
+
+	err := m.addExtras(data, helpers.CurrentProperty(r).ID, nil)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	render.Template(w, r, "admin-extras.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminPostExtras adds an extra to the current property
+func (m *Repository) AdminPostExtras(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "pricing", "price")
+
+	extra := models.Extra{
+		PropertyID:  helpers.CurrentProperty(r).ID,
+		Name:        strings.TrimSpace(r.Form.Get("name")),
+		Description: strings.TrimSpace(r.Form.Get("description")),
+		Pricing:     r.Form.Get("pricing"),
+	}
+
+	if form.Has("pricing") && !extras.ValidKind(extra.Pricing) {
+		form.Errors.Add("pricing", "Invalid pricing")
+	}
+
+	extra.Price, err = pricing.ParseMoney(r.Form.Get("price"))
+	if form.Has("price") && err != nil {
+		form.Errors.Add("price", "Invalid price")
+	}
+
+	if form.Has("stock") && form.IntRange("stock", 0, 100000) {
+		extra.Stock, _ = strconv.Atoi(strings.TrimSpace(r.Form.Get("stock")))
+	}
+
+	if !form.Valid() {
+		m.renderExtras(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertExtra(extra)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Extra added")
+	http.Redirect(w, r, "/admin/extras", http.StatusSeeOther)
+}
+
+// AdminDeleteExtra removes an extra of the current property, reservations keep the extras they
+// booked
+func (m *Repository) AdminDeleteExtra(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = m.DB.DeleteExtra(helpers.CurrentProperty(r).ID, id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Extra deleted")
+	http.Redirect(w, r, "/admin/extras", http.StatusSeeOther)
+}
+
+// AdminExportReservations downloads every reservation of the current property as a CSV file,
+// with the extras, discount and total of each
+func (m *Repository) AdminExportReservations(w http.ResponseWriter, r *http.Request) {
+	property := helpers.CurrentProperty(r)
+
+	reservations, err := m.DB.AllReservations(property.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	items, err := m.DB.AllReservationExtras(property.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	byReservation := make(map[int][]models.ReservationExtra)
+	for _, item := range items {
+		byReservation[item.ReservationID] = append(byReservation[item.ReservationID], item)
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="reservations.csv"`)
+
+	out := csv.NewWriter(w)
+	out.Write([]string{
+		"ID", "First name", "Last name", "Email", "Phone", "Room", "Arrival", "Departure",
+		"Adults", "Children", "Extras", "Extras total", "Promo code", "Discount", "Total", "Status", "Booked at",
+	})
+
+	for _, res := range reservations {
+		var booked []string
+		for _, item := range byReservation[res.ID] {
+			booked = append(booked, extras.Line(item).Description)
+		}
+
+		status := "new"
+		switch {
+		case !res.CancelledAt.IsZero():
+			status = "cancelled"
+		case res.Processed == 1:
+			status = "processed"
+		}
+
+		out.Write([]string{
+			strconv.Itoa(res.ID), res.FirstName, res.LastName, res.Email, res.Phone, res.Room.RoomName,
+			res.StartDate.Format(dates.Layout), res.EndDate.Format(dates.Layout),
+			strconv.Itoa(res.Adults), strconv.Itoa(res.Children),
+			strings.Join(booked, "; "), pricing.FormatMoney(extras.Total(byReservation[res.ID])),
+			res.PromoCode, pricing.FormatMoney(res.Discount), pricing.FormatMoney(res.Total),
+			status, res.CreatedAt.Format(dates.Layout),
+		})
+	}
+
+	out.Flush()
+	if err := out.Error(); err != nil {
+		helpers.Logger(r).Error("writing reservations export", "error", err)
+	}
+}
+
+// AdminGuests lists the guests of the current property, narrowed down by the q query parameter
+func (m *Repository) AdminGuests(w http.ResponseWriter, r *http.Request) {
+	search := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	list, err := m.DB.AllGuests(helpers.CurrentProperty(r).ID, search)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["q"] = search
+
+	data := make(map[string]interface{})
+	data["guests"] = list
+
+	render.Template(w, r, "admin-guests.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// guestForRequest returns the guest of the current property in the id URL parameter, writing
+// the error response and returning false if there isn't one
+func (m *Repository) guestForRequest(w http.ResponseWriter, r *http.Request) (models.Guest, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return models.Guest{}, false
+	}
+
+	g, err := m.DB.GetGuestByID(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && g.PropertyID != helpers.CurrentProperty(r).ID) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return models.Guest{}, false
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return models.Guest{}, false
+	}
+	return g, true
+}
+
+// AdminShowGuest shows a guest with their upcoming and past stays, what they have spent, and
+// forms to edit their notes and tags and to merge a duplicate into them
+func (m *Repository) AdminShowGuest(w http.ResponseWriter, r *http.Request) {
+	g, ok := m.guestForRequest(w, r)
+	if !ok {
+		return
+	}
+	m.renderGuest(w, r, g, forms.New(nil))
+}
+
+// renderGuest renders the page of a guest with the given edit form
+func (m *Repository) renderGuest(w http.ResponseWriter, r *http.Request, g models.Guest, form *forms.Form) {
+	reservations, err := m.DB.GetReservationsForGuest(g.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	others, err := m.DB.AllGuests(g.PropertyID, "")
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	upcoming, past := splitStays(reservations, dates.Today(dates.Location(helpers.CurrentProperty(r).Timezone)))
+
+	var duplicates []models.Guest
+	for _, o := range others {
+		if o.ID != g.ID {
+			duplicates = append(duplicates, o)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["guest"] = g
+	data["upcoming"] = upcoming
+	data["past"] = past
+	data["others"] = duplicates
+	data["known_tags"] = guests.Tags
+
+	// tags the site knows are ticked in checkboxes, the rest are typed in
+	checked := make(map[string]bool)
+	for _, t := range guests.Tags {
+		checked[t] = guests.HasTag(g, t)
+	}
+	var other []string
+	for _, t := range g.Tags {
+		if !guests.HasTag(models.Guest{Tags: guests.Tags}, t) {
+			other = append(other, t)
+		}
+	}
+	data["checked"] = checked
+
+	stringMap := make(map[string]string)
+	stringMap["other_tags"] = strings.Join(other, ", ")
+
+	intMap := make(map[string]int)
+	intMap["spend"] = guests.Spend(reservations)
+
+	render.Template(w, r, "admin-guest.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		IntMap:    intMap,
+		Form:      form,
+	})
+}
+
+// splitStays splits reservations into the stays that haven't ended by today, the day it is at
+// their property, and those that have or were cancelled. Reservations come latest first, so
+// upcoming stays are listed soonest last.
+func splitStays(reservations []models.Reservation, today time.Time) (upcoming, past []models.Reservation) {
+	for _, res := range reservations {
+		if res.EndDate.After(today) && res.CancelledAt.IsZero() {
+			upcoming = append(upcoming, res)
+		} else {
+			past = append(past, res)
+		}
+	}
+	return upcoming, past
+}
+
+// AdminPostGuest saves the details, notes and tags of a guest
+func (m *Repository) AdminPostGuest(w http.ResponseWriter, r *http.Request) {
+	g, ok := m.guestForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name")
+
+	g.FirstName = strings.TrimSpace(r.Form.Get("first_name"))
+	g.LastName = strings.TrimSpace(r.Form.Get("last_name"))
+	g.Phone = strings.TrimSpace(r.Form.Get("phone"))
+	g.Notes = strings.TrimSpace(r.Form.Get("notes"))
+	g.Tags = guests.MergeTags(r.Form["tag"], guests.ParseTags(r.Form.Get("tags")))
+
+	if !form.Valid() {
+		m.renderGuest(w, r, g, form)
+		return
+	}
+
+	err = m.DB.UpdateGuest(g)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Guest saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d", g.ID), http.StatusSeeOther)
+}
+
+// AdminMergeGuest merges the guest in the other_id form field into the guest in the URL, moving
+// over their reservations and adding their notes and tags
+func (m *Repository) AdminMergeGuest(w http.ResponseWriter, r *http.Request) {
+	keep, ok := m.guestForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	guestURL := fmt.Sprintf("/admin/guests/%d", keep.ID)
+
+	otherID, err := strconv.Atoi(r.Form.Get("other_id"))
+	if err != nil || otherID == keep.ID {
+		m.App.Session.Put(r.Context(), "error", "Choose another guest to merge")
+		http.Redirect(w, r, guestURL, http.StatusSeeOther)
+		return
+	}
+
+	other, err := m.DB.GetGuestByID(otherID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && other.PropertyID != keep.PropertyID) {
+		m.App.Session.Put(r.Context(), "error", "Guest to merge not found")
+		http.Redirect(w, r, guestURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// the kept guest's details win, only filling in what they are missing
+	if keep.Phone == "" {
+		keep.Phone = other.Phone
+	}
+	keep.Notes = guests.MergeNotes(keep.Notes, other.Notes)
+	keep.Tags = guests.MergeTags(keep.Tags, other.Tags)
+
+	err = m.DB.MergeGuests(keep, other.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Merged %s %s <%s> into this guest", other.FirstName, other.LastName, other.Email))
+	http.Redirect(w, r, guestURL, http.StatusSeeOther)
+}
+
+// loginLinkValidFor is how long the links guests log in to their account with work for
+const loginLinkValidFor = 15 * time.Minute
+
+// AccountLogin shows the forms guests log in to their account with, by password or by a link
+// emailed to them
+func (m *Repository) AccountLogin(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "account-login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostAccountLogin logs a guest in to their account with their password
+func (m *Repository) PostAccountLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "account-login.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	id, _, err := m.DB.Authenticate(guests.NormalizeEmail(r.Form.Get("email")), r.Form.Get("password"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	}
+
+	// staff log in to the admin tool, not a guest account
+	user, err := m.DB.GetUserByID(id)
+	if err != nil || user.AccessLevel != models.AccessLevelGuest {
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	}
+
+	// anyone can register any email with a password, it only works once the email is confirmed
+	if user.EmailVerifiedAt.IsZero() {
+		m.App.Session.Put(r.Context(), "error", "Confirm your email first, follow the link we've emailed you or ask for another one")
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	}
+
+	m.logIn(r, user)
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// PostAccountLoginLink emails a guest a link to log in to their account with, the account is
+// made the first time they use it
+func (m *Repository) PostAccountLoginLink(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "account-login.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	email := guests.NormalizeEmail(r.Form.Get("email"))
+
+	// the same message is shown whether or not the email has an account, or belongs to staff
+	// who are sent nothing, so the form doesn't tell who has one
+	user, err := m.DB.GetUserByEmail(email)
+	switch {
+	case err == nil && user.AccessLevel != models.AccessLevelGuest:
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		helpers.ServerError(w, r, err)
+		return
+	default:
+		err = m.sendLoginLink(helpers.CurrentProperty(r), email)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Check your email, we've sent you a link to log in with")
+	http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+}
+
+// sendLoginLink emails a link to log in to the account of email with. Using it confirms the
+// email belongs to the guest.
+func (m *Repository) sendLoginLink(property models.Property, email string) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	err = m.DB.InsertLoginToken(email, hashToken(token), time.Now().Add(loginLinkValidFor))
+	if err != nil {
+		return err
+	}
+
+	link := m.siteURL(property, "/account/login/"+token)
+	m.App.MailChan <- models.MailData{
+		To:      email,
+		From:    property.SenderEmail,
+		Subject: "Log in to " + property.Name,
+		Content: fmt.Sprintf(`
+	<strong>Log in to your account</strong><br>
+	Follow <a href="%s">%s</a> to log in to your account and see your reservations.
+	The link works once, for the next %d minutes.<br>
+	If you didn't ask for it you can ignore this email.`, link, link, int(loginLinkValidFor.Minutes())),
+		Template: "basic.html",
+	}
+	return nil
+}
+
+// AccountLoginWithToken logs a guest in by the link emailed to them, making their account if
+// they don't have one yet. Following the link confirms the email is theirs.
+func (m *Repository) AccountLoginWithToken(w http.ResponseWriter, r *http.Request) {
+	email, err := m.DB.UseLoginToken(hashToken(chi.URLParam(r, "token")))
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "This link has expired or has already been used, ask for another one")
+		http.Redirect(w, r, "/account/login", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	user, err := m.DB.GetUserByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		user = models.User{Email: email, AccessLevel: models.AccessLevelGuest, EmailVerifiedAt: time.Now()}
+
+		// start them off with the details they last booked with
+		if g, guestErr := m.DB.GetGuestByEmail(helpers.CurrentProperty(r).ID, email); guestErr == nil {
+			user.FirstName, user.LastName, user.Phone = g.FirstName, g.LastName, g.Phone
+		}
+
+		user.ID, err = m.DB.InsertUser(user, "")
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if user.AccessLevel != models.AccessLevelGuest {
+		m.App.Session.Put(r.Context(), "error", "This link can't be used, log in with your password")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	if user.EmailVerifiedAt.IsZero() {
+		// the password was chosen by whoever registered the email, unless that's who followed
+		// the link it can't be trusted
+		if helpers.GuestAccountID(r) != user.ID {
+			err = m.DB.RemoveUserPassword(user.ID)
+			if err != nil {
+				helpers.ServerError(w, r, err)
+				return
+			}
+		}
+
+		user.EmailVerifiedAt = time.Now()
+		err = m.DB.UpdateUser(user)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	m.logIn(r, user)
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// AccountRegister shows the form guests create an account with
+func (m *Repository) AccountRegister(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "account-register.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostAccountRegister creates a guest account and logs the guest in to it. Anyone can type in
+// any email, so the stays booked with it are only shown once the guest has followed the link
+// emailed to them.
+func (m *Repository) PostAccountRegister(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "password")
+	form.IsEmail("email")
+	form.MinLength("password", 8)
+
+	user := models.User{
+		FirstName:        strings.TrimSpace(r.Form.Get("first_name")),
+		LastName:         strings.TrimSpace(r.Form.Get("last_name")),
+		Email:            guests.NormalizeEmail(r.Form.Get("email")),
+		Phone:            strings.TrimSpace(r.Form.Get("phone")),
+		AccessLevel:      models.AccessLevelGuest,
+		MarketingConsent: r.Form.Get("marketing_consent") != "",
+	}
+	if user.MarketingConsent {
+		user.MarketingConsentAt = time.Now()
+	}
+
+	if form.Valid() {
+		_, err = m.DB.GetUserByEmail(user.Email)
+		switch {
+		case err == nil:
+			form.Errors.Add("email", "There is already an account with this email, log in or ask for a link instead")
+		case !errors.Is(err, sql.ErrNoRows):
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		render.Template(w, r, "account-register.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	user.ID, err = m.DB.InsertUser(user, r.Form.Get("password"))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = m.sendLoginLink(helpers.CurrentProperty(r), user.Email)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.logIn(r, user)
+	m.App.Session.Put(r.Context(), "flash", "Your account has been created, follow the link we've emailed you to confirm your email and see your reservations")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// Account shows a guest their upcoming and past reservations at the current property, and their
+// details and marketing consent to change
+func (m *Repository) Account(w http.ResponseWriter, r *http.Request) {
+	user, err := m.DB.GetUserByID(helpers.GuestAccountID(r))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.renderAccount(w, r, user, forms.New(nil))
+}
+
+// renderAccount renders the account page of a user with the given details form
+func (m *Repository) renderAccount(w http.ResponseWriter, r *http.Request, user models.User, form *forms.Form) {
+	property := helpers.CurrentProperty(r)
+
+	// stays are found through the guest the property knows by the account's email, once the
+	// guest has shown the email is theirs
+	var reservations []models.Reservation
+	if !user.EmailVerifiedAt.IsZero() {
+		g, err := m.DB.GetGuestByEmail(property.ID, user.Email)
+		if err == nil {
+			reservations, err = m.DB.GetReservationsForGuest(g.ID)
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	upcoming, past := splitStays(reservations, dates.Today(dates.Location(property.Timezone)))
+
+	data := make(map[string]interface{})
+	data["user"] = user
+	data["verified"] = !user.EmailVerifiedAt.IsZero()
+	data["upcoming"] = upcoming
+	data["past"] = past
+
+	render.Template(w, r, "account.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// PostAccount saves the details and marketing consent of a guest account
+func (m *Repository) PostAccount(w http.ResponseWriter, r *http.Request) {
+	user, err := m.DB.GetUserByID(helpers.GuestAccountID(r))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name")
+
+	user.FirstName = strings.TrimSpace(r.Form.Get("first_name"))
+	user.LastName = strings.TrimSpace(r.Form.Get("last_name"))
+	user.Phone = strings.TrimSpace(r.Form.Get("phone"))
+
+	// keep when consent was given, for as long as it stands
+	consent := r.Form.Get("marketing_consent") != ""
+	switch {
+	case consent && !user.MarketingConsent:
+		user.MarketingConsentAt = time.Now()
+	case !consent:
+		user.MarketingConsentAt = time.Time{}
+	}
+	user.MarketingConsent = consent
+
+	if !form.Valid() {
+		m.renderAccount(w, r, user, form)
+		return
+	}
+
+	err = m.DB.UpdateUser(user)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your details have been saved")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// Waitlist shows the form guests join the waitlist of a room with, the dates and guests are
+// filled in from the s, e, a and c query parameters of a search that found nothing
+func (m *Repository) Waitlist(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	form := forms.New(url.Values{
+		"start_date": {q.Get("s")},
+		"end_date":   {q.Get("e")},
+		"adults":     {q.Get("a")},
+		"children":   {q.Get("c")},
+		"room_id":    {q.Get("room_id")},
+	})
+
+	// guests logged in to their account don't have to type in their details again
+	if accountID := helpers.GuestAccountID(r); accountID > 0 {
+		user, err := m.DB.GetUserByID(accountID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		form.Set("first_name", user.FirstName)
+		form.Set("last_name", user.LastName)
+		form.Set("email", user.Email)
+		form.Set("phone", user.Phone)
+	}
+
+	m.renderWaitlist(w, r, form)
+}
+
+// renderWaitlist renders the join the waitlist page with the given form
+func (m *Repository) renderWaitlist(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rooms, err := m.DB.AllRooms(helpers.CurrentProperty(r).ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "waitlist.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// PostWaitlist puts a guest on the waitlist of a room for their dates, or sends them on to book
+// if the room is free after all
+func (m *Repository) PostWaitlist(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "start_date", "end_date", "room_id")
+	form.IsEmail("email")
+
+	entry := models.WaitlistEntry{
+		PropertyID: property.ID,
+		FirstName:  strings.TrimSpace(r.Form.Get("first_name")),
+		LastName:   strings.TrimSpace(r.Form.Get("last_name")),
+		Email:      guests.NormalizeEmail(r.Form.Get("email")),
+		Phone:      strings.TrimSpace(r.Form.Get("phone")),
+		Status:     waitlist.StatusWaiting,
+	}
+
+	var room models.Room
+	entry.RoomID, err = strconv.Atoi(r.Form.Get("room_id"))
+	if err == nil {
+		room, err = m.DB.GetRoomByID(entry.RoomID)
+	}
+	if form.Has("room_id") && (err != nil || room.PropertyID != property.ID) {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+
+	entry.StartDate, err = dates.Parse(r.Form.Get("start_date"))
+	if form.Has("start_date") && err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+	entry.EndDate, err = dates.Parse(r.Form.Get("end_date"))
+	if form.Has("end_date") && err != nil {
+		form.Errors.Add("end_date", "Invalid date")
+	}
+	if form.Valid() {
+		today := dates.Today(dates.Location(property.Timezone))
+		switch {
+		case entry.StartDate.Before(today):
+			form.Errors.Add("start_date", "Arrival can't be in the past")
+		case !entry.EndDate.After(entry.StartDate):
+			form.Errors.Add("end_date", "Departure must be after arrival")
+		}
+	}
+
+	entry.Adults, entry.Children, err = parseGuests(r.Form.Get("adults"), r.Form.Get("children"))
+	switch {
+	case err != nil:
+		form.Errors.Add("adults", "Invalid number of guests")
+	case room.ID > 0 && entry.Adults+entry.Children > room.MaxOccupancy:
+		form.Errors.Add("children", fmt.Sprintf("This room sleeps at most %d guests.", room.MaxOccupancy))
+	}
+
+	if !form.Valid() {
+		m.renderWaitlist(w, r, form)
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(entry.RoomID, entry.StartDate, entry.EndDate)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if available {
+		m.App.Session.Put(r.Context(), "flash", "Good news, "+room.RoomName+" is free on your dates")
+		http.Redirect(w, r, fmt.Sprintf("/book-room?id=%d&s=%s&e=%s&a=%d&c=%d", entry.RoomID,
+			entry.StartDate.Format(dates.Layout), entry.EndDate.Format(dates.Layout), entry.Adults, entry.Children),
+			http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.DB.InsertWaitlistEntry(entry)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf(
+		"You're on the waitlist, we'll email you if %s frees up for your dates", room.RoomName))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// WaitlistOffer books the nights a guest on the waitlist was offered, by the link emailed to them
+func (m *Repository) WaitlistOffer(w http.ResponseWriter, r *http.Request) {
+	property := helpers.CurrentProperty(r)
+
+	entry, err := m.DB.GetWaitlistEntryByToken(hashToken(chi.URLParam(r, "token")))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if err != nil || entry.PropertyID != property.ID || entry.Status != waitlist.StatusOffered {
+		m.App.Session.Put(r.Context(), "error", "This offer is no longer available")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if waitlist.Expired(entry, time.Now()) {
+		// pass the nights on to whoever is next in line
+		m.notifyWaitlist(property)
+		m.App.Session.Put(r.Context(), "error", "Sorry, this offer has expired")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	res := models.Reservation{
+		FirstName: entry.FirstName,
+		LastName:  entry.LastName,
+		Email:     entry.Email,
+		Phone:     entry.Phone,
+		RoomID:    entry.RoomID,
+		StartDate: entry.StartDate,
+		EndDate:   entry.EndDate,
+		Adults:    entry.Adults,
+		Children:  entry.Children,
+		Room:      entry.Room,
+	}
+
+	// the unit held with the offer becomes the guest's hold while they fill in the form
+	if entry.HoldID != 0 {
+		m.releaseHold(r)
+		m.App.Session.Put(r.Context(), "hold_id", entry.HoldID)
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+	m.App.Session.Put(r.Context(), "waitlist_entry_id", entry.ID)
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// waitlistBooked marks the waitlist entry the guest came to book from, if any, as booked with
+// their new reservation
+func (m *Repository) waitlistBooked(r *http.Request, reservationID int) {
+	entryID := m.App.Session.PopInt(r.Context(), "waitlist_entry_id")
+	if entryID == 0 {
+		return
+	}
+
+	entry, err := m.DB.GetWaitlistEntryByID(entryID)
+	if err != nil {
+		helpers.Logger(r).Error("getting booked waitlist entry", "waitlist_entry_id", entryID, "error", err)
+		return
+	}
+
+	// the hold was given up for the reservation
+	entry.Status = waitlist.StatusBooked
+	entry.TokenHash = ""
+	entry.HoldID = 0
+	entry.ReservationID = reservationID
+	err = m.DB.UpdateWaitlistEntry(entry)
+	if err != nil {
+		helpers.Logger(r).Error("updating booked waitlist entry", "waitlist_entry_id", entryID, "error", err)
+	}
+}
+
+// notifyWaitlist expires the waitlist offers of a property that ran out and offers the nights
+// that freed up to the guests next in line. It runs after reservations or blocks change, and a
+// failure only holds up the offers, so errors are logged rather than returned.
+func (m *Repository) notifyWaitlist(property models.Property) {
+	entries, err := m.DB.GetActiveWaitlist(property.ID)
+	if err != nil {
+		m.App.Logger.Error("getting waitlist", "property_id", property.ID, "error", err)
+		return
+	}
+
+	now := time.Now()
+	expired, offers := waitlist.Next(entries, now, func(e models.WaitlistEntry) bool {
+		available, err := m.DB.SearchAvailabilityByDatesByRoomID(e.RoomID, e.StartDate, e.EndDate)
+		if err != nil {
+			m.App.Logger.Error("checking availability for waitlist", "waitlist_entry_id", e.ID, "error", err)
+			return false
+		}
+		return available
+	})
+
+	for _, e := range expired {
+		err = m.releaseWaitlistHold(&e)
+		if err != nil {
+			m.App.Logger.Error("releasing waitlist hold", "waitlist_entry_id", e.ID, "error", err)
+			continue
+		}
+
+		e.Status = waitlist.StatusExpired
+		e.TokenHash = ""
+		err = m.DB.UpdateWaitlistEntry(e)
+		if err != nil {
+			m.App.Logger.Error("expiring waitlist entry", "waitlist_entry_id", e.ID, "error", err)
+		}
+	}
+
+	for _, e := range offers {
+		err = m.offerWaitlist(property, e, now)
+		if err != nil {
+			m.App.Logger.Error("offering waitlist entry", "waitlist_entry_id", e.ID, "error", err)
+		}
+	}
+}
+
+// offerWaitlist offers a guest on the waitlist the nights they asked for, emailing them a link
+// to book with until the offer expires. A unit is held for them until then, so nobody else books
+// the nights first, and repository.ErrUnavailable is returned when there is none to hold.
+func (m *Repository) offerWaitlist(property models.Property, e models.WaitlistEntry, now time.Time) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	// an offer made again replaces the hold of the last one
+	err = m.releaseWaitlistHold(&e)
+	if err != nil {
+		return err
+	}
+
+	e.Status = waitlist.StatusOffered
+	e.TokenHash = hashToken(token)
+	e.OfferedAt = now
+	e.OfferExpiresAt = now.Add(waitlist.OfferValidFor)
+
+	unitID, err := m.DB.FindAvailableUnit(e.RoomID, e.StartDate, e.EndDate)
+	if err != nil {
+		return err
+	}
+	if unitID == 0 {
+		return repository.ErrUnavailable
+	}
+	e.HoldID, err = m.DB.InsertHold(models.RoomRestriction{
+		StartDate:     e.StartDate,
+		EndDate:       e.EndDate,
+		RoomID:        e.RoomID,
+		RoomUnitID:    unitID,
+		RestrictionID: models.RestrictionHold,
+		ExpiresAt:     e.OfferExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	m.restrictionsChanged()
+
+	err = m.DB.UpdateWaitlistEntry(e)
+	if err != nil {
+		if releaseErr := m.releaseWaitlistHold(&e); releaseErr != nil {
+			m.App.Logger.Error("releasing waitlist hold", "waitlist_entry_id", e.ID, "error", releaseErr)
+		}
+		return err
+	}
+
+	link := m.siteURL(property, "/waitlist/"+token)
+	m.App.MailChan <- models.MailData{
+		To:      e.Email,
+		From:    property.SenderEmail,
+		Subject: "Your dates are available",
+		Content: fmt.Sprintf(`
+	<strong>Your dates are available</strong><br>
+	Dear %s,<br>
+	%s has freed up from %s to %s. Book it at <a href="%s">%s</a> before %s,
+	after that we'll offer it to the next guest on the waitlist.`,
+			e.FirstName, e.Room.RoomName,
+			e.StartDate.Format(dates.Layout), e.EndDate.Format(dates.Layout), link, link,
+			e.OfferExpiresAt.In(dates.Location(property.Timezone)).Format("2006-01-02 15:04")),
+		Template: "basic.html",
+	}
+	return nil
+}
+
+// releaseWaitlistHold releases the hold of the offer made to a waitlist entry, if any
+func (m *Repository) releaseWaitlistHold(e *models.WaitlistEntry) error {
+	if e.HoldID == 0 {
+		return nil
+	}
+
+	err := m.DB.ReleaseHold(e.HoldID)
+	if err != nil {
+		return err
+	}
+	e.HoldID = 0
+	m.restrictionsChanged()
+	return nil
+}
+
+// AdminWaitlist lists the waitlist of the current property, the guests still waiting first in
+// the order they joined
+func (m *Repository) AdminWaitlist(w http.ResponseWriter, r *http.Request) {
+	property := helpers.CurrentProperty(r)
+
+	// offers that ran out since the last change are passed on before listing
+	m.notifyWaitlist(property)
+
+	entries, err := m.DB.AllWaitlistEntries(property.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["entries"] = entries
+
+	render.Template(w, r, "admin-waitlist.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// waitlistEntryForRequest returns the active waitlist entry of the current property in the id URL
+// parameter, writing the error response and returning false if there isn't one
+func (m *Repository) waitlistEntryForRequest(w http.ResponseWriter, r *http.Request) (models.WaitlistEntry, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return models.WaitlistEntry{}, false
+	}
+
+	entry, err := m.DB.GetWaitlistEntryByID(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && entry.PropertyID != helpers.CurrentProperty(r).ID) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return models.WaitlistEntry{}, false
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return models.WaitlistEntry{}, false
+	}
+
+	if !waitlist.Active(entry) {
+		m.App.Session.Put(r.Context(), "error", "This guest is no longer on the waitlist")
+		http.Redirect(w, r, "/admin/waitlist", http.StatusSeeOther)
+		return models.WaitlistEntry{}, false
+	}
+	return entry, true
+}
+
+// AdminOfferWaitlist offers a guest on the waitlist their nights now, ahead of anyone before them
+func (m *Repository) AdminOfferWaitlist(w http.ResponseWriter, r *http.Request) {
+	entry, ok := m.waitlistEntryForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(entry.RoomID, entry.StartDate, entry.EndDate)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if !available {
+		m.App.Session.Put(r.Context(), "error", "These nights are still booked up")
+		http.Redirect(w, r, "/admin/waitlist", http.StatusSeeOther)
+		return
+	}
+
+	err = m.offerWaitlist(helpers.CurrentProperty(r), entry, time.Now())
+	if errors.Is(err, repository.ErrUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "These nights are still booked up")
+		http.Redirect(w, r, "/admin/waitlist", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Offer sent to "+entry.Email)
+	http.Redirect(w, r, "/admin/waitlist", http.StatusSeeOther)
+}
+
+// AdminRemoveWaitlist takes a guest off the waitlist, withdrawing any offer they hold
+func (m *Repository) AdminRemoveWaitlist(w http.ResponseWriter, r *http.Request) {
+	entry, ok := m.waitlistEntryForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.releaseWaitlistHold(&entry)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	entry.Status = waitlist.StatusRemoved
+	entry.TokenHash = ""
+	err = m.DB.UpdateWaitlistEntry(entry)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// whoever is next may be offered the nights this guest held
+	m.notifyWaitlist(helpers.CurrentProperty(r))
+
+	m.App.Session.Put(r.Context(), "flash", "Guest removed from the waitlist")
+	http.Redirect(w, r, "/admin/waitlist", http.StatusSeeOther)
+}
+
+// groupInSession returns the group booking the guest is putting together in their session
+func (m *Repository) groupInSession(r *http.Request) models.GroupBooking {
+	g, _ := m.App.Session.Get(r.Context(), "group").(models.GroupBooking)
+	return g
+}
+
+// PostGroupAdd adds a room stay to the group booking the guest is putting together, so a party
+// can book several rooms, for different dates if they like, in one go
+func (m *Repository) PostGroupAdd(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	g := m.groupInSession(r)
+	if len(g.Reservations) >= groups.MaxStays {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("A group booking can have at most %d rooms", groups.MaxStays))
+		http.Redirect(w, r, "/group", http.StatusSeeOther)
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+
+	var room models.Room
+	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
+	if err == nil {
+		room, err = m.DB.GetRoomByID(roomID)
+	}
+	if err != nil || room.PropertyID != property.ID {
+		m.App.Session.Put(r.Context(), "error", "Can't find room")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	today := dates.Today(dates.Location(property.Timezone))
+	startDate, err := dates.Parse(r.Form.Get("start_date"))
+	endDate, endErr := dates.Parse(r.Form.Get("end_date"))
+	if err != nil || endErr != nil || startDate.Before(today) || !endDate.After(startDate) {
+		m.App.Session.Put(r.Context(), "error", "Invalid dates")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	adults, children, err := parseGuests(r.Form.Get("adults"), r.Form.Get("children"))
+	if err != nil || adults+children > room.MaxOccupancy {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s sleeps at most %d guests", room.RoomName, room.MaxOccupancy))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	rules, err := m.DB.GetStayRulesForArrival(property.ID, startDate)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	err = stayrules.Check(stayrules.ForRoom(rules, roomID), startDate, endDate, today)
+	var ruleErr *stayrules.Error
+	if errors.As(err, &ruleErr) {
+		m.App.Session.Put(r.Context(), "error", ruleErr.Message)
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(roomID, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if !available {
+		m.App.Session.Put(r.Context(), "error", "Sorry, "+room.RoomName+" is no longer available for your dates")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	g.Reservations = append(g.Reservations, models.Reservation{
+		StartDate: startDate,
+		EndDate:   endDate,
+		RoomID:    roomID,
+		Adults:    adults,
+		Children:  children,
+		Room:      room,
+	})
+	m.App.Session.Put(r.Context(), "group", g)
+
+	m.App.Session.Put(r.Context(), "flash", room.RoomName+" was added to your group booking")
+	http.Redirect(w, r, "/group", http.StatusSeeOther)
+}
+
+// PostGroupRemove takes a room stay out of the group booking the guest is putting together
+func (m *Repository) PostGroupRemove(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	g := m.groupInSession(r)
+	i, err := strconv.Atoi(r.Form.Get("stay"))
+	if err != nil || i < 0 || i >= len(g.Reservations) {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	g.Reservations = append(g.Reservations[:i], g.Reservations[i+1:]...)
+	m.App.Session.Put(r.Context(), "group", g)
+
+	m.App.Session.Put(r.Context(), "flash", "The room was taken out of your group booking")
+	http.Redirect(w, r, "/group", http.StatusSeeOther)
+}
+
+// Group shows the room stays of the group booking the guest is putting together, with the form
+// to book them all at once
+func (m *Repository) Group(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(nil)
+
+	// guests logged in to their account don't have to type in their details again
+	if accountID := helpers.GuestAccountID(r); accountID > 0 {
+		user, err := m.DB.GetUserByID(accountID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		form.Set("first_name", user.FirstName)
+		form.Set("last_name", user.LastName)
+		form.Set("email", user.Email)
+		form.Set("phone", user.Phone)
+	}
+
+	m.renderGroup(w, r, form)
+}
+
+// renderGroup renders the group booking page with the given form
+func (m *Repository) renderGroup(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	g := m.groupInSession(r)
+	if len(g.Reservations) == 0 {
+		m.App.Session.Put(r.Context(), "warning", "Your group booking has no rooms yet, search for rooms to add to it")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+	for i, res := range g.Reservations {
+		quote, err := m.quoteFor(property.ID, res)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		g.Reservations[i].Total = quote.Total
+	}
+
+	data := make(map[string]interface{})
+	data["group"] = g
+
+	intMap := make(map[string]int)
+	intMap["total"] = groups.Total(g.Reservations)
+	intMap["max_stays"] = groups.MaxStays
+
+	render.Template(w, r, "group.page.tmpl", &models.TemplateData{
+		Form:   form,
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// PostGroup books every room stay of the group booking the guest put together under one
+// reference, or none of them if a room was taken in the meantime
+func (m *Repository) PostGroup(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	g := m.groupInSession(r)
+	if len(g.Reservations) == 0 {
+		http.Redirect(w, r, "/group", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+	if !form.Valid() {
+		m.renderGroup(w, r, form)
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+	g.PropertyID = property.ID
+	g.FirstName = r.Form.Get("first_name")
+	g.LastName = r.Form.Get("last_name")
+	g.Email = r.Form.Get("email")
+	g.Phone = r.Form.Get("phone")
+
+	g.Reference, err = groups.NewReference()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// guests are told apart by email, a returning guest's details are updated to the latest
+	g.GuestID, err = m.DB.UpsertGuest(models.Guest{
+		PropertyID: property.ID,
+		FirstName:  g.FirstName,
+		LastName:   g.LastName,
+		Email:      g.Email,
+		Phone:      g.Phone,
+	})
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't save guest into database")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	for i := range g.Reservations {
+		res := &g.Reservations[i]
+
+		// prices and policies are those of the rooms now, not when they were added
+		res.Room, err = m.DB.GetRoomByID(res.RoomID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		quote, err := m.quoteFor(property.ID, *res)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		res.ManageToken, err = newToken()
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+
+		res.FirstName = g.FirstName
+		res.LastName = g.LastName
+		res.Email = g.Email
+		res.Phone = g.Phone
+		res.GuestID = g.GuestID
+		res.Total = quote.Total
+		res.CancellationPolicy = res.Room.CancellationPolicy
+	}
+
+	// the stays only keep their units while the guest pays, and are confirmed together once the
+	// payment is taken
+	due := groups.AmountDue(property.PaymentPolicy, property.DepositPercent, g.Reservations)
+	if due > 0 {
+		pendingUntil := time.Now().Add(holds.PaymentDuration)
+		for i := range g.Reservations {
+			g.Reservations[i].PendingUntil = pendingUntil
+		}
+	}
+
+	g.ID, err = m.DB.InsertGroupBooking(g)
+	if errors.Is(err, repository.ErrUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, one of the rooms is no longer available for its dates")
+		http.Redirect(w, r, "/group", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't insert group booking into database")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	m.restrictionsChanged()
+
+	m.App.Session.Remove(r.Context(), "group")
+	m.App.Session.Put(r.Context(), "group_booking_id", g.ID)
+
+	// rooms without a price have nothing to pay, so skip the checkout
+	if due <= 0 {
+		booked, err := m.DB.GetGroupBookingByID(g.ID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		m.sendGroupConfirmation(property, booked, 0)
+		http.Redirect(w, r, "/group/summary", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/group/checkout", http.StatusSeeOther)
+}
+
+// bookedGroup returns the group booking the guest made, kept in their session until they leave
+func (m *Repository) bookedGroup(w http.ResponseWriter, r *http.Request) (models.GroupBooking, bool) {
+	id := m.App.Session.GetInt(r.Context(), "group_booking_id")
+	if id == 0 {
+		m.App.Session.Put(r.Context(), "error", "can't get group booking from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return models.GroupBooking{}, false
+	}
+
+	g, err := m.DB.GetGroupBookingByID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return models.GroupBooking{}, false
+	}
+	return g, true
+}
+
+// groupPaid returns what has been paid towards the stays of a group booking, less refunds
+func (m *Repository) groupPaid(g models.GroupBooking) (int, error) {
+	paid := 0
+	for _, res := range g.Reservations {
+		ps, err := m.DB.GetPaymentsForReservation(res.ID)
+		if err != nil {
+			return 0, err
+		}
+		paid += amountPaid(ps)
+	}
+	return paid, nil
+}
+
+// groupLapsed reports whether the stays of a group booking, which run out together, ran out at
+// now before they were paid for
+func groupLapsed(g models.GroupBooking, now time.Time) bool {
+	return len(g.Reservations) > 0 && holds.Lapsed(g.Reservations[0], now)
+}
+
+// groupBookingLapsed sends a guest whose group booking ran out before it was paid for back to
+// book again, with message telling them what happened
+func (m *Repository) groupBookingLapsed(w http.ResponseWriter, r *http.Request, message string) {
+	m.App.Session.Remove(r.Context(), "group_booking_id")
+	m.App.Session.Put(r.Context(), "error", message)
+	http.Redirect(w, r, "/group", http.StatusSeeOther)
+}
+
+// GroupCheckout shows the guest what they pay now for the group booking they just made
+func (m *Repository) GroupCheckout(w http.ResponseWriter, r *http.Request) {
+	g, ok := m.bookedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	paid, err := m.groupPaid(g)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if paid > 0 {
+		http.Redirect(w, r, "/group/summary", http.StatusSeeOther)
+		return
+	}
+	if groupLapsed(g, time.Now()) {
+		m.groupBookingLapsed(w, r, lapsedMessage)
+		return
+	}
+
+	property := helpers.CurrentProperty(r)
+	due := groups.AmountDue(property.PaymentPolicy, property.DepositPercent, g.Reservations)
 
-	sd := reservation.StartDate.Format("2006-01-02")
-	ed := reservation.EndDate.Format("2006-01-02")
+	data := make(map[string]interface{})
+	data["group"] = g
 
 	stringMap := make(map[string]string)
+	stringMap["gateway"] = m.App.Payments.Name()
 
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
+	intMap := make(map[string]int)
+	intMap["due"] = due
+	intMap["balance"] = g.Total - due
+	if len(g.Reservations) > 0 && !g.Reservations[0].PendingUntil.IsZero() {
+		intMap["pending_minutes"] = int(math.Ceil(time.Until(g.Reservations[0].PendingUntil).Minutes()))
+	}
 
-	render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
+	render.Template(w, r, "group-checkout.page.tmpl", &models.TemplateData{
 		Data:      data,
 		StringMap: stringMap,
+		IntMap:    intMap,
 	})
 }
 
-// Displays a list of available rooms
-func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
-	exploded := strings.Split(r.RequestURI, "/")
-	roomID, err := strconv.Atoi(exploded[2])
+// PostGroupCheckout takes one payment for the stays of the group booking in the session and
+// confirms it
+func (m *Repository) PostGroupCheckout(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/group/checkout", http.StatusSeeOther)
 		return
 	}
 
-	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	g, ok := m.bookedGroup(w, r)
 	if !ok {
-		helpers.ServerError(w, err)
 		return
 	}
-	res.RoomID = roomID
 
-	m.App.Session.Put(r.Context(), "reservation", res)
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
-}
-
-// BookRoom takes URL parameters, builds a sessional variable and takes use to make-reservation screen
-func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
-	roomID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	paid, err := m.groupPaid(g)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if paid > 0 {
+		http.Redirect(w, r, "/group/summary", http.StatusSeeOther)
+		return
+	}
+	if groupLapsed(g, time.Now()) {
+		m.groupBookingLapsed(w, r, lapsedMessage)
+		return
 	}
-	sd := r.URL.Query().Get("s")
-
-	ed := r.URL.Query().Get("e")
 
-	var res models.Reservation
+	property := helpers.CurrentProperty(r)
+	due := groups.AmountDue(property.PaymentPolicy, property.DepositPercent, g.Reservations)
+	gateway := m.App.Payments
+
+	// each stay's share is recorded against its own reservation before the guest is charged, so
+	// a stay can be cancelled and refunded on its own and submitting the form twice doesn't
+	// charge the guest twice. Failed attempts show on the admin reservation pages too.
+	var shares []models.Payment
+	for _, res := range g.Reservations {
+		if !res.CancelledAt.IsZero() {
+			continue
+		}
+		share := payments.AmountDue(property.PaymentPolicy, property.DepositPercent, res.Total)
+		payment := models.Payment{
+			ReservationID: res.ID,
+			Kind:          payments.Kind(share, res.Total),
+			Amount:        share,
+			Gateway:       gateway.Name(),
+		}
+		payment.ID, err = m.DB.StartPayment(payment)
+		if err != nil {
+			m.failPayments(r, shares)
+			if errors.Is(err, repository.ErrPaymentInProgress) {
+				m.App.Session.Put(r.Context(), "warning", "Your payment is already being taken")
+				http.Redirect(w, r, "/group/checkout", http.StatusSeeOther)
+				return
+			}
+			helpers.ServerError(w, r, err)
+			return
+		}
+		shares = append(shares, payment)
+	}
 
-	res.RoomID = roomID
-	layout := "2006-01-02"
-	startDate, err := time.Parse(layout, sd)
+	reference, err := m.takePayment(r, payments.Charge{
+		Amount:      due,
+		Token:       r.Form.Get("payment_token"),
+		Description: fmt.Sprintf("%s group booking %s", property.Name, g.Reference),
+	})
+	status := payments.StatusCaptured
 	if err != nil {
-		helpers.ServerError(w, err)
+		status = payments.StatusFailed
 	}
-	endDate, err := time.Parse(layout, ed)
-	if err != nil {
-		helpers.ServerError(w, err)
+
+	for i := range shares {
+		shares[i].Status = status
+		shares[i].Reference = reference
+		updateErr := m.DB.UpdatePayment(shares[i])
+		if updateErr != nil {
+			helpers.ServerError(w, r, updateErr)
+			return
+		}
 	}
-	room, err := m.DB.GetRoomByID(roomID)
+
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Can't get room from db!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		helpers.Logger(r).Error("taking group payment", "error", err)
+		message := "We couldn't take your payment, please try again"
+		if errors.Is(err, payments.ErrDeclined) {
+			message = "Your payment was declined, please try another card"
+		}
+		m.App.Session.Put(r.Context(), "error", message)
+		http.Redirect(w, r, "/group/checkout", http.StatusSeeOther)
 		return
 	}
 
-	res.StartDate = startDate
-	res.EndDate = endDate
-	res.Room.RoomName = room.RoomName
+	// the stays may have run out while the payment was being taken, in which case each stay's
+	// share is given back
+	if len(g.Reservations) > 0 && !g.Reservations[0].PendingUntil.IsZero() {
+		confirmed, err := m.DB.ConfirmGroupBooking(g.ID, time.Now())
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		if !confirmed {
+			for _, p := range shares {
+				err = m.refund(p.ReservationID, []models.Payment{p}, p.Amount)
+				if err != nil {
+					helpers.Logger(r).Error("refunding lapsed group booking", "reservation_id", p.ReservationID, "error", err)
+				}
+			}
+			m.groupBookingLapsed(w, r, "Sorry, your group booking ran out while your payment was being taken. "+
+				"Your payment has been refunded, please book again")
+			return
+		}
+	}
 
-	m.App.Session.Put(r.Context(), "reservation", res)
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+	m.sendGroupConfirmation(property, g, due)
+
+	m.App.Session.Put(r.Context(), "flash", "Payment received, your group booking is confirmed")
+	http.Redirect(w, r, "/group/summary", http.StatusSeeOther)
 }
 
-// ShowLogin renders the login page
-func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
-		Form: forms.New(nil),
+// failPayments marks payments that were started but never sent to the gateway as failed, so the
+// guest can try again
+func (m *Repository) failPayments(r *http.Request, started []models.Payment) {
+	for _, p := range started {
+		p.Status = payments.StatusFailed
+		err := m.DB.UpdatePayment(p)
+		if err != nil {
+			helpers.Logger(r).Error("failing payment", "payment_id", p.ID, "error", err)
+		}
+	}
+}
+
+// GroupSummary shows the guest the group booking they made
+func (m *Repository) GroupSummary(w http.ResponseWriter, r *http.Request) {
+	g, ok := m.bookedGroup(w, r)
+	if !ok {
+		return
+	}
+
+	paid, err := m.groupPaid(g)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["group"] = g
+
+	intMap := make(map[string]int)
+	intMap["paid"] = paid
+	intMap["balance"] = g.Total - paid
+
+	render.Template(w, r, "group-summary.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
 	})
 }
 
-// PostShowLogin handles logging the user in
-func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.RenewToken(r.Context())
+// sendGroupConfirmation emails the guest one confirmation for all the stays of their group
+// booking, and lets the property know about it
+func (m *Repository) sendGroupConfirmation(property models.Property, g models.GroupBooking, paid int) {
+	var stays strings.Builder
+	var ownerStays strings.Builder
+	for _, res := range g.Reservations {
+		m.App.Metrics.ReservationsCreated.Inc(reservationSourceDirect)
+		m.emitWebhook(property.ID, webhooks.EventReservationCreated, webhooks.ReservationData(res))
+
+		fmt.Fprintf(&stays, `<li>%s from %s to %s for %d adult(s) and %d child(ren), %s.
+		%s You can cancel this room at <a href="%s">%s</a></li>`,
+			html.EscapeString(res.Room.RoomName), res.StartDate.Format(dates.Layout), res.EndDate.Format(dates.Layout),
+			res.Adults, res.Children, pricing.FormatMoney(res.Total), cancellation.Describe(res.CancellationPolicy),
+			m.manageURL(property, res.ManageToken), m.manageURL(property, res.ManageToken))
+		fmt.Fprintf(&ownerStays, "<li>%s: %s to %s, %d adult(s), %d child(ren), %s</li>",
+			html.EscapeString(res.Room.RoomName), res.StartDate.Format(dates.Layout), res.EndDate.Format(dates.Layout),
+			res.Adults, res.Children, pricing.FormatMoney(res.Total))
+	}
 
-	err := r.ParseForm()
+	htmlMessageToGuest := fmt.Sprintf(`
+	<strong>Group Booking Confirmation</strong> <br>
+	Dear %s,
+	
+	Thank you for your group booking, your reference is %s. 
+	Check-in is from %s and check-out until %s. You have booked:
+	<ul>%s</ul>
+	The total for your group is %s, of which you have paid %s and %s is due on arrival.`,
+		g.FirstName, g.Reference, property.CheckInTime, property.CheckOutTime, stays.String(),
+		pricing.FormatMoney(g.Total), pricing.FormatMoney(paid), pricing.FormatMoney(g.Total-paid))
+
+	m.App.MailChan <- models.MailData{
+		To:       g.Email,
+		From:     property.SenderEmail,
+		Subject:  "Group Booking Confirmation " + g.Reference,
+		Content:  htmlMessageToGuest,
+		Template: "basic.html",
+	}
+
+	htmlMessageToOwner := fmt.Sprintf(`
+	<strong>New Group Booking %s</strong> <br>
+	Guest: %s %s, %s, %s<br>
+	Rooms:
+	<ol>%s</ol>
+	Total: %s, paid: %s
+	`, g.Reference, g.FirstName, g.LastName, g.Email, g.Phone, ownerStays.String(),
+		pricing.FormatMoney(g.Total), pricing.FormatMoney(paid))
+
+	m.App.MailChan <- models.MailData{
+		To:      property.NotificationEmail,
+		From:    property.SenderEmail,
+		Subject: "New Group Booking " + g.Reference,
+		Content: htmlMessageToOwner,
+	}
+}
+
+// AdminGroupBookings lists the group bookings of the property
+func (m *Repository) AdminGroupBookings(w http.ResponseWriter, r *http.Request) {
+	bookings, err := m.DB.AllGroupBookings(helpers.CurrentProperty(r).ID)
 	if err != nil {
-		log.Println(err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	email := r.Form.Get("email")
-	password := r.Form.Get("password")
+	data := make(map[string]interface{})
+	data["groups"] = bookings
 
-	form := forms.New(r.PostForm)
-	form.Required("email", "password")
-	form.IsEmail("email")
-	if !form.Valid() {
-		// Take user back to the login page
-		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
-			Form: form,
-		})
+	render.Template(w, r, "admin-group-bookings.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowGroupBooking shows a group booking with the reservations of all its stays
+func (m *Repository) AdminShowGroupBooking(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	g, err := m.DB.GetGroupBookingByID(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && g.PropertyID != helpers.CurrentProperty(r).ID) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		log.Println(err)
+		helpers.ServerError(w, r, err)
+		return
+	}
 
-		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
-		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+	paid, err := m.groupPaid(g)
+	if err != nil {
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "user_id", id)
-	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	data := make(map[string]interface{})
+	data["group"] = g
 
-}
+	intMap := make(map[string]int)
+	intMap["paid"] = paid
+	intMap["balance"] = g.Total - paid
 
-// Logout logs the user out
-func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.Destroy(r.Context())
-	_ = m.App.Session.RenewToken(r.Context())
+	render.Template(w, r, "admin-group-booking.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
 
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+// emitWebhook hands an event of a property to the webhook listener, which delivers it to the
+// subscriptions of the property that want it. Requests never wait on the listener: when it is
+// behind, the deliveries are only saved and left for RetryWebhookDeliveries to send.
+func (m *Repository) emitWebhook(propertyID int, event string, data interface{}) {
+	e := models.WebhookEvent{PropertyID: propertyID, Event: event, Data: data}
+	select {
+	case m.App.WebhookChan <- e:
+	default:
+		m.App.Logger.Warn("webhook listener is behind, leaving the event to the retries", "event", event,
+			"property_id", propertyID)
+		m.saveWebhookDeliveries(e)
+	}
 }
 
-func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	total_reservations, err := m.DB.GetTotalReservations()
-	if err != nil {
-		helpers.ServerError(w, err)
+// DeliverWebhookEvent records a delivery of an event for every subscription of its property that
+// wants it and attempts each right away. Failed deliveries are left for RetryWebhookDeliveries,
+// so errors are logged rather than returned.
+func (m *Repository) DeliverWebhookEvent(e models.WebhookEvent) {
+	for _, d := range m.saveWebhookDeliveries(e) {
+		m.attemptWebhookDelivery(d)
 	}
-	revenue_total := 0
-	room_ids, err := m.DB.GetRoomIDs()
+}
+
+// saveWebhookDeliveries records a delivery of an event, due right away, for every subscription
+// of its property that wants it and returns them. Errors are logged rather than returned, a
+// delivery that couldn't be saved is left out.
+func (m *Repository) saveWebhookDeliveries(e models.WebhookEvent) []models.WebhookDelivery {
+	subscriptions, err := m.DB.AllWebhookSubscriptions(e.PropertyID)
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Logger.Error("getting webhook subscriptions", "property_id", e.PropertyID, "error", err)
+		return nil
 	}
-	for id := range room_ids {
-		var revenue int
-		revenue, err = m.DB.GetRevenueByRoom(id)
+
+	now := time.Now()
+	var payload []byte
+	var deliveries []models.WebhookDelivery
+
+	for _, s := range subscriptions {
+		if !webhooks.Subscribed(s, e.Event) {
+			continue
+		}
+
+		// every subscription is sent the same body, so it is only built when someone wants it
+		if payload == nil {
+			payload, err = webhooks.Payload(e.Event, e.Data, now)
+			if err != nil {
+				m.App.Logger.Error("building webhook payload", "event", e.Event, "error", err)
+				return nil
+			}
+		}
+
+		d := models.WebhookDelivery{
+			SubscriptionID: s.ID,
+			Event:          e.Event,
+			Payload:        string(payload),
+			NextAttemptAt:  now,
+			Subscription:   s,
+		}
+		d.ID, err = m.DB.InsertWebhookDelivery(d)
 		if err != nil {
-			helpers.ServerError(w, err)
+			m.App.Logger.Error("saving webhook delivery", "webhook_id", s.ID, "event", e.Event, "error", err)
+			continue
 		}
-		revenue_total += revenue
+		deliveries = append(deliveries, d)
 	}
-	var todos []models.TodoData
-	todos, err = m.DB.GetToDoList()
+	return deliveries
+}
+
+// RetryWebhookDeliveries attempts the webhook deliveries that failed and are due another go
+func (m *Repository) RetryWebhookDeliveries() {
+	deliveries, err := m.DB.DueWebhookDeliveries(time.Now())
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Logger.Error("getting due webhook deliveries", "error", err)
+		return
 	}
 
-	data := make(map[string]interface{})
-	data["count"] = total_reservations
-	data["revenue"] = revenue_total
-	data["todos"] = todos
-
-	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{
-		Data: data,
-	})
+	for _, d := range deliveries {
+		m.attemptWebhookDelivery(d)
+	}
 }
 
-// Shows all reservations in admin tool
-func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+// attemptWebhookDelivery sends a delivery to its subscription and saves how it went, backing
+// off before the next attempt when it failed. The delivery is claimed first, so it isn't sent
+// twice when the retries come round while it is attempted right after it was made.
+func (m *Repository) attemptWebhookDelivery(d models.WebhookDelivery) {
+	now := time.Now()
+	claimed, err := m.DB.ClaimWebhookDelivery(d.ID, now, now.Add(webhooks.ClaimFor))
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Logger.Error("claiming webhook delivery", "delivery_id", d.ID, "error", err)
+		return
+	}
+	if !claimed {
 		return
 	}
 
-	data := make(map[string]interface{})
-	data["reservations"] = reservations
+	status, err := webhooks.Send(d)
 
-	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
-	})
+	d.Attempts++
+	d.StatusCode = status
+	d.LastAttemptAt = now
+	d.NextAttemptAt = time.Time{}
+	d.Error = ""
+	if err == nil {
+		d.DeliveredAt = now
+	} else {
+		d.Error = err.Error()
+		d.NextAttemptAt, _ = webhooks.NextAttempt(d.Attempts, now)
+	}
 
+	err = m.DB.UpdateWebhookDelivery(d)
+	if err != nil {
+		m.App.Logger.Error("saving webhook delivery", "delivery_id", d.ID, "error", err)
+	}
 }
 
-// Shows new reservations in admin tool
-func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+// AdminWebhooks lists the webhook subscriptions of the current property with a form to add one
+func (m *Repository) AdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	m.renderWebhooks(w, r, forms.New(nil))
+}
+
+// renderWebhooks renders the webhooks page with the given add subscription form
+func (m *Repository) renderWebhooks(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	subscriptions, err := m.DB.AllWebhookSubscriptions(helpers.CurrentProperty(r).ID)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	data := make(map[string]interface{})
-	data["reservations"] = reservations
-	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
+	data["subscriptions"] = subscriptions
+	data["events"] = webhooks.Events
+	data["selected"] = selectedEvents(form.Values["events"])
+
+	render.Template(w, r, "admin-webhooks.page.tmpl", &models.TemplateData{
+		Form: form,
 		Data: data,
 	})
+}
 
+// selectedEvents returns which of the events were ticked on a webhook subscription form
+func selectedEvents(events []string) map[string]bool {
+	selected := make(map[string]bool)
+	for _, e := range events {
+		selected[e] = true
+	}
+	return selected
 }
 
-// AdminShowReservation shows the reservation in the admin tool
-func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
-	exploded := strings.Split(r.RequestURI, "/")
-	id, err := strconv.Atoi(exploded[4])
+// webhookSubscriptionFromForm validates the URL and events posted for a webhook subscription
+// and copies them into s
+func webhookSubscriptionFromForm(form *forms.Form, s *models.WebhookSubscription) {
+	form.Required("url")
+	if form.Has("url") && !webhooks.ValidURL(strings.TrimSpace(form.Get("url"))) {
+		form.Errors.Add("url", "Enter an http or https URL")
+	}
+
+	s.URL = strings.TrimSpace(form.Get("url"))
+	s.Events = nil
+	for _, e := range form.Values["events"] {
+		if !webhooks.ValidEvent(e) {
+			form.Errors.Add("events", "Invalid event")
+			return
+		}
+		s.Events = append(s.Events, e)
+	}
+	if len(s.Events) == 0 {
+		form.Errors.Add("events", "Pick at least one event")
+	}
+}
+
+// AdminPostWebhooks adds a webhook subscription to the current property, with a new secret to
+// sign its deliveries with
+func (m *Repository) AdminPostWebhooks(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	src := exploded[3]
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
+	s := models.WebhookSubscription{
+		PropertyID: helpers.CurrentProperty(r).ID,
+		Active:     true,
+	}
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	form := forms.New(r.PostForm)
+	webhookSubscriptionFromForm(form, &s)
+	if !form.Valid() {
+		m.renderWebhooks(w, r, form)
+		return
+	}
 
-	stringMap["year"] = year
-	stringMap["month"] = month
-	// Get reservation from the database
-	res, err := m.DB.GetReservationByID(id)
+	s.Secret, err = webhooks.NewSecret()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	s.ID, err = m.DB.InsertWebhookSubscription(s)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Webhook added, check its deliveries with the secret below")
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", s.ID), http.StatusSeeOther)
+}
+
+// adminWebhookSubscription returns the webhook subscription of the current property in the URL,
+// responding with an error when there is none
+func (m *Repository) adminWebhookSubscription(w http.ResponseWriter, r *http.Request) (models.WebhookSubscription, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return models.WebhookSubscription{}, false
+	}
+
+	s, err := m.DB.GetWebhookSubscriptionByID(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && s.PropertyID != helpers.CurrentProperty(r).ID) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return s, false
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return s, false
+	}
+	return s, true
+}
+
+// AdminShowWebhook shows a webhook subscription with its latest deliveries
+func (m *Repository) AdminShowWebhook(w http.ResponseWriter, r *http.Request) {
+	s, ok := m.adminWebhookSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(url.Values{
+		"url":    {s.URL},
+		"events": s.Events,
+	})
+	if s.Active {
+		form.Set("active", "1")
+	}
+
+	m.renderWebhook(w, r, s, form)
+}
+
+// renderWebhook renders the page of a webhook subscription with the given edit form
+func (m *Repository) renderWebhook(w http.ResponseWriter, r *http.Request, s models.WebhookSubscription, form *forms.Form) {
+	deliveries, err := m.DB.GetWebhookDeliveriesForSubscription(s.ID)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	data := make(map[string]interface{})
-	data["reservation"] = res
-	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
-		StringMap: stringMap,
+	data["subscription"] = s
+	data["deliveries"] = deliveries
+	data["events"] = webhooks.Events
+	data["selected"] = selectedEvents(form.Values["events"])
+
+	stringMap := make(map[string]string)
+	stringMap["signature_header"] = webhooks.SignatureHeader
+	stringMap["event_header"] = webhooks.EventHeader
+	stringMap["delivery_header"] = webhooks.DeliveryHeader
+
+	intMap := make(map[string]int)
+	intMap["max_attempts"] = webhooks.MaxAttempts
+
+	render.Template(w, r, "admin-webhook.page.tmpl", &models.TemplateData{
+		Form:      form,
 		Data:      data,
-		Form:      forms.New(nil),
+		StringMap: stringMap,
+		IntMap:    intMap,
 	})
 }
 
-// AdminPostShowReservation posts the modified reservation in the admin tool
-func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+// AdminPostWebhook saves the URL, events and whether a webhook subscription is active
+func (m *Repository) AdminPostWebhook(w http.ResponseWriter, r *http.Request) {
+	s, ok := m.adminWebhookSubscription(w, r)
+	if !ok {
+		return
+	}
+
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	exploded := strings.Split(r.RequestURI, "/")
-	id, err := strconv.Atoi(exploded[4])
+	form := forms.New(r.PostForm)
+	webhookSubscriptionFromForm(form, &s)
+	s.Active = form.Has("active")
+	if !form.Valid() {
+		m.renderWebhook(w, r, s, form)
+		return
+	}
+
+	err = m.DB.UpdateWebhookSubscription(s)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	src := exploded[3]
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
+	m.App.Session.Put(r.Context(), "flash", "Changes Saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", s.ID), http.StatusSeeOther)
+}
 
-	// Get reservation from the database
-	res, err := m.DB.GetReservationByID(id)
+// AdminDeleteWebhook removes a webhook subscription of the current property with its deliveries
+func (m *Repository) AdminDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	s, ok := m.adminWebhookSubscription(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DeleteWebhookSubscription(s.PropertyID, s.ID)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	res.FirstName = r.Form.Get("first_name")
-	res.LastName = r.Form.Get("last_name")
-	res.Email = r.Form.Get("email")
-	res.Phone = r.Form.Get("phone")
+	m.App.Session.Put(r.Context(), "flash", "Webhook deleted")
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
 
-	err = m.DB.UpdateReservation(res)
+// AdminReplayWebhookDelivery sends an event to a subscription again as a new delivery, which is
+// attempted with the next round of retries
+func (m *Repository) AdminReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ClientError(w, r, http.StatusBadRequest)
 		return
 	}
 
-	year := r.Form.Get("year")
-	month := r.Form.Get("month")
+	d, err := m.DB.GetWebhookDeliveryByID(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && d.Subscription.PropertyID != helpers.CurrentProperty(r).ID) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes Saved")
+	replay := models.WebhookDelivery{
+		SubscriptionID: d.SubscriptionID,
+		Event:          d.Event,
+		Payload:        d.Payload,
+		NextAttemptAt:  time.Now(),
+	}
+	_, err = m.DB.InsertWebhookDelivery(replay)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	if d.Subscription.Active {
+		m.App.Session.Put(r.Context(), "flash", "The delivery will be sent again within a minute")
 	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calender?y=%s&m=%s", year, month), http.StatusSeeOther)
-
+		m.App.Session.Put(r.Context(), "warning", "The delivery will be sent again once the webhook is active")
 	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", d.SubscriptionID), http.StatusSeeOther)
 }
 
-// AdminReservationsCalender displays the reservation calender
-func (m *Repository) AdminReservationsCalender(w http.ResponseWriter, r *http.Request) {
-	// assume there is no month and year in the url parameters
-	now := time.Now()
+// channel returns the channel called name the application is connected to
+func (m *Repository) channel(name string) (channels.Channel, bool) {
+	for _, ch := range m.App.Channels {
+		if ch.Name() == name {
+			return ch, true
+		}
+	}
+	return nil, false
+}
 
-	if r.URL.Query().Get("y") != "" {
-		year, err := strconv.Atoi(r.URL.Query().Get("y"))
+// SyncChannels syncs every property with rooms mapped to a channel with that channel. A failed
+// sync is picked up by the next one, so errors are logged rather than returned.
+func (m *Repository) SyncChannels() {
+	for _, ch := range m.App.Channels {
+		propertyIDs, err := m.DB.GetChannelPropertyIDs(ch.Name())
 		if err != nil {
-			helpers.ServerError(w, err)
+			m.App.Logger.Error("getting channel properties", "channel", ch.Name(), "error", err)
+			continue
 		}
-		month, err := strconv.Atoi(r.URL.Query().Get("m"))
-		if err != nil {
-			helpers.ServerError(w, err)
+
+		for _, id := range propertyIDs {
+			property, err := m.DB.GetPropertyByID(id)
+			if err != nil {
+				m.App.Logger.Error("getting property to sync", "channel", ch.Name(), "property_id", id, "error", err)
+				continue
+			}
+			_, err = m.syncChannel(property, ch)
+			if err != nil {
+				m.App.Logger.Warn("syncing channel", "channel", ch.Name(), "property_id", id, "error", err)
+			}
 		}
-		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
 	}
+}
 
-	data := make(map[string]interface{})
-	data["now"] = now
-
-	next := now.AddDate(0, 1, 0)
-	last := now.AddDate(0, -1, 0)
-
-	nextMonth := next.Format("01")
-	nextMonthYear := next.Format("2006")
+// channelSync sums up a sync of a property with a channel
+type channelSync struct {
+	Imported  int
+	Conflicts int
+	Failed    int
+	Pushed    int
+}
 
-	lastMonth := last.Format("01")
-	lastMonthYear := last.Format("2006")
+// syncChannel pulls the reservations a channel took for the mapped rooms of a property, then
+// pushes the availability of those rooms so it takes the imported reservations into account.
+// Every step is recorded in the sync log of the channel.
+func (m *Repository) syncChannel(property models.Property, ch channels.Channel) (channelSync, error) {
+	var sum channelSync
 
-	stringMap := make(map[string]string)
-	stringMap["next_month"] = nextMonth
-	stringMap["next_month_year"] = nextMonthYear
+	mappings, err := m.DB.GetChannelMappings(property.ID, ch.Name())
+	if err != nil || len(mappings) == 0 {
+		return sum, err
+	}
 
-	stringMap["last_month"] = lastMonth
-	stringMap["last_month_year"] = lastMonthYear
+	err = m.pullChannel(property, ch, mappings, &sum)
+	if err != nil {
+		return sum, err
+	}
 
-	stringMap["this_month"] = now.Format("01")
-	stringMap["this_month_year"] = now.Format("2006")
+	today := dates.Today(dates.Location(property.Timezone))
+	nights, err := m.DB.GetNightlyAvailability(property.ID, 1, today, today.AddDate(0, 0, channels.HorizonDays))
+	if err != nil {
+		return sum, err
+	}
 
-	// get the first and last days of the month
-	currentYear, currentMonth, _ := now.Date()
-	currentLocation := now.Location()
-	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
-	intMap := make(map[string]int)
-	intMap["days_in_month"] = lastOfMonth.Day()
+	updates := channels.Updates(mappings, nights)
+	err = ch.PushAvailability(updates)
+	if err != nil {
+		m.logChannelSync(models.ChannelSyncLog{
+			PropertyID: property.ID,
+			Channel:    ch.Name(),
+			Direction:  channels.DirectionPush,
+			Status:     channels.StatusError,
+			Message:    err.Error(),
+		})
+		return sum, err
+	}
+	sum.Pushed = len(updates)
+
+	m.logChannelSync(models.ChannelSyncLog{
+		PropertyID: property.ID,
+		Channel:    ch.Name(),
+		Direction:  channels.DirectionPush,
+		Status:     channels.StatusOK,
+		Message:    fmt.Sprintf("Pushed availability for %d room night(s)", len(updates)),
+	})
+	return sum, nil
+}
 
-	rooms, err := m.DB.AllRooms()
+// pullChannel imports the reservations a channel took for the mapped rooms of a property since
+// the last pull, adding up what happened to them in sum
+func (m *Repository) pullChannel(property models.Property, ch channels.Channel, mappings []models.ChannelMapping, sum *channelSync) error {
+	since, err := m.DB.LastChannelPull(property.ID, ch.Name())
 	if err != nil {
-		helpers.ServerError(w, err)
+		return err
+	}
+	if !since.IsZero() {
+		since = since.Add(-channels.PullOverlap)
 	}
-	data["rooms"] = rooms
 
-	for _, x := range rooms {
-		// create maps
-		reservationMap := make(map[string]int)
-		blockMap := make(map[string]int)
+	pulled, err := ch.PullReservations(since)
+	if err != nil {
+		m.logChannelSync(models.ChannelSyncLog{
+			PropertyID: property.ID,
+			Channel:    ch.Name(),
+			Direction:  channels.DirectionPull,
+			Status:     channels.StatusError,
+			Message:    err.Error(),
+		})
+		return err
+	}
 
-		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
-			reservationMap[d.Format("2006-01-2")] = 0
-			blockMap[d.Format("2006-01-2")] = 0
+	// a channel returns the bookings of every property with rooms on it, those of rooms that
+	// aren't mapped for this property are left to the properties they belong to
+	var own []channels.Reservation
+	for _, x := range pulled {
+		if _, ok := channels.RoomFor(mappings, x.ExternalRoomID); ok {
+			own = append(own, x)
 		}
+	}
 
-		// get all the restrictions for the current room
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
-		if err != nil {
-			helpers.ServerError(w, err)
-			return
+	for _, x := range own {
+		entry := m.importChannelReservation(property, ch.Name(), mappings, x)
+		if entry.Status == "" {
+			continue
+		}
+		switch entry.Status {
+		case channels.StatusOK:
+			sum.Imported++
+		case channels.StatusConflict:
+			sum.Conflicts++
+		default:
+			sum.Failed++
 		}
+		m.logChannelSync(entry)
+	}
+	if sum.Imported > 0 {
+		m.restrictionsChanged()
+	}
 
-		for _, y := range restrictions {
-			if y.ReservationID > 0 {
-				// if it's a reservation
+	// the next pull starts from the last one that went through, so bookings that failed to
+	// import are pulled again
+	status := channels.StatusOK
+	if sum.Failed > 0 {
+		status = channels.StatusError
+	}
+	m.logChannelSync(models.ChannelSyncLog{
+		PropertyID: property.ID,
+		Channel:    ch.Name(),
+		Direction:  channels.DirectionPull,
+		Status:     status,
+		Message: fmt.Sprintf("Pulled %d reservation(s): %d imported, %d conflict(s), %d failed",
+			len(own), sum.Imported, sum.Conflicts, sum.Failed),
+	})
+	return nil
+}
 
-				for d := y.StartDate; !d.After(y.EndDate); d = d.AddDate(0, 0, 1) {
-					reservationMap[d.Format("2006-01-2")] = y.ReservationID
-				}
+// importChannelReservation inserts a reservation a channel took, giving it a free unit of the
+// mapped room, and returns the sync log entry saying how it went. Bookings imported before
+// return an entry without a status. When every unit is taken for some of the nights the booking is a
+// conflict, which the property is emailed about as staff have to sort it out with the guest. A
+// conflict is only logged and emailed once, later pulls of the booking return an entry without
+// a status too.
+func (m *Repository) importChannelReservation(property models.Property, channel string, mappings []models.ChannelMapping, x channels.Reservation) models.ChannelSyncLog {
+	entry := models.ChannelSyncLog{
+		PropertyID:        property.ID,
+		Channel:           channel,
+		Direction:         channels.DirectionPull,
+		Status:            channels.StatusError,
+		ExternalReference: x.Reference,
+	}
+	failed := func(err error) models.ChannelSyncLog {
+		entry.Message = err.Error()
+		return entry
+	}
 
-			} else {
-				// if it's a block
-				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
-			}
+	existingID, err := m.DB.GetReservationIDByChannelReference(channel, x.Reference)
+	if err != nil {
+		return failed(err)
+	}
+	if existingID > 0 {
+		return models.ChannelSyncLog{}
+	}
+	recorded, err := m.DB.ChannelConflictRecorded(channel, x.Reference)
+	if err != nil {
+		return failed(err)
+	}
+	if recorded {
+		return models.ChannelSyncLog{}
+	}
+
+	err = channels.Check(x)
+	if err != nil {
+		return failed(err)
+	}
+
+	roomID, ok := channels.RoomFor(mappings, x.ExternalRoomID)
+	if !ok {
+		return failed(fmt.Errorf("no room is mapped to external room %s", x.ExternalRoomID))
+	}
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil {
+		return failed(err)
+	}
+
+	res := models.Reservation{
+		FirstName:          x.FirstName,
+		LastName:           x.LastName,
+		Email:              x.Email,
+		Phone:              x.Phone,
+		StartDate:          dates.Normalize(x.StartDate),
+		EndDate:            dates.Normalize(x.EndDate),
+		RoomID:             roomID,
+		Adults:             x.Adults,
+		Children:           x.Children,
+		Total:              x.Total,
+		Room:               room,
+		CancellationPolicy: room.CancellationPolicy,
+		Channel:            channel,
+		ChannelReference:   x.Reference,
+	}
+
+	conflict := func() models.ChannelSyncLog {
+		entry.Status = channels.StatusConflict
+		entry.Message = fmt.Sprintf("%s is already booked for some of the nights from %s to %s",
+			room.RoomName, res.StartDate.Format(dates.Layout), res.EndDate.Format(dates.Layout))
+
+		m.App.MailChan <- models.MailData{
+			To:      property.NotificationEmail,
+			From:    property.SenderEmail,
+			Subject: fmt.Sprintf("Double booking from %s", channel),
+			Content: fmt.Sprintf(`
+			<strong>Double Booking</strong> <br>
+			%s booking %s for %s %s couldn't be imported: %s.<br>
+			It won't be imported again, please book it by hand once a room is free or contact the guest.`,
+				html.EscapeString(channel), html.EscapeString(x.Reference), html.EscapeString(x.FirstName),
+				html.EscapeString(x.LastName), html.EscapeString(entry.Message)),
 		}
-		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
-		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+		return entry
+	}
 
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+	res.RoomUnitID, err = m.DB.FindAvailableUnit(roomID, res.StartDate, res.EndDate)
+	if err != nil {
+		return failed(err)
+	}
+	if res.RoomUnitID == 0 {
+		return conflict()
 	}
 
-	render.Template(w, r, "admin-reservations-calender.page.tmpl", &models.TemplateData{
-		StringMap: stringMap,
-		Data:      data,
-		IntMap:    intMap,
-	})
+	res.ManageToken, err = newToken()
+	if err != nil {
+		return failed(err)
+	}
+
+	// channels don't always pass on the guest's email, and guests are told apart by it
+	if res.Email != "" {
+		res.GuestID, err = m.DB.UpsertGuest(models.Guest{
+			PropertyID: property.ID,
+			FirstName:  res.FirstName,
+			LastName:   res.LastName,
+			Email:      res.Email,
+			Phone:      res.Phone,
+		})
+		if err != nil {
+			return failed(err)
+		}
+	}
+
+	// the unit may have been taken since it was found, which is a conflict like any other
+	res.ID, err = m.DB.InsertReservationWithRestriction(res, 0)
+	if errors.Is(err, repository.ErrUnavailable) {
+		return conflict()
+	}
+	if err != nil {
+		return failed(err)
+	}
+	m.App.Metrics.ReservationsCreated.Inc(channel)
+	m.emitWebhook(property.ID, webhooks.EventReservationCreated, webhooks.ReservationData(res))
 
+	entry.Status = channels.StatusOK
+	entry.ReservationID = res.ID
+	entry.Message = fmt.Sprintf("Imported as reservation %d", res.ID)
+	return entry
 }
 
-// AdminPostReservationsCalender handles post of reservation calender
-func (m *Repository) AdminPostReservationsCalender(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+// logChannelSync adds an entry to the sync log of a channel, a failure only loses the entry so
+// it is logged rather than returned
+func (m *Repository) logChannelSync(entry models.ChannelSyncLog) {
+	_, err := m.DB.InsertChannelSyncLog(entry)
 	if err != nil {
-		helpers.ServerError(w, err)
-		return
+		m.App.Logger.Error("saving channel sync log", "channel", entry.Channel, "error", err)
 	}
+}
 
-	year, _ := strconv.Atoi(r.Form.Get("y"))
-	month, _ := strconv.Atoi(r.Form.Get("m"))
+// channelRoom is a room of a property with the id a channel knows it by
+type channelRoom struct {
+	Room           models.Room
+	ExternalRoomID string
+}
 
-	// process blocks
-	rooms, err := m.DB.AllRooms()
+// channelView is what the channels page shows of a channel
+type channelView struct {
+	Name  string
+	Rooms []channelRoom
+	Logs  []models.ChannelSyncLog
+}
+
+// AdminChannels shows the channels rooms are also sold on, with the ids they know the rooms of
+// the current property by and their sync logs
+func (m *Repository) AdminChannels(w http.ResponseWriter, r *http.Request) {
+	property := helpers.CurrentProperty(r)
+
+	rooms, err := m.DB.AllRooms(property.ID)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	form := forms.New(r.PostForm)
+	var views []channelView
+	for _, ch := range m.App.Channels {
+		mappings, err := m.DB.GetChannelMappings(property.ID, ch.Name())
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		external := make(map[int]string)
+		for _, x := range mappings {
+			external[x.RoomID] = x.ExternalRoomID
+		}
 
-	for _, x := range rooms {
-		// get the block map from the session
-		// loop through entire map, if there is an entry in the map that does not exist
-		// in a posted data and the restriction id > 0 then it is a block needed to be removed
-		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
-		for name, value := range curMap {
-			// ok will be false if the value is not in the map
-			if val, ok := curMap[name]; ok {
-				// only pay attention to values > 0, and that are not in the form post
-				// the rest are just placeholders for days without blocks
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
-						// delete restriction by id
-						err = m.DB.DeleteBlockByID(value)
-						if err != nil {
-							helpers.ServerError(w, err)
-						}
-					}
-				}
-			}
+		view := channelView{Name: ch.Name()}
+		for _, room := range rooms {
+			view.Rooms = append(view.Rooms, channelRoom{Room: room, ExternalRoomID: external[room.ID]})
 		}
-	}
 
-	// handle new blocks
-	for name, _ := range r.PostForm {
-		if strings.HasPrefix(name, "add_block") {
-			exploded := strings.Split(name, "_")
-			roomID, _ := strconv.Atoi(exploded[2])
-			time, _ := time.Parse("2006-01-2", exploded[3])
-			// insert a new block
-			err := m.DB.InsertBlockForRoom(roomID, time)
-			if err != nil {
-				helpers.ServerError(w, err)
-			}
+		view.Logs, err = m.DB.GetChannelSyncLogs(property.ID, ch.Name())
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
 		}
+		views = append(views, view)
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes Saved")
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calender?y=%d&m=%d", year, month), http.StatusSeeOther)
+	data := make(map[string]interface{})
+	data["channels"] = views
+
+	render.Template(w, r, "admin-channels.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
-// AdminProcessReservation marks a reservation as processed
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+// AdminPostChannelMappings saves the ids a channel knows the rooms of the current property by,
+// posted as external_room_id_{roomID}. Rooms left blank aren't synced with the channel.
+func (m *Repository) AdminPostChannelMappings(w http.ResponseWriter, r *http.Request) {
+	ch, ok := m.channel(chi.URLParam(r, "channel"))
+	if !ok {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
 
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
+		return
 	}
 
-	src := chi.URLParam(r, "src")
+	property := helpers.CurrentProperty(r)
 
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
+	rooms, err := m.DB.AllRooms(property.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	// two rooms can't be the same room on the channel
+	seen := make(map[string]bool)
+	for _, room := range rooms {
+		external := strings.TrimSpace(r.Form.Get(fmt.Sprintf("external_room_id_%d", room.ID)))
+		if external == "" {
+			continue
+		}
+		if seen[external] {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s is mapped to more than one room", external))
+			http.Redirect(w, r, "/admin/channels", http.StatusSeeOther)
+			return
+		}
+		seen[external] = true
+	}
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation Updated")
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calender?y=%s&m=%s", year, month), http.StatusSeeOther)
+	for _, room := range rooms {
+		external := strings.TrimSpace(r.Form.Get(fmt.Sprintf("external_room_id_%d", room.ID)))
+		if external == "" {
+			err = m.DB.DeleteChannelMapping(property.ID, ch.Name(), room.ID)
+		} else {
+			err = m.DB.SaveChannelMapping(models.ChannelMapping{
+				PropertyID:     property.ID,
+				Channel:        ch.Name(),
+				RoomID:         room.ID,
+				ExternalRoomID: external,
+			})
+		}
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
 	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rooms mapped to "+ch.Name()+" saved")
+	http.Redirect(w, r, "/admin/channels", http.StatusSeeOther)
 }
 
-// AdminDeleteReservation deletes a reservation
-func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+// AdminSyncChannel syncs the current property with a channel right away rather than waiting for
+// the next scheduled sync
+func (m *Repository) AdminSyncChannel(w http.ResponseWriter, r *http.Request) {
+	ch, ok := m.channel(chi.URLParam(r, "channel"))
+	if !ok {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
 
-	id, err := strconv.Atoi(chi.URLParam(r, "id"))
-	if err != nil {
-		helpers.ServerError(w, err)
+	sum, err := m.syncChannel(helpers.CurrentProperty(r), ch)
+	switch {
+	case err != nil:
+		helpers.Logger(r).Warn("syncing channel", "channel", ch.Name(), "error", err)
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Sync with %s failed: %s", ch.Name(), err))
+	case sum.Conflicts > 0 || sum.Failed > 0:
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("Synced with %s: %d imported, %d conflict(s), %d failed, see the log below",
+			ch.Name(), sum.Imported, sum.Conflicts, sum.Failed))
+	default:
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Synced with %s: %d imported, %d room night(s) pushed",
+			ch.Name(), sum.Imported, sum.Pushed))
 	}
+	http.Redirect(w, r, "/admin/channels", http.StatusSeeOther)
+}
 
-	src := chi.URLParam(r, "src")
+// Healthz tells load balancers the application is up
+func (m *Repository) Healthz(w http.ResponseWriter, r *http.Request) {
+	m.writeHealth(w, r, health.Report{Status: health.StatusOK})
+}
 
-	err = m.DB.DeleteReservation(id)
+// Readyz tells load balancers whether the application can serve requests, with how each of the
+// things it needs is doing
+func (m *Repository) Readyz(w http.ResponseWriter, r *http.Request) {
+	m.writeHealth(w, r, health.Run(r.Context(), m.App.Readiness))
+}
 
+// writeHealth writes out a health report, with a 503 when it isn't ok
+func (m *Repository) writeHealth(w http.ResponseWriter, r *http.Request, report health.Report) {
+	out, err := json.MarshalIndent(report, "", "    ")
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
+		return
 	}
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
-
-	m.App.Session.Put(r.Context(), "flash", "Reservation Deleted")
-
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calender?y=%s&m=%s", year, month), http.StatusSeeOther)
-
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !report.OK() {
+		w.WriteHeader(http.StatusServiceUnavailable)
 	}
+	w.Write(out)
 }
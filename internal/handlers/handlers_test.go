@@ -3,15 +3,26 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"testing"
-
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/channels"
+	"github.com/RakhmanovTimur/bookings/internal/dates"
+	"github.com/RakhmanovTimur/bookings/internal/extras"
+	"github.com/RakhmanovTimur/bookings/internal/groups"
+	"github.com/RakhmanovTimur/bookings/internal/health"
+	"github.com/RakhmanovTimur/bookings/internal/helpers"
 	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/RakhmanovTimur/bookings/internal/payments"
+	"github.com/go-chi/chi"
 )
 
 type postedData struct {
@@ -25,11 +36,15 @@ var theTests = []struct {
 	method             string
 	expectedStatusCode int
 }{
+	{"healthz", "/healthz", "GET", http.StatusOK},
+	{"readyz", "/readyz", "GET", http.StatusOK},
 	{"home", "/", "GET", http.StatusOK},
 	{"about", "/about", "GET", http.StatusOK},
 	{"traveler-room", "/traveler-room", "GET", http.StatusOK},
 	{"wizard-room", "/wizard-room", "GET", http.StatusOK},
 	{"search-availability", "/search-availability", "GET", http.StatusOK},
+	{"flexible-availability", "/search-availability/flexible?m=2050-01&adults=2", "GET", http.StatusOK},
+	{"flexible-availability-this-month", "/search-availability/flexible", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
 	{"non-existent", "/green/eggs/and/ham", "GET", http.StatusNotFound},
 	{"login", "/login", "Get", http.StatusOK},
@@ -38,6 +53,24 @@ var theTests = []struct {
 	{"new reservations", "/admin/reservations-new", "Get", http.StatusOK},
 	{"all reservations", "/admin/reservations-all", "Get", http.StatusOK},
 	{"show reservation", "/admin/reservations/new/1", "Get", http.StatusOK},
+	{"stay rules", "/admin/stay-rules", "Get", http.StatusOK},
+	{"cancellation policies", "/admin/cancellation-policies", "Get", http.StatusOK},
+	{"taxes", "/admin/taxes", "Get", http.StatusOK},
+	{"promo codes", "/admin/promo-codes", "Get", http.StatusOK},
+	{"extras", "/admin/extras", "Get", http.StatusOK},
+	{"account login", "/account/login", "Get", http.StatusOK},
+	{"waitlist", "/waitlist?s=2070-06-10&e=2070-06-12&a=2&c=0&room_id=1", "Get", http.StatusOK},
+	{"admin waitlist", "/admin/waitlist", "Get", http.StatusOK},
+	{"admin group bookings", "/admin/group-bookings", "Get", http.StatusOK},
+	{"admin group booking", "/admin/group-bookings/1", "Get", http.StatusOK},
+	{"admin webhooks", "/admin/webhooks", "Get", http.StatusOK},
+	{"admin webhook", "/admin/webhooks/1", "Get", http.StatusOK},
+	{"admin channels", "/admin/channels", "Get", http.StatusOK},
+	{"account register", "/account/register", "Get", http.StatusOK},
+	{"guests", "/admin/guests?q=smith", "Get", http.StatusOK},
+	{"guest", "/admin/guests/1", "Get", http.StatusOK},
+	{"unknown guest", "/admin/guests/3", "Get", http.StatusNotFound},
+	{"export reservations", "/admin/reservations-export", "Get", http.StatusOK},
 }
 
 func TestHandlers(t *testing.T) {
@@ -112,6 +145,96 @@ func TestRepository_MakeReservation(t *testing.T) {
 	}
 }
 
+func TestRepository_MakeReservationHoldsRoom(t *testing.T) {
+	tests := []struct {
+		name             string
+		start            time.Time
+		holdID           int
+		expectedStatus   int
+		expectedLocation string
+		expectedHoldID   int
+	}{
+		{"new-hold", time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC), 0, http.StatusOK, "", 10},
+		{"kept-hold", time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC), 10, http.StatusOK, "", 10},
+		{"run-out-hold", time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC), 11, http.StatusOK, "", 10},
+		{"booked-up", time.Date(2070, 6, 10, 0, 0, 0, 0, time.UTC), 10, http.StatusSeeOther, "/search-availability", 0},
+		{"unit-taken", time.Date(2055, 1, 1, 0, 0, 0, 0, time.UTC), 0, http.StatusSeeOther, "/search-availability", 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/make-reservation", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		session.Put(ctx, "reservation", models.Reservation{
+			RoomID:    1,
+			StartDate: e.start,
+			EndDate:   e.start.AddDate(0, 0, 2),
+		})
+		if e.holdID > 0 {
+			session.Put(ctx, "hold_id", e.holdID)
+		}
+
+		handler := http.HandlerFunc(Repo.MakeReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+		if session.GetInt(ctx, "hold_id") != e.expectedHoldID {
+			t.Errorf("failed %s: expected hold %d, got %d", e.name, e.expectedHoldID, session.GetInt(ctx, "hold_id"))
+		}
+		if e.expectedStatus == http.StatusOK && !strings.Contains(rr.Body.String(), `id="hold-countdown"`) {
+			t.Errorf("failed %s: expected the hold countdown on the form", e.name)
+		}
+	}
+}
+
+func TestRepository_PostReservationWithHold(t *testing.T) {
+	tests := []struct {
+		name             string
+		startDate        string
+		endDate          string
+		holdID           int
+		expectedLocation string
+	}{
+		{"held", "2050-06-10", "2050-06-12", 10, "/checkout"},
+		{"hold-run-out", "2050-06-10", "2050-06-12", 11, "/checkout"},
+		{"dates-changed", "2070-06-10", "2070-06-12", 10, "/search-availability"},
+		{"unit-taken", "2055-01-01", "2055-01-03", 0, "/search-availability"},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("start_date", e.startDate)
+		postedData.Add("end_date", e.endDate)
+		postedData.Add("first_name", "John")
+		postedData.Add("last_name", "Smith")
+		postedData.Add("email", "john@smith.com")
+		postedData.Add("room_id", "1")
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		session.Put(ctx, "hold_id", e.holdID)
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+		if session.GetInt(ctx, "hold_id") != 0 {
+			t.Errorf("failed %s: expected the hold to be released", e.name)
+		}
+	}
+}
+
 func TestRepository_PostReservation(t *testing.T) {
 
 	postedData := url.Values{}
@@ -243,6 +366,54 @@ func TestRepository_PostReservation(t *testing.T) {
 		t.Errorf("postreservation handler returned wrong response code for invalid data: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
 
+	// test for more guests than the room sleeps
+	postedData = url.Values{}
+	postedData.Add("start_date", "2030-01-01")
+	postedData.Add("end_date", "2030-01-02")
+	postedData.Add("first_name", "Tim")
+	postedData.Add("last_name", "Timii")
+	postedData.Add("email", "ewim@ddcs.com")
+	postedData.Add("phone", "1231-2123-1211")
+	postedData.Add("room_id", "1")
+	postedData.Add("adults", "2")
+	postedData.Add("children", "1")
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("postreservation handler returned wrong response code for too many guests: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	// test for no free unit of the room type
+	postedData = url.Values{}
+	postedData.Add("start_date", "2070-01-01")
+	postedData.Add("end_date", "2070-01-02")
+	postedData.Add("first_name", "Tim")
+	postedData.Add("last_name", "Timii")
+	postedData.Add("email", "ewim@ddcs.com")
+	postedData.Add("phone", "1231-2123-1211")
+	postedData.Add("room_id", "1")
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("postreservation handler returned wrong response code when no unit is free: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
 	// test for failure to insert the reservation into database
 	postedData = url.Values{}
 	postedData.Add("start_date", "2030-01-01")
@@ -407,8 +578,8 @@ func TestRepository_PostAvailability(t *testing.T) {
 	// case 1: Correct request
 
 	// Create the body
-	reqBody := "start=2010-10-10"
-	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2010-10-11")
+	reqBody := "start=2050-10-10"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2050-10-11")
 
 	// Create a new request
 	req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody))
@@ -485,6 +656,67 @@ func TestRepository_PostAvailability(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Error("Did not get status Temporary Redirect without body")
 	}
+
+	// case 4: no room sleeps the party
+
+	reqBody = "start=2050-10-10"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2050-10-11")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=2")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "children=2")
+
+	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(Repo.PostAvailability)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Error("Did not get status See Other when no room sleeps the party")
+	}
+
+	// case 5: invalid number of guests
+
+	reqBody = "start=2050-10-10"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2050-10-11")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "adults=none")
+
+	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(Repo.PostAvailability)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Error("Did not get status See Other for an invalid number of guests")
+	}
+
+	// case 6: no availability, but free rooms a few days earlier
+
+	emptySearches := app.Metrics.EmptySearches.Value()
+	reqBody = "start=2099-01-06"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end=2099-01-07")
+
+	req, _ = http.NewRequest("POST", "/search-availability", strings.NewReader(reqBody))
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	handler = http.HandlerFunc(Repo.PostAvailability)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status OK with suggestions, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "2099-01-04") {
+		t.Error("Expected a suggestion starting on 2099-01-04")
+	}
+	if app.Metrics.EmptySearches.Value() != emptySearches+1 {
+		t.Error("Expected the search to be counted as empty")
+	}
 }
 
 func TestRepository_ReservationSummary(t *testing.T) {
@@ -620,6 +852,13 @@ var loginTests = []struct {
 		`action="/user/login"`,
 		"",
 	},
+	{
+		"guest-account",
+		"guest@me.com",
+		http.StatusSeeOther,
+		"",
+		"/user/login",
+	},
 }
 
 func TestLogin(t *testing.T) {
@@ -665,3 +904,2169 @@ func TestLogin(t *testing.T) {
 
 	}
 }
+
+var adminPostShowReservationTests = []struct {
+	name               string
+	id                 string
+	startDate          string
+	endDate            string
+	unitID             string
+	email              string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"valid-change", "1", "2050-01-01", "2050-01-03", "1", "ewim@ddcs.com", http.StatusSeeOther, "/admin/reservations-all"},
+	{"departure-before-arrival", "1", "2050-01-03", "2050-01-01", "1", "ewim@ddcs.com", http.StatusOK, ""},
+	{"invalid-arrival", "1", "invalid", "2050-01-03", "1", "ewim@ddcs.com", http.StatusOK, ""},
+	{"invalid-room", "1", "2050-01-01", "2050-01-03", "invalid", "ewim@ddcs.com", http.StatusOK, ""},
+	{"room-not-available", "1", "2070-01-01", "2070-01-03", "1", "ewim@ddcs.com", http.StatusOK, ""},
+	{"unknown-unit", "1", "2050-01-01", "2050-01-03", "5", "ewim@ddcs.com", http.StatusOK, ""},
+	{"invalid-email", "1", "2050-01-01", "2050-01-03", "1", "ewim", http.StatusOK, ""},
+	{"cancelled", "8", "2050-01-01", "2050-01-03", "1", "ewim@ddcs.com", http.StatusSeeOther, "/admin/reservations/all/8/show"},
+}
+
+func TestRepository_AdminPostShowReservation(t *testing.T) {
+	for _, e := range adminPostShowReservationTests {
+		postedData := url.Values{}
+		postedData.Add("first_name", "Tim")
+		postedData.Add("last_name", "Timii")
+		postedData.Add("email", e.email)
+		postedData.Add("phone", "1231-2123-1211")
+		postedData.Add("start_date", e.startDate)
+		postedData.Add("end_date", e.endDate)
+		postedData.Add("room_unit_id", e.unitID)
+		postedData.Add("notify_guest", "1")
+
+		showURL := "/admin/reservations/all/" + e.id + "/show"
+		req, _ := http.NewRequest("POST", showURL, strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = showURL
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostShowReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_AdminReservationsTimeline(t *testing.T) {
+	tests := []struct {
+		name             string
+		query            string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"today", "", http.StatusOK, ""},
+		{"start", "?start=2050-01-01", http.StatusOK, ""},
+		{"malformed start", "?start=2050-13-45", http.StatusSeeOther, "/admin/reservations-timeline"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reservations-timeline"+e.query, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReservationsTimeline)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_AdminProcessAndDeleteReservation(t *testing.T) {
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		id             string
+		expectedStatus int
+	}{
+		{"process", Repo.AdminProcessReservation, "1", http.StatusSeeOther},
+		{"process other property's", Repo.AdminProcessReservation, "7", http.StatusNotFound},
+		{"process invalid id", Repo.AdminProcessReservation, "x", http.StatusBadRequest},
+		{"delete", Repo.AdminDeleteReservation, "1", http.StatusSeeOther},
+		{"delete other property's", Repo.AdminDeleteReservation, "7", http.StatusNotFound},
+		{"delete invalid id", Repo.AdminDeleteReservation, "x", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/process-reservation/all/"+e.id+"/do", nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminRestrictionsJSON(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations-timeline/json?start=2050-01-01&end=2050-01-28", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminRestrictionsJSON)
+	handler.ServeHTTP(rr, req)
+
+	var j timelineResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &j)
+	if err != nil {
+		t.Error("failed to parse json")
+	}
+	if !j.OK {
+		t.Errorf("expected ok response, got message %s", j.Message)
+	}
+
+	// invalid window
+	req, _ = http.NewRequest("GET", "/admin/reservations-timeline/json?start=2050-01-28&end=2050-01-01", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	err = json.Unmarshal(rr.Body.Bytes(), &j)
+	if err != nil {
+		t.Error("failed to parse json")
+	}
+	if j.OK {
+		t.Error("expected failure for end date before start date")
+	}
+}
+
+var timelineChangeTests = []struct {
+	name       string
+	handler    func(*Repository, http.ResponseWriter, *http.Request)
+	postedData url.Values
+	expectedOK bool
+}{
+	{"move-reservation", (*Repository).AdminMoveReservationJSON, url.Values{"reservation_id": {"1"}, "room_unit_id": {"1"}, "start": {"2050-01-01"}, "end": {"2050-01-03"}}, true},
+	{"move-reservation-unavailable", (*Repository).AdminMoveReservationJSON, url.Values{"reservation_id": {"1"}, "room_unit_id": {"1"}, "start": {"2070-01-01"}, "end": {"2070-01-03"}}, false},
+	{"move-reservation-reversed", (*Repository).AdminMoveReservationJSON, url.Values{"reservation_id": {"1"}, "room_unit_id": {"1"}, "start": {"2050-01-03"}, "end": {"2050-01-01"}}, false},
+	{"move-reservation-no-room", (*Repository).AdminMoveReservationJSON, url.Values{"reservation_id": {"1"}, "room_unit_id": {"10"}, "start": {"2050-01-01"}, "end": {"2050-01-03"}}, false},
+	{"move-reservation-cancelled", (*Repository).AdminMoveReservationJSON, url.Values{"reservation_id": {"8"}, "room_unit_id": {"1"}, "start": {"2050-01-01"}, "end": {"2050-01-03"}}, false},
+	{"new-block", (*Repository).AdminSaveBlockJSON, url.Values{"room_unit_id": {"1"}, "start": {"2050-01-01"}, "end": {"2050-01-02"}}, true},
+	{"resize-block", (*Repository).AdminSaveBlockJSON, url.Values{"block_id": {"2"}, "room_unit_id": {"1"}, "start": {"2050-01-01"}, "end": {"2050-01-05"}}, true},
+	{"resize-reservation-as-block", (*Repository).AdminSaveBlockJSON, url.Values{"block_id": {"1"}, "room_unit_id": {"1"}, "start": {"2050-01-01"}, "end": {"2050-01-05"}}, false},
+	{"block-database-error", (*Repository).AdminSaveBlockJSON, url.Values{"room_unit_id": {"1"}, "start": {"2100-01-02"}, "end": {"2100-01-03"}}, false},
+	{"delete-block", (*Repository).AdminDeleteBlockJSON, url.Values{"block_id": {"2"}}, true},
+	{"delete-missing-block", (*Repository).AdminDeleteBlockJSON, url.Values{"block_id": {"5"}}, false},
+}
+
+func TestRepository_TimelineChanges(t *testing.T) {
+	for _, e := range timelineChangeTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations-timeline", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		e.handler(Repo, rr, req)
+
+		var j jsonResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &j)
+		if err != nil {
+			t.Errorf("failed %s: can't parse json", e.name)
+			continue
+		}
+		if j.OK != e.expectedOK {
+			t.Errorf("failed %s: expected ok %t, but got %t (%s)", e.name, e.expectedOK, j.OK, j.Message)
+		}
+	}
+}
+
+func TestRepository_AdminSelectProperty(t *testing.T) {
+	properties := []models.Property{{ID: 1, Name: "Golden Tavern"}}
+
+	for _, id := range []string{"1", "2"} {
+		req, _ := http.NewRequest("GET", "/admin/properties/"+id+"/select", nil)
+		ctx := getCtx(req)
+		ctx = helpers.WithUserProperties(ctx, properties)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminSelectProperty)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("AdminSelectProperty returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+		}
+
+		selected := session.GetInt(ctx, "admin_property_id")
+		if id == "1" && selected != 1 {
+			t.Errorf("expected property 1 to be selected, got %d", selected)
+		}
+		if id == "2" && selected != 0 {
+			t.Errorf("selected property %d the user has no access to", selected)
+		}
+	}
+}
+
+var roomAvailabilityTests = []struct {
+	name           string
+	roomID         string
+	query          string
+	expectedOK     bool
+	expectedNights int
+}{
+	{"month", "1", "start=2099-01-01", true, 31},
+	{"range", "1", "start=2099-01-03&end=2099-01-07", true, 4},
+	{"unknown-room", "3", "start=2099-01-01", false, 0},
+	{"reversed", "1", "start=2099-01-07&end=2099-01-03", false, 0},
+	{"too-long", "1", "start=2099-01-01&end=2100-06-01", false, 0},
+	{"invalid-date", "1", "start=invalid", false, 0},
+}
+
+func TestRepository_RoomAvailabilityJSON(t *testing.T) {
+	for _, e := range roomAvailabilityTests {
+		req, _ := http.NewRequest("GET", "/rooms/"+e.roomID+"/availability?"+e.query, nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.roomID)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.RoomAvailabilityJSON)
+		handler.ServeHTTP(rr, req)
+
+		var j roomAvailabilityResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &j)
+		if err != nil {
+			t.Errorf("failed %s: can't parse json", e.name)
+			continue
+		}
+		if j.OK != e.expectedOK {
+			t.Errorf("failed %s: expected ok %t, but got %t (%s)", e.name, e.expectedOK, j.OK, j.Message)
+		}
+		if len(j.Nights) != e.expectedNights {
+			t.Errorf("failed %s: expected %d nights, got %d", e.name, e.expectedNights, len(j.Nights))
+		}
+	}
+
+	// room 1 is booked out from 2099-01-05 in the test repo
+	req, _ := http.NewRequest("GET", "/rooms/1/availability?start=2099-01-03&end=2099-01-07", nil)
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	Repo.RoomAvailabilityJSON(rr, req)
+
+	var j roomAvailabilityResponse
+	_ = json.Unmarshal(rr.Body.Bytes(), &j)
+	for _, night := range j.Nights {
+		expected := night.Date < "2099-01-05"
+		if night.Available != expected {
+			t.Errorf("expected available %t on %s", expected, night.Date)
+		}
+		if night.Price != 10000 {
+			t.Errorf("expected a nightly price of 10000 on %s, got %d", night.Date, night.Price)
+		}
+	}
+
+	// saving a block drops the cached availability
+	if _, ok := app.AvailabilityCache.Get("grid:0:0:2099-01-03:2099-01-07"); !ok {
+		t.Fatal("expected the availability to be cached")
+	}
+	postedData := url.Values{"room_unit_id": {"1"}, "start": {"2050-01-01"}, "end": {"2050-01-02"}}
+	req, _ = http.NewRequest("POST", "/admin/reservations-timeline/block", strings.NewReader(postedData.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	Repo.AdminSaveBlockJSON(httptest.NewRecorder(), req)
+
+	if _, ok := app.AvailabilityCache.Get("grid:0:0:2099-01-03:2099-01-07"); ok {
+		t.Error("expected the availability cache to be flushed after saving a block")
+	}
+}
+
+var stayRuleSearchTests = []struct {
+	name               string
+	start              string
+	end                string
+	expectedStatusCode int
+}{
+	{"past-dates", "2020-01-01", "2020-01-02", http.StatusSeeOther},
+	{"reversed-dates", "2050-01-02", "2050-01-01", http.StatusSeeOther},
+	{"no-nights", "2050-01-01", "2050-01-01", http.StatusSeeOther},
+	{"below-minimum-stay", "2055-03-10", "2055-03-11", http.StatusSeeOther},
+	{"minimum-stay-met", "2055-03-10", "2055-03-13", http.StatusOK},
+}
+
+func TestRepository_PostAvailabilityStayRules(t *testing.T) {
+	for _, e := range stayRuleSearchTests {
+		postedData := url.Values{"start": {e.start}, "end": {e.end}}
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostAvailability)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+var stayRuleReservationTests = []struct {
+	name               string
+	start              string
+	end                string
+	expectedStatusCode int
+	expectedError      string
+}{
+	{"past-dates", "2020-01-01", "2020-01-02", http.StatusOK, "Arrival date can&#39;t be in the past"},
+	{"reversed-dates", "2030-01-02", "2030-01-01", http.StatusOK, "Departure date must be after the arrival date"},
+	{"below-minimum-stay", "2055-03-10", "2055-03-11", http.StatusOK, "must be at least 3 nights"},
+	{"minimum-stay-met", "2055-03-10", "2055-03-13", http.StatusSeeOther, ""},
+}
+
+func TestRepository_PostReservationStayRules(t *testing.T) {
+	for _, e := range stayRuleReservationTests {
+		postedData := url.Values{
+			"start_date": {e.start},
+			"end_date":   {e.end},
+			"first_name": {"Tim"},
+			"last_name":  {"Timii"},
+			"email":      {"ewim@ddcs.com"},
+			"room_id":    {"1"},
+		}
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedError != "" && !strings.Contains(rr.Body.String(), e.expectedError) {
+			t.Errorf("failed %s: expected the error %q on the page", e.name, e.expectedError)
+		}
+	}
+}
+
+func TestRepository_AvailabilityJSONStayRules(t *testing.T) {
+	postedData := url.Values{"start": {"2055-03-10"}, "end": {"2055-03-11"}, "room_id": {"1"}}
+	req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AvailabilityJSON)
+	handler.ServeHTTP(rr, req)
+
+	var j jsonResponse
+	err := json.Unmarshal(rr.Body.Bytes(), &j)
+	if err != nil {
+		t.Fatal("failed to parse json")
+	}
+	if j.OK || !strings.Contains(j.Message, "at least 3 nights") {
+		t.Errorf("expected the minimum stay to be enforced, got ok %t (%s)", j.OK, j.Message)
+	}
+}
+
+var stayRuleAdminTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+}{
+	{"valid", url.Values{"room_id": {"1"}, "start_date": {"2050-06-01"}, "end_date": {"2050-09-01"}, "min_nights": {"3"}, "arrival_days": {"6"}}, http.StatusSeeOther},
+	{"missing-dates", url.Values{"room_id": {"1"}}, http.StatusOK},
+	{"reversed-dates", url.Values{"room_id": {"1"}, "start_date": {"2050-09-01"}, "end_date": {"2050-06-01"}}, http.StatusOK},
+	{"unknown-room", url.Values{"room_id": {"3"}, "start_date": {"2050-06-01"}, "end_date": {"2050-09-01"}}, http.StatusOK},
+	{"max-below-min", url.Values{"room_id": {"1"}, "start_date": {"2050-06-01"}, "end_date": {"2050-09-01"}, "min_nights": {"7"}, "max_nights": {"3"}}, http.StatusOK},
+	{"invalid-nights", url.Values{"room_id": {"1"}, "start_date": {"2050-06-01"}, "end_date": {"2050-09-01"}, "min_nights": {"-1"}}, http.StatusOK},
+}
+
+func TestRepository_AdminPostStayRules(t *testing.T) {
+	for _, e := range stayRuleAdminTests {
+		req, _ := http.NewRequest("POST", "/admin/stay-rules", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostStayRules)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+var checkoutTests = []struct {
+	name               string
+	reservationID      int
+	inSession          bool
+	pendingUntil       time.Time
+	expectedStatusCode int
+}{
+	{"unpaid", 2, true, time.Now().Add(time.Hour), http.StatusOK},
+	{"already-paid", 1, true, time.Time{}, http.StatusSeeOther},
+	{"not-saved", 0, true, time.Time{}, http.StatusSeeOther},
+	{"no-session", 2, false, time.Time{}, http.StatusSeeOther},
+	{"lapsed", 2, true, time.Now().Add(-time.Minute), http.StatusSeeOther},
+}
+
+func TestRepository_Checkout(t *testing.T) {
+	for _, e := range checkoutTests {
+		req, _ := http.NewRequest("GET", "/checkout", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		if e.inSession {
+			session.Put(ctx, "reservation", models.Reservation{ID: e.reservationID, RoomID: 1, Total: 10000,
+				PendingUntil: e.pendingUntil})
+		}
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.Checkout)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+var postCheckoutTests = []struct {
+	name               string
+	reservationID      int
+	token              string
+	pendingUntil       time.Time
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{"approved", 2, "tok_visa", time.Time{}, http.StatusSeeOther, "/reservation-summary"},
+	{"approved-pending", 2, "tok_visa", time.Now().Add(time.Hour), http.StatusSeeOther, "/reservation-summary"},
+	{"declined", 2, "tok_declined", time.Time{}, http.StatusSeeOther, "/checkout"},
+	{"not-captured", 2, "tok_uncapturable", time.Time{}, http.StatusSeeOther, "/checkout"},
+	{"no-token", 2, "", time.Time{}, http.StatusSeeOther, "/checkout"},
+	{"already-paid", 1, "tok_visa", time.Time{}, http.StatusSeeOther, "/reservation-summary"},
+	{"payment-not-recorded", 1000, "tok_visa", time.Time{}, http.StatusInternalServerError, ""},
+	{"payment-in-progress", 5, "tok_visa", time.Time{}, http.StatusSeeOther, "/checkout"},
+	{"lapsed", 2, "tok_visa", time.Now().Add(-time.Minute), http.StatusSeeOther, "/search-availability"},
+	{"lapsed-while-paying", 4, "tok_visa", time.Now().Add(time.Hour), http.StatusSeeOther, "/search-availability"},
+}
+
+func TestRepository_PostCheckout(t *testing.T) {
+	for _, e := range postCheckoutTests {
+		postedData := url.Values{"payment_token": {e.token}}
+		req, _ := http.NewRequest("POST", "/checkout", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "reservation", models.Reservation{ID: e.reservationID, RoomID: 1, Total: 10000,
+			PendingUntil: e.pendingUntil})
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostCheckout)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected redirect to %s, but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+var paymentWebhookTests = []struct {
+	name               string
+	payload            string
+	signed             bool
+	expectedStatusCode int
+}{
+	{"refunded", `{"reference": "fake_1", "status": "refunded"}`, true, http.StatusOK},
+	{"bad-signature", `{"reference": "fake_1", "status": "refunded"}`, false, http.StatusBadRequest},
+	{"unknown-payment", `{"reference": "fake_99", "status": "refunded"}`, true, http.StatusNotFound},
+	{"unknown-status", `{"reference": "fake_1", "status": "lost"}`, true, http.StatusBadRequest},
+}
+
+func TestRepository_PaymentWebhook(t *testing.T) {
+	gateway := app.Payments.(*payments.Fake)
+
+	for _, e := range paymentWebhookTests {
+		req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(e.payload))
+		if e.signed {
+			req.Header.Set("X-Payment-Signature", gateway.Sign([]byte(e.payload)))
+		}
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PaymentWebhook)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+var cancelReservationTests = []struct {
+	name               string
+	token              string
+	expectedStatusCode int
+}{
+	{"upcoming", "upcoming", http.StatusOK},
+	{"cancelled", "cancelled", http.StatusOK},
+	{"arrived", "arrived", http.StatusOK},
+	{"unknown", "nope", http.StatusNotFound},
+}
+
+func TestRepository_CancelReservation(t *testing.T) {
+	for _, e := range cancelReservationTests {
+		req, _ := http.NewRequest("GET", "/reservations/"+e.token+"/cancel", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", e.token)
+		ctx := context.WithValue(getCtx(req), chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.CancelReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+var postCancelReservationTests = []struct {
+	name               string
+	token              string
+	expectedStatusCode int
+	expectedMessage    string
+}{
+	{"upcoming", "upcoming", http.StatusSeeOther, "cancelled"},
+	{"cancelled", "cancelled", http.StatusSeeOther, "already cancelled"},
+	{"arrived", "arrived", http.StatusSeeOther, "day of arrival"},
+	{"unknown", "nope", http.StatusNotFound, ""},
+}
+
+func TestRepository_PostCancelReservation(t *testing.T) {
+	for _, e := range postCancelReservationTests {
+		req, _ := http.NewRequest("POST", "/reservations/"+e.token+"/cancel", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", e.token)
+		ctx := context.WithValue(getCtx(req), chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostCancelReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedMessage == "" {
+			continue
+		}
+		if rr.Header().Get("Location") != "/reservations/"+e.token+"/cancel" {
+			t.Errorf("failed %s: unexpected redirect to %s", e.name, rr.Header().Get("Location"))
+		}
+		message := session.PopString(ctx, "flash") + session.PopString(ctx, "error")
+		if !strings.Contains(message, e.expectedMessage) {
+			t.Errorf("failed %s: expected a message about %q, got %q", e.name, e.expectedMessage, message)
+		}
+	}
+}
+
+func TestRepository_AdminCancelReservation(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/cancel-reservation/all/1/do", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("src", "all")
+	rctx.URLParams.Add("id", "1")
+	ctx := context.WithValue(getCtx(req), chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminCancelReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/reservations-all" {
+		t.Errorf("expected redirect to the reservations, got %d to %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if session.PopString(ctx, "flash") != "Reservation Cancelled" {
+		t.Error("expected the reservation to be cancelled")
+	}
+}
+
+var cancellationPolicyAdminTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+}{
+	{"valid", url.Values{"name": {"Flexible"}, "free_days": {"7"}, "fee_percent": {"50"}}, http.StatusSeeOther},
+	{"non-refundable", url.Values{"name": {"Saver"}, "non_refundable": {"1"}}, http.StatusSeeOther},
+	{"missing-name", url.Values{"free_days": {"7"}}, http.StatusOK},
+	{"fee-above-total", url.Values{"name": {"Strict"}, "fee_percent": {"150"}}, http.StatusOK},
+	{"insert-fails", url.Values{"name": {"invalid"}}, http.StatusInternalServerError},
+}
+
+func TestRepository_AdminPostCancellationPolicies(t *testing.T) {
+	for _, e := range cancellationPolicyAdminTests {
+		req, _ := http.NewRequest("POST", "/admin/cancellation-policies", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostCancellationPolicies)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+var roomCancellationPolicyTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedFlash      bool
+}{
+	{"set", url.Values{"room_id": {"1"}, "policy_id": {"1"}}, http.StatusSeeOther, true},
+	{"remove", url.Values{"room_id": {"1"}, "policy_id": {"0"}}, http.StatusSeeOther, true},
+	{"unknown-policy", url.Values{"room_id": {"1"}, "policy_id": {"2"}}, http.StatusSeeOther, false},
+	{"unknown-room", url.Values{"room_id": {"3"}, "policy_id": {"1"}}, http.StatusNotFound, false},
+	{"invalid-policy", url.Values{"room_id": {"1"}, "policy_id": {"x"}}, http.StatusBadRequest, false},
+}
+
+func TestRepository_AdminPostRoomCancellationPolicy(t *testing.T) {
+	for _, e := range roomCancellationPolicyTests {
+		req, _ := http.NewRequest("POST", "/admin/cancellation-policies/rooms", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoomCancellationPolicy)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if session.Exists(ctx, "flash") != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %t", e.name, e.expectedFlash)
+		}
+	}
+}
+
+func TestAmountPaid(t *testing.T) {
+	ps := []models.Payment{
+		{Kind: "deposit", Amount: 3000, Status: payments.StatusCaptured},
+		{Kind: "full", Amount: 7000, Status: payments.StatusFailed},
+		{Kind: payments.KindRefund, Amount: 1000, Status: payments.StatusRefunded},
+	}
+	if amountPaid(ps) != 2000 {
+		t.Errorf("expected 2000 paid, got %d", amountPaid(ps))
+	}
+}
+
+func TestRepriceStay(t *testing.T) {
+	res := models.Reservation{
+		RoomID:    1,
+		StartDate: time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 6, 13, 0, 0, 0, 0, time.UTC),
+		Adults:    2,
+		Total:     20000,
+		Discount:  1000,
+		Extras: []models.ReservationExtra{
+			{Name: "Breakfast", Pricing: extras.PerNight, Quantity: 1, Nights: 1, UnitPrice: 500, Amount: 500},
+			{Name: "Parking", Pricing: extras.PerStay, Quantity: 1, UnitPrice: 700, Amount: 700},
+		},
+	}
+
+	repriced, err := Repo.repriceStay(1, res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repriced.Extras[0].Nights != 3 || repriced.Extras[0].Amount != 1500 {
+		t.Errorf("expected breakfast for 3 nights at the booked price, got %+v", repriced.Extras[0])
+	}
+	if repriced.Extras[1].Amount != 700 {
+		t.Errorf("expected parking to cost the same, got %+v", repriced.Extras[1])
+	}
+	// 3 nights at 100.00, the extras and the promo code discount
+	if repriced.Total != 30000+1500+700-1000 {
+		t.Errorf("expected a total of %d, got %d", 30000+1500+700-1000, repriced.Total)
+	}
+}
+
+var invoiceTests = []struct {
+	name               string
+	id                 string
+	expectedStatusCode int
+	expectedFile       string
+}{
+	{"issued", "1", http.StatusOK, "invoice-000001.pdf"},
+	{"new", "2", http.StatusOK, "invoice-000002.pdf"},
+	{"total-changed", "3", http.StatusOK, "invoice-000004.pdf"},
+	{"invalid-id", "x", http.StatusBadRequest, ""},
+}
+
+func TestRepository_AdminReservationInvoice(t *testing.T) {
+	for _, e := range invoiceTests {
+		req, _ := http.NewRequest("GET", "/admin/reservations/all/"+e.id+"/invoice", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		ctx := context.WithValue(getCtx(req), chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminReservationInvoice)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedFile == "" {
+			continue
+		}
+		if !strings.Contains(rr.Header().Get("Content-Disposition"), e.expectedFile) {
+			t.Errorf("failed %s: expected %s, got %s", e.name, e.expectedFile, rr.Header().Get("Content-Disposition"))
+		}
+		if !strings.HasPrefix(rr.Body.String(), "%PDF-") {
+			t.Errorf("failed %s: expected a PDF", e.name)
+		}
+	}
+}
+
+var sendInvoiceTests = []struct {
+	name          string
+	id            string
+	expectedFlash string
+}{
+	{"issued", "1", "Invoice 000001 sent"},
+	{"total-changed", "3", "Invoice 000004 sent"},
+}
+
+func TestRepository_AdminSendInvoice(t *testing.T) {
+	for _, e := range sendInvoiceTests {
+		req, _ := http.NewRequest("GET", "/admin/reservations/all/"+e.id+"/invoice/send", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		ctx := context.WithValue(getCtx(req), chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminSendInvoice)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/reservations/all/"+e.id+"/show" {
+			t.Errorf("failed %s: expected redirect to the reservation, got %d to %s", e.name, rr.Code, rr.Header().Get("Location"))
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected %q, got %q", e.name, e.expectedFlash, flash)
+		}
+	}
+}
+
+var taxAdminTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+}{
+	{"valid", url.Values{"name": {"VAT"}, "kind": {"percent"}, "amount": {"7.7"}, "inclusive": {"1"}}, http.StatusSeeOther},
+	{"with-dates", url.Values{"name": {"Summer fee"}, "kind": {"per_stay"}, "amount": {"50"}, "start_date": {"2050-06-01"}, "end_date": {"2050-09-01"}}, http.StatusSeeOther},
+	{"missing-amount", url.Values{"name": {"City tax"}, "kind": {"per_guest_night"}}, http.StatusOK},
+	{"invalid-kind", url.Values{"name": {"City tax"}, "kind": {"per_room"}, "amount": {"2.50"}}, http.StatusOK},
+	{"invalid-amount", url.Values{"name": {"City tax"}, "kind": {"per_night"}, "amount": {"2,50"}}, http.StatusOK},
+	{"percent-above-100", url.Values{"name": {"VAT"}, "kind": {"percent"}, "amount": {"120"}}, http.StatusOK},
+	{"end-before-start", url.Values{"name": {"Summer fee"}, "kind": {"per_stay"}, "amount": {"50"}, "start_date": {"2050-09-01"}, "end_date": {"2050-06-01"}}, http.StatusOK},
+	{"insert-fails", url.Values{"name": {"invalid"}, "kind": {"per_stay"}, "amount": {"50"}}, http.StatusInternalServerError},
+}
+
+func TestRepository_AdminPostTaxes(t *testing.T) {
+	for _, e := range taxAdminTests {
+		req, _ := http.NewRequest("POST", "/admin/taxes", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostTaxes)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+var promoCodeReservationTests = []struct {
+	name               string
+	code               string
+	expectedStatusCode int
+	expectedTotal      int
+}{
+	{"valid", "save10", http.StatusSeeOther, 9000},
+	{"unknown", "NOPE", http.StatusOK, 0},
+	{"other-room", "ROOM2", http.StatusOK, 0},
+	{"used-up", "USEDUP", http.StatusOK, 0},
+}
+
+func TestRepository_PostReservationPromoCode(t *testing.T) {
+	for _, e := range promoCodeReservationTests {
+		postedData := url.Values{
+			"start_date": {"2030-01-01"},
+			"end_date":   {"2030-01-02"},
+			"first_name": {"Tim"},
+			"last_name":  {"Timii"},
+			"email":      {"ewim@ddcs.com"},
+			"room_id":    {"1"},
+			"promo_code": {e.code},
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedTotal == 0 {
+			continue
+		}
+
+		res, ok := session.Get(ctx, "reservation").(models.Reservation)
+		if !ok {
+			t.Errorf("failed %s: expected the reservation in the session", e.name)
+			continue
+		}
+		if res.Total != e.expectedTotal || res.Discount != 1000 || res.PromoCode != "SAVE10" || res.PromoCodeID != 1 {
+			t.Errorf("failed %s: expected the discount to be recorded, got %+v", e.name, res)
+		}
+	}
+}
+
+var promoCodeAdminTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+}{
+	{"valid", url.Values{"code": {"summer"}, "kind": {"percent"}, "amount": {"15"}}, http.StatusSeeOther},
+	{"restricted", url.Values{
+		"code": {"JUNE"}, "kind": {"fixed"}, "amount": {"20"}, "valid_until": {"2050-05-01"},
+		"stay_from": {"2050-06-01"}, "stay_until": {"2050-07-01"}, "room_id": {"1"},
+		"max_uses": {"100"}, "max_uses_per_email": {"1"},
+	}, http.StatusSeeOther},
+	{"missing-code", url.Values{"kind": {"percent"}, "amount": {"15"}}, http.StatusOK},
+	{"space-in-code", url.Values{"code": {"SUMMER SALE"}, "kind": {"percent"}, "amount": {"15"}}, http.StatusOK},
+	{"invalid-kind", url.Values{"code": {"SUMMER"}, "kind": {"free"}, "amount": {"15"}}, http.StatusOK},
+	{"zero-amount", url.Values{"code": {"SUMMER"}, "kind": {"fixed"}, "amount": {"0"}}, http.StatusOK},
+	{"percent-above-100", url.Values{"code": {"SUMMER"}, "kind": {"percent"}, "amount": {"150"}}, http.StatusOK},
+	{"unknown-room", url.Values{"code": {"SUMMER"}, "kind": {"percent"}, "amount": {"15"}, "room_id": {"3"}}, http.StatusOK},
+	{"reversed-stay", url.Values{"code": {"SUMMER"}, "kind": {"percent"}, "amount": {"15"}, "stay_from": {"2050-07-01"}, "stay_until": {"2050-06-01"}}, http.StatusOK},
+	{"negative-uses", url.Values{"code": {"SUMMER"}, "kind": {"percent"}, "amount": {"15"}, "max_uses": {"-1"}}, http.StatusOK},
+	{"insert-fails", url.Values{"code": {"invalid"}, "kind": {"percent"}, "amount": {"15"}}, http.StatusInternalServerError},
+}
+
+func TestRepository_AdminPostPromoCodes(t *testing.T) {
+	for _, e := range promoCodeAdminTests {
+		req, _ := http.NewRequest("POST", "/admin/promo-codes", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostPromoCodes)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+var extrasReservationTests = []struct {
+	name               string
+	extras             url.Values
+	expectedStatusCode int
+	expectedTotal      int
+}{
+	{"none", url.Values{}, http.StatusSeeOther, 10000},
+	{"parking-and-breakfast", url.Values{"extra_1": {"1"}, "extra_2": {"1"}}, http.StatusSeeOther, 12700},
+	{"zero", url.Values{"extra_1": {"0"}}, http.StatusSeeOther, 10000},
+	{"out-of-stock", url.Values{"extra_1": {"2"}}, http.StatusOK, 0},
+	{"more-than-guests", url.Values{"extra_2": {"2"}}, http.StatusOK, 0},
+	{"invalid-quantity", url.Values{"extra_1": {"x"}}, http.StatusOK, 0},
+}
+
+func TestRepository_PostReservationExtras(t *testing.T) {
+	for _, e := range extrasReservationTests {
+		postedData := url.Values{
+			"start_date": {"2030-01-01"},
+			"end_date":   {"2030-01-02"},
+			"first_name": {"Tim"},
+			"last_name":  {"Timii"},
+			"email":      {"ewim@ddcs.com"},
+			"room_id":    {"1"},
+		}
+		for k, v := range e.extras {
+			postedData[k] = v
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedTotal == 0 {
+			continue
+		}
+
+		res, ok := session.Get(ctx, "reservation").(models.Reservation)
+		if !ok || res.Total != e.expectedTotal {
+			t.Errorf("failed %s: expected a total of %d, got %+v", e.name, e.expectedTotal, res)
+		}
+	}
+}
+
+var reservationExtrasAdminTests = []struct {
+	name             string
+	id               string
+	postedData       url.Values
+	expectedLocation string
+	expectedFlash    string
+	expectedError    string
+}{
+	{"add-parking", "1", url.Values{"extra_1": {"1"}}, "/admin/reservations/all/1/show", "Extras saved, the total is now 15.00", ""},
+	{"out-of-stock", "1", url.Values{"extra_1": {"5"}}, "/admin/reservations/all/1/show", "", "Only 1 of Parking left for these dates"},
+	{"save-fails", "1000", url.Values{"extra_1": {"1"}}, "", "", ""},
+}
+
+func TestRepository_AdminPostReservationExtras(t *testing.T) {
+	for _, e := range reservationExtrasAdminTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id+"/extras", strings.NewReader(e.postedData.Encode()))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		ctx := context.WithValue(getCtx(req), chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostReservationExtras)
+		handler.ServeHTTP(rr, req)
+
+		if e.expectedLocation == "" {
+			if rr.Code != http.StatusInternalServerError {
+				t.Errorf("failed %s: expected %d, but got %d", e.name, http.StatusInternalServerError, rr.Code)
+			}
+			continue
+		}
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected redirect to %s, got %d to %s", e.name, e.expectedLocation, rr.Code, rr.Header().Get("Location"))
+		}
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, got %q", e.name, e.expectedFlash, flash)
+		}
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+var extraAdminTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+}{
+	{"valid", url.Values{"name": {"Breakfast"}, "pricing": {"per_guest_night"}, "price": {"12.50"}}, http.StatusSeeOther},
+	{"with-stock", url.Values{"name": {"Parking"}, "pricing": {"per_night"}, "price": {"15"}, "stock": {"4"}}, http.StatusSeeOther},
+	{"missing-name", url.Values{"pricing": {"per_stay"}, "price": {"30"}}, http.StatusOK},
+	{"invalid-pricing", url.Values{"name": {"Late checkout"}, "pricing": {"per_hour"}, "price": {"30"}}, http.StatusOK},
+	{"invalid-price", url.Values{"name": {"Late checkout"}, "pricing": {"per_stay"}, "price": {"thirty"}}, http.StatusOK},
+	{"negative-stock", url.Values{"name": {"Parking"}, "pricing": {"per_night"}, "price": {"15"}, "stock": {"-1"}}, http.StatusOK},
+	{"insert-fails", url.Values{"name": {"invalid"}, "pricing": {"per_stay"}, "price": {"30"}}, http.StatusInternalServerError},
+}
+
+func TestRepository_AdminPostExtras(t *testing.T) {
+	for _, e := range extraAdminTests {
+		req, _ := http.NewRequest("POST", "/admin/extras", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostExtras)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminExportReservations(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations-export", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminExportReservations)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Errorf("expected a CSV file, got %s", rr.Header().Get("Content-Type"))
+	}
+
+	lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID,First name") {
+		t.Fatalf("expected a header and one reservation, got %q", rr.Body.String())
+	}
+	if !strings.Contains(lines[1], "\"Breakfast, 2 guest(s) x 1 night(s)\",24.00") {
+		t.Errorf("expected the extras of the reservation, got %s", lines[1])
+	}
+}
+
+func TestRepository_PostReservationGuest(t *testing.T) {
+	tests := []struct {
+		email           string
+		expectedGuestID int
+		expectedError   bool
+	}{
+		{"ewim@ddcs.com", 1, false},
+		{"nobody@fails.com", 0, true},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{
+			"start_date": {"2030-01-01"},
+			"end_date":   {"2030-01-02"},
+			"first_name": {"Tim"},
+			"last_name":  {"Timii"},
+			"email":      {e.email},
+			"room_id":    {"1"},
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected %d, but got %d", e.email, http.StatusSeeOther, rr.Code)
+		}
+		if e.expectedError {
+			if session.GetString(ctx, "error") == "" {
+				t.Errorf("failed %s: expected an error", e.email)
+			}
+			continue
+		}
+
+		res, ok := session.Get(ctx, "reservation").(models.Reservation)
+		if !ok || res.GuestID != e.expectedGuestID {
+			t.Errorf("failed %s: expected guest %d, got %+v", e.email, e.expectedGuestID, res)
+		}
+	}
+}
+
+var guestAdminTests = []struct {
+	name               string
+	id                 string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+}{
+	{"valid", "1", "", url.Values{
+		"first_name": {"John"}, "last_name": {"Smith"}, "tag": {"VIP"}, "tags": {"Regular"}, "notes": {"Quiet room"},
+	}, http.StatusSeeOther},
+	{"missing-name", "1", "", url.Values{"first_name": {"John"}}, http.StatusOK},
+	{"update-fails", "1", "", url.Values{"first_name": {"invalid"}, "last_name": {"Smith"}}, http.StatusInternalServerError},
+	{"unknown-guest", "3", "", url.Values{"first_name": {"John"}, "last_name": {"Smith"}}, http.StatusNotFound},
+	{"invalid-id", "x", "", url.Values{}, http.StatusBadRequest},
+	{"merge", "1", "/merge", url.Values{"other_id": {"2"}}, http.StatusSeeOther},
+	{"merge-unknown", "1", "/merge", url.Values{"other_id": {"3"}}, http.StatusSeeOther},
+	{"merge-itself", "1", "/merge", url.Values{"other_id": {"1"}}, http.StatusSeeOther},
+	{"merge-into-unknown", "3", "/merge", url.Values{"other_id": {"1"}}, http.StatusNotFound},
+}
+
+func TestRepository_AdminPostGuest(t *testing.T) {
+	for _, e := range guestAdminTests {
+		req, _ := http.NewRequest("POST", "/admin/guests/"+e.id+e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostGuest)
+		if e.url == "/merge" {
+			handler = Repo.AdminMergeGuest
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		switch e.name {
+		case "merge":
+			if !strings.Contains(session.GetString(ctx, "flash"), "jsmith@work.com") {
+				t.Errorf("failed %s: expected the merged guest in the flash, got %q", e.name, session.GetString(ctx, "flash"))
+			}
+		case "merge-unknown", "merge-itself":
+			if session.GetString(ctx, "error") == "" {
+				t.Errorf("failed %s: expected an error", e.name)
+			}
+		}
+	}
+}
+
+var accountLoginTests = []struct {
+	name             string
+	url              string
+	email            string
+	expectedStatus   int
+	expectedLocation string
+	expectedUserID   int
+}{
+	{"password", "/account/login", "guest@me.com", http.StatusSeeOther, "/account", 2},
+	{"password-staff", "/account/login", "sad@me.com", http.StatusSeeOther, "/account/login", 0},
+	{"password-unconfirmed", "/account/login", "victim@me.com", http.StatusSeeOther, "/account/login", 0},
+	{"password-invalid", "/account/login", "someone@me.com", http.StatusSeeOther, "/account/login", 0},
+	{"password-invalid-data", "/account/login", "guest", http.StatusOK, "", 0},
+	{"link", "/account/login-link", "new@guest.com", http.StatusSeeOther, "/account/login", 0},
+	{"link-staff", "/account/login-link", "sad@me.com", http.StatusSeeOther, "/account/login", 0},
+	{"link-fails", "/account/login-link", "nobody@fails.com", http.StatusInternalServerError, "", 0},
+	{"link-invalid-data", "/account/login-link", "guest", http.StatusOK, "", 0},
+}
+
+func TestRepository_PostAccountLogin(t *testing.T) {
+	for _, e := range accountLoginTests {
+		postedData := url.Values{"email": {e.email}, "password": {"password"}}
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostAccountLogin)
+		if e.url == "/account/login-link" {
+			handler = Repo.PostAccountLoginLink
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+		if session.GetInt(ctx, "user_id") != e.expectedUserID {
+			t.Errorf("failed %s: expected user %d to be logged in, got %d", e.name, e.expectedUserID, session.GetInt(ctx, "user_id"))
+		}
+	}
+}
+
+func TestRepository_AccountLoginWithToken(t *testing.T) {
+	tests := []struct {
+		token            string
+		expectedLocation string
+		expectedUserID   int
+	}{
+		{"guest-token", "/account", 2},
+		{"new-token", "/account", 3},
+		{"victim-token", "/account", 4},
+		{"staff-token", "/user/login", 0},
+		{"used-token", "/account/login", 0},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/account/login/"+e.token, nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", e.token)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AccountLoginWithToken)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, got %s", e.token, e.expectedLocation, rr.Header().Get("Location"))
+		}
+		if session.GetInt(ctx, "user_id") != e.expectedUserID {
+			t.Errorf("failed %s: expected user %d to be logged in, got %d", e.token, e.expectedUserID, session.GetInt(ctx, "user_id"))
+		}
+		if e.expectedUserID > 0 && session.GetInt(ctx, "access_level") != models.AccessLevelGuest {
+			t.Errorf("failed %s: expected a guest access level", e.token)
+		}
+	}
+}
+
+var accountRegisterTests = []struct {
+	name           string
+	postedData     url.Values
+	expectedStatus int
+}{
+	{"valid", url.Values{
+		"first_name": {"Tim"}, "last_name": {"Timii"}, "email": {"Tim@Example.com"}, "password": {"password"},
+		"marketing_consent": {"1"},
+	}, http.StatusSeeOther},
+	{"taken", url.Values{
+		"first_name": {"John"}, "last_name": {"Smith"}, "email": {"guest@me.com"}, "password": {"password"},
+	}, http.StatusOK},
+	{"short-password", url.Values{
+		"first_name": {"Tim"}, "last_name": {"Timii"}, "email": {"tim@example.com"}, "password": {"pass"},
+	}, http.StatusOK},
+	{"insert-fails", url.Values{
+		"first_name": {"Tim"}, "last_name": {"Timii"}, "email": {"nobody@fails.com"}, "password": {"password"},
+	}, http.StatusInternalServerError},
+}
+
+func TestRepository_PostAccountRegister(t *testing.T) {
+	for _, e := range accountRegisterTests {
+		req, _ := http.NewRequest("POST", "/account/register", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostAccountRegister)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedStatus == http.StatusSeeOther && session.GetInt(ctx, "user_id") != 3 {
+			t.Errorf("failed %s: expected the new account to be logged in", e.name)
+		}
+	}
+}
+
+func TestRepository_Account(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/account", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 2)
+	session.Put(ctx, "access_level", models.AccessLevelGuest)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.Account)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected %d, but got %d", http.StatusOK, rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "555-1234") || !strings.Contains(rr.Body.String(), "My Account") {
+		t.Error("expected the account details of the guest")
+	}
+
+	// the reservation form is filled in from the account
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 2)
+	session.Put(ctx, "access_level", models.AccessLevelGuest)
+	session.Put(ctx, "reservation", models.Reservation{RoomID: 1})
+	rr = httptest.NewRecorder()
+
+	handler = Repo.MakeReservation
+	handler.ServeHTTP(rr, req)
+
+	if !strings.Contains(rr.Body.String(), `value="guest@me.com"`) {
+		t.Error("expected the reservation form to be filled in from the account")
+	}
+}
+
+func TestSplitStays(t *testing.T) {
+	today := time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC)
+	reservations := []models.Reservation{
+		{ID: 1, EndDate: today.AddDate(0, 0, 1)},
+		{ID: 2, EndDate: today},
+		{ID: 3, EndDate: today.AddDate(0, 0, 5), CancelledAt: today},
+	}
+
+	upcoming, past := splitStays(reservations, today)
+	if len(upcoming) != 1 || upcoming[0].ID != 1 {
+		t.Errorf("expected only the stay leaving tomorrow to be upcoming, got %+v", upcoming)
+	}
+	if len(past) != 2 {
+		t.Errorf("expected the stay that left today and the cancelled one to be past, got %+v", past)
+	}
+}
+
+func TestRepository_AccountUnverified(t *testing.T) {
+	tests := []struct {
+		name          string
+		userID        int
+		expectedStays bool
+	}{
+		{"verified", 2, true},
+		{"unverified", 4, false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/account", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		session.Put(ctx, "user_id", e.userID)
+		session.Put(ctx, "access_level", models.AccessLevelGuest)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.Account)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("failed %s: expected %d, but got %d", e.name, http.StatusOK, rr.Code)
+		}
+		body := rr.Body.String()
+		if strings.Contains(body, "No upcoming stays") == e.expectedStays {
+			t.Errorf("failed %s: expected the stays of the guest to be shown to be %v", e.name, e.expectedStays)
+		}
+		if strings.Contains(body, "Confirm your email") == e.expectedStays {
+			t.Errorf("failed %s: expected to be asked to confirm the email to be %v", e.name, !e.expectedStays)
+		}
+	}
+}
+
+var postAccountTests = []struct {
+	name           string
+	postedData     url.Values
+	expectedStatus int
+}{
+	{"valid", url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "marketing_consent": {"1"}}, http.StatusSeeOther},
+	{"missing-name", url.Values{"first_name": {"John"}}, http.StatusOK},
+	{"update-fails", url.Values{"first_name": {"invalid"}, "last_name": {"Smith"}}, http.StatusInternalServerError},
+}
+
+func TestRepository_PostAccount(t *testing.T) {
+	for _, e := range postAccountTests {
+		req, _ := http.NewRequest("POST", "/account", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "user_id", 2)
+		session.Put(ctx, "access_level", models.AccessLevelGuest)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostAccount)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+var waitlistTests = []struct {
+	name             string
+	postedData       url.Values
+	expectedStatus   int
+	expectedLocation string
+}{
+	{"valid", url.Values{
+		"room_id": {"1"}, "start_date": {"2070-06-10"}, "end_date": {"2070-06-12"}, "adults": {"2"},
+		"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+	}, http.StatusSeeOther, "/"},
+	{"room-free", url.Values{
+		"room_id": {"1"}, "start_date": {"2050-06-10"}, "end_date": {"2050-06-12"}, "adults": {"2"},
+		"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+	}, http.StatusSeeOther, "/book-room?id=1&s=2050-06-10&e=2050-06-12&a=2&c=0"},
+	{"missing-email", url.Values{
+		"room_id": {"1"}, "start_date": {"2070-06-10"}, "end_date": {"2070-06-12"},
+		"first_name": {"John"}, "last_name": {"Smith"},
+	}, http.StatusOK, ""},
+	{"past", url.Values{
+		"room_id": {"1"}, "start_date": {"2000-06-10"}, "end_date": {"2000-06-12"},
+		"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+	}, http.StatusOK, ""},
+	{"reversed", url.Values{
+		"room_id": {"1"}, "start_date": {"2070-06-12"}, "end_date": {"2070-06-10"},
+		"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+	}, http.StatusOK, ""},
+	{"unknown-room", url.Values{
+		"room_id": {"3"}, "start_date": {"2070-06-10"}, "end_date": {"2070-06-12"},
+		"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+	}, http.StatusOK, ""},
+	{"insert-fails", url.Values{
+		"room_id": {"1"}, "start_date": {"2070-06-10"}, "end_date": {"2070-06-12"},
+		"first_name": {"John"}, "last_name": {"Smith"}, "email": {"nobody@fails.com"},
+	}, http.StatusInternalServerError, ""},
+}
+
+func TestRepository_PostWaitlist(t *testing.T) {
+	for _, e := range waitlistTests {
+		req, _ := http.NewRequest("POST", "/waitlist", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostWaitlist)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_WaitlistOffer(t *testing.T) {
+	tests := []struct {
+		token            string
+		expectedLocation string
+	}{
+		{"offer-token", "/make-reservation"},
+		{"expired-token", "/search-availability"},
+		{"booked-token", "/search-availability"},
+		{"unknown-token", "/search-availability"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/waitlist/"+e.token, nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", e.token)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.WaitlistOffer)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, got %s", e.token, e.expectedLocation, rr.Header().Get("Location"))
+		}
+		if e.token != "offer-token" {
+			continue
+		}
+
+		res, ok := session.Get(ctx, "reservation").(models.Reservation)
+		if !ok || res.RoomID != 1 || res.Adults != 2 || res.Email != "john@smith.com" {
+			t.Errorf("failed %s: expected the offered stay in the session, got %+v", e.token, res)
+		}
+		if session.GetInt(ctx, "waitlist_entry_id") != 4 {
+			t.Errorf("failed %s: expected the waitlist entry in the session", e.token)
+		}
+		if session.GetInt(ctx, "hold_id") != 10 {
+			t.Errorf("failed %s: expected the offer's hold in the session", e.token)
+		}
+	}
+}
+
+func TestRepository_AdminWaitlistActions(t *testing.T) {
+	tests := []struct {
+		name           string
+		id             string
+		action         string
+		expectedStatus int
+		expectedError  bool
+	}{
+		{"offer", "1", "offer", http.StatusSeeOther, false},
+		{"offer-again", "6", "offer", http.StatusSeeOther, false},
+		{"offer-booked-up", "2", "offer", http.StatusSeeOther, true},
+		{"offer-not-waiting", "3", "offer", http.StatusSeeOther, true},
+		{"offer-unknown", "5", "offer", http.StatusNotFound, false},
+		{"remove", "1", "remove", http.StatusSeeOther, false},
+		{"remove-offered", "6", "remove", http.StatusSeeOther, false},
+		{"remove-not-waiting", "3", "remove", http.StatusSeeOther, true},
+		{"remove-invalid", "x", "remove", http.StatusBadRequest, false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/waitlist/"+e.id+"/"+e.action, nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminOfferWaitlist)
+		if e.action == "remove" {
+			handler = Repo.AdminRemoveWaitlist
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedError != (session.GetString(ctx, "error") != "") {
+			t.Errorf("failed %s: expected an error %t, got %q", e.name, e.expectedError, session.GetString(ctx, "error"))
+		}
+	}
+}
+
+var groupAddTests = []struct {
+	name             string
+	postedData       url.Values
+	stays            int
+	expectedLocation string
+	expectedStays    int
+}{
+	{
+		name: "valid",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"start_date": {"2050-06-10"},
+			"end_date":   {"2050-06-12"},
+			"adults":     {"2"},
+			"children":   {"0"},
+		},
+		expectedLocation: "/group",
+		expectedStays:    1,
+	},
+	{
+		name: "unknown room",
+		postedData: url.Values{
+			"room_id":    {"9"},
+			"start_date": {"2050-06-10"},
+			"end_date":   {"2050-06-12"},
+			"adults":     {"2"},
+		},
+		expectedLocation: "/search-availability",
+	},
+	{
+		name: "dates in the past",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"start_date": {"2000-06-10"},
+			"end_date":   {"2000-06-12"},
+			"adults":     {"2"},
+		},
+		expectedLocation: "/search-availability",
+	},
+	{
+		name: "too many guests",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"start_date": {"2050-06-10"},
+			"end_date":   {"2050-06-12"},
+			"adults":     {"3"},
+		},
+		expectedLocation: "/search-availability",
+	},
+	{
+		name: "unavailable",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"start_date": {"2070-06-10"},
+			"end_date":   {"2070-06-12"},
+			"adults":     {"2"},
+		},
+		expectedLocation: "/search-availability",
+	},
+	{
+		name: "group full",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"start_date": {"2050-06-10"},
+			"end_date":   {"2050-06-12"},
+			"adults":     {"2"},
+		},
+		stays:            groups.MaxStays,
+		expectedLocation: "/group",
+		expectedStays:    groups.MaxStays,
+	},
+}
+
+func TestRepository_PostGroupAdd(t *testing.T) {
+	for _, e := range groupAddTests {
+		req, _ := http.NewRequest("POST", "/group/add", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		if e.stays > 0 {
+			session.Put(ctx, "group", models.GroupBooking{Reservations: make([]models.Reservation, e.stays)})
+		}
+
+		handler := http.HandlerFunc(Repo.PostGroupAdd)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+
+		g, _ := session.Get(ctx, "group").(models.GroupBooking)
+		if len(g.Reservations) != e.expectedStays {
+			t.Errorf("failed %s: expected %d stays in the group, got %d", e.name, e.expectedStays, len(g.Reservations))
+		}
+	}
+}
+
+// groupStay returns a room stay as it is added to a group booking
+func groupStay(start string) models.Reservation {
+	startDate, _ := time.Parse("2006-01-02", start)
+	return models.Reservation{
+		RoomID:    1,
+		StartDate: startDate,
+		EndDate:   startDate.AddDate(0, 0, 2),
+		Adults:    2,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters", Price: 10000},
+	}
+}
+
+func TestRepository_Group(t *testing.T) {
+	// case 1: nothing in the group yet
+	req, _ := http.NewRequest("GET", "/group", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.Group)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("Group handler returned %d to %s for an empty group", rr.Code, rr.Header().Get("Location"))
+	}
+
+	// case 2: a group with two stays
+	req, _ = http.NewRequest("GET", "/group", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	session.Put(ctx, "group", models.GroupBooking{Reservations: []models.Reservation{groupStay("2050-06-10"), groupStay("2050-06-20")}})
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Group handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRepository_PostGroupRemove(t *testing.T) {
+	tests := []struct {
+		stay           string
+		expectedStatus int
+		expectedStays  int
+	}{
+		{"0", http.StatusSeeOther, 1},
+		{"2", http.StatusBadRequest, 2},
+		{"x", http.StatusBadRequest, 2},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/group/remove", strings.NewReader("stay="+e.stay))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		session.Put(ctx, "group", models.GroupBooking{Reservations: []models.Reservation{groupStay("2050-06-10"), groupStay("2050-06-20")}})
+
+		handler := http.HandlerFunc(Repo.PostGroupRemove)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed stay %s: expected %d, but got %d", e.stay, e.expectedStatus, rr.Code)
+		}
+
+		g, _ := session.Get(ctx, "group").(models.GroupBooking)
+		if len(g.Reservations) != e.expectedStays {
+			t.Errorf("failed stay %s: expected %d stays in the group, got %d", e.stay, e.expectedStays, len(g.Reservations))
+		}
+	}
+}
+
+var postGroupTests = []struct {
+	name             string
+	email            string
+	start            string
+	expectedStatus   int
+	expectedLocation string
+	expectedID       int
+}{
+	{"valid", "john@smith.com", "2050-06-10", http.StatusSeeOther, "/group/checkout", 2},
+	{"unavailable", "john@smith.com", "2070-06-10", http.StatusSeeOther, "/group", 0},
+	{"invalid email", "john", "2050-06-10", http.StatusOK, "", 0},
+	{"guest can't be saved", "nobody@fails.com", "2050-06-10", http.StatusSeeOther, "/", 0},
+}
+
+func TestRepository_PostGroup(t *testing.T) {
+	for _, e := range postGroupTests {
+		postedData := url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {e.email},
+			"phone":      {"555-555-5555"},
+		}
+
+		req, _ := http.NewRequest("POST", "/group", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		session.Put(ctx, "group", models.GroupBooking{Reservations: []models.Reservation{groupStay("2050-06-20"), groupStay(e.start)}})
+
+		handler := http.HandlerFunc(Repo.PostGroup)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+		if session.GetInt(ctx, "group_booking_id") != e.expectedID {
+			t.Errorf("failed %s: expected group booking %d in the session, got %d", e.name, e.expectedID, session.GetInt(ctx, "group_booking_id"))
+		}
+		if _, ok := session.Get(ctx, "group").(models.GroupBooking); ok != (e.expectedID == 0) {
+			t.Errorf("failed %s: expected the group to be cleared from the session only once booked", e.name)
+		}
+	}
+}
+
+var groupCheckoutTests = []struct {
+	name             string
+	groupID          int
+	token            string
+	expectedStatus   int
+	expectedLocation string
+}{
+	{"unpaid", 2, "tok_visa", http.StatusSeeOther, "/group/summary"},
+	{"declined", 2, payments.DeclinedToken, http.StatusSeeOther, "/group/checkout"},
+	{"not-captured", 2, payments.UncapturableToken, http.StatusSeeOther, "/group/checkout"},
+	{"already paid", 1, "tok_visa", http.StatusSeeOther, "/group/summary"},
+	{"no group in session", 0, "tok_visa", http.StatusSeeOther, "/"},
+	{"payment can't be saved", 3, "tok_visa", http.StatusInternalServerError, ""},
+}
+
+func TestRepository_GroupCheckout(t *testing.T) {
+	tests := []struct {
+		groupID          int
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{2, http.StatusOK, ""},
+		{1, http.StatusSeeOther, "/group/summary"},
+		{0, http.StatusSeeOther, "/"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/group/checkout", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		if e.groupID > 0 {
+			session.Put(ctx, "group_booking_id", e.groupID)
+		}
+
+		handler := http.HandlerFunc(Repo.GroupCheckout)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed group %d: expected %d, but got %d", e.groupID, e.expectedStatus, rr.Code)
+		}
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed group %d: expected location %s, got %s", e.groupID, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_PostGroupCheckout(t *testing.T) {
+	for _, e := range groupCheckoutTests {
+		req, _ := http.NewRequest("POST", "/group/checkout", strings.NewReader("payment_token="+e.token))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		if e.groupID > 0 {
+			session.Put(ctx, "group_booking_id", e.groupID)
+		}
+
+		handler := http.HandlerFunc(Repo.PostGroupCheckout)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_GroupSummary(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/group/summary", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	session.Put(ctx, "group_booking_id", 1)
+
+	handler := http.HandlerFunc(Repo.GroupSummary)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("GroupSummary handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestRepository_AdminShowGroupBooking(t *testing.T) {
+	tests := []struct {
+		id             string
+		expectedStatus int
+	}{
+		{"1", http.StatusOK},
+		{"9", http.StatusNotFound},
+		{"x", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/group-bookings/"+e.id, nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminShowGroupBooking)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed group booking %s: expected %d, but got %d", e.id, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+var webhookAdminTests = []struct {
+	name             string
+	id               string
+	postedData       url.Values
+	expectedStatus   int
+	expectedLocation string
+}{
+	{
+		name:             "add",
+		postedData:       url.Values{"url": {"https://example.com/hooks"}, "events": {"reservation.created", "block.created"}},
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/webhooks/2",
+	},
+	{
+		name:           "add without a valid url",
+		postedData:     url.Values{"url": {"example.com/hooks"}, "events": {"reservation.created"}},
+		expectedStatus: http.StatusOK,
+	},
+	{
+		name:           "add without events",
+		postedData:     url.Values{"url": {"https://example.com/hooks"}},
+		expectedStatus: http.StatusOK,
+	},
+	{
+		name:           "add with an unknown event",
+		postedData:     url.Values{"url": {"https://example.com/hooks"}, "events": {"reservation.deleted"}},
+		expectedStatus: http.StatusOK,
+	},
+	{
+		name:             "update",
+		id:               "1",
+		postedData:       url.Values{"url": {"https://example.com/other"}, "events": {"reservation.cancelled"}},
+		expectedStatus:   http.StatusSeeOther,
+		expectedLocation: "/admin/webhooks/1",
+	},
+	{
+		name:           "update without events",
+		id:             "1",
+		postedData:     url.Values{"url": {"https://example.com/other"}, "active": {"1"}},
+		expectedStatus: http.StatusOK,
+	},
+	{
+		name:           "update unknown webhook",
+		id:             "9",
+		postedData:     url.Values{"url": {"https://example.com/other"}, "events": {"reservation.cancelled"}},
+		expectedStatus: http.StatusNotFound,
+	},
+}
+
+func TestRepository_AdminPostWebhooks(t *testing.T) {
+	for _, e := range webhookAdminTests {
+		req, _ := http.NewRequest("POST", "/admin/webhooks", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		handler := http.HandlerFunc(Repo.AdminPostWebhooks)
+		if e.id != "" {
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("id", e.id)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+			handler = Repo.AdminPostWebhook
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_AdminWebhookActions(t *testing.T) {
+	tests := []struct {
+		name             string
+		handler          http.HandlerFunc
+		id               string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"delete", Repo.AdminDeleteWebhook, "1", http.StatusSeeOther, "/admin/webhooks"},
+		{"delete unknown webhook", Repo.AdminDeleteWebhook, "9", http.StatusNotFound, ""},
+		{"delete invalid id", Repo.AdminDeleteWebhook, "x", http.StatusBadRequest, ""},
+		{"replay", Repo.AdminReplayWebhookDelivery, "1", http.StatusSeeOther, "/admin/webhooks/1"},
+		{"replay unknown delivery", Repo.AdminReplayWebhookDelivery, "9", http.StatusNotFound, ""},
+		{"replay invalid id", Repo.AdminReplayWebhookDelivery, "x", http.StatusBadRequest, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/webhooks", nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+// channelStay returns a booking of room ext-1 taken on the fake channel
+func TestRepository_AttemptWebhookDelivery(t *testing.T) {
+	var received atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		id       int
+		expected int32
+	}{
+		{"due", 2, 1},
+		{"claimed by another attempt", 3, 0},
+	}
+
+	for _, e := range tests {
+		received.Store(0)
+		Repo.attemptWebhookDelivery(models.WebhookDelivery{
+			ID:           e.id,
+			Event:        "reservation.created",
+			Payload:      `{"event":"reservation.created"}`,
+			Subscription: models.WebhookSubscription{ID: 1, URL: srv.URL, Active: true},
+		})
+
+		if got := received.Load(); got != e.expected {
+			t.Errorf("failed %s: expected %d request(s), got %d", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestRepository_EmitWebhookListenerBehind(t *testing.T) {
+	// nothing takes events off the channel, like a listener stuck on a slow endpoint
+	behind := app
+	behind.WebhookChan = make(chan models.WebhookEvent)
+	repo := NewTestRepo(&behind)
+
+	done := make(chan struct{})
+	go func() {
+		repo.emitWebhook(1, "reservation.created", map[string]int{"id": 1})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("emitWebhook waited on a listener that is behind")
+	}
+}
+
+func channelStay(reference, externalRoomID, start string) channels.Reservation {
+	startDate, _ := time.Parse("2006-01-02", start)
+	return channels.Reservation{
+		Reference:      reference,
+		ExternalRoomID: externalRoomID,
+		FirstName:      "Jane",
+		LastName:       "Doe",
+		Email:          "jane@doe.com",
+		StartDate:      startDate,
+		EndDate:        startDate.AddDate(0, 0, 2),
+		Adults:         2,
+		Total:          25000,
+	}
+}
+
+func TestRepository_AdminSyncChannel(t *testing.T) {
+	imported := app.Metrics.ReservationsCreated.Value("fake")
+	fakeChannel.Book(channelStay("BK-NEW", "ext-1", "2050-06-10"))
+	fakeChannel.Book(channelStay("BK-CONFLICT", "ext-1", "2070-06-10"))
+	fakeChannel.Book(channelStay("BK-UNMAPPED", "ext-9", "2050-06-10"))
+	invalid := channelStay("BK-INVALID", "ext-1", "2050-06-10")
+	invalid.Adults = 0
+	fakeChannel.Book(invalid)
+	fakeChannel.Book(channelStay("BK-IMPORTED", "ext-1", "2050-06-10"))
+	fakeChannel.Book(channelStay("BK-CONFLICTED", "ext-1", "2070-06-10"))
+	fakeChannel.Book(channelStay("BK-TAKEN", "ext-1", "2055-01-01"))
+
+	tests := []struct {
+		name            string
+		channel         string
+		down            bool
+		expectedStatus  int
+		expectedMessage string
+	}{
+		{"sync", "fake", false, http.StatusSeeOther, "Synced with fake: 1 imported, 2 conflict(s), 1 failed, see the log below"},
+		{"channel down", "fake", true, http.StatusSeeOther, "Sync with fake failed: channel unavailable"},
+		{"unknown channel", "other", false, http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/channels/"+e.channel+"/sync", nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("channel", e.channel)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		fakeChannel.SetDown(e.down)
+		handler := http.HandlerFunc(Repo.AdminSyncChannel)
+		handler.ServeHTTP(rr, req)
+		fakeChannel.SetDown(false)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected %d, but got %d", e.name, e.expectedStatus, rr.Code)
+		}
+		message := session.GetString(ctx, "warning") + session.GetString(ctx, "error")
+		if message != e.expectedMessage {
+			t.Errorf("failed %s: expected message %q, got %q", e.name, e.expectedMessage, message)
+		}
+	}
+
+	// the availability of the mapped room is pushed after the reservations are pulled
+	today := dates.Today(time.UTC)
+	if a, ok := fakeChannel.Availability("ext-1", today); !ok || a.Free != 1 || a.Price != 10000 {
+		t.Errorf("expected the availability of ext-1 to be pushed, got %+v %v", a, ok)
+	}
+	if n := app.Metrics.ReservationsCreated.Value("fake") - imported; n != 1 {
+		t.Errorf("expected 1 reservation created from fake, got %v", n)
+	}
+}
+
+func TestRepository_AdminPostChannelMappings(t *testing.T) {
+	tests := []struct {
+		channel        string
+		expectedStatus int
+	}{
+		{"fake", http.StatusSeeOther},
+		{"other", http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/channels/"+e.channel+"/mappings", strings.NewReader("external_room_id_1=ext-1"))
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("channel", e.channel)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostChannelMappings)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("failed %s: expected %d, but got %d", e.channel, e.expectedStatus, rr.Code)
+		}
+	}
+}
+
+func TestRepository_Readyz(t *testing.T) {
+	mailDown = errors.New("connection refused")
+	defer func() { mailDown = nil }()
+
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.Readyz)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected %d when the mail server is down, got %d", http.StatusServiceUnavailable, rr.Code)
+	}
+
+	var report health.Report
+	err := json.Unmarshal(rr.Body.Bytes(), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != health.StatusError || report.Checks["mail"].Error != "connection refused" {
+		t.Errorf("expected the report to say the mail server is down, got %+v", report)
+	}
+}
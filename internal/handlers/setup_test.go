@@ -1,19 +1,28 @@
 package handlers
 
 import (
+	"context"
 	"encoding/gob"
 	"fmt"
 	"html/template"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/RakhmanovTimur/bookings/internal/channels"
 	"github.com/RakhmanovTimur/bookings/internal/config"
+	"github.com/RakhmanovTimur/bookings/internal/health"
+	"github.com/RakhmanovTimur/bookings/internal/helpers"
+	"github.com/RakhmanovTimur/bookings/internal/metrics"
 	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/RakhmanovTimur/bookings/internal/payments"
+	"github.com/RakhmanovTimur/bookings/internal/pricing"
 	"github.com/RakhmanovTimur/bookings/internal/render"
+	"github.com/RakhmanovTimur/bookings/internal/ttlcache"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -23,11 +32,16 @@ import (
 var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
+var fakeChannel = channels.NewFake("fake")
+
+// mailDown fails the readiness check of the mail server when set
+var mailDown error
 var functions = template.FuncMap{
 	"humanDate":  render.HumanDate,
 	"formatDate": render.FormatDate,
 	"iterate":    render.Iterate,
 	"add":        render.Add,
+	"money":      pricing.FormatMoney,
 }
 
 func TestMain(m *testing.M) {
@@ -37,15 +51,13 @@ func TestMain(m *testing.M) {
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
+	gob.Register(models.GroupBooking{})
 
 	// change this to true when in production
 	app.InProduction = false
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	app.Logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	app.Metrics = metrics.New()
 
 	// set up the session
 	session = scs.New()
@@ -56,12 +68,24 @@ func TestMain(m *testing.M) {
 
 	app.Session = session
 
+	app.AvailabilityCache = ttlcache.New(time.Minute)
+
+	app.Payments = payments.NewFake("secret")
+	app.Channels = []channels.Channel{fakeChannel}
+	app.Readiness = []health.Check{{Name: "mail", Run: func(ctx context.Context) error { return mailDown }}}
+
 	mailChan := make(chan models.MailData)
 	app.MailChan = mailChan
 	defer close(mailChan)
 
 	listenForMail()
 
+	webhookChan := make(chan models.WebhookEvent)
+	app.WebhookChan = webhookChan
+	defer close(webhookChan)
+
+	listenForWebhooks()
+
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
 		log.Fatal("cannot create template cache")
@@ -74,6 +98,7 @@ func TestMain(m *testing.M) {
 	NewHandlers(repo)
 
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 
@@ -87,6 +112,14 @@ func listenForMail() {
 	}()
 }
 
+func listenForWebhooks() {
+	go func() {
+		for {
+			_ = <-app.WebhookChan
+		}
+	}()
+}
+
 func getRoutes() http.Handler {
 
 	mux := chi.NewRouter()
@@ -95,6 +128,9 @@ func getRoutes() http.Handler {
 	// mux.Use(NoSurf)
 	mux.Use(SessionLoad)
 
+	mux.Get("/healthz", Repo.Healthz)
+	mux.Get("/readyz", Repo.Readyz)
+
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
 	mux.Get("/traveler-room", Repo.TravelerRoom)
@@ -102,17 +138,43 @@ func getRoutes() http.Handler {
 
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Post("/search-availability", Repo.PostAvailability)
+	mux.Get("/search-availability/flexible", Repo.FlexibleAvailability)
 	mux.Post("/search-availability-json", Repo.AvailabilityJSON)
+	mux.Get("/rooms/{id}/availability", Repo.RoomAvailabilityJSON)
 
 	mux.Get("/contact", Repo.Contact)
 
 	mux.Get("/make-reservation", Repo.MakeReservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
+	mux.Get("/checkout", Repo.Checkout)
+	mux.Post("/checkout", Repo.PostCheckout)
+	mux.Post("/payments/webhook", Repo.PaymentWebhook)
+	mux.Get("/waitlist", Repo.Waitlist)
+	mux.Post("/waitlist", Repo.PostWaitlist)
+	mux.Get("/waitlist/{token}", Repo.WaitlistOffer)
+	mux.Get("/group", Repo.Group)
+	mux.Post("/group", Repo.PostGroup)
+	mux.Post("/group/add", Repo.PostGroupAdd)
+	mux.Post("/group/remove", Repo.PostGroupRemove)
+	mux.Get("/group/checkout", Repo.GroupCheckout)
+	mux.Post("/group/checkout", Repo.PostGroupCheckout)
+	mux.Get("/group/summary", Repo.GroupSummary)
+	mux.Get("/reservations/{token}/cancel", Repo.CancelReservation)
+	mux.Post("/reservations/{token}/cancel", Repo.PostCancelReservation)
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostShowLogin)
 	mux.Get("/user/logout", Repo.Logout)
 
+	mux.Get("/account/login", Repo.AccountLogin)
+	mux.Post("/account/login", Repo.PostAccountLogin)
+	mux.Post("/account/login-link", Repo.PostAccountLoginLink)
+	mux.Get("/account/login/{token}", Repo.AccountLoginWithToken)
+	mux.Get("/account/register", Repo.AccountRegister)
+	mux.Post("/account/register", Repo.PostAccountRegister)
+	mux.Get("/account", Repo.Account)
+	mux.Post("/account", Repo.PostAccount)
+
 	mux.Route("/admin", func(mux chi.Router) {
 		// mux.Use(Auth)
 
@@ -121,11 +183,55 @@ func getRoutes() http.Handler {
 		mux.Get("/reservations-all", Repo.AdminAllReservations)
 		mux.Get("/reservations-calender", Repo.AdminReservationsCalender)
 		mux.Post("/reservations-calender", Repo.AdminPostReservationsCalender)
+		mux.Get("/reservations-timeline", Repo.AdminReservationsTimeline)
+		mux.Get("/reservations-timeline/json", Repo.AdminRestrictionsJSON)
+		mux.Post("/reservations-timeline/reservation", Repo.AdminMoveReservationJSON)
+		mux.Post("/reservations-timeline/block", Repo.AdminSaveBlockJSON)
+		mux.Post("/reservations-timeline/block/delete", Repo.AdminDeleteBlockJSON)
+		mux.Get("/stay-rules", Repo.AdminStayRules)
+		mux.Post("/stay-rules", Repo.AdminPostStayRules)
+		mux.Get("/stay-rules/{id}/delete", Repo.AdminDeleteStayRule)
+		mux.Get("/cancellation-policies", Repo.AdminCancellationPolicies)
+		mux.Post("/cancellation-policies", Repo.AdminPostCancellationPolicies)
+		mux.Post("/cancellation-policies/rooms", Repo.AdminPostRoomCancellationPolicy)
+		mux.Get("/cancellation-policies/{id}/delete", Repo.AdminDeleteCancellationPolicy)
+		mux.Get("/taxes", Repo.AdminTaxes)
+		mux.Post("/taxes", Repo.AdminPostTaxes)
+		mux.Get("/taxes/{id}/delete", Repo.AdminDeleteTax)
+		mux.Get("/promo-codes", Repo.AdminPromoCodes)
+		mux.Post("/promo-codes", Repo.AdminPostPromoCodes)
+		mux.Get("/promo-codes/{id}/delete", Repo.AdminDeletePromoCode)
+		mux.Get("/extras", Repo.AdminExtras)
+		mux.Post("/extras", Repo.AdminPostExtras)
+		mux.Get("/extras/{id}/delete", Repo.AdminDeleteExtra)
+		mux.Get("/reservations-export", Repo.AdminExportReservations)
+		mux.Get("/waitlist", Repo.AdminWaitlist)
+		mux.Get("/waitlist/{id}/offer", Repo.AdminOfferWaitlist)
+		mux.Get("/waitlist/{id}/remove", Repo.AdminRemoveWaitlist)
+		mux.Get("/guests", Repo.AdminGuests)
+		mux.Get("/guests/{id}", Repo.AdminShowGuest)
+		mux.Post("/guests/{id}", Repo.AdminPostGuest)
+		mux.Post("/guests/{id}/merge", Repo.AdminMergeGuest)
+		mux.Get("/group-bookings", Repo.AdminGroupBookings)
+		mux.Get("/group-bookings/{id}", Repo.AdminShowGroupBooking)
+		mux.Get("/webhooks", Repo.AdminWebhooks)
+		mux.Post("/webhooks", Repo.AdminPostWebhooks)
+		mux.Get("/webhooks/{id}", Repo.AdminShowWebhook)
+		mux.Post("/webhooks/{id}", Repo.AdminPostWebhook)
+		mux.Get("/webhooks/{id}/delete", Repo.AdminDeleteWebhook)
+		mux.Get("/webhooks/deliveries/{id}/replay", Repo.AdminReplayWebhookDelivery)
+		mux.Get("/channels", Repo.AdminChannels)
+		mux.Post("/channels/{channel}/mappings", Repo.AdminPostChannelMappings)
+		mux.Get("/channels/{channel}/sync", Repo.AdminSyncChannel)
 		mux.Get("/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
 		mux.Get("/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
+		mux.Get("/cancel-reservation/{src}/{id}/do", Repo.AdminCancelReservation)
 
 		mux.Get("/reservations/{src}/{id}/show", Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}/show", Repo.AdminPostShowReservation)
+		mux.Get("/reservations/{src}/{id}/invoice", Repo.AdminReservationInvoice)
+		mux.Get("/reservations/{src}/{id}/invoice/send", Repo.AdminSendInvoice)
+		mux.Post("/reservations/{src}/{id}/extras", Repo.AdminPostReservationExtras)
 	})
 
 	fileServer := http.FileServer(http.Dir("./static/"))
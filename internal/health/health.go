@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Statuses of a report and of each of its checks
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// Timeout is how long a check gets before it counts as failed, so a load balancer asking isn't
+// kept waiting on something that hangs
+const Timeout = 2 * time.Second
+
+// Check is something the application needs to serve requests, like its database. Run returns
+// an error when it isn't ready.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Result is how a check went
+type Result struct {
+	Status     string  `json:"status"`
+	Error      string  `json:"error,omitempty"`
+	DurationMS float64 `json:"duration_ms"`
+}
+
+// Report is how the checks went, it is ok when every one of them is
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// OK reports whether every check passed
+func (r Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// Run runs the checks at the same time and reports how they went
+func Run(ctx context.Context, checks []Check) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Result)}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c Check) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, Timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.Run(ctx)
+			result := Result{Status: StatusOK, DurationMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err == nil && ctx.Err() != nil {
+				// a check that ignores its context still fails once it runs out of time
+				err = ctx.Err()
+			}
+			if err != nil {
+				result.Status = StatusError
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[c.Name] = result
+			if err != nil {
+				report.Status = StatusError
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	return report
+}
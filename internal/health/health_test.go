@@ -0,0 +1,44 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	ok := Check{Name: "database", Run: func(ctx context.Context) error { return nil }}
+	failing := Check{Name: "mail", Run: func(ctx context.Context) error { return errors.New("connection refused") }}
+
+	report := Run(context.Background(), []Check{ok})
+	if !report.OK() || report.Checks["database"].Status != StatusOK {
+		t.Errorf("expected the report to be ok, got %+v", report)
+	}
+
+	report = Run(context.Background(), []Check{ok, failing})
+	if report.OK() {
+		t.Error("expected a failing check to fail the report")
+	}
+	if r := report.Checks["mail"]; r.Status != StatusError || r.Error != "connection refused" {
+		t.Errorf("expected the mail check to have failed, got %+v", r)
+	}
+	if report.Checks["database"].Status != StatusOK {
+		t.Errorf("expected the database check to still pass, got %+v", report.Checks["database"])
+	}
+
+	report = Run(context.Background(), nil)
+	if !report.OK() {
+		t.Error("expected nothing to check to be ok")
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the check doesn't look at its context, but has run out of time all the same
+	report := Run(ctx, []Check{{Name: "slow", Run: func(ctx context.Context) error { return nil }}})
+	if report.OK() || report.Checks["slow"].Error != context.Canceled.Error() {
+		t.Errorf("expected a check that ran out of time to fail, got %+v", report)
+	}
+}
@@ -1,35 +1,97 @@
 package helpers
 
 import (
-	"fmt"
+	"context"
+	"log/slog"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/RakhmanovTimur/bookings/internal/config"
+	"github.com/RakhmanovTimur/bookings/internal/models"
 )
 
 var app *config.AppConfig
 
+type contextKey string
+
+const propertyKey contextKey = "property"
+const userPropertiesKey contextKey = "user_properties"
+const requestIDKey contextKey = "request_id"
+
 // NewHelpers sets up app config for helpers
 func NewHelpers(a *config.AppConfig) {
 	app = a
 }
 
-// ClientError writes a client-side error into InfoLog
-func ClientError(w http.ResponseWriter, status int) {
-	app.InfoLog.Println("Client error with status of", status)
+// ClientError logs a client-side error with the request it was made in and writes it out
+func ClientError(w http.ResponseWriter, r *http.Request, status int) {
+	Logger(r).Info("client error", "status", status, "method", r.Method, "path", r.URL.Path)
 	http.Error(w, http.StatusText(status), status)
 }
 
-// ServerError traces the server error and writes it to the info log
-func ServerError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.ErrorLog.Println(trace)
+// ServerError logs the server error with the request it happened in and a stack trace
+func ServerError(w http.ResponseWriter, r *http.Request, err error) {
+	Logger(r).Error("server error",
+		"error", err,
+		"method", r.Method,
+		"path", r.URL.Path,
+		"stack", string(debug.Stack()),
+	)
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
-// isAuthenticated returns true if the user is logged in, or false otherwise
+// WithRequestID returns a copy of ctx holding the id of the request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the id of the request, or an empty string if it hasn't been given one
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// Logger returns the application logger, tagging what it logs with the id of the request
+func Logger(r *http.Request) *slog.Logger {
+	if id := RequestID(r); id != "" {
+		return app.Logger.With("request_id", id)
+	}
+	return app.Logger
+}
+
+// isAuthenticated returns true if a staff user is logged in, or false otherwise. Guests logged in
+// to their account don't count, whatever they can reach is behind GuestAccountID.
 func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
-	return exists
+	return exists && app.Session.GetInt(r.Context(), "access_level") >= models.AccessLevelStaff
+}
+
+// GuestAccountID returns the id of the guest account logged in, or 0 if there isn't one
+func GuestAccountID(r *http.Request) int {
+	if IsAuthenticated(r) {
+		return 0
+	}
+	return app.Session.GetInt(r.Context(), "user_id")
+}
+
+// WithProperty returns a copy of ctx holding the property the request is scoped to
+func WithProperty(ctx context.Context, p models.Property) context.Context {
+	return context.WithValue(ctx, propertyKey, p)
+}
+
+// CurrentProperty returns the property the request is scoped to
+func CurrentProperty(r *http.Request) models.Property {
+	p, _ := r.Context().Value(propertyKey).(models.Property)
+	return p
+}
+
+// WithUserProperties returns a copy of ctx holding the properties the logged in user can manage
+func WithUserProperties(ctx context.Context, properties []models.Property) context.Context {
+	return context.WithValue(ctx, userPropertiesKey, properties)
+}
+
+// UserProperties returns the properties the logged in user can manage
+func UserProperties(r *http.Request) []models.Property {
+	properties, _ := r.Context().Value(userPropertiesKey).([]models.Property)
+	return properties
 }
@@ -0,0 +1,40 @@
+package holds
+
+import (
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+// Duration is how long a unit is held for a guest who picked a room before it is released
+// for other guests to book
+const Duration = 15 * time.Minute
+
+// PaymentDuration is how long a reservation waiting to be paid for keeps its unit before it is
+// cancelled and the unit released for other guests to book
+const PaymentDuration = 30 * time.Minute
+
+// Active reports whether a room restriction is a hold that hasn't run out at now
+func Active(h models.RoomRestriction, now time.Time) bool {
+	return h.RestrictionID == models.RestrictionHold && now.Before(h.ExpiresAt)
+}
+
+// Covers reports whether a hold is active at now and holds the room for exactly the stay from
+// start to end, so a guest who changes their room or dates has to be given a new hold
+func Covers(h models.RoomRestriction, roomID int, start, end, now time.Time) bool {
+	return Active(h, now) && h.RoomID == roomID && h.StartDate.Equal(start) && h.EndDate.Equal(end)
+}
+
+// Remaining returns how long a hold has left at now in whole seconds, or zero once it has run out
+func Remaining(h models.RoomRestriction, now time.Time) time.Duration {
+	if !Active(h, now) {
+		return 0
+	}
+	return h.ExpiresAt.Sub(now).Truncate(time.Second)
+}
+
+// Lapsed reports whether a reservation waiting to be paid for ran out at now before it was
+// confirmed. Confirmed reservations never lapse.
+func Lapsed(res models.Reservation, now time.Time) bool {
+	return !res.PendingUntil.IsZero() && !now.Before(res.PendingUntil)
+}
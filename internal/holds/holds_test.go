@@ -0,0 +1,107 @@
+package holds
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+var now = time.Date(2050, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func hold(expires time.Time) models.RoomRestriction {
+	return models.RoomRestriction{
+		RoomID:        1,
+		RoomUnitID:    2,
+		RestrictionID: models.RestrictionHold,
+		StartDate:     time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2050, 6, 12, 0, 0, 0, 0, time.UTC),
+		ExpiresAt:     expires,
+	}
+}
+
+func TestActive(t *testing.T) {
+	block := hold(now.Add(time.Minute))
+	block.RestrictionID = models.RestrictionBlock
+
+	tests := []struct {
+		name     string
+		h        models.RoomRestriction
+		expected bool
+	}{
+		{"live", hold(now.Add(time.Minute)), true},
+		{"run-out", hold(now), false},
+		{"long-gone", hold(now.Add(-time.Hour)), false},
+		{"not-a-hold", block, false},
+	}
+
+	for _, e := range tests {
+		if got := Active(e.h, now); got != e.expected {
+			t.Errorf("%s: expected %t, got %t", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestCovers(t *testing.T) {
+	h := hold(now.Add(time.Minute))
+	start := time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2050, 6, 12, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		h        models.RoomRestriction
+		roomID   int
+		start    time.Time
+		end      time.Time
+		expected bool
+	}{
+		{"same-stay", h, 1, start, end, true},
+		{"other-room", h, 2, start, end, false},
+		{"later-arrival", h, 1, start.AddDate(0, 0, 1), end, false},
+		{"longer-stay", h, 1, start, end.AddDate(0, 0, 1), false},
+		{"run-out", hold(now), 1, start, end, false},
+	}
+
+	for _, e := range tests {
+		if got := Covers(e.h, e.roomID, e.start, e.end, now); got != e.expected {
+			t.Errorf("%s: expected %t, got %t", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	tests := []struct {
+		name     string
+		h        models.RoomRestriction
+		expected time.Duration
+	}{
+		{"fresh", hold(now.Add(Duration)), Duration},
+		{"part-second", hold(now.Add(90*time.Second + 500*time.Millisecond)), 90 * time.Second},
+		{"run-out", hold(now.Add(-time.Second)), 0},
+	}
+
+	for _, e := range tests {
+		if got := Remaining(e.h, now); got != e.expected {
+			t.Errorf("%s: expected %s, got %s", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestLapsed(t *testing.T) {
+	tests := []struct {
+		name         string
+		pendingUntil time.Time
+		expected     bool
+	}{
+		{"confirmed", time.Time{}, false},
+		{"waiting", now.Add(PaymentDuration), false},
+		{"run-out", now, true},
+		{"long-gone", now.Add(-time.Hour), true},
+	}
+
+	for _, e := range tests {
+		if got := Lapsed(models.Reservation{PendingUntil: e.pendingUntil}, now); got != e.expected {
+			t.Errorf("%s: expected %t, got %t", e.name, e.expected, got)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package invoices
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/dates"
+	"github.com/RakhmanovTimur/bookings/internal/extras"
+	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/RakhmanovTimur/bookings/internal/payments"
+	"github.com/RakhmanovTimur/bookings/internal/pdf"
+	"github.com/RakhmanovTimur/bookings/internal/pricing"
+)
+
+// Invoice is everything an invoice shows, amounts are in cents
+type Invoice struct {
+	Number      int
+	IssuedAt    time.Time
+	Property    models.Property
+	Reservation models.Reservation
+	Lines       []pricing.Line
+	Included    []pricing.Line
+	Total       int
+	Payments    []models.Payment
+	Paid        int
+	Balance     int
+	// Credits is the number of the invoice a credit note cancels, zero on invoices
+	Credits int
+}
+
+// Build works out the invoice of a reservation. Stays are invoiced a line per night, and per
+// night of extra guests, at the room's prices with an adjustment line when those have changed
+// since the guest booked. The extras booked and then taxes and fees follow the nights, those included in the prices are
+// listed apart, followed by the promo code discount. Cancelled reservations are invoiced their cancellation fee.
+func Build(property models.Property, res models.Reservation, room models.Room, taxes []models.TaxFee, ps []models.Payment, paid int) Invoice {
+	inv := Invoice{
+		Property:    property,
+		Reservation: res,
+		Paid:        paid,
+	}
+
+	if !res.CancelledAt.IsZero() {
+		if res.CancellationFee > 0 {
+			inv.add(pricing.Line{Description: "Cancellation fee", Quantity: 1, UnitPrice: res.CancellationFee})
+		}
+	} else {
+		stay := pricing.Stay{
+			Room:      room,
+			StartDate: res.StartDate,
+			EndDate:   res.EndDate,
+			Adults:    res.Adults,
+			Children:  res.Children,
+			Taxes:     taxes,
+		}
+		extra := stay.ExtraGuests()
+
+		for d := res.StartDate; d.Before(res.EndDate); d = d.AddDate(0, 0, 1) {
+			night := d.Format(dates.Layout)
+			inv.add(pricing.Line{
+				Description: fmt.Sprintf("%s, night of %s", room.RoomName, night),
+				Quantity:    1,
+				UnitPrice:   room.Price,
+			})
+			if extra > 0 && room.ExtraGuestPrice > 0 {
+				inv.add(pricing.Line{
+					Description: fmt.Sprintf("Extra guests, night of %s", night),
+					Quantity:    extra,
+					UnitPrice:   room.ExtraGuestPrice,
+				})
+			}
+		}
+
+		for _, item := range res.Extras {
+			inv.add(extras.Line(item))
+		}
+
+		exclusive, inclusive := pricing.Taxes(stay, room.Price+extra*room.ExtraGuestPrice)
+		for _, l := range exclusive {
+			inv.add(l)
+		}
+		inv.Included = inclusive
+
+		if res.Discount > 0 {
+			inv.add(pricing.Line{Description: "Promo code " + res.PromoCode, Quantity: 1, UnitPrice: -res.Discount})
+		}
+
+		if inv.Total != res.Total {
+			inv.add(pricing.Line{Description: "Adjustment to the booked price", Quantity: 1, UnitPrice: res.Total - inv.Total})
+		}
+	}
+
+	for _, p := range ps {
+		refund := p.Kind == payments.KindRefund && p.Status == payments.StatusRefunded
+		captured := p.Kind != payments.KindRefund && p.Status == payments.StatusCaptured
+		if refund || captured {
+			inv.Payments = append(inv.Payments, p)
+		}
+	}
+
+	inv.Balance = inv.Total - inv.Paid
+	return inv
+}
+
+// CreditNote works out the credit note cancelling invoice number credited of a reservation,
+// which was issued for total
+func CreditNote(property models.Property, res models.Reservation, credited, total int) Invoice {
+	inv := Invoice{
+		Property:    property,
+		Reservation: res,
+		Credits:     credited,
+	}
+	inv.add(pricing.Line{Description: "Cancels invoice " + FormatNumber(credited), Quantity: 1, UnitPrice: -total})
+	return inv
+}
+
+// add appends a line, working out its amount, and updates the total
+func (inv *Invoice) add(l pricing.Line) {
+	l.Amount = l.Quantity * l.UnitPrice
+	inv.Lines = append(inv.Lines, l)
+	inv.Total += l.Amount
+}
+
+// FormatNumber formats an invoice number for display
+func FormatNumber(n int) string {
+	return fmt.Sprintf("%06d", n)
+}
+
+// FileName returns the name an invoice is downloaded and attached as
+func FileName(n int) string {
+	return fmt.Sprintf("invoice-%s.pdf", FormatNumber(n))
+}
+
+// CreditNoteFileName returns the name a credit note is attached as
+func CreditNoteFileName(n int) string {
+	return fmt.Sprintf("credit-note-%s.pdf", FormatNumber(n))
+}
+
+// title is what a document is called in its heading and page headers
+func (inv Invoice) title() string {
+	if inv.Credits != 0 {
+		return "Credit note"
+	}
+	return "Invoice"
+}
+
+// layout of an invoice page, in points
+const (
+	left      = 50.0
+	right     = pdf.A4Width - 50
+	top       = 60.0
+	bottom    = pdf.A4Height - 60
+	lineStep  = 15.0
+	qtyRight  = 380.0
+	unitRight = 465.0
+)
+
+// writer writes lines of text down the pages of a document
+type writer struct {
+	doc  *pdf.Document
+	page *pdf.Page
+	y    float64
+	inv  Invoice
+}
+
+// need starts a new page unless h more points fit on the current one
+func (w *writer) need(h float64) {
+	if w.page != nil && w.y+h <= bottom {
+		return
+	}
+	w.page = w.doc.AddPage()
+	w.y = top
+	if w.doc.Pages() > 1 {
+		w.page.Text(left, w.y, pdf.Regular, 9, fmt.Sprintf("%s %s, continued", w.inv.title(), FormatNumber(w.inv.Number)))
+		w.y += 2 * lineStep
+	}
+}
+
+// text writes a line of text and moves down
+func (w *writer) text(font string, size float64, s string) {
+	w.need(lineStep)
+	w.page.Text(left, w.y, font, size, s)
+	w.y += lineStep
+}
+
+// row writes a row of the lines table and moves down
+func (w *writer) row(font, description, qty, unit, amount string) {
+	w.need(lineStep)
+	w.page.Text(left, w.y, font, 10, description)
+	w.page.TextRight(qtyRight, w.y, font, 10, qty)
+	w.page.TextRight(unitRight, w.y, font, 10, unit)
+	w.page.TextRight(right, w.y, font, 10, amount)
+	w.y += lineStep
+}
+
+// rule draws a line across the page and moves down
+func (w *writer) rule() {
+	w.need(lineStep)
+	w.page.Line(left, w.y-10, right, w.y-10)
+	w.y += 5
+}
+
+// Render lays an invoice, or a credit note, out as a PDF document
+func Render(inv Invoice) []byte {
+	res := inv.Reservation
+	w := &writer{doc: pdf.New(inv.title() + " " + FormatNumber(inv.Number)), inv: inv}
+
+	// an invoice issued once everything has been paid doubles as the receipt
+	heading := "INVOICE"
+	if inv.Credits != 0 {
+		heading = "CREDIT NOTE"
+	} else if inv.Paid > 0 && inv.Balance <= 0 {
+		heading = "INVOICE / RECEIPT"
+	}
+
+	w.need(0)
+	w.page.TextRight(right, w.y, pdf.Bold, 18, heading)
+	w.text(pdf.Bold, 18, inv.Property.Name)
+	w.y += 5
+	for _, s := range []string{inv.Property.Address, inv.Property.Email} {
+		if s != "" {
+			w.text(pdf.Regular, 10, s)
+		}
+	}
+
+	w.y += lineStep
+	w.text(pdf.Bold, 10, inv.title()+" no. "+FormatNumber(inv.Number))
+	if inv.Credits != 0 {
+		w.text(pdf.Regular, 10, "Cancels invoice no. "+FormatNumber(inv.Credits))
+	}
+	w.text(pdf.Regular, 10, "Date: "+inv.IssuedAt.Format(dates.Layout))
+	w.text(pdf.Regular, 10, fmt.Sprintf("Reservation: %d", res.ID))
+
+	w.y += lineStep
+	w.text(pdf.Bold, 10, "Bill to")
+	w.text(pdf.Regular, 10, res.FirstName+" "+res.LastName)
+	w.text(pdf.Regular, 10, res.Email)
+	if res.Phone != "" {
+		w.text(pdf.Regular, 10, res.Phone)
+	}
+
+	w.y += lineStep
+	w.text(pdf.Regular, 10, fmt.Sprintf("Stay: %s to %s, %d adult(s), %d child(ren)",
+		res.StartDate.Format(dates.Layout), res.EndDate.Format(dates.Layout), res.Adults, res.Children))
+	if !res.CancelledAt.IsZero() {
+		w.text(pdf.Regular, 10, "Cancelled on "+res.CancelledAt.Format(dates.Layout))
+	}
+
+	w.y += lineStep
+	w.row(pdf.Bold, "Description", "Qty", "Unit price", "Amount")
+	w.rule()
+	for _, l := range inv.Lines {
+		w.row(pdf.Regular, l.Description, fmt.Sprint(l.Quantity), pricing.FormatMoney(l.UnitPrice), pricing.FormatMoney(l.Amount))
+	}
+	w.rule()
+	w.row(pdf.Bold, "Total", "", "", pricing.FormatMoney(inv.Total))
+	for _, l := range inv.Included {
+		w.row(pdf.Regular, "Includes "+l.Description, "", "", pricing.FormatMoney(l.Amount))
+	}
+
+	// payments stay with the invoice issued in place of the one a credit note cancels
+	if inv.Credits != 0 {
+		return w.doc.Bytes()
+	}
+
+	if len(inv.Payments) > 0 {
+		w.y += lineStep
+		w.text(pdf.Bold, 10, "Payments received")
+		for _, p := range inv.Payments {
+			amount := p.Amount
+			description := fmt.Sprintf("%s, %s %s", p.CreatedAt.Format(dates.Layout), p.Kind, p.Reference)
+			if p.Kind == payments.KindRefund {
+				amount = -amount
+			}
+			w.row(pdf.Regular, description, "", "", pricing.FormatMoney(amount))
+		}
+	}
+
+	w.rule()
+	w.row(pdf.Regular, "Paid", "", "", pricing.FormatMoney(inv.Paid))
+	w.row(pdf.Bold, "Balance due", "", "", pricing.FormatMoney(inv.Balance))
+
+	return w.doc.Bytes()
+}
@@ -0,0 +1,174 @@
+package invoices
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/RakhmanovTimur/bookings/internal/payments"
+	"github.com/RakhmanovTimur/bookings/internal/pricing"
+)
+
+var room = models.Room{RoomName: "Secret HQ", Price: 10000, IncludedGuests: 2, ExtraGuestPrice: 1500}
+
+var reservation = models.Reservation{
+	ID:        7,
+	FirstName: "John",
+	LastName:  "Smith",
+	Email:     "john@smith.com",
+	StartDate: time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC),
+	EndDate:   time.Date(2050, 6, 12, 0, 0, 0, 0, time.UTC),
+	Adults:    3,
+	Total:     23000,
+}
+
+var paid = []models.Payment{
+	{Kind: payments.PolicyDeposit, Amount: 5000, Status: payments.StatusCaptured, Reference: "fake_1"},
+	{Kind: payments.PolicyFull, Amount: 23000, Status: payments.StatusFailed, Reference: "fake_2"},
+	{Kind: payments.KindRefund, Amount: 1000, Status: payments.StatusRefunded, Reference: "fake_1"},
+}
+
+func TestBuild(t *testing.T) {
+	inv := Build(models.Property{}, reservation, room, nil, paid, 4000)
+
+	// two nights and two nights of one extra guest
+	if len(inv.Lines) != 4 {
+		t.Fatalf("expected 4 lines, got %d", len(inv.Lines))
+	}
+	if inv.Lines[0].Description != "Secret HQ, night of 2050-06-10" || inv.Lines[1].Amount != 1500 {
+		t.Errorf("unexpected lines %+v", inv.Lines)
+	}
+	if inv.Total != 23000 || inv.Balance != 19000 {
+		t.Errorf("expected total 23000 and balance 19000, got %d and %d", inv.Total, inv.Balance)
+	}
+	if len(inv.Payments) != 2 {
+		t.Errorf("expected the failed payment to be left out, got %d payments", len(inv.Payments))
+	}
+}
+
+func TestBuildAdjustment(t *testing.T) {
+	res := reservation
+	res.Total = 22000
+
+	inv := Build(models.Property{}, res, room, nil, nil, 0)
+
+	last := inv.Lines[len(inv.Lines)-1]
+	if last.Amount != -1000 || inv.Total != 22000 {
+		t.Errorf("expected an adjustment to the booked total, got %+v and total %d", last, inv.Total)
+	}
+}
+
+func TestBuildTaxes(t *testing.T) {
+	taxes := []models.TaxFee{
+		{Name: "City tax", Kind: pricing.TaxPerGuestNight, Amount: 200},
+		{Name: "VAT", Kind: pricing.TaxPercent, Amount: 1000, Inclusive: true},
+	}
+	res := reservation
+	res.Total = 24200
+
+	inv := Build(models.Property{}, res, room, taxes, nil, 0)
+
+	last := inv.Lines[len(inv.Lines)-1]
+	if last.Description != "City tax, 3 guest(s) x 2 night(s)" || last.Amount != 1200 || inv.Total != 24200 {
+		t.Errorf("expected the city tax to be charged, got %+v and total %d", last, inv.Total)
+	}
+	if len(inv.Included) != 1 || inv.Included[0].Amount != 2091 {
+		t.Errorf("expected the VAT to be included, got %+v", inv.Included)
+	}
+}
+
+func TestBuildDiscount(t *testing.T) {
+	res := reservation
+	res.PromoCode = "SAVE10"
+	res.Discount = 2300
+	res.Total = 20700
+
+	inv := Build(models.Property{}, res, room, nil, nil, 0)
+
+	last := inv.Lines[len(inv.Lines)-1]
+	if last.Description != "Promo code SAVE10" || last.Amount != -2300 || inv.Total != 20700 {
+		t.Errorf("expected the discount and no adjustment, got %+v and total %d", last, inv.Total)
+	}
+}
+
+func TestBuildExtras(t *testing.T) {
+	res := reservation
+	res.Extras = []models.ReservationExtra{
+		{Name: "Breakfast", Pricing: "per_guest_night", Quantity: 3, Nights: 2, UnitPrice: 1000, Amount: 6000},
+	}
+	res.Total = 29000
+
+	inv := Build(models.Property{}, res, room, nil, nil, 0)
+
+	last := inv.Lines[len(inv.Lines)-1]
+	if last.Description != "Breakfast, 3 guest(s) x 2 night(s)" || last.Amount != 6000 || inv.Total != 29000 {
+		t.Errorf("expected the extras to be invoiced, got %+v and total %d", last, inv.Total)
+	}
+}
+
+func TestBuildCancelled(t *testing.T) {
+	res := reservation
+	res.CancelledAt = time.Date(2050, 6, 5, 0, 0, 0, 0, time.UTC)
+	res.CancellationFee = 11500
+
+	inv := Build(models.Property{}, res, room, nil, nil, 0)
+	if len(inv.Lines) != 1 || inv.Total != 11500 {
+		t.Errorf("expected only the cancellation fee, got %+v", inv.Lines)
+	}
+
+	res.CancellationFee = 0
+	inv = Build(models.Property{}, res, room, nil, nil, 0)
+	if len(inv.Lines) != 0 || inv.Total != 0 {
+		t.Errorf("expected nothing to invoice, got %+v", inv.Lines)
+	}
+}
+
+func TestRender(t *testing.T) {
+	res := reservation
+	res.EndDate = res.StartDate.AddDate(0, 0, 60)
+	res.Total = 0
+
+	inv := Build(models.Property{Name: "Golden Tavern"}, res, room, nil, paid, 4000)
+	inv.Number = 12
+	out := Render(inv)
+
+	if !bytes.HasPrefix(out, []byte("%PDF-")) {
+		t.Fatal("expected a PDF document")
+	}
+	for _, s := range []string{"(Invoice no. 000012)", "(Golden Tavern)", "(Secret HQ, night of 2050-08-08)", "(-10.00)"} {
+		if !bytes.Contains(out, []byte(s)) {
+			t.Errorf("expected the invoice to contain %s", s)
+		}
+	}
+	// 120 lines don't fit on one page
+	if bytes.Contains(out, []byte("/Count 1 ")) {
+		t.Error("expected the invoice to run over several pages")
+	}
+	if FileName(inv.Number) != fmt.Sprintf("invoice-%06d.pdf", 12) {
+		t.Errorf("unexpected file name %s", FileName(inv.Number))
+	}
+}
+
+func TestCreditNote(t *testing.T) {
+	inv := CreditNote(models.Property{Name: "Golden Tavern"}, reservation, 12, 23000)
+	inv.Number = 13
+
+	if inv.Total != -23000 || len(inv.Lines) != 1 || inv.Credits != 12 {
+		t.Fatalf("expected a credit note for -230.00 cancelling invoice 12, got %+v", inv)
+	}
+
+	out := Render(inv)
+	for _, s := range []string{"(CREDIT NOTE)", "(Credit note no. 000013)", "(Cancels invoice no. 000012)", "(-230.00)"} {
+		if !bytes.Contains(out, []byte(s)) {
+			t.Errorf("expected the credit note to contain %s", s)
+		}
+	}
+	if bytes.Contains(out, []byte("(Balance due)")) {
+		t.Error("expected no balance on a credit note")
+	}
+	if CreditNoteFileName(inv.Number) != "credit-note-000013.pdf" {
+		t.Errorf("unexpected file name %s", CreditNoteFileName(inv.Number))
+	}
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"database/sql"
+)
+
+// Metrics are the metrics the application keeps about itself
+type Metrics struct {
+	Registry *Registry
+
+	HTTPRequests        *Counter
+	HTTPRequestDuration *Histogram
+
+	MailSent     *Counter
+	MailFailures *Counter
+
+	ReservationsCreated *Counter
+	EmptySearches       *Counter
+}
+
+// New returns the metrics of the application in a registry of their own
+func New() *Metrics {
+	reg := NewRegistry()
+
+	return &Metrics{
+		Registry: reg,
+
+		HTTPRequests: reg.Counter("bookings_http_requests_total",
+			"HTTP requests handled, by route pattern", "method", "route", "status"),
+		HTTPRequestDuration: reg.Histogram("bookings_http_request_duration_seconds",
+			"How long HTTP requests took to handle, by route pattern", DefaultBuckets, "method", "route"),
+
+		MailSent: reg.Counter("bookings_mail_sent_total", "Emails sent"),
+		MailFailures: reg.Counter("bookings_mail_failures_total",
+			"Emails that couldn't be sent"),
+
+		ReservationsCreated: reg.Counter("bookings_reservations_created_total",
+			"Reservations created, by where they were booked", "source"),
+		EmptySearches: reg.Counter("bookings_searches_empty_total",
+			"Availability searches no room was free for"),
+	}
+}
+
+// WatchDB adds the stats of the database pool, read from stats, to the metrics
+func (m *Metrics) WatchDB(stats func() sql.DBStats) {
+	m.Registry.GaugeFunc("bookings_db_max_open_connections", "Most connections the database pool opens",
+		func() float64 { return float64(stats().MaxOpenConnections) })
+	m.Registry.GaugeFunc("bookings_db_open_connections", "Connections the database pool has open",
+		func() float64 { return float64(stats().OpenConnections) })
+	m.Registry.GaugeFunc("bookings_db_in_use_connections", "Connections of the database pool in use",
+		func() float64 { return float64(stats().InUse) })
+	m.Registry.GaugeFunc("bookings_db_idle_connections", "Idle connections of the database pool",
+		func() float64 { return float64(stats().Idle) })
+	m.Registry.CounterFunc("bookings_db_wait_count_total", "Times a query waited for a connection",
+		func() float64 { return float64(stats().WaitCount) })
+	m.Registry.CounterFunc("bookings_db_wait_duration_seconds_total", "How long queries waited for a connection",
+		func() float64 { return stats().WaitDuration.Seconds() })
+}
+
+// WatchMailQueue adds how many emails are waiting to be sent, read from length, to the metrics
+func (m *Metrics) WatchMailQueue(length func() int) {
+	m.Registry.GaugeFunc("bookings_mail_queue_length", "Emails waiting to be sent",
+		func() float64 { return float64(length()) })
+}
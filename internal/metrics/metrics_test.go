@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("test_requests_total", "Requests", "route", "status")
+
+	c.Inc("/rooms/{id}", "200")
+	c.Inc("/rooms/{id}", "200")
+	c.Add(3, "/", "500")
+
+	if v := c.Value("/rooms/{id}", "200"); v != 2 {
+		t.Errorf("expected a count of 2, got %v", v)
+	}
+	if v := c.Value("/", "404"); v != 0 {
+		t.Errorf("expected nothing counted, got %v", v)
+	}
+
+	var buf bytes.Buffer
+	reg.Write(&buf)
+	expected := `# HELP test_requests_total Requests
+# TYPE test_requests_total counter
+test_requests_total{route="/",status="500"} 3
+test_requests_total{route="/rooms/{id}",status="200"} 2
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output\n%s", buf.String())
+	}
+}
+
+func TestCounterLabels(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("test_total", "Test", "name")
+
+	c.Inc(`say "hi"` + "\n" + `\o/`)
+	var buf bytes.Buffer
+	reg.Write(&buf)
+	if !strings.Contains(buf.String(), `test_total{name="say \"hi\"\n\\o/"} 1`) {
+		t.Errorf("expected the label value to be escaped, got\n%s", buf.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a missing label value")
+		}
+	}()
+	c.Inc()
+}
+
+func TestHistogram(t *testing.T) {
+	reg := NewRegistry()
+	h := reg.Histogram("test_duration_seconds", "Durations", []float64{0.1, 1}, "route")
+
+	h.Observe(0.05, "/")
+	h.Observe(0.5, "/")
+	h.Observe(5, "/")
+
+	if n := h.Count("/"); n != 3 {
+		t.Errorf("expected 3 observations, got %d", n)
+	}
+
+	var buf bytes.Buffer
+	reg.Write(&buf)
+	expected := `# HELP test_duration_seconds Durations
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/",le="0.1"} 1
+test_duration_seconds_bucket{route="/",le="1"} 2
+test_duration_seconds_bucket{route="/",le="+Inf"} 3
+test_duration_seconds_sum{route="/"} 5.55
+test_duration_seconds_count{route="/"} 3
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output\n%s", buf.String())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	m := New()
+	m.EmptySearches.Inc()
+	m.WatchMailQueue(func() int { return 4 })
+	m.WatchDB(func() sql.DBStats { return sql.DBStats{MaxOpenConnections: 10, InUse: 2} })
+
+	rr := httptest.NewRecorder()
+	m.Registry.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected response %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	for _, line := range []string{
+		"bookings_searches_empty_total 1",
+		"bookings_mail_queue_length 4",
+		"bookings_db_max_open_connections 10",
+		"bookings_db_in_use_connections 2",
+		"# TYPE bookings_db_wait_count_total counter",
+	} {
+		if !strings.Contains(rr.Body.String(), line+"\n") {
+			t.Errorf("expected the metrics to have %q, got\n%s", line, rr.Body.String())
+		}
+	}
+}
@@ -4,26 +4,93 @@ import (
 	"time"
 )
 
-// User describes the user table
+// Access levels of users, guests have an account to manage their own reservations while staff
+// and admins get into the admin tool
+const (
+	AccessLevelGuest = 0
+	AccessLevelStaff = 1
+	AccessLevelAdmin = 3
+)
+
+// User describes the user table. Guest accounts keep a phone number to fill in reservations
+// with and whether they agreed to marketing emails, and when.
 type User struct {
-	ID          int
-	FirstName   string
-	LastName    string
-	Email       string
-	Password    string
-	AccessLevel int
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID                 int
+	FirstName          string
+	LastName           string
+	Email              string
+	Password           string
+	AccessLevel        int
+	Phone              string
+	MarketingConsent   bool
+	MarketingConsentAt time.Time
+	EmailVerifiedAt    time.Time
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+}
+
+// Property is the property model, a tavern that owns rooms
+type Property struct {
+	ID                int
+	Name              string
+	Slug              string
+	Hostname          string
+	Email             string
+	SenderEmail       string
+	NotificationEmail string
+	Timezone          string
+	CheckInTime       string
+	CheckOutTime      string
+	Tagline           string
+	Description       string
+	Address           string
+	PaymentPolicy     string
+	DepositPercent    int
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
 }
 
 // Room is the room model
 type Room struct {
+	ID                   int
+	RoomName             string
+	PropertyID           int
+	Price                int
+	MaxOccupancy         int
+	IncludedGuests       int
+	ExtraGuestPrice      int
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+	Units                []RoomUnit
+	CancellationPolicyID int
+	CancellationPolicy   CancellationPolicy
+}
+
+// RoomUnit is a single bookable unit of a room type
+type RoomUnit struct {
 	ID        int
-	RoomName  string
+	RoomID    int
+	Name      string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	Room      Room
+}
+
+// RoomNight is the number of free units of a room on one night
+type RoomNight struct {
+	Room  Room
+	Night time.Time
+	Free  int
 }
 
+// Ids of the seeded restrictions. Holds keep a unit for a guest while they fill in the
+// reservation form and run out at the ExpiresAt of their room restriction.
+const (
+	RestrictionReservation = 1
+	RestrictionBlock       = 2
+	RestrictionHold        = 3
+)
+
 // Restriction is the restriction model
 type Restriction struct {
 	ID              int
@@ -32,20 +99,59 @@ type Restriction struct {
 	UpdatedAt       time.Time
 }
 
-// Reservation is the reservation model
+// Guest is a person who has stayed at or booked a property, told apart by email. Stays and
+// Spend sum up their reservations and LastStay is the arrival of the latest.
+type Guest struct {
+	ID         int
+	PropertyID int
+	FirstName  string
+	LastName   string
+	Email      string
+	Phone      string
+	Notes      string
+	Tags       []string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Stays      int
+	Spend      int
+	LastStay   time.Time
+}
+
+// Reservation is the reservation model. A reservation waiting to be paid for is pending until
+// PendingUntil and keeps its unit till then, it is confirmed once PendingUntil is zero.
 type Reservation struct {
-	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomID    int
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Processed int
-	Room      Room
+	ID                 int
+	FirstName          string
+	LastName           string
+	Email              string
+	Phone              string
+	StartDate          time.Time
+	EndDate            time.Time
+	RoomID             int
+	RoomUnitID         int
+	Adults             int
+	Children           int
+	Total              int
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	Processed          int
+	Room               Room
+	RoomUnit           RoomUnit
+	ManageToken        string
+	CancellationPolicy CancellationPolicy
+	CancelledAt        time.Time
+	CancelledBy        string
+	CancellationFee    int
+	Refund             int
+	PromoCodeID        int
+	PromoCode          string
+	Discount           int
+	Extras             []ReservationExtra
+	GuestID            int
+	GroupBookingID     int
+	Channel            string
+	ChannelReference   string
+	PendingUntil       time.Time
 }
 
 // RoomRestriction is the room restriction model
@@ -55,19 +161,274 @@ type RoomRestriction struct {
 	EndDate       time.Time
 	ReservationID int
 	RoomID        int
+	RoomUnitID    int
 	RestrictionID int
+	ExpiresAt     time.Time
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	Room          Room
+	RoomUnit      RoomUnit
 	Reservation   Reservation
 	Restriction   Restriction
 }
 
+// StayRule limits the stays of a room arriving from StartDate up to EndDate, zero values
+// mean no limit
+type StayRule struct {
+	ID              int
+	RoomID          int
+	StartDate       time.Time
+	EndDate         time.Time
+	MinNights       int
+	MaxNights       int
+	ArrivalDays     int
+	DepartureDays   int
+	ClosedToArrival bool
+	MinAdvanceDays  int
+	MaxAdvanceDays  int
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Room            Room
+}
+
+// Payment is a payment made through a gateway towards a reservation, Amount is in cents
+type Payment struct {
+	ID            int
+	ReservationID int
+	Kind          string
+	Amount        int
+	Status        string
+	Gateway       string
+	Reference     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// CancellationPolicy is the cancellation policy model, guests cancel free of charge until FreeDays
+// before arrival and pay FeePercent of the total after that, or pay it all when NonRefundable
+type CancellationPolicy struct {
+	ID            int
+	PropertyID    int
+	Name          string
+	FreeDays      int
+	FeePercent    int
+	NonRefundable bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// TaxFee is a tax or fee charged on stays at a property from StartDate up to EndDate, zero
+// dates leave the range open. Amount is in cents, or in hundredths of a percent for
+// percentage taxes, and Inclusive ones are already part of the room prices.
+type TaxFee struct {
+	ID         int
+	PropertyID int
+	Name       string
+	Kind       string
+	Amount     int
+	Inclusive  bool
+	StartDate  time.Time
+	EndDate    time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Extra is something sold along with stays, like breakfast or parking. Price is in cents per
+// stay, night, guest or guest per night as Pricing says, and at most Stock of it can be booked
+// on any night, a zero Stock is unlimited.
+type Extra struct {
+	ID          int
+	PropertyID  int
+	Name        string
+	Description string
+	Pricing     string
+	Price       int
+	Stock       int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// ReservationExtra is an extra booked on a reservation, priced as it was when it was booked.
+// Quantity is how many were booked, or how many guests take it for per guest extras.
+type ReservationExtra struct {
+	ID            int
+	ReservationID int
+	ExtraID       int
+	Name          string
+	Pricing       string
+	Quantity      int
+	Nights        int
+	UnitPrice     int
+	Amount        int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// PromoCode is a discount guests get by entering Code when booking from ValidFrom up to
+// ValidUntil, for stays within StayFrom and StayUntil. Amount is in cents, or in hundredths of
+// a percent for percentage discounts. Zero dates and limits leave that unrestricted and a zero
+// RoomID allows any room. Uses counts the reservations made with the code and Discounted is
+// what they were given off in total.
+type PromoCode struct {
+	ID              int
+	PropertyID      int
+	Code            string
+	Kind            string
+	Amount          int
+	ValidFrom       time.Time
+	ValidUntil      time.Time
+	StayFrom        time.Time
+	StayUntil       time.Time
+	RoomID          int
+	MaxUses         int
+	MaxUsesPerEmail int
+	Uses            int
+	Discounted      int
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Room            Room
+}
+
+// Invoice is an invoice issued for a reservation, Number counts up per property and PDF is the
+// document as it was issued. A credit note is stored as an invoice with a negative Total and
+// CreditsInvoiceID set to the invoice it cancels
+type Invoice struct {
+	ID               int
+	PropertyID       int
+	ReservationID    int
+	Number           int
+	Total            int
+	CreditsInvoiceID int
+	PDF              []byte
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// WaitlistEntry is a guest waiting for the nights of a room to free up. When they do the guest
+// is sent a link, whose token is stored hashed, to book with until OfferExpiresAt, and a unit is
+// held for them by the hold HoldID meanwhile.
+type WaitlistEntry struct {
+	ID             int
+	PropertyID     int
+	RoomID         int
+	FirstName      string
+	LastName       string
+	Email          string
+	Phone          string
+	Adults         int
+	Children       int
+	StartDate      time.Time
+	EndDate        time.Time
+	Status         string
+	TokenHash      string
+	OfferedAt      time.Time
+	OfferExpiresAt time.Time
+	HoldID         int
+	ReservationID  int
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Room           Room
+}
+
+// GroupBooking is several room stays, possibly of different rooms and dates, booked together
+// by one guest under one reference. Each stay is a reservation of its own so it can be changed
+// or cancelled on its own. Rooms, Total, StartDate and EndDate sum up the stays.
+type GroupBooking struct {
+	ID           int
+	PropertyID   int
+	Reference    string
+	GuestID      int
+	FirstName    string
+	LastName     string
+	Email        string
+	Phone        string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Rooms        int
+	Total        int
+	StartDate    time.Time
+	EndDate      time.Time
+	Reservations []Reservation
+}
+
+// WebhookSubscription is an endpoint of another system that is sent the events of a property it
+// subscribed to, signed with its secret
+type WebhookSubscription struct {
+	ID         int
+	PropertyID int
+	URL        string
+	Secret     string
+	Events     []string
+	Active     bool
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// WebhookDelivery is one event sent, or still to be sent, to a webhook subscription. Failed
+// deliveries are attempted again at NextAttemptAt until they succeed or are given up on.
+type WebhookDelivery struct {
+	ID             int
+	SubscriptionID int
+	Event          string
+	Payload        string
+	Attempts       int
+	StatusCode     int
+	Error          string
+	LastAttemptAt  time.Time
+	NextAttemptAt  time.Time
+	DeliveredAt    time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Subscription   WebhookSubscription
+}
+
+// WebhookEvent is something that happened at a property, handed to the webhook listener to be
+// delivered to the subscriptions of the property
+type WebhookEvent struct {
+	PropertyID int
+	Event      string
+	Data       interface{}
+}
+
+// ChannelMapping is the id a channel knows one of the rooms of a property by
+type ChannelMapping struct {
+	ID             int
+	PropertyID     int
+	Channel        string
+	RoomID         int
+	ExternalRoomID string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// ChannelSyncLog is an entry in the log of syncs with a channel. Entries for reservations
+// pulled from the channel carry its reference of the booking and, once imported, the
+// reservation it was imported as.
+type ChannelSyncLog struct {
+	ID                int
+	PropertyID        int
+	Channel           string
+	Direction         string
+	Status            string
+	Message           string
+	ExternalReference string
+	ReservationID     int
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
 // MailData holds an email message
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To          string
+	From        string
+	Subject     string
+	Content     string
+	Template    string
+	Attachments []Attachment
+}
+
+// Attachment is a file sent along with an email
+type Attachment struct {
+	Name string
+	Data []byte
 }
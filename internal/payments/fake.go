@@ -0,0 +1,149 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DeclinedToken is the token the fake gateway declines, every other token is approved
+const DeclinedToken = "tok_declined"
+
+// UncapturableToken is approved by the fake gateway but its payments can't be captured
+const UncapturableToken = "tok_uncapturable"
+
+// Fake is an in-memory gateway for development and tests, its webhooks are json events
+// signed with a hex encoded HMAC-SHA256 of the body
+type Fake struct {
+	secret []byte
+
+	mu       sync.Mutex
+	next     int
+	payments map[string]*fakePayment
+}
+
+type fakePayment struct {
+	authorized   int
+	captured     int
+	refunded     int
+	uncapturable bool
+	voided       bool
+}
+
+// NewFake creates a fake gateway that signs webhooks with secret
+func NewFake(secret string) *Fake {
+	return &Fake{
+		secret:   []byte(secret),
+		payments: make(map[string]*fakePayment),
+	}
+}
+
+// Name returns the name payments through this gateway are recorded under
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+// Authorize reserves the amount of a charge
+func (f *Fake) Authorize(c Charge) (Result, error) {
+	if c.Amount <= 0 {
+		return Result{}, errors.New("amount must be positive")
+	}
+	if c.Token == "" || c.Token == DeclinedToken {
+		return Result{Status: StatusFailed}, ErrDeclined
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.next++
+	reference := fmt.Sprintf("fake_%d", f.next)
+	f.payments[reference] = &fakePayment{authorized: c.Amount, uncapturable: c.Token == UncapturableToken}
+
+	return Result{Reference: reference, Status: StatusAuthorized, Amount: c.Amount}, nil
+}
+
+// Capture takes up to the authorized amount of a payment
+func (f *Fake) Capture(reference string, amount int) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[reference]
+	if !ok {
+		return Result{}, fmt.Errorf("unknown payment %s", reference)
+	}
+	if amount <= 0 || p.captured+amount > p.authorized || p.uncapturable || p.voided {
+		return Result{}, fmt.Errorf("can't capture %d of payment %s", amount, reference)
+	}
+
+	p.captured += amount
+	return Result{Reference: reference, Status: StatusCaptured, Amount: amount}, nil
+}
+
+// Refund gives back up to the captured amount of a payment
+func (f *Fake) Refund(reference string, amount int) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[reference]
+	if !ok {
+		return Result{}, fmt.Errorf("unknown payment %s", reference)
+	}
+	if amount <= 0 || p.refunded+amount > p.captured {
+		return Result{}, fmt.Errorf("can't refund %d of payment %s", amount, reference)
+	}
+
+	p.refunded += amount
+	return Result{Reference: reference, Status: StatusRefunded, Amount: amount}, nil
+}
+
+// Void releases the authorization of a payment nothing has been captured of
+func (f *Fake) Void(reference string) (Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.payments[reference]
+	if !ok {
+		return Result{}, fmt.Errorf("unknown payment %s", reference)
+	}
+	if p.captured > 0 {
+		return Result{}, fmt.Errorf("can't void payment %s, it has been captured", reference)
+	}
+
+	p.voided = true
+	return Result{Reference: reference, Status: StatusFailed}, nil
+}
+
+// Sign returns the signature the fake gateway sends with a webhook body
+func (f *Fake) Sign(payload []byte) string {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the signature of a webhook and returns the event it reports
+func (f *Fake) VerifyWebhook(payload []byte, signature string) (Event, error) {
+	var e Event
+
+	if len(f.secret) == 0 || !hmac.Equal([]byte(f.Sign(payload)), []byte(signature)) {
+		return e, ErrInvalidSignature
+	}
+
+	var body struct {
+		Reference string `json:"reference"`
+		Status    string `json:"status"`
+	}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return e, err
+	}
+	if body.Reference == "" || body.Status == "" {
+		return e, errors.New("webhook is missing the payment reference or status")
+	}
+
+	e.Reference = body.Reference
+	e.Status = body.Status
+	return e, nil
+}
@@ -0,0 +1,78 @@
+package payments
+
+import (
+	"errors"
+)
+
+// Statuses of a payment, a payment is pending while the gateway is being asked to take it
+const (
+	StatusPending    = "pending"
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusRefunded   = "refunded"
+	StatusFailed     = "failed"
+)
+
+// Payment policies of a property
+const (
+	PolicyFull    = "full"
+	PolicyDeposit = "deposit"
+)
+
+// KindRefund is what money given back to a guest is recorded as, other payments are recorded
+// as a deposit or in full
+const KindRefund = "refund"
+
+// ErrDeclined is returned when the gateway refuses to authorize a payment
+var ErrDeclined = errors.New("payment declined")
+
+// ErrInvalidSignature is returned for webhooks that weren't signed by the gateway
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Charge is a payment asked of a guest, amounts are in cents
+type Charge struct {
+	Amount      int
+	Token       string
+	Description string
+}
+
+// Result is the outcome of a call to the gateway, amounts are in cents
+type Result struct {
+	Reference string
+	Status    string
+	Amount    int
+}
+
+// Event is a change to a payment the gateway reports by webhook
+type Event struct {
+	Reference string
+	Status    string
+}
+
+// Gateway is a payment provider, Token is whatever the checkout page got from the provider for
+// the guest's payment method and Reference is the provider's id of the payment
+type Gateway interface {
+	Name() string
+	Authorize(c Charge) (Result, error)
+	Capture(reference string, amount int) (Result, error)
+	Refund(reference string, amount int) (Result, error)
+	Void(reference string) (Result, error)
+	VerifyWebhook(payload []byte, signature string) (Event, error)
+}
+
+// AmountDue returns what is paid at checkout for a stay costing total: the whole total, or
+// depositPercent of it rounded up to the cent under the deposit policy
+func AmountDue(policy string, depositPercent, total int) int {
+	if policy != PolicyDeposit || depositPercent <= 0 || depositPercent >= 100 {
+		return total
+	}
+	return (total*depositPercent + 99) / 100
+}
+
+// Kind returns what a payment of amount towards total is recorded as
+func Kind(amount, total int) string {
+	if amount < total {
+		return PolicyDeposit
+	}
+	return PolicyFull
+}
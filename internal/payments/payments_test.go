@@ -0,0 +1,106 @@
+package payments
+
+import (
+	"errors"
+	"testing"
+)
+
+var amountDueTests = []struct {
+	name     string
+	policy   string
+	percent  int
+	total    int
+	expected int
+}{
+	{"full", PolicyFull, 30, 10000, 10000},
+	{"deposit", PolicyDeposit, 30, 10000, 3000},
+	{"deposit-rounds-up", PolicyDeposit, 30, 10001, 3001},
+	{"deposit-without-percent", PolicyDeposit, 0, 10000, 10000},
+	{"deposit-of-everything", PolicyDeposit, 100, 10000, 10000},
+	{"unknown-policy", "", 30, 10000, 10000},
+}
+
+func TestAmountDue(t *testing.T) {
+	for _, e := range amountDueTests {
+		if got := AmountDue(e.policy, e.percent, e.total); got != e.expected {
+			t.Errorf("failed %s: expected %d, got %d", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestKind(t *testing.T) {
+	if Kind(3000, 10000) != PolicyDeposit {
+		t.Error("expected a part payment to be a deposit")
+	}
+	if Kind(10000, 10000) != PolicyFull {
+		t.Error("expected a payment of the total to be a full payment")
+	}
+}
+
+func TestFake(t *testing.T) {
+	var g Gateway = NewFake("secret")
+
+	_, err := g.Authorize(Charge{Amount: 5000, Token: DeclinedToken})
+	if !errors.Is(err, ErrDeclined) {
+		t.Errorf("expected the declined token to be declined, got %v", err)
+	}
+
+	res, err := g.Authorize(Charge{Amount: 5000, Token: "tok_visa"})
+	if err != nil || res.Status != StatusAuthorized || res.Reference == "" {
+		t.Fatalf("expected an authorized payment, got %+v (%v)", res, err)
+	}
+
+	if _, err := g.Capture(res.Reference, 6000); err == nil {
+		t.Error("captured more than was authorized")
+	}
+	if res, err := g.Capture(res.Reference, 5000); err != nil || res.Status != StatusCaptured {
+		t.Errorf("expected the payment to be captured, got %+v (%v)", res, err)
+	}
+	if _, err := g.Capture("fake_unknown", 100); err == nil {
+		t.Error("captured an unknown payment")
+	}
+
+	if _, err := g.Refund(res.Reference, 2000); err != nil {
+		t.Errorf("expected a part refund, got %v", err)
+	}
+	if _, err := g.Refund(res.Reference, 3001); err == nil {
+		t.Error("refunded more than was captured")
+	}
+	if _, err := g.Void(res.Reference); err == nil {
+		t.Error("voided a captured payment")
+	}
+
+	res, err = g.Authorize(Charge{Amount: 5000, Token: UncapturableToken})
+	if err != nil {
+		t.Fatalf("expected the uncapturable token to be authorized, got %v", err)
+	}
+	if _, err := g.Capture(res.Reference, 5000); err == nil {
+		t.Error("captured an uncapturable payment")
+	}
+	if _, err := g.Void(res.Reference); err != nil {
+		t.Errorf("expected the authorization to be voided, got %v", err)
+	}
+}
+
+func TestFake_VerifyWebhook(t *testing.T) {
+	f := NewFake("secret")
+	payload := []byte(`{"reference": "fake_1", "status": "refunded"}`)
+
+	e, err := f.VerifyWebhook(payload, f.Sign(payload))
+	if err != nil || e.Reference != "fake_1" || e.Status != StatusRefunded {
+		t.Errorf("expected a refunded event for fake_1, got %+v (%v)", e, err)
+	}
+
+	if _, err := f.VerifyWebhook(payload, "bad"); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected an invalid signature, got %v", err)
+	}
+
+	if _, err := NewFake("").VerifyWebhook(payload, NewFake("").Sign(payload)); err == nil {
+		t.Error("accepted a webhook without a secret")
+	}
+
+	bad := []byte(`{"status": "refunded"}`)
+	if _, err := f.VerifyWebhook(bad, f.Sign(bad)); err == nil {
+		t.Error("accepted a webhook without a reference")
+	}
+}
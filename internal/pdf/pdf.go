@@ -0,0 +1,165 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	A4Width  = 595.28
+	A4Height = 841.89
+)
+
+// Fonts pages can be written in, both use the standard PDF fonts so nothing is embedded
+const (
+	Regular = "F1"
+	Bold    = "F2"
+)
+
+// Document is a text only PDF document made of A4 pages
+type Document struct {
+	Title string
+	pages []*Page
+}
+
+// Page is a page of a document, positions are in points from the top left corner
+type Page struct {
+	content bytes.Buffer
+}
+
+// New creates an empty document
+func New(title string) *Document {
+	return &Document{Title: title}
+}
+
+// AddPage appends a blank page to the document
+func (d *Document) AddPage() *Page {
+	p := &Page{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Pages returns the number of pages in the document
+func (d *Document) Pages() int {
+	return len(d.pages)
+}
+
+// Text writes s with its baseline at y
+func (p *Page) Text(x, y float64, font string, size float64, s string) {
+	fmt.Fprintf(&p.content, "BT /%s %s Tf %s %s Td (%s) Tj ET\n",
+		font, num(size), num(x), num(A4Height-y), escape(s))
+}
+
+// TextRight writes s so that it ends at x
+func (p *Page) TextRight(x, y float64, font string, size float64, s string) {
+	p.Text(x-Width(s, size), y, font, size, s)
+}
+
+// Line draws a thin line from x1, y1 to x2, y2
+func (p *Page) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "0.5 w %s %s m %s %s l S\n",
+		num(x1), num(A4Height-y1), num(x2), num(A4Height-y2))
+}
+
+// Bytes returns the document as a PDF file
+func (d *Document) Bytes() []byte {
+	var b bytes.Buffer
+	var offsets []int
+
+	// objects are numbered from 1 in the order they are written
+	obj := func(body string) {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	pages := d.pages
+	if len(pages) == 0 {
+		pages = []*Page{{}}
+	}
+
+	// catalog, page tree, fonts and info come first, then each page and its content stream
+	const firstPage = 6
+	var kids []string
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPage+2*i))
+	}
+
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	obj(fmt.Sprintf("<< /Title (%s) /Producer (bookings) >>", escape(d.Title)))
+
+	for i, p := range pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			num(A4Width), num(A4Height), firstPage+2*i+1))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()))
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(offsets)+1, xref)
+
+	return b.Bytes()
+}
+
+// num formats a number the way PDF operators take it
+func num(f float64) string {
+	s := fmt.Sprintf("%.2f", f)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// escape makes s safe to use as a PDF string in the WinAnsi encoding, characters it doesn't
+// have are written as a question mark
+func escape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n' || r == '\r' || r == '\t':
+			b.WriteByte(' ')
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		case r >= 160 && r < 256:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// helveticaWidths are the widths of the printable ASCII characters in Helvetica, in thousandths of the font size
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// Width returns how wide s is written in Helvetica at size, which is close enough for bold
+// figures too
+func Width(s string, size float64) float64 {
+	w := 0
+	for _, r := range s {
+		if r >= 32 && r < 127 {
+			w += helveticaWidths[r-32]
+		} else {
+			w += 556
+		}
+	}
+	return float64(w) * size / 1000
+}
@@ -0,0 +1,81 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestDocument_Bytes(t *testing.T) {
+	d := New("Invoice 1")
+	p := d.AddPage()
+	p.Text(50, 50, Bold, 18, "Invoice (copy)")
+	p.TextRight(545, 80, Regular, 10, "100.00")
+	p.Line(50, 90, 545, 90)
+	d.AddPage().Text(50, 50, Regular, 10, "Page 2")
+
+	out := d.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatal("missing the PDF header or trailer")
+	}
+	if !bytes.Contains(out, []byte("/Count 2")) {
+		t.Error("expected two pages in the page tree")
+	}
+	if !bytes.Contains(out, []byte(`(Invoice \(copy\)) Tj`)) {
+		t.Error("expected parentheses in text to be escaped")
+	}
+
+	// every offset in the cross reference table points at its object
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(out[xref:], []byte("xref\n")) {
+		t.Fatal("startxref doesn't point at the cross reference table")
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out[xref:], -1)
+	if len(entries) != 9 {
+		t.Fatalf("expected 9 objects, got %d", len(entries))
+	}
+	for i, e := range entries {
+		offset, _ := strconv.Atoi(string(e[1]))
+		if !bytes.HasPrefix(out[offset:], []byte(fmt.Sprintf("%d 0 obj", i+1))) {
+			t.Errorf("object %d is not at offset %d", i+1, offset)
+		}
+	}
+}
+
+func TestDocument_BytesEmpty(t *testing.T) {
+	if !bytes.Contains(New("").Bytes(), []byte("/Count 1")) {
+		t.Error("expected an empty document to have a blank page")
+	}
+}
+
+var escapeTests = []struct {
+	in       string
+	expected string
+}{
+	{"plain", "plain"},
+	{`a\b`, `a\\b`},
+	{"two\nlines", "two lines"},
+	{"café", `caf\351`},
+	{"日本", "??"},
+}
+
+func TestEscape(t *testing.T) {
+	for _, e := range escapeTests {
+		if got := escape(e.in); got != e.expected {
+			t.Errorf("escape(%q): expected %q, got %q", e.in, e.expected, got)
+		}
+	}
+}
+
+func TestWidth(t *testing.T) {
+	if w := Width("100.00", 10); w != 30.58 {
+		t.Errorf("expected 30.58, got %v", w)
+	}
+}
@@ -0,0 +1,137 @@
+package pricing
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/dates"
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+// Line is a single priced line of a stay, amounts are in cents
+type Line struct {
+	Description string
+	Quantity    int
+	UnitPrice   int
+	Amount      int
+}
+
+// Quote is the price breakdown of a stay, amounts are in cents. Included lists the taxes that
+// are already part of the price and so don't add up to the total.
+type Quote struct {
+	Lines    []Line
+	Included []Line
+	Total    int
+}
+
+// Stay describes what is being priced
+type Stay struct {
+	Room      models.Room
+	StartDate time.Time
+	EndDate   time.Time
+	Adults    int
+	Children  int
+	Taxes     []models.TaxFee
+	Extras    []Line
+}
+
+// Guests returns the number of people staying
+func (s Stay) Guests() int {
+	return s.Adults + s.Children
+}
+
+// ExtraGuests returns the number of guests above what the room price includes
+func (s Stay) ExtraGuests() int {
+	extra := s.Guests() - s.Room.IncludedGuests
+	if extra < 0 {
+		return 0
+	}
+	return extra
+}
+
+// ForStay prices a stay: the room's nightly price plus a charge per extra guest per night, the
+// extras booked with it, and the taxes and fees of the stay
+func ForStay(s Stay) Quote {
+	var q Quote
+	nights := dates.Nights(s.StartDate, s.EndDate)
+	if nights <= 0 {
+		return q
+	}
+
+	q.add(Line{
+		Description: fmt.Sprintf("%s, %d night(s)", s.Room.RoomName, nights),
+		Quantity:    nights,
+		UnitPrice:   s.Room.Price,
+	})
+
+	if extra := s.ExtraGuests(); extra > 0 && s.Room.ExtraGuestPrice > 0 {
+		q.add(Line{
+			Description: fmt.Sprintf("Extra guest charge, %d guest(s) x %d night(s)", extra, nights),
+			Quantity:    extra * nights,
+			UnitPrice:   s.Room.ExtraGuestPrice,
+		})
+	}
+
+	for _, l := range s.Extras {
+		q.add(l)
+	}
+
+	exclusive, inclusive := Taxes(s, s.Room.Price+s.ExtraGuests()*s.Room.ExtraGuestPrice)
+	for _, l := range exclusive {
+		q.add(l)
+	}
+	q.Included = inclusive
+
+	return q
+}
+
+// add appends a line, working out its amount, and updates the total
+func (q *Quote) add(l Line) {
+	l.Amount = l.Quantity * l.UnitPrice
+	q.Lines = append(q.Lines, l)
+	q.Total += l.Amount
+}
+
+// ApplyDiscount takes amount off the total as a line of its own
+func (q *Quote) ApplyDiscount(description string, amount int) {
+	if amount <= 0 {
+		return
+	}
+	q.add(Line{Description: description, Quantity: 1, UnitPrice: -amount})
+}
+
+// FormatMoney formats an amount in cents for display
+func FormatMoney(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, cents/100, cents%100)
+}
+
+// ParseMoney parses an amount with up to two decimals, like 12.5, into hundredths, so 1250.
+// It reads percentages the same way.
+func ParseMoney(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" || len(frac) > 2 || strings.HasPrefix(whole, "-") || strings.HasPrefix(whole, "+") {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	n, err := strconv.Atoi(whole)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+
+	cents := 0
+	if frac != "" {
+		cents, err = strconv.Atoi((frac + "0")[:2])
+		if err != nil || strings.ContainsAny(frac, "+-") {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+	}
+	return n*100 + cents, nil
+}
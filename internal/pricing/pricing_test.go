@@ -0,0 +1,147 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/dates"
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+var room = models.Room{
+	RoomName:        "New World",
+	Price:           12000,
+	MaxOccupancy:    4,
+	IncludedGuests:  2,
+	ExtraGuestPrice: 1500,
+}
+
+var forStayTests = []struct {
+	name          string
+	start         string
+	end           string
+	adults        int
+	children      int
+	expectedLines int
+	expectedTotal int
+}{
+	{"included-guests", "2050-01-01", "2050-01-04", 2, 0, 1, 36000},
+	{"extra-guests", "2050-01-01", "2050-01-04", 2, 2, 2, 36000 + 2*3*1500},
+	{"single-guest", "2050-01-01", "2050-01-02", 1, 0, 1, 12000},
+	{"no-nights", "2050-01-01", "2050-01-01", 2, 0, 0, 0},
+	{"reversed", "2050-01-04", "2050-01-01", 2, 0, 0, 0},
+}
+
+func TestForStay(t *testing.T) {
+	for _, e := range forStayTests {
+		start, _ := dates.Parse(e.start)
+		end, _ := dates.Parse(e.end)
+
+		q := ForStay(Stay{Room: room, StartDate: start, EndDate: end, Adults: e.adults, Children: e.children})
+		if len(q.Lines) != e.expectedLines {
+			t.Errorf("failed %s: expected %d lines, got %d", e.name, e.expectedLines, len(q.Lines))
+		}
+		if q.Total != e.expectedTotal {
+			t.Errorf("failed %s: expected total %d, got %d", e.name, e.expectedTotal, q.Total)
+		}
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	for cents, expected := range map[int]string{0: "0.00", 5: "0.05", 12345: "123.45", -250: "-2.50"} {
+		if FormatMoney(cents) != expected {
+			t.Errorf("expected %s for %d, got %s", expected, cents, FormatMoney(cents))
+		}
+	}
+}
+
+func TestApplyDiscount(t *testing.T) {
+	q := ForStay(Stay{Room: room, StartDate: parseDate("2050-01-01"), EndDate: parseDate("2050-01-03"), Adults: 2})
+	total := q.Total
+
+	q.ApplyDiscount("Promo code SUMMER", 1500)
+	q.ApplyDiscount("Nothing", 0)
+
+	last := q.Lines[len(q.Lines)-1]
+	if last.Amount != -1500 || q.Total != total-1500 {
+		t.Errorf("expected 1500 off %d, got %+v and total %d", total, last, q.Total)
+	}
+}
+
+func TestParseMoney(t *testing.T) {
+	for s, expected := range map[string]int{"0": 0, "12": 1200, "12.5": 1250, " 7.70 ": 770, "0.05": 5} {
+		got, err := ParseMoney(s)
+		if err != nil || got != expected {
+			t.Errorf("expected %d for %q, got %d and %v", expected, s, got, err)
+		}
+	}
+	for _, s := range []string{"", ".5", "-1", "1.234", "1.-5", "abc"} {
+		if _, err := ParseMoney(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+var (
+	vat          = models.TaxFee{Name: "VAT", Kind: TaxPercent, Amount: 770}
+	includedVAT  = models.TaxFee{Name: "VAT", Kind: TaxPercent, Amount: 1000, Inclusive: true}
+	cityTax      = models.TaxFee{Name: "City tax", Kind: TaxPerGuestNight, Amount: 250}
+	resortFee    = models.TaxFee{Name: "Resort fee", Kind: TaxPerNight, Amount: 1000}
+	cleaningFee  = models.TaxFee{Name: "Cleaning", Kind: TaxPerStay, Amount: 5000}
+	summerTax    = models.TaxFee{Name: "Summer tax", Kind: TaxPerNight, Amount: 100, StartDate: parseDate("2050-01-02"), EndDate: parseDate("2050-01-03")}
+	summerFee    = models.TaxFee{Name: "Summer cleaning", Kind: TaxPerStay, Amount: 5000, StartDate: parseDate("2050-01-02")}
+	includedFees = models.TaxFee{Name: "Linen", Kind: TaxPerStay, Amount: 800, Inclusive: true}
+)
+
+func parseDate(s string) time.Time {
+	d, _ := dates.Parse(s)
+	return d
+}
+
+var taxTests = []struct {
+	name             string
+	tax              models.TaxFee
+	expectedTotal    int
+	expectedIncluded int
+}{
+	// three nights of 12000 for 3 guests, one above the included guests at 1500
+	{"percent", vat, 40500 + 3119, 0},
+	{"percent-inclusive", includedVAT, 40500, 3682},
+	{"per-guest-night", cityTax, 40500 + 3*3*250, 0},
+	{"per-night", resortFee, 40500 + 3*1000, 0},
+	{"per-stay", cleaningFee, 40500 + 5000, 0},
+	{"dated-per-night", summerTax, 40500 + 100, 0},
+	{"per-stay-arriving-outside-range", summerFee, 40500, 0},
+	{"per-stay-inclusive", includedFees, 40500, 800},
+}
+
+func TestForStayTaxes(t *testing.T) {
+	for _, e := range taxTests {
+		q := ForStay(Stay{
+			Room:      room,
+			StartDate: parseDate("2050-01-01"),
+			EndDate:   parseDate("2050-01-04"),
+			Adults:    3,
+			Taxes:     []models.TaxFee{e.tax},
+		})
+
+		if q.Total != e.expectedTotal {
+			t.Errorf("failed %s: expected total %d, got %d", e.name, e.expectedTotal, q.Total)
+		}
+		included := 0
+		for _, l := range q.Included {
+			included += l.Amount
+		}
+		if included != e.expectedIncluded {
+			t.Errorf("failed %s: expected %d included, got %d", e.name, e.expectedIncluded, included)
+		}
+	}
+}
+
+func TestFormatPercent(t *testing.T) {
+	for hundredths, expected := range map[int]string{0: "0", 770: "7.7", 1900: "19", 1225: "12.25"} {
+		if FormatPercent(hundredths) != expected {
+			t.Errorf("expected %s for %d, got %s", expected, hundredths, FormatPercent(hundredths))
+		}
+	}
+}
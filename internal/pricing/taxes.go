@@ -0,0 +1,109 @@
+package pricing
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+// Kinds of taxes and fees
+const (
+	TaxPercent       = "percent"
+	TaxPerNight      = "per_night"
+	TaxPerGuestNight = "per_guest_night"
+	TaxPerStay       = "per_stay"
+)
+
+// TaxKinds lists the kinds of taxes and fees with a description for forms
+var TaxKinds = []struct {
+	Kind        string
+	Description string
+}{
+	{TaxPercent, "Percentage of the room price"},
+	{TaxPerNight, "Per night"},
+	{TaxPerGuestNight, "Per guest per night"},
+	{TaxPerStay, "Per stay"},
+}
+
+// ValidTaxKind reports whether kind is one of the kinds of taxes and fees
+func ValidTaxKind(kind string) bool {
+	for _, k := range TaxKinds {
+		if k.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// nightsCovered returns how many nights of a stay fall in the date range of a tax
+func nightsCovered(t models.TaxFee, start, end time.Time) int {
+	n := 0
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		if covers(t, d) {
+			n++
+		}
+	}
+	return n
+}
+
+// covers reports whether a tax applies on day d
+func covers(t models.TaxFee, d time.Time) bool {
+	if !t.StartDate.IsZero() && d.Before(t.StartDate) {
+		return false
+	}
+	if !t.EndDate.IsZero() && !d.Before(t.EndDate) {
+		return false
+	}
+	return true
+}
+
+// Taxes works out the taxes and fees of a stay whose nights cost nightly each. Exclusive ones
+// are charged on top of the price, inclusive ones are already part of it and only shown.
+// Percentages apply to the room charges, per stay fees to stays arriving in their date range.
+func Taxes(s Stay, nightly int) (exclusive, inclusive []Line) {
+	for _, t := range s.Taxes {
+		nights := nightsCovered(t, s.StartDate, s.EndDate)
+
+		var l Line
+		switch t.Kind {
+		case TaxPercent:
+			base := nightly * nights
+			amount := (base*t.Amount + 5000) / 10000
+			if t.Inclusive {
+				amount = (base*t.Amount + (10000+t.Amount)/2) / (10000 + t.Amount)
+			}
+			l = Line{Description: fmt.Sprintf("%s %s%%", t.Name, FormatPercent(t.Amount)), Quantity: 1, UnitPrice: amount}
+		case TaxPerNight:
+			l = Line{Description: fmt.Sprintf("%s, %d night(s)", t.Name, nights), Quantity: nights, UnitPrice: t.Amount}
+		case TaxPerGuestNight:
+			l = Line{
+				Description: fmt.Sprintf("%s, %d guest(s) x %d night(s)", t.Name, s.Guests(), nights),
+				Quantity:    s.Guests() * nights,
+				UnitPrice:   t.Amount,
+			}
+		case TaxPerStay:
+			if covers(t, s.StartDate) {
+				l = Line{Description: t.Name, Quantity: 1, UnitPrice: t.Amount}
+			}
+		}
+
+		l.Amount = l.Quantity * l.UnitPrice
+		if l.Amount == 0 {
+			continue
+		}
+		if t.Inclusive {
+			inclusive = append(inclusive, l)
+		} else {
+			exclusive = append(exclusive, l)
+		}
+	}
+	return exclusive, inclusive
+}
+
+// FormatPercent formats hundredths of a percent for display, 770 is 7.7
+func FormatPercent(hundredths int) string {
+	s := fmt.Sprintf("%d.%02d", hundredths/100, hundredths%100)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
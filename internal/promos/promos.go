@@ -0,0 +1,125 @@
+package promos
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/dates"
+	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/RakhmanovTimur/bookings/internal/pricing"
+)
+
+// Kinds of discount
+const (
+	KindPercent = "percent"
+	KindFixed   = "fixed"
+)
+
+// Error is a reason a promo code can't be used, the message is shown to guests
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Reasons a promo code can't be used
+var (
+	ErrUnknown     = &Error{"This promo code doesn't exist"}
+	ErrNotYetValid = &Error{"This promo code isn't valid yet"}
+	ErrExpired     = &Error{"This promo code has expired"}
+	ErrStayDates   = &Error{"This promo code isn't valid for these dates"}
+	ErrRoom        = &Error{"This promo code isn't valid for this room"}
+	ErrUsedUp      = &Error{"This promo code has been used up"}
+	ErrUsedByEmail = &Error{"You have already used this promo code"}
+)
+
+// Booking is what a promo code is entered on. Uses are the reservations already made with the
+// code and EmailUses those of them made with the same email address.
+type Booking struct {
+	Today     time.Time
+	StartDate time.Time
+	EndDate   time.Time
+	RoomID    int
+	Uses      int
+	EmailUses int
+}
+
+// Normalize returns code the way codes are stored, codes are not case sensitive
+func Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Check returns why the promo code can't be used on the booking, or nil if it can. Codes are
+// valid up to, but not on, ValidUntil and stays must be over by StayUntil.
+func Check(p models.PromoCode, b Booking) error {
+	switch {
+	case !p.ValidFrom.IsZero() && b.Today.Before(p.ValidFrom):
+		return ErrNotYetValid
+	case !p.ValidUntil.IsZero() && !b.Today.Before(p.ValidUntil):
+		return ErrExpired
+	case !p.StayFrom.IsZero() && b.StartDate.Before(p.StayFrom):
+		return ErrStayDates
+	case !p.StayUntil.IsZero() && b.EndDate.After(p.StayUntil):
+		return ErrStayDates
+	case p.RoomID != 0 && p.RoomID != b.RoomID:
+		return ErrRoom
+	case p.MaxUses > 0 && b.Uses >= p.MaxUses:
+		return ErrUsedUp
+	case p.MaxUsesPerEmail > 0 && b.EmailUses >= p.MaxUsesPerEmail:
+		return ErrUsedByEmail
+	}
+	return nil
+}
+
+// Discount returns how much the promo code takes off total, never more than total
+func Discount(p models.PromoCode, total int) int {
+	var d int
+	switch p.Kind {
+	case KindPercent:
+		d = total * p.Amount / 10000
+	case KindFixed:
+		d = p.Amount
+	}
+
+	if d > total {
+		return total
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// Describe explains what a promo code takes off
+func Describe(p models.PromoCode) string {
+	if p.Kind == KindPercent {
+		return fmt.Sprintf("%s%% off", pricing.FormatPercent(p.Amount))
+	}
+	return fmt.Sprintf("%s off", pricing.FormatMoney(p.Amount))
+}
+
+// Window describes the dates a promo code is limited to, empty when it is not
+func Window(p models.PromoCode) string {
+	var parts []string
+	if !p.ValidFrom.IsZero() || !p.ValidUntil.IsZero() {
+		parts = append(parts, "booked "+span(p.ValidFrom, p.ValidUntil))
+	}
+	if !p.StayFrom.IsZero() || !p.StayUntil.IsZero() {
+		parts = append(parts, "staying "+span(p.StayFrom, p.StayUntil))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// span describes a date range that may be open on either side
+func span(from, until time.Time) string {
+	switch {
+	case from.IsZero():
+		return "until " + until.Format(dates.Layout)
+	case until.IsZero():
+		return "from " + from.Format(dates.Layout)
+	}
+	return from.Format(dates.Layout) + " to " + until.Format(dates.Layout)
+}
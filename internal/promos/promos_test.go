@@ -0,0 +1,96 @@
+package promos
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/dates"
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+func day(s string) time.Time {
+	d, _ := dates.Parse(s)
+	return d
+}
+
+// every booking is made on 2050-05-01 for room 1, 2050-06-10 to 2050-06-12
+var booking = Booking{Today: day("2050-05-01"), StartDate: day("2050-06-10"), EndDate: day("2050-06-12"), RoomID: 1}
+
+var checkTests = []struct {
+	name      string
+	code      models.PromoCode
+	uses      int
+	emailUses int
+	expected  *Error
+}{
+	{"unrestricted", models.PromoCode{}, 0, 0, nil},
+	{"not-yet-valid", models.PromoCode{ValidFrom: day("2050-05-02")}, 0, 0, ErrNotYetValid},
+	{"first-day", models.PromoCode{ValidFrom: day("2050-05-01")}, 0, 0, nil},
+	{"expired", models.PromoCode{ValidUntil: day("2050-05-01")}, 0, 0, ErrExpired},
+	{"last-day", models.PromoCode{ValidUntil: day("2050-05-02")}, 0, 0, nil},
+	{"arrives-too-early", models.PromoCode{StayFrom: day("2050-06-11")}, 0, 0, ErrStayDates},
+	{"leaves-too-late", models.PromoCode{StayUntil: day("2050-06-11")}, 0, 0, ErrStayDates},
+	{"stay-within", models.PromoCode{StayFrom: day("2050-06-10"), StayUntil: day("2050-06-12")}, 0, 0, nil},
+	{"other-room", models.PromoCode{RoomID: 2}, 0, 0, ErrRoom},
+	{"same-room", models.PromoCode{RoomID: 1}, 0, 0, nil},
+	{"used-up", models.PromoCode{MaxUses: 10}, 10, 0, ErrUsedUp},
+	{"uses-left", models.PromoCode{MaxUses: 10}, 9, 0, nil},
+	{"used-by-email", models.PromoCode{MaxUsesPerEmail: 1}, 3, 1, ErrUsedByEmail},
+	{"first-use-by-email", models.PromoCode{MaxUsesPerEmail: 1}, 3, 0, nil},
+}
+
+func TestCheck(t *testing.T) {
+	for _, e := range checkTests {
+		b := booking
+		b.Uses = e.uses
+		b.EmailUses = e.emailUses
+
+		err := Check(e.code, b)
+		if e.expected == nil && err != nil || e.expected != nil && err != error(e.expected) {
+			t.Errorf("failed %s: expected %v, got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestDiscount(t *testing.T) {
+	tests := []struct {
+		code     models.PromoCode
+		total    int
+		expected int
+	}{
+		{models.PromoCode{Kind: KindPercent, Amount: 1000}, 23000, 2300},
+		{models.PromoCode{Kind: KindPercent, Amount: 1250}, 999, 124},
+		{models.PromoCode{Kind: KindFixed, Amount: 5000}, 23000, 5000},
+		{models.PromoCode{Kind: KindFixed, Amount: 5000}, 3000, 3000},
+		{models.PromoCode{Kind: "unknown", Amount: 5000}, 23000, 0},
+	}
+
+	for _, e := range tests {
+		if got := Discount(e.code, e.total); got != e.expected {
+			t.Errorf("expected a discount of %d for %+v on %d, got %d", e.expected, e.code, e.total, got)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	if s := Describe(models.PromoCode{Kind: KindPercent, Amount: 1250}); s != "12.5% off" {
+		t.Errorf("unexpected description %s", s)
+	}
+	if s := Describe(models.PromoCode{Kind: KindFixed, Amount: 2000}); s != "20.00 off" {
+		t.Errorf("unexpected description %s", s)
+	}
+
+	w := Window(models.PromoCode{ValidUntil: day("2050-05-01"), StayFrom: day("2050-06-01"), StayUntil: day("2050-09-01")})
+	if w != "booked until 2050-05-01, staying 2050-06-01 to 2050-09-01" {
+		t.Errorf("unexpected window %s", w)
+	}
+	if w := Window(models.PromoCode{}); w != "" {
+		t.Errorf("expected no window, got %s", w)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if Normalize(" summer25 ") != "SUMMER25" {
+		t.Errorf("unexpected code %s", Normalize(" summer25 "))
+	}
+}
@@ -5,13 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
 	"path/filepath"
 	"time"
 
 	"github.com/RakhmanovTimur/bookings/internal/config"
+	"github.com/RakhmanovTimur/bookings/internal/helpers"
 	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/RakhmanovTimur/bookings/internal/pricing"
 	"github.com/justinas/nosurf"
 )
 
@@ -20,6 +21,7 @@ var functions = template.FuncMap{
 	"formatDate": FormatDate,
 	"iterate":    Iterate,
 	"add":        Add,
+	"money":      pricing.FormatMoney,
 }
 
 var app *config.AppConfig
@@ -60,9 +62,14 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	td.Error = app.Session.PopString(r.Context(), "error")
 	td.Warning = app.Session.PopString(r.Context(), "warning")
 	td.CSRFToken = nosurf.Token(r)
-	if app.Session.Exists(r.Context(), "user_id") {
+	if helpers.IsAuthenticated(r) {
 		td.IsAuthenticated = 1
 	}
+	if helpers.GuestAccountID(r) > 0 {
+		td.IsGuest = 1
+	}
+	td.Property = helpers.CurrentProperty(r)
+	td.Properties = helpers.UserProperties(r)
 
 	return td
 }
@@ -90,14 +97,14 @@ func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.Te
 	_ = t.Execute(buf, td)
 	_, err := buf.WriteTo(w)
 	if err != nil {
-		log.Println(err)
+		helpers.Logger(r).Error("writing template", "template", tmpl, "error", err)
 		return err
 	}
 
 	// render the template
 	_, err = buf.WriteTo(w)
 	if err != nil {
-		log.Println(err)
+		helpers.Logger(r).Error("writing template", "template", tmpl, "error", err)
 		return err
 	}
 	return nil
@@ -2,13 +2,14 @@ package render
 
 import (
 	"encoding/gob"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/RakhmanovTimur/bookings/internal/config"
+	"github.com/RakhmanovTimur/bookings/internal/helpers"
 	"github.com/RakhmanovTimur/bookings/internal/models"
 	"github.com/alexedwards/scs/v2"
 )
@@ -22,11 +23,7 @@ func TestMain(m *testing.M) {
 	// change this to true when in production
 	testApp.InProduction = false
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	testApp.InfoLog = infoLog
-
-	errLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	testApp.ErrorLog = errLog
+	testApp.Logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
 
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -37,6 +34,7 @@ func TestMain(m *testing.M) {
 	testApp.Session = session
 
 	app = &testApp
+	helpers.NewHelpers(&testApp)
 	os.Exit(m.Run())
 }
 
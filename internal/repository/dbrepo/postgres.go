@@ -2,11 +2,17 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
-	"log"
+	"strings"
 	"time"
 
+	"github.com/RakhmanovTimur/bookings/internal/cancellation"
+	"github.com/RakhmanovTimur/bookings/internal/channels"
+	"github.com/RakhmanovTimur/bookings/internal/guests"
 	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/RakhmanovTimur/bookings/internal/payments"
+	"github.com/RakhmanovTimur/bookings/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -14,18 +20,118 @@ func (m *postgresDBRepo) AllUsers() bool {
 	return true
 }
 
-// InsertReservation inserts a reservation into the database
+// InsertReservation inserts a reservation into the database along with its extras
 func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	newID, err := insertReservation(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
+
+// InsertReservationWithRestriction inserts a reservation along with its extras and the room
+// restriction that gives it its unit, in one transaction. The guest's hold holdID on the unit, if
+// any, is released in its place. repository.ErrUnavailable is returned when anything else has
+// the unit on a night of the stay.
+func (m *postgresDBRepo) InsertReservationWithRestriction(res models.Reservation, holdID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = lockUnit(ctx, tx, res.RoomUnitID)
+	if err != nil {
+		return 0, err
+	}
+
+	if holdID != 0 {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where id = $1 and restriction_id = $2 
+			and room_unit_id = $3`, holdID, models.RestrictionHold, res.RoomUnitID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	newID, err := insertReservation(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
+	// the unit of a reservation waiting to be paid for is released with it when it runs out
+	_, err = claimUnit(ctx, tx, models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomUnitID:    res.RoomUnitID,
+		ReservationID: newID,
+		RestrictionID: models.RestrictionReservation,
+		ExpiresAt:     res.PendingUntil,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
+
+// lockUnit locks a room unit until the end of a transaction, so that whoever claims it next
+// sees what the transaction gave it
+func lockUnit(ctx context.Context, tx *sql.Tx, unitID int) error {
+	var id int
+	return tx.QueryRowContext(ctx, `select id from room_units where id = $1 for update`, unitID).Scan(&id)
+}
+
+// claimUnit inserts a room restriction on a unit locked with lockUnit as part of a transaction
+// and returns its id, the room is the unit's. repository.ErrUnavailable is returned when anything
+// else has the unit on a night of the restriction.
+func claimUnit(ctx context.Context, tx *sql.Tx, r models.RoomRestriction) (int, error) {
+	var newID int
+	query := `insert into room_restrictions (start_date, end_date, room_id, room_unit_id, reservation_id, 
+			restriction_id, expires_at, created_at, updated_at) 
+		select $1, $2, u.room_id, u.id, nullif($4, 0), $5, $6, $7, $8 from room_units u 
+		where u.id = $3 and not exists 
+			(select 1 from room_restrictions rr 
+				where rr.room_unit_id = u.id and $1 < rr.end_date and $2 > rr.start_date
+				and (rr.expires_at is null or rr.expires_at > $7))
+		returning id`
+
+	err := tx.QueryRowContext(ctx, query, r.StartDate, r.EndDate, r.RoomUnitID, r.ReservationID,
+		r.RestrictionID, sql.NullTime{Time: r.ExpiresAt, Valid: !r.ExpiresAt.IsZero()},
+		time.Now(), time.Now()).Scan(&newID)
+	if err == sql.ErrNoRows {
+		return 0, repository.ErrUnavailable
+	}
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// insertReservation inserts a reservation and its extras as part of a transaction
+func insertReservation(ctx context.Context, tx *sql.Tx, res models.Reservation) (int, error) {
 	var newID int
 	stmt := `insert into reservations 
 	(first_name, last_name, email, phone, start_date, end_date, 
-		room_id, created_at, updated_at)
-	values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+		room_id, adults, children, total, manage_token, cancellation_policy, cancellation_free_days, 
+		cancellation_fee_percent, cancellation_non_refundable, promo_code_id, promo_code, discount,
+		guest_id, group_booking_id, channel, channel_reference, pending_until, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, nullif($16, 0), $17, $18,
+		nullif($19, 0), nullif($20, 0), $21, $22, $23, $24, $25) returning id`
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err := tx.QueryRowContext(ctx, stmt,
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -33,49 +139,154 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		res.Adults,
+		res.Children,
+		res.Total,
+		res.ManageToken,
+		res.CancellationPolicy.Name,
+		res.CancellationPolicy.FreeDays,
+		res.CancellationPolicy.FeePercent,
+		res.CancellationPolicy.NonRefundable,
+		res.PromoCodeID,
+		res.PromoCode,
+		res.Discount,
+		res.GuestID,
+		res.GroupBookingID,
+		res.Channel,
+		res.ChannelReference,
+		sql.NullTime{Time: res.PendingUntil, Valid: !res.PendingUntil.IsZero()},
 		time.Now(),
 		time.Now()).Scan(&newID)
 
 	if err != nil {
 		return 0, err
 	}
+
+	err = insertReservationExtras(ctx, tx, newID, res.Extras)
+	if err != nil {
+		return 0, err
+	}
+
 	return newID, nil
 }
 
+// insertReservationExtras inserts the extras of a reservation as part of a transaction
+func insertReservationExtras(ctx context.Context, tx *sql.Tx, reservationID int, items []models.ReservationExtra) error {
+	stmt := `insert into reservation_extras (reservation_id, extra_id, name, pricing, quantity, nights, 
+		unit_price, amount, created_at, updated_at)
+	values ($1, nullif($2, 0), $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	for _, item := range items {
+		_, err := tx.ExecContext(ctx, stmt,
+			reservationID, item.ExtraID, item.Name, item.Pricing, item.Quantity, item.Nights,
+			item.UnitPrice, item.Amount, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // InsertRoomRestrictions inserts a room restriction into the database
 func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	stmt := `insert into room_restrictions (start_date, end_date, 
-		room_id, reservation_id, created_at, updated_at, restriction_id)
-		values ($1, $2, $3, $4, $5, $6, $7)`
+		room_id, room_unit_id, reservation_id, created_at, updated_at, restriction_id, expires_at)
+		values ($1, $2, $3, nullif($4, 0), $5, $6, $7, $8, $9)`
 
 	_, err := m.DB.ExecContext(ctx, stmt,
-		r.StartDate, r.EndDate, r.RoomID,
+		r.StartDate, r.EndDate, r.RoomID, r.RoomUnitID,
 		r.ReservationID, time.Now(), time.Now(),
-		r.RestrictionID)
+		r.RestrictionID, sql.NullTime{Time: r.ExpiresAt, Valid: !r.ExpiresAt.IsZero()})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID, and false if no availability
+// SearchAvailabilityByDatesByRoomID returns true if at least one unit of roomID is free
+// on every night of the stay, and false if no availability
 func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(roomID int, start, end time.Time) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 	var numRows int
 
+	// count the nights on which every unit of the room is taken
+	query := `
+		select 
+			count(*)
+		from 
+			generate_series($2::date, $3::date - 1, interval '1 day') as n(night)
+		where 
+			(select count(rr.id) from room_restrictions rr 
+				where rr.room_id = $1 and rr.start_date <= n.night and rr.end_date > n.night
+				and (rr.expires_at is null or rr.expires_at > $4))
+			>= (select count(u.id) from room_units u where u.room_id = $1);`
+
+	row := m.DB.QueryRowContext(ctx, query, roomID, start, end, time.Now())
+	err := row.Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+
+	if numRows == 0 {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// SearchAvailabilityByDatesByUnitIDExcludingReservation returns true if the unit is free for the
+// whole stay, ignoring the restriction that belongs to the given reservation
+func (m *postgresDBRepo) SearchAvailabilityByDatesByUnitIDExcludingReservation(unitID, reservationID int, start, end time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var numRows int
+
+	query := `
+		select 
+			count(id)
+		from 
+			room_restrictions 
+		where 
+			room_unit_id = $1
+			and coalesce(reservation_id, 0) <> $2
+			and $3 < end_date and $4 > start_date
+			and (expires_at is null or expires_at > $5) ;`
+
+	row := m.DB.QueryRowContext(ctx, query, unitID, reservationID, start, end, time.Now())
+	err := row.Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+
+	if numRows == 0 {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// SearchAvailabilityByDatesByUnitIDExcludingRestriction returns true if the unit is free for the
+// whole stay, ignoring the given room restriction
+func (m *postgresDBRepo) SearchAvailabilityByDatesByUnitIDExcludingRestriction(unitID, restrictionID int, start, end time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	var numRows int
+
 	query := `
 		select 
 			count(id)
 		from 
 			room_restrictions 
 		where 
-			room_id = $1
-			and $2 <= end_date and $3 >= start_date ;`
+			room_unit_id = $1
+			and id <> $2
+			and $3 < end_date and $4 > start_date
+			and (expires_at is null or expires_at > $5) ;`
 
-	row := m.DB.QueryRowContext(ctx, query, roomID, start, end)
+	row := m.DB.QueryRowContext(ctx, query, unitID, restrictionID, start, end, time.Now())
 	err := row.Scan(&numRows)
 	if err != nil {
 		return false, err
@@ -88,8 +299,41 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(roomID int, start, en
 	return false, nil
 }
 
+// availableUnitQuery selects the first unit of room $1 that is free from $2 up to $3, ignoring
+// holds that have run out at $4
+const availableUnitQuery = `
+	select 
+		u.id
+	from 
+		room_units u
+	where 
+		u.room_id = $1 and u.id not in 
+		(select rr.room_unit_id from room_restrictions rr 
+			where rr.room_unit_id is not null and $2 < rr.end_date and $3 > rr.start_date
+			and (rr.expires_at is null or rr.expires_at > $4))
+	order by u.name, u.id
+	limit 1;`
+
+// FindAvailableUnit returns the id of a unit of roomID that is free for the whole stay,
+// or 0 if every unit is taken on at least one night
+func (m *postgresDBRepo) FindAvailableUnit(roomID int, start, end time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var unitID int
+
+	err := m.DB.QueryRowContext(ctx, availableUnitQuery, roomID, start, end, time.Now()).Scan(&unitID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return unitID, nil
+}
+
 // SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(propertyID, guests int, start, end time.Time) ([]models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -97,14 +341,20 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 
 	query := `
 		select
-			r.id, r.room_name
+			r.id, r.room_name, r.property_id, r.price, r.max_occupancy, 
+			r.included_guests, r.extra_guest_price
 		from
 			rooms r
-		where r.id not in 
-		(select room_id from room_restrictions rr where $1 <= rr.end_date and $2 >= rr.start_date);
+		where r.property_id = $3 and r.max_occupancy >= $4 and not exists 
+		(select 1 from generate_series($1::date, $2::date - 1, interval '1 day') as n(night)
+			where (select count(rr.id) from room_restrictions rr 
+				where rr.room_id = r.id and rr.start_date <= n.night and rr.end_date > n.night
+				and (rr.expires_at is null or rr.expires_at > $5))
+			>= (select count(u.id) from room_units u where u.room_id = r.id))
+		order by r.price;
 		`
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	rows, err := m.DB.QueryContext(ctx, query, start, end, propertyID, guests, time.Now())
 	if err != nil {
 		return rooms, err
 	}
@@ -114,6 +364,11 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 		err := rows.Scan(
 			&room.ID,
 			&room.RoomName,
+			&room.PropertyID,
+			&room.Price,
+			&room.MaxOccupancy,
+			&room.IncludedGuests,
+			&room.ExtraGuestPrice,
 		)
 		if err != nil {
 			return rooms, err
@@ -128,6 +383,72 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 	return rooms, nil
 }
 
+// GetNightlyAvailability returns, for every room that sleeps guests and every night from start
+// up to end, how many units of the room are free
+func (m *postgresDBRepo) GetNightlyAvailability(propertyID, guests int, start, end time.Time) ([]models.RoomNight, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var nights []models.RoomNight
+
+	query := `
+		with nights as (
+			select n::date as night from generate_series($1::date, $2::date - 1, interval '1 day') as n
+		),
+		units as (
+			select room_id, count(id) as total from room_units group by room_id
+		),
+		taken as (
+			select rr.room_id, n.night, count(rr.id) as taken
+			from room_restrictions rr
+			join nights n on (rr.start_date <= n.night and rr.end_date > n.night)
+			where rr.expires_at is null or rr.expires_at > $5
+			group by rr.room_id, n.night
+		)
+		select
+			r.id, r.room_name, r.property_id, r.price, r.max_occupancy, 
+			r.included_guests, r.extra_guest_price, n.night,
+			coalesce(u.total, 0) - coalesce(t.taken, 0)
+		from
+			rooms r
+			cross join nights n
+			left join units u on (u.room_id = r.id)
+			left join taken t on (t.room_id = r.id and t.night = n.night)
+		where r.property_id = $3 and r.max_occupancy >= $4
+		order by r.price, r.id, n.night;
+		`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end, propertyID, guests, time.Now())
+	if err != nil {
+		return nights, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var x models.RoomNight
+		err := rows.Scan(
+			&x.Room.ID,
+			&x.Room.RoomName,
+			&x.Room.PropertyID,
+			&x.Room.Price,
+			&x.Room.MaxOccupancy,
+			&x.Room.IncludedGuests,
+			&x.Room.ExtraGuestPrice,
+			&x.Night,
+			&x.Free,
+		)
+		if err != nil {
+			return nights, err
+		}
+		nights = append(nights, x)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nights, err
+	}
+	return nights, nil
+}
+
 // Gets a room by ID
 func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -136,14 +457,25 @@ func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 	var room models.Room
 	query := `
 		select 
-			id, room_name, created_at, updated_at from rooms where id = $1
+			r.id, r.room_name, coalesce(r.property_id, 0), r.price, r.max_occupancy, r.included_guests, 
+			r.extra_guest_price, r.created_at, r.updated_at, 
+			coalesce(c.id, 0), coalesce(c.name, ''), coalesce(c.free_days, 0), 
+			coalesce(c.fee_percent, 0), coalesce(c.non_refundable, false)
+		from rooms r
+		left join cancellation_policies c on (r.cancellation_policy_id = c.id)
+		where r.id = $1
 		`
 
 	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&room.ID, &room.RoomName, &room.CreatedAt, &room.UpdatedAt)
+	err := row.Scan(&room.ID, &room.RoomName, &room.PropertyID, &room.Price, &room.MaxOccupancy,
+		&room.IncludedGuests, &room.ExtraGuestPrice, &room.CreatedAt, &room.UpdatedAt,
+		&room.CancellationPolicy.ID, &room.CancellationPolicy.Name, &room.CancellationPolicy.FreeDays,
+		&room.CancellationPolicy.FeePercent, &room.CancellationPolicy.NonRefundable)
 	if err != nil {
 		return room, err
 	}
+	room.CancellationPolicyID = room.CancellationPolicy.ID
+	room.CancellationPolicy.PropertyID = room.PropertyID
 	return room, nil
 
 }
@@ -153,35 +485,101 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// ID          int
-	// FirstName   string
-	// LastName    string
-	// Email       string
-	// Password    string
-	// AccessLevel int
-	query := `select id, first_name, last_name, email, 
-	password, access_level, created_at, updated_at where id = $1`
+	query := `select ` + userColumns + ` from users where id = $1`
 
-	row := m.DB.QueryRowContext(ctx, query, id)
+	return scanUser(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// userColumns are the columns scanUser reads
+const userColumns = `id, first_name, last_name, email, password, access_level, phone, marketing_consent, 
+	marketing_consent_at, email_verified_at, created_at, updated_at`
 
+// scanUser scans the userColumns
+func scanUser(row interface{ Scan(...any) error }) (models.User, error) {
 	var u models.User
-	err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Password, &u.AccessLevel, &u.CreatedAt, &u.UpdatedAt)
+	var consentAt, verifiedAt sql.NullTime
+
+	err := row.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Password, &u.AccessLevel, &u.Phone,
+		&u.MarketingConsent, &consentAt, &verifiedAt, &u.CreatedAt, &u.UpdatedAt)
 	if err != nil {
 		return u, err
 	}
+
+	u.MarketingConsentAt = consentAt.Time
+	u.EmailVerifiedAt = verifiedAt.Time
 	return u, nil
 }
 
+// GetUserByEmail returns a user by email
+func (m *postgresDBRepo) GetUserByEmail(email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + userColumns + ` from users where email = $1`
+
+	return scanUser(m.DB.QueryRowContext(ctx, query, email))
+}
+
+// InsertUser inserts a user with the given password, hashed, and returns their id. Users
+// without a password can only log in by a link sent to their email.
+func (m *postgresDBRepo) InsertUser(u models.User, password string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var hashedPassword string
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+		if err != nil {
+			return 0, err
+		}
+		hashedPassword = string(hash)
+	}
+
+	var id int
+	query := `insert into users (first_name, last_name, email, password, access_level, phone, 
+		marketing_consent, marketing_consent_at, email_verified_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		u.FirstName, u.LastName, u.Email, hashedPassword, u.AccessLevel, u.Phone, u.MarketingConsent,
+		sql.NullTime{Time: u.MarketingConsentAt, Valid: !u.MarketingConsentAt.IsZero()},
+		sql.NullTime{Time: u.EmailVerifiedAt, Valid: !u.EmailVerifiedAt.IsZero()},
+		time.Now(), time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
 // UpdateUser updates a user in the database
 func (m *postgresDBRepo) UpdateUser(u models.User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	query := `update users set first_name = $1, last_name = $2, email = $3, 
-	access_level = $4, updated_at = $5 `
+	access_level = $4, phone = $5, marketing_consent = $6, marketing_consent_at = $7, email_verified_at = $8,
+	updated_at = $9
+	where id = $10`
 
 	_, err := m.DB.ExecContext(ctx, query,
-		u.FirstName, u.LastName, u.Email, u.AccessLevel, time.Now())
+		u.FirstName, u.LastName, u.Email, u.AccessLevel, u.Phone, u.MarketingConsent,
+		sql.NullTime{Time: u.MarketingConsentAt, Valid: !u.MarketingConsentAt.IsZero()},
+		sql.NullTime{Time: u.EmailVerifiedAt, Valid: !u.EmailVerifiedAt.IsZero()},
+		time.Now(), u.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// RemoveUserPassword removes a user's password, so they can only log in by a link sent to
+// their email
+func (m *postgresDBRepo) RemoveUserPassword(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update users set password = '', updated_at = $1 where id = $2`, time.Now(), id)
 	if err != nil {
 		return err
 	}
@@ -215,7 +613,7 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 }
 
 // AllReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
+func (m *postgresDBRepo) AllReservations(propertyID int) ([]models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -223,13 +621,16 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 	query := `
 	select 
 		r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
-		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
-		rm.id, rm.room_name 
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.cancelled_at,
+		r.adults, r.children, r.total, r.promo_code, r.discount, r.cancellation_fee, r.refund_amount,
+		r.pending_until, rm.id, rm.room_name 
 	from 
 		reservations r left join rooms rm on (r.room_id = rm.id)
+	where 
+		rm.property_id = $1
 	order by r.start_date asc
 	`
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, propertyID)
 	if err != nil {
 		return reservations, err
 	}
@@ -237,14 +638,18 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 
 	for rows.Next() {
 		var i models.Reservation
+		var cancelledAt, pendingUntil sql.NullTime
 		err := rows.Scan(
 			&i.ID, &i.FirstName, &i.LastName, &i.Email, &i.Phone, &i.StartDate,
-			&i.EndDate, &i.RoomID, &i.CreatedAt, &i.UpdatedAt, &i.Processed,
-			&i.Room.ID, &i.Room.RoomName,
+			&i.EndDate, &i.RoomID, &i.CreatedAt, &i.UpdatedAt, &i.Processed, &cancelledAt,
+			&i.Adults, &i.Children, &i.Total, &i.PromoCode, &i.Discount, &i.CancellationFee, &i.Refund,
+			&pendingUntil, &i.Room.ID, &i.Room.RoomName,
 		)
 		if err != nil {
 			return reservations, err
 		}
+		i.CancelledAt = cancelledAt.Time
+		i.PendingUntil = pendingUntil.Time
 		reservations = append(reservations, i)
 	}
 	err = rows.Err()
@@ -255,7 +660,7 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 }
 
 // AllNewReservations returns a slice of new reservations
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
+func (m *postgresDBRepo) AllNewReservations(propertyID int) ([]models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -268,10 +673,10 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 	from 
 		reservations r left join rooms rm on (r.room_id = rm.id)
 	where 
-		processed = 0
+		processed = 0 and r.cancelled_at is null and r.pending_until is null and rm.property_id = $1
 	order by r.start_date asc
 	`
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, propertyID)
 	if err != nil {
 		return reservations, err
 	}
@@ -305,40 +710,145 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 	query := `
 		select 
 			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
-			r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
-			rm.id, rm.room_name 
+			r.end_date, r.room_id, r.adults, r.children, r.total, r.created_at, r.updated_at, r.processed,
+			r.manage_token, r.cancellation_policy, r.cancellation_free_days, r.cancellation_fee_percent, 
+			r.cancellation_non_refundable, r.cancelled_at, r.cancelled_by, r.cancellation_fee, r.refund_amount,
+			coalesce(r.promo_code_id, 0), r.promo_code, r.discount, coalesce(r.guest_id, 0),
+			coalesce(r.group_booking_id, 0), r.channel, r.channel_reference, r.pending_until, rm.id, 
+			rm.room_name, coalesce(rm.property_id, 0), coalesce(u.id, 0), coalesce(u.name, '')
 		from reservations r
 		left join rooms rm on (r.room_id = rm.id)
+		left join room_restrictions rr on (rr.reservation_id = r.id)
+		left join room_units u on (rr.room_unit_id = u.id)
 		where 
 			r.id = $1
 		`
 	row := m.DB.QueryRowContext(ctx, query, id)
 
+	var cancelledAt, pendingUntil sql.NullTime
 	err := row.Scan(
 		&res.ID, &res.FirstName, &res.LastName, &res.Email, &res.Phone, &res.StartDate,
-		&res.EndDate, &res.RoomID, &res.CreatedAt, &res.UpdatedAt, &res.Processed,
-		&res.Room.ID, &res.Room.RoomName,
+		&res.EndDate, &res.RoomID, &res.Adults, &res.Children, &res.Total,
+		&res.CreatedAt, &res.UpdatedAt, &res.Processed,
+		&res.ManageToken, &res.CancellationPolicy.Name, &res.CancellationPolicy.FreeDays,
+		&res.CancellationPolicy.FeePercent, &res.CancellationPolicy.NonRefundable, &cancelledAt,
+		&res.CancelledBy, &res.CancellationFee, &res.Refund,
+		&res.PromoCodeID, &res.PromoCode, &res.Discount, &res.GuestID,
+		&res.GroupBookingID, &res.Channel, &res.ChannelReference, &pendingUntil, &res.Room.ID,
+		&res.Room.RoomName, &res.Room.PropertyID, &res.RoomUnit.ID, &res.RoomUnit.Name,
 	)
 	if err != nil {
 		return res, err
 	}
+	res.CancelledAt = cancelledAt.Time
+	res.PendingUntil = pendingUntil.Time
+	res.RoomUnitID = res.RoomUnit.ID
+	res.RoomUnit.RoomID = res.RoomID
+
+	res.Extras, err = m.GetExtrasForReservation(res.ID)
+	if err != nil {
+		return res, err
+	}
 	return res, nil
 }
 
-// UpdateReservation updates a user in the database
+// GetReservationByToken gets a reservation by the token its guest manages it with
+func (m *postgresDBRepo) GetReservationByToken(token string) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	if token == "" {
+		return models.Reservation{}, sql.ErrNoRows
+	}
+
+	var id int
+	query := `select id from reservations where manage_token = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, token).Scan(&id)
+	if err != nil {
+		return models.Reservation{}, err
+	}
+	return m.GetReservationByID(id)
+}
+
+// CancelReservation records the cancellation of a reservation and frees its room
+func (m *postgresDBRepo) CancelReservation(res models.Reservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `update reservations set cancelled_at = $1, cancelled_by = $2, cancellation_fee = $3, 
+	refund_amount = $4, updated_at = $5 where id = $6 and cancelled_at is null`
+
+	result, err := tx.ExecContext(ctx, query,
+		res.CancelledAt, res.CancelledBy, res.CancellationFee, res.Refund, time.Now(), res.ID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("reservation is already cancelled")
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, res.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateReservation updates a reservation with its total and extras, and its room restriction,
+// in the database
 func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `update reservations set first_name = $1, last_name = $2, email = $3, 
-	phone = $4, updated_at = $5 where id = $6`
+	phone = $4, start_date = $5, end_date = $6, room_id = $7, total = $8, updated_at = $9 where id = $10`
 
-	_, err := m.DB.ExecContext(ctx, query,
-		u.FirstName, u.LastName, u.Email, u.Phone, time.Now(), u.ID)
+	_, err = tx.ExecContext(ctx, query,
+		u.FirstName, u.LastName, u.Email, u.Phone,
+		u.StartDate, u.EndDate, u.RoomID, u.Total, time.Now(), u.ID)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	// extras charged by the night change with the dates
+	_, err = tx.ExecContext(ctx, `delete from reservation_extras where reservation_id = $1`, u.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertReservationExtras(ctx, tx, u.ID, u.Extras)
+	if err != nil {
+		return err
+	}
+
+	query = `update room_restrictions set start_date = $1, end_date = $2, room_id = $3, 
+	room_unit_id = nullif($4, 0), updated_at = $5 where reservation_id = $6`
+
+	_, err = tx.ExecContext(ctx, query,
+		u.StartDate, u.EndDate, u.RoomID, u.RoomUnitID, time.Now(), u.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // DeleteReservation deletes one reservation by id
@@ -370,15 +880,17 @@ func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error
 }
 
 // AllRooms returns all rooms in the database
-func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
+func (m *postgresDBRepo) AllRooms(propertyID int) ([]models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var rooms []models.Room
 
-	query := `select id, room_name, created_at, updated_at from rooms order by room_name`
+	query := `select id, room_name, property_id, price, max_occupancy, included_guests, 
+	extra_guest_price, coalesce(cancellation_policy_id, 0), created_at, updated_at from rooms 
+	where property_id = $1 order by room_name`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.DB.QueryContext(ctx, query, propertyID)
 	if err != nil {
 		return rooms, nil
 	}
@@ -388,8 +900,8 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 	for rows.Next() {
 		var rm models.Room
 		err := rows.Scan(
-			&rm.ID, &rm.RoomName,
-			&rm.CreatedAt, &rm.UpdatedAt,
+			&rm.ID, &rm.RoomName, &rm.PropertyID, &rm.Price, &rm.MaxOccupancy,
+			&rm.IncludedGuests, &rm.ExtraGuestPrice, &rm.CancellationPolicyID, &rm.CreatedAt, &rm.UpdatedAt,
 		)
 		if err != nil {
 			return rooms, err
@@ -403,19 +915,19 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 
 }
 
-// GetRestrictionsForRoomByDate returns restrictions for a room by date range
-func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+// GetRestrictionsForUnitByDate returns restrictions for a room unit by date range
+func (m *postgresDBRepo) GetRestrictionsForUnitByDate(unitID int, start, end time.Time) ([]models.RoomRestriction, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var restrictions []models.RoomRestriction
 
-	query := `select id, coalesce (reservation_id, 0), restriction_id, room_id, start_date, end_date
+	query := `select id, coalesce (reservation_id, 0), restriction_id, room_id, room_unit_id, start_date, end_date
 	from 
 		room_restrictions where $1 < end_date and $2 >= start_date
-		and room_id = $3
+		and room_unit_id = $3
 	`
-	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	rows, err := m.DB.QueryContext(ctx, query, start, end, unitID)
 	if err != nil {
 		return restrictions, err
 	}
@@ -427,6 +939,7 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end tim
 			&r.ReservationID,
 			&r.RestrictionID,
 			&r.RoomID,
+			&r.RoomUnitID,
 			&r.StartDate,
 			&r.EndDate,
 		)
@@ -441,20 +954,23 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end tim
 	return restrictions, nil
 }
 
-// InsertBlockForRoom inserts a room restriction for block
-func (m *postgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+// InsertBlockForUnit inserts a room restriction for a one night block of a room unit and returns
+// its id
+func (m *postgresDBRepo) InsertBlockForUnit(unitID int, startDate time.Time) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, 
-			created_at, updated_at) values ($1, $2, $3, $4, $5, $6)`
+	var newID int
+	query := `insert into room_restrictions (start_date, end_date, room_id, room_unit_id, restriction_id, 
+			created_at, updated_at) 
+			select $1, $2, u.room_id, u.id, $4, $5, $6 from room_units u where u.id = $3 returning id`
 
-	_, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
+	err := m.DB.QueryRowContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), unitID, models.RestrictionBlock,
+		time.Now(), time.Now()).Scan(&newID)
 	if err != nil {
-		log.Println(err)
-		return err
+		return 0, err
 	}
-	return nil
+	return newID, nil
 }
 
 // DeleteBlockByID inserts a room restriction for block
@@ -466,8 +982,2238 @@ func (m *postgresDBRepo) DeleteBlockByID(id int) error {
 
 	_, err := m.DB.ExecContext(ctx, query, id)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
 	return nil
 }
+
+// GetRestrictionsByDate returns restrictions for all rooms by date range
+func (m *postgresDBRepo) GetRestrictionsByDate(propertyID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `select rr.id, coalesce(rr.reservation_id, 0), rr.restriction_id, rr.room_id, 
+		coalesce(rr.room_unit_id, 0), rr.start_date, rr.end_date, 
+		coalesce(r.first_name, ''), coalesce(r.last_name, ''),
+		rs.restriction_name
+	from 
+		room_restrictions rr
+		left join reservations r on (rr.reservation_id = r.id)
+		left join restrictions rs on (rr.restriction_id = rs.id)
+		left join rooms rm on (rr.room_id = rm.id)
+	where $1 < rr.end_date and $2 >= rr.start_date and rm.property_id = $3
+		and (rr.expires_at is null or rr.expires_at > $4)
+	order by rr.room_id, rr.room_unit_id, rr.start_date
+	`
+	rows, err := m.DB.QueryContext(ctx, query, start, end, propertyID, time.Now())
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.ReservationID,
+			&r.RestrictionID,
+			&r.RoomID,
+			&r.RoomUnitID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.Reservation.FirstName,
+			&r.Reservation.LastName,
+			&r.Restriction.RestrictionName,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+	return restrictions, nil
+}
+
+// GetRoomRestrictionByID returns a room restriction by id
+func (m *postgresDBRepo) GetRoomRestrictionByID(id int) (models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var r models.RoomRestriction
+	var expiresAt sql.NullTime
+
+	query := `select id, coalesce(reservation_id, 0), restriction_id, room_id, coalesce(room_unit_id, 0), 
+	start_date, end_date, expires_at from room_restrictions where id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&r.ID,
+		&r.ReservationID,
+		&r.RestrictionID,
+		&r.RoomID,
+		&r.RoomUnitID,
+		&r.StartDate,
+		&r.EndDate,
+		&expiresAt,
+	)
+	if err != nil {
+		return r, err
+	}
+	r.ExpiresAt = expiresAt.Time
+	return r, nil
+}
+
+// InsertBlockForUnitByDates inserts a room restriction for a block of a room unit spanning the given dates
+func (m *postgresDBRepo) InsertBlockForUnitByDates(unitID int, start, end time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	query := `insert into room_restrictions (start_date, end_date, room_id, room_unit_id, restriction_id, 
+			created_at, updated_at) 
+			select $1, $2, u.room_id, u.id, $4, $5, $6 from room_units u where u.id = $3 returning id`
+
+	err := m.DB.QueryRowContext(ctx, query, start, end, unitID, models.RestrictionBlock, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdateBlockByID moves or resizes a block
+func (m *postgresDBRepo) UpdateBlockByID(id, unitID int, start, end time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update room_restrictions set room_unit_id = u.id, room_id = u.room_id, start_date = $2, 
+		end_date = $3, updated_at = $4 from room_units u 
+		where u.id = $1 and room_restrictions.id = $5 and room_restrictions.reservation_id is null`
+
+	_, err := m.DB.ExecContext(ctx, query, unitID, start, end, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// InsertHold inserts a hold on a room unit that runs out at the hold's ExpiresAt and returns its
+// id, or repository.ErrUnavailable when anything else has the unit on a night of the hold
+func (m *postgresDBRepo) InsertHold(h models.RoomRestriction) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = lockUnit(ctx, tx, h.RoomUnitID)
+	if err != nil {
+		return 0, err
+	}
+
+	h.RestrictionID = models.RestrictionHold
+	newID, err := claimUnit(ctx, tx, h)
+	if err != nil {
+		return 0, err
+	}
+	return newID, tx.Commit()
+}
+
+// ReleaseHold removes a hold, leaving any other kind of room restriction with the id alone
+func (m *postgresDBRepo) ReleaseHold(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from room_restrictions where id = $1 and restriction_id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, id, models.RestrictionHold)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReleaseExpiredHolds removes the holds that have run out at now, cancels the reservations that
+// weren't paid for in time and frees their units, and returns the ids of the properties whose
+// rooms they held
+func (m *postgresDBRepo) ReleaseExpiredHolds(now time.Time) ([]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var propertyIDs []int
+
+	// the restriction of a reservation waiting to be paid for runs out with the reservation
+	query := `
+		with lapsed as (
+			update reservations set cancelled_at = $1, cancelled_by = $2, updated_at = $1
+			where pending_until <= $1 and cancelled_at is null
+		), released as (
+			delete from room_restrictions where expires_at <= $1 returning room_id
+		)
+		select distinct r.property_id from released x join rooms r on (r.id = x.room_id)`
+
+	rows, err := m.DB.QueryContext(ctx, query, now, cancellation.BySystem)
+	if err != nil {
+		return propertyIDs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return propertyIDs, err
+		}
+		propertyIDs = append(propertyIDs, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return propertyIDs, err
+	}
+
+	return propertyIDs, nil
+}
+
+// ConfirmReservation confirms a reservation waiting to be paid for, so its unit is kept for good.
+// It reports false when the reservation ran out at now before it was confirmed.
+func (m *postgresDBRepo) ConfirmReservation(id int, now time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `update reservations set pending_until = null, updated_at = $1 
+	where id = $2 and cancelled_at is null and pending_until > $1`
+
+	result, err := tx.ExecContext(ctx, query, now, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	query = `update room_restrictions set expires_at = null, updated_at = $1 where reservation_id = $2`
+
+	_, err = tx.ExecContext(ctx, query, now, id)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// ConfirmGroupBooking confirms the stays of a group booking waiting to be paid for, which run out
+// together. It reports false when they ran out at now before they were confirmed.
+func (m *postgresDBRepo) ConfirmGroupBooking(id int, now time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `update reservations set pending_until = null, updated_at = $1 
+	where group_booking_id = $2 and cancelled_at is null and pending_until > $1`
+
+	result, err := tx.ExecContext(ctx, query, now, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	query = `update room_restrictions set expires_at = null, updated_at = $1 
+	where reservation_id in (select id from reservations where group_booking_id = $2)`
+
+	_, err = tx.ExecContext(ctx, query, now, id)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// GetUnitsByRoomID returns the units of a room type
+func (m *postgresDBRepo) GetUnitsByRoomID(roomID int) ([]models.RoomUnit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var units []models.RoomUnit
+
+	query := `select id, room_id, name, created_at, updated_at from room_units 
+	where room_id = $1 order by name, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return units, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.RoomUnit
+		err := rows.Scan(&u.ID, &u.RoomID, &u.Name, &u.CreatedAt, &u.UpdatedAt)
+		if err != nil {
+			return units, err
+		}
+		units = append(units, u)
+	}
+	if err = rows.Err(); err != nil {
+		return units, err
+	}
+	return units, nil
+}
+
+// GetRoomUnitByID returns a room unit together with its room type
+func (m *postgresDBRepo) GetRoomUnitByID(id int) (models.RoomUnit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var u models.RoomUnit
+
+	query := `select u.id, u.room_id, u.name, u.created_at, u.updated_at, 
+		r.id, r.room_name, coalesce(r.property_id, 0)
+	from room_units u
+	left join rooms r on (u.room_id = r.id)
+	where u.id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&u.ID, &u.RoomID, &u.Name, &u.CreatedAt, &u.UpdatedAt,
+		&u.Room.ID, &u.Room.RoomName, &u.Room.PropertyID,
+	)
+	if err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+const stayRuleColumns = `s.id, s.room_id, s.start_date, s.end_date, s.min_nights, s.max_nights, 
+	s.arrival_days, s.departure_days, s.closed_to_arrival, s.min_advance_days, s.max_advance_days, 
+	s.created_at, s.updated_at, r.id, r.room_name, r.property_id`
+
+// scanStayRules scans stay_rules rows selected with stayRuleColumns
+func scanStayRules(rows *sql.Rows) ([]models.StayRule, error) {
+	var rules []models.StayRule
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.StayRule
+		err := rows.Scan(
+			&s.ID, &s.RoomID, &s.StartDate, &s.EndDate, &s.MinNights, &s.MaxNights,
+			&s.ArrivalDays, &s.DepartureDays, &s.ClosedToArrival, &s.MinAdvanceDays, &s.MaxAdvanceDays,
+			&s.CreatedAt, &s.UpdatedAt, &s.Room.ID, &s.Room.RoomName, &s.Room.PropertyID,
+		)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, s)
+	}
+	if err := rows.Err(); err != nil {
+		return rules, err
+	}
+	return rules, nil
+}
+
+// GetStayRulesForArrival returns the stay rules of all rooms of a property that cover an arrival date
+func (m *postgresDBRepo) GetStayRulesForArrival(propertyID int, arrival time.Time) ([]models.StayRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + stayRuleColumns + `
+	from stay_rules s
+	join rooms r on (s.room_id = r.id)
+	where r.property_id = $1 and s.start_date <= $2 and s.end_date > $2
+	order by s.room_id, s.start_date, s.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyID, arrival)
+	if err != nil {
+		return nil, err
+	}
+	return scanStayRules(rows)
+}
+
+// AllStayRules returns the stay rules of all rooms of a property
+func (m *postgresDBRepo) AllStayRules(propertyID int) ([]models.StayRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + stayRuleColumns + `
+	from stay_rules s
+	join rooms r on (s.room_id = r.id)
+	where r.property_id = $1
+	order by s.start_date, r.room_name, s.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	return scanStayRules(rows)
+}
+
+// InsertStayRule inserts a stay rule into the database
+func (m *postgresDBRepo) InsertStayRule(s models.StayRule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	query := `insert into stay_rules (room_id, start_date, end_date, min_nights, max_nights, 
+			arrival_days, departure_days, closed_to_arrival, min_advance_days, max_advance_days, 
+			created_at, updated_at) 
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		s.RoomID, s.StartDate, s.EndDate, s.MinNights, s.MaxNights,
+		s.ArrivalDays, s.DepartureDays, s.ClosedToArrival, s.MinAdvanceDays, s.MaxAdvanceDays,
+		time.Now(), time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// DeleteStayRule deletes a stay rule of one of the rooms of a property
+func (m *postgresDBRepo) DeleteStayRule(propertyID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from stay_rules s using rooms r 
+	where s.room_id = r.id and r.property_id = $1 and s.id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, propertyID, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+const propertyColumns = `id, name, slug, hostname, email, sender_email, notification_email, 
+	timezone, check_in_time, check_out_time, tagline, description, address, payment_policy, 
+	deposit_percent, created_at, updated_at`
+
+// scanProperty scans a single properties row
+func scanProperty(row interface{ Scan(...any) error }) (models.Property, error) {
+	var p models.Property
+	err := row.Scan(
+		&p.ID, &p.Name, &p.Slug, &p.Hostname, &p.Email, &p.SenderEmail, &p.NotificationEmail,
+		&p.Timezone, &p.CheckInTime, &p.CheckOutTime, &p.Tagline, &p.Description, &p.Address,
+		&p.PaymentPolicy, &p.DepositPercent, &p.CreatedAt, &p.UpdatedAt,
+	)
+	return p, err
+}
+
+// GetPropertyByID returns a property by id
+func (m *postgresDBRepo) GetPropertyByID(id int) (models.Property, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + propertyColumns + ` from properties where id = $1`
+
+	return scanProperty(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetPropertyBySlug returns a property by its url slug
+func (m *postgresDBRepo) GetPropertyBySlug(slug string) (models.Property, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + propertyColumns + ` from properties where slug = $1`
+
+	return scanProperty(m.DB.QueryRowContext(ctx, query, slug))
+}
+
+// GetPropertyByHostname returns a property by the hostname it is served on
+func (m *postgresDBRepo) GetPropertyByHostname(hostname string) (models.Property, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + propertyColumns + ` from properties where hostname = $1 order by id limit 1`
+
+	return scanProperty(m.DB.QueryRowContext(ctx, query, hostname))
+}
+
+// GetDefaultProperty returns the first property, used when a request matches no other property
+func (m *postgresDBRepo) GetDefaultProperty() (models.Property, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + propertyColumns + ` from properties order by id limit 1`
+
+	return scanProperty(m.DB.QueryRowContext(ctx, query))
+}
+
+// GetPropertiesForUser returns the properties a user has been granted access to
+func (m *postgresDBRepo) GetPropertiesForUser(userID int) ([]models.Property, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var properties []models.Property
+
+	query := `select p.id, p.name, p.slug, p.hostname, p.email, p.sender_email, p.notification_email, 
+		p.timezone, p.check_in_time, p.check_out_time, p.tagline, p.description, p.address,
+		p.created_at, p.updated_at
+	from 
+		properties p
+		inner join user_properties up on (up.property_id = p.id)
+	where 
+		up.user_id = $1
+	order by p.name`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return properties, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanProperty(rows)
+		if err != nil {
+			return properties, err
+		}
+		properties = append(properties, p)
+	}
+	if err = rows.Err(); err != nil {
+		return properties, err
+	}
+	return properties, nil
+}
+
+// InsertPayment inserts a payment into the database
+func (m *postgresDBRepo) InsertPayment(p models.Payment) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	query := `insert into payments (reservation_id, kind, amount, status, gateway, reference, 
+			created_at, updated_at) 
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		p.ReservationID, p.Kind, p.Amount, p.Status, p.Gateway, p.Reference, time.Now(), time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// StartPayment records a payment about to be taken as pending. Only one payment of a reservation
+// can be pending, authorized or captured at a time, so ErrPaymentInProgress is returned when
+// another one already is.
+func (m *postgresDBRepo) StartPayment(p models.Payment) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	query := `insert into payments (reservation_id, kind, amount, status, gateway, reference, 
+			created_at, updated_at) 
+			values ($1, $2, $3, $4, $5, '', $6, $7)
+			on conflict (reservation_id) where kind <> 'refund' and status in ('pending', 'authorized', 'captured')
+			do nothing returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		p.ReservationID, p.Kind, p.Amount, payments.StatusPending, p.Gateway, time.Now(), time.Now(),
+	).Scan(&newID)
+	if err == sql.ErrNoRows {
+		return 0, repository.ErrPaymentInProgress
+	}
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdatePayment records how taking a payment went, its status and the gateway's reference
+func (m *postgresDBRepo) UpdatePayment(p models.Payment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update payments set status = $1, reference = $2, updated_at = $3 where id = $4`
+
+	_, err := m.DB.ExecContext(ctx, query, p.Status, p.Reference, time.Now(), p.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetPaymentsForReservation returns the payments made towards a reservation, oldest first
+func (m *postgresDBRepo) GetPaymentsForReservation(reservationID int) ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var payments []models.Payment
+
+	query := `select id, reservation_id, kind, amount, status, gateway, reference, created_at, updated_at 
+	from payments where reservation_id = $1 order by created_at, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return payments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Payment
+		err := rows.Scan(&p.ID, &p.ReservationID, &p.Kind, &p.Amount, &p.Status, &p.Gateway, &p.Reference,
+			&p.CreatedAt, &p.UpdatedAt)
+		if err != nil {
+			return payments, err
+		}
+		payments = append(payments, p)
+	}
+	if err = rows.Err(); err != nil {
+		return payments, err
+	}
+	return payments, nil
+}
+
+// UpdatePaymentStatusByReference sets the status of the payment a gateway knows by reference,
+// and returns false if there is no such payment
+func (m *postgresDBRepo) UpdatePaymentStatusByReference(gateway, reference, status string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update payments set status = $1, updated_at = $2 
+	where gateway = $3 and reference = $4 and kind <> 'refund'`
+
+	result, err := m.DB.ExecContext(ctx, query, status, time.Now(), gateway, reference)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// AllCancellationPolicies returns the cancellation policies of a property
+func (m *postgresDBRepo) AllCancellationPolicies(propertyID int) ([]models.CancellationPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var policies []models.CancellationPolicy
+
+	query := `select id, property_id, name, free_days, fee_percent, non_refundable, created_at, updated_at 
+	from cancellation_policies where property_id = $1 order by name`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyID)
+	if err != nil {
+		return policies, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.CancellationPolicy
+		err := rows.Scan(&c.ID, &c.PropertyID, &c.Name, &c.FreeDays, &c.FeePercent, &c.NonRefundable,
+			&c.CreatedAt, &c.UpdatedAt)
+		if err != nil {
+			return policies, err
+		}
+		policies = append(policies, c)
+	}
+	if err = rows.Err(); err != nil {
+		return policies, err
+	}
+	return policies, nil
+}
+
+// InsertCancellationPolicy inserts a cancellation policy into the database
+func (m *postgresDBRepo) InsertCancellationPolicy(c models.CancellationPolicy) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	query := `insert into cancellation_policies (property_id, name, free_days, fee_percent, non_refundable, 
+			created_at, updated_at) 
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		c.PropertyID, c.Name, c.FreeDays, c.FeePercent, c.NonRefundable, time.Now(), time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// DeleteCancellationPolicy deletes one of the cancellation policies of a property, rooms using it
+// are left without a policy
+func (m *postgresDBRepo) DeleteCancellationPolicy(propertyID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from cancellation_policies where property_id = $1 and id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, propertyID, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SetRoomCancellationPolicy attaches a cancellation policy of the room's own property to a room,
+// a policyID of 0 removes it
+func (m *postgresDBRepo) SetRoomCancellationPolicy(roomID, policyID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var query string
+	var args []any
+	if policyID == 0 {
+		query = `update rooms set cancellation_policy_id = null, updated_at = $1 where id = $2`
+		args = []any{time.Now(), roomID}
+	} else {
+		query = `update rooms r set cancellation_policy_id = c.id, updated_at = $1 
+		from cancellation_policies c 
+		where r.id = $2 and c.id = $3 and c.property_id = r.property_id`
+		args = []any{time.Now(), roomID, policyID}
+	}
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New("can't find room or cancellation policy")
+	}
+	return nil
+}
+
+// GetInvoiceForReservation returns the invoice in force for a reservation, the last one issued
+// that is not a credit note, or sql.ErrNoRows
+func (m *postgresDBRepo) GetInvoiceForReservation(reservationID int) (models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var inv models.Invoice
+	query := `select id, property_id, reservation_id, number, total, coalesce(credits_invoice_id, 0), pdf, 
+	created_at, updated_at 
+	from invoices where reservation_id = $1 and credits_invoice_id is null 
+	order by id desc limit 1`
+
+	err := m.DB.QueryRowContext(ctx, query, reservationID).Scan(
+		&inv.ID, &inv.PropertyID, &inv.ReservationID, &inv.Number, &inv.Total, &inv.CreditsInvoiceID, &inv.PDF,
+		&inv.CreatedAt, &inv.UpdatedAt,
+	)
+	if err != nil {
+		return inv, err
+	}
+	return inv, nil
+}
+
+// InsertInvoice takes the next invoice number of the property and stores the invoice with the
+// document render makes for that number, numbers are only used up by invoices that are stored
+func (m *postgresDBRepo) InsertInvoice(inv models.Invoice, render func(number int) []byte) (models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return inv, err
+	}
+	defer tx.Rollback()
+
+	inv, err = insertInvoice(ctx, tx, inv, render)
+	if err != nil {
+		return inv, err
+	}
+
+	return inv, tx.Commit()
+}
+
+// ReissueInvoice stores a credit note cancelling an invoice and the invoice issued in its place,
+// each numbered and rendered like InsertInvoice does, in one transaction. An invoice is only
+// credited once, reissuing one that already has been fails
+func (m *postgresDBRepo) ReissueInvoice(credit models.Invoice, renderCredit func(number int) []byte, inv models.Invoice, render func(number int) []byte) (models.Invoice, models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return credit, inv, err
+	}
+	defer tx.Rollback()
+
+	credit, err = insertInvoice(ctx, tx, credit, renderCredit)
+	if err != nil {
+		return credit, inv, err
+	}
+
+	inv, err = insertInvoice(ctx, tx, inv, render)
+	if err != nil {
+		return credit, inv, err
+	}
+
+	return credit, inv, tx.Commit()
+}
+
+// insertInvoice takes the next invoice number of the property and stores the invoice within tx
+func insertInvoice(ctx context.Context, tx *sql.Tx, inv models.Invoice, render func(number int) []byte) (models.Invoice, error) {
+	query := `update properties set next_invoice_number = next_invoice_number + 1 
+	where id = $1 returning next_invoice_number - 1`
+
+	err := tx.QueryRowContext(ctx, query, inv.PropertyID).Scan(&inv.Number)
+	if err != nil {
+		return inv, err
+	}
+
+	inv.PDF = render(inv.Number)
+	inv.CreatedAt = time.Now()
+	inv.UpdatedAt = inv.CreatedAt
+
+	query = `insert into invoices (property_id, reservation_id, number, total, credits_invoice_id, pdf, 
+	created_at, updated_at) 
+	values ($1, $2, $3, $4, nullif($5, 0), $6, $7, $8) returning id`
+
+	err = tx.QueryRowContext(ctx, query,
+		inv.PropertyID, inv.ReservationID, inv.Number, inv.Total, inv.CreditsInvoiceID, inv.PDF,
+		inv.CreatedAt, inv.UpdatedAt,
+	).Scan(&inv.ID)
+	if err != nil {
+		return inv, err
+	}
+
+	return inv, nil
+}
+
+// AllTaxFees returns the taxes and fees of a property
+func (m *postgresDBRepo) AllTaxFees(propertyID int) ([]models.TaxFee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var taxes []models.TaxFee
+
+	query := `select id, property_id, name, kind, amount, inclusive, start_date, end_date, created_at, updated_at 
+	from tax_fees where property_id = $1 order by start_date nulls first, name, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyID)
+	if err != nil {
+		return taxes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.TaxFee
+		var start, end sql.NullTime
+		err := rows.Scan(&t.ID, &t.PropertyID, &t.Name, &t.Kind, &t.Amount, &t.Inclusive, &start, &end,
+			&t.CreatedAt, &t.UpdatedAt)
+		if err != nil {
+			return taxes, err
+		}
+		t.StartDate = start.Time
+		t.EndDate = end.Time
+		taxes = append(taxes, t)
+	}
+	if err = rows.Err(); err != nil {
+		return taxes, err
+	}
+	return taxes, nil
+}
+
+// InsertTaxFee inserts a tax or fee into the database, zero dates are stored as null
+func (m *postgresDBRepo) InsertTaxFee(t models.TaxFee) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	query := `insert into tax_fees (property_id, name, kind, amount, inclusive, start_date, end_date, 
+			created_at, updated_at) 
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		t.PropertyID, t.Name, t.Kind, t.Amount, t.Inclusive,
+		sql.NullTime{Time: t.StartDate, Valid: !t.StartDate.IsZero()},
+		sql.NullTime{Time: t.EndDate, Valid: !t.EndDate.IsZero()},
+		time.Now(), time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// DeleteTaxFee deletes one of the taxes and fees of a property
+func (m *postgresDBRepo) DeleteTaxFee(propertyID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from tax_fees where property_id = $1 and id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, propertyID, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// promoCodeColumns are the columns scanPromoCode reads, promo codes are aliased p
+const promoCodeColumns = `p.id, p.property_id, p.code, p.kind, p.amount, p.valid_from, p.valid_until,
+	p.stay_from, p.stay_until, coalesce(p.room_id, 0), p.max_uses, p.max_uses_per_email,
+	p.created_at, p.updated_at`
+
+// scanPromoCode scans the promoCodeColumns, and any columns after them into extra
+func scanPromoCode(row interface{ Scan(...any) error }, extra ...any) (models.PromoCode, error) {
+	var p models.PromoCode
+	var validFrom, validUntil, stayFrom, stayUntil sql.NullTime
+
+	dest := []any{
+		&p.ID, &p.PropertyID, &p.Code, &p.Kind, &p.Amount, &validFrom, &validUntil,
+		&stayFrom, &stayUntil, &p.RoomID, &p.MaxUses, &p.MaxUsesPerEmail,
+		&p.CreatedAt, &p.UpdatedAt,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return p, err
+	}
+
+	p.ValidFrom = validFrom.Time
+	p.ValidUntil = validUntil.Time
+	p.StayFrom = stayFrom.Time
+	p.StayUntil = stayUntil.Time
+	return p, nil
+}
+
+// GetPromoCode returns a promo code of a property by its code, codes are stored upper case
+func (m *postgresDBRepo) GetPromoCode(propertyID int, code string) (models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + promoCodeColumns + ` from promo_codes p where p.property_id = $1 and p.code = $2`
+
+	return scanPromoCode(m.DB.QueryRowContext(ctx, query, propertyID, code))
+}
+
+// PromoCodeUses returns how many reservations that haven't been cancelled were made with a
+// promo code, in all and with the given email address
+func (m *postgresDBRepo) PromoCodeUses(promoCodeID int, email string) (int, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var uses, emailUses int
+	query := `
+		select 
+			count(*), count(*) filter (where lower(email) = lower($2))
+		from 
+			reservations 
+		where 
+			promo_code_id = $1 and cancelled_at is null`
+
+	err := m.DB.QueryRowContext(ctx, query, promoCodeID, email).Scan(&uses, &emailUses)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uses, emailUses, nil
+}
+
+// AllPromoCodes returns the promo codes of a property with their room and how much they were used
+func (m *postgresDBRepo) AllPromoCodes(propertyID int) ([]models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var codes []models.PromoCode
+
+	query := `
+		select 
+			` + promoCodeColumns + `, coalesce(rm.room_name, ''),
+			(select count(*) from reservations r where r.promo_code_id = p.id and r.cancelled_at is null),
+			(select coalesce(sum(r.discount), 0) from reservations r where r.promo_code_id = p.id and r.cancelled_at is null)
+		from 
+			promo_codes p
+			left join rooms rm on (rm.id = p.room_id)
+		where 
+			p.property_id = $1
+		order by p.code`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyID)
+	if err != nil {
+		return codes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roomName string
+		var uses, discounted int
+		p, err := scanPromoCode(rows, &roomName, &uses, &discounted)
+		if err != nil {
+			return codes, err
+		}
+		p.Room = models.Room{ID: p.RoomID, RoomName: roomName}
+		p.Uses = uses
+		p.Discounted = discounted
+		codes = append(codes, p)
+	}
+	if err = rows.Err(); err != nil {
+		return codes, err
+	}
+	return codes, nil
+}
+
+// InsertPromoCode inserts a promo code into the database, zero dates are stored as null
+func (m *postgresDBRepo) InsertPromoCode(p models.PromoCode) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	query := `insert into promo_codes (property_id, code, kind, amount, valid_from, valid_until, 
+			stay_from, stay_until, room_id, max_uses, max_uses_per_email, created_at, updated_at) 
+			values ($1, $2, $3, $4, $5, $6, $7, $8, nullif($9, 0), $10, $11, $12, $13) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		p.PropertyID, p.Code, p.Kind, p.Amount,
+		sql.NullTime{Time: p.ValidFrom, Valid: !p.ValidFrom.IsZero()},
+		sql.NullTime{Time: p.ValidUntil, Valid: !p.ValidUntil.IsZero()},
+		sql.NullTime{Time: p.StayFrom, Valid: !p.StayFrom.IsZero()},
+		sql.NullTime{Time: p.StayUntil, Valid: !p.StayUntil.IsZero()},
+		p.RoomID, p.MaxUses, p.MaxUsesPerEmail,
+		time.Now(), time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// DeletePromoCode deletes one of the promo codes of a property, reservations made with it keep
+// the code and discount they were given
+func (m *postgresDBRepo) DeletePromoCode(propertyID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from promo_codes where property_id = $1 and id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, propertyID, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AllExtras returns the extras a property sells
+func (m *postgresDBRepo) AllExtras(propertyID int) ([]models.Extra, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var list []models.Extra
+
+	query := `select id, property_id, name, description, pricing, price, stock, created_at, updated_at 
+	from extras where property_id = $1 order by name, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyID)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.Extra
+		err := rows.Scan(&e.ID, &e.PropertyID, &e.Name, &e.Description, &e.Pricing, &e.Price, &e.Stock,
+			&e.CreatedAt, &e.UpdatedAt)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, e)
+	}
+	if err = rows.Err(); err != nil {
+		return list, err
+	}
+	return list, nil
+}
+
+// InsertExtra inserts an extra into the database
+func (m *postgresDBRepo) InsertExtra(e models.Extra) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	query := `insert into extras (property_id, name, description, pricing, price, stock, created_at, updated_at) 
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		e.PropertyID, e.Name, e.Description, e.Pricing, e.Price, e.Stock, time.Now(), time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// DeleteExtra deletes one of the extras of a property, reservations keep the extras they booked
+func (m *postgresDBRepo) DeleteExtra(propertyID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from extras where property_id = $1 and id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, propertyID, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ExtraBooked returns how many of an extra are booked on the busiest night from start up to
+// end, by reservations that haven't been cancelled other than excludeReservationID
+func (m *postgresDBRepo) ExtraBooked(extraID int, start, end time.Time, excludeReservationID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var booked int
+	query := `
+		select 
+			coalesce(max(booked), 0)
+		from (
+			select 
+				n.night, sum(re.quantity) as booked
+			from 
+				generate_series($2::date, $3::date - 1, interval '1 day') as n(night)
+				join reservations r on (r.start_date <= n.night and r.end_date > n.night)
+				join reservation_extras re on (re.reservation_id = r.id)
+			where 
+				re.extra_id = $1 and r.cancelled_at is null and r.id <> $4
+			group by n.night
+		) nights`
+
+	err := m.DB.QueryRowContext(ctx, query, extraID, start, end, excludeReservationID).Scan(&booked)
+	if err != nil {
+		return 0, err
+	}
+	return booked, nil
+}
+
+// reservationExtraColumns are the columns scanReservationExtras reads
+const reservationExtraColumns = `id, reservation_id, coalesce(extra_id, 0), name, pricing, quantity, nights, 
+	unit_price, amount, created_at, updated_at`
+
+// scanReservationExtras scans rows of the reservationExtraColumns
+func scanReservationExtras(rows *sql.Rows) ([]models.ReservationExtra, error) {
+	var items []models.ReservationExtra
+	for rows.Next() {
+		var i models.ReservationExtra
+		err := rows.Scan(&i.ID, &i.ReservationID, &i.ExtraID, &i.Name, &i.Pricing, &i.Quantity, &i.Nights,
+			&i.UnitPrice, &i.Amount, &i.CreatedAt, &i.UpdatedAt)
+		if err != nil {
+			return items, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return items, err
+	}
+	return items, nil
+}
+
+// GetExtrasForReservation returns the extras booked on a reservation
+func (m *postgresDBRepo) GetExtrasForReservation(reservationID int) ([]models.ReservationExtra, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + reservationExtraColumns + ` from reservation_extras where reservation_id = $1 order by id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanReservationExtras(rows)
+}
+
+// AllReservationExtras returns the extras booked on every reservation of a property
+func (m *postgresDBRepo) AllReservationExtras(propertyID int) ([]models.ReservationExtra, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select ` + reservationExtraColumns + ` from reservation_extras 
+		where reservation_id in (
+			select r.id from reservations r join rooms rm on (rm.id = r.room_id) where rm.property_id = $1
+		)
+		order by reservation_id, id`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanReservationExtras(rows)
+}
+
+// SetReservationExtras replaces the extras booked on a reservation and sets its total
+func (m *postgresDBRepo) SetReservationExtras(reservationID, total int, items []models.ReservationExtra) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `delete from reservation_extras where reservation_id = $1`, reservationID)
+	if err != nil {
+		return err
+	}
+
+	err = insertReservationExtras(ctx, tx, reservationID, items)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set total = $1, updated_at = $2 where id = $3`,
+		total, time.Now(), reservationID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpsertGuest returns the id of the guest of a property with the email address of g, adding
+// them if they are new and updating their name and phone to those of g if they are not
+func (m *postgresDBRepo) UpsertGuest(g models.Guest) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	query := `
+		insert into guests (property_id, first_name, last_name, email, phone, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7)
+		on conflict (property_id, email) do update 
+			set first_name = excluded.first_name, last_name = excluded.last_name, 
+				phone = excluded.phone, updated_at = excluded.updated_at
+		returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		g.PropertyID, g.FirstName, g.LastName, guests.NormalizeEmail(g.Email), g.Phone, time.Now(), time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// guestColumns are the columns scanGuest reads, guests are aliased g. Stays and spend don't count
+// reservations that were cancelled, other than their fee.
+const guestColumns = `g.id, g.property_id, g.first_name, g.last_name, g.email, g.phone, g.notes, g.tags, 
+	g.created_at, g.updated_at,
+	(select count(*) from reservations r where r.guest_id = g.id and r.cancelled_at is null),
+	(select coalesce(sum(case when r.cancelled_at is null then r.total else r.cancellation_fee end), 0) 
+		from reservations r where r.guest_id = g.id),
+	(select max(r.start_date) from reservations r where r.guest_id = g.id and r.cancelled_at is null)`
+
+// scanGuest scans the guestColumns
+func scanGuest(row interface{ Scan(...any) error }) (models.Guest, error) {
+	var g models.Guest
+	var tags string
+	var lastStay sql.NullTime
+
+	err := row.Scan(&g.ID, &g.PropertyID, &g.FirstName, &g.LastName, &g.Email, &g.Phone, &g.Notes, &tags,
+		&g.CreatedAt, &g.UpdatedAt, &g.Stays, &g.Spend, &lastStay)
+	if err != nil {
+		return g, err
+	}
+
+	g.Tags = guests.ParseTags(tags)
+	g.LastStay = lastStay.Time
+	return g, nil
+}
+
+// AllGuests returns the guests of a property whose name, email or tags contain search, or all of
+// them when search is empty
+func (m *postgresDBRepo) AllGuests(propertyID int, search string) ([]models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var list []models.Guest
+
+	query := `
+		select ` + guestColumns + `
+		from 
+			guests g
+		where 
+			g.property_id = $1
+			and ($2 = '' or g.first_name || ' ' || g.last_name || ' ' || g.email || ' ' || g.tags ilike '%' || $2 || '%')
+		order by g.last_name, g.first_name, g.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyID, search)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		g, err := scanGuest(rows)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, g)
+	}
+	if err = rows.Err(); err != nil {
+		return list, err
+	}
+	return list, nil
+}
+
+// GetGuestByID returns a guest by id
+func (m *postgresDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + guestColumns + ` from guests g where g.id = $1`
+
+	return scanGuest(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetGuestByEmail returns the guest of a property with an email address
+func (m *postgresDBRepo) GetGuestByEmail(propertyID int, email string) (models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + guestColumns + ` from guests g where g.property_id = $1 and g.email = $2`
+
+	return scanGuest(m.DB.QueryRowContext(ctx, query, propertyID, guests.NormalizeEmail(email)))
+}
+
+// GetReservationsForGuest returns every reservation of a guest, latest arrival first
+func (m *postgresDBRepo) GetReservationsForGuest(guestID int) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+	query := `
+	select 
+		r.id, r.first_name, r.last_name, r.email, r.start_date, r.end_date, r.room_id, 
+		r.adults, r.children, r.total, r.processed, r.cancelled_at, r.cancellation_fee, r.manage_token,
+		r.created_at, rm.id, rm.room_name 
+	from 
+		reservations r left join rooms rm on (r.room_id = rm.id)
+	where 
+		r.guest_id = $1
+	order by r.start_date desc
+	`
+	rows, err := m.DB.QueryContext(ctx, query, guestID)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		var cancelledAt sql.NullTime
+		err := rows.Scan(
+			&i.ID, &i.FirstName, &i.LastName, &i.Email, &i.StartDate, &i.EndDate, &i.RoomID,
+			&i.Adults, &i.Children, &i.Total, &i.Processed, &cancelledAt, &i.CancellationFee, &i.ManageToken,
+			&i.CreatedAt, &i.Room.ID, &i.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		i.CancelledAt = cancelledAt.Time
+		i.GuestID = guestID
+		reservations = append(reservations, i)
+	}
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+// UpdateGuest updates the details, notes and tags of a guest
+func (m *postgresDBRepo) UpdateGuest(g models.Guest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update guests set first_name = $1, last_name = $2, phone = $3, notes = $4, tags = $5, 
+		updated_at = $6 where id = $7`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		g.FirstName, g.LastName, g.Phone, g.Notes, strings.Join(g.Tags, ","), time.Now(), g.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// MergeGuests moves the reservations of guest mergeID over to keep, saves keep and deletes the
+// merged guest
+func (m *postgresDBRepo) MergeGuests(keep models.Guest, mergeID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update reservations set guest_id = $1, updated_at = $2 where guest_id = $3`,
+		keep.ID, time.Now(), mergeID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update group_bookings set guest_id = $1, updated_at = $2 where guest_id = $3`,
+		keep.ID, time.Now(), mergeID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update guests set first_name = $1, last_name = $2, phone = $3, notes = $4, 
+		tags = $5, updated_at = $6 where id = $7`,
+		keep.FirstName, keep.LastName, keep.Phone, keep.Notes, strings.Join(keep.Tags, ","), time.Now(), keep.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from guests where id = $1`, mergeID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertLoginToken saves the hash of a token that logs in the account of email until expires
+func (m *postgresDBRepo) InsertLoginToken(email, tokenHash string, expires time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `insert into login_tokens (email, token_hash, expires_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err := m.DB.ExecContext(ctx, query, email, tokenHash, expires, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UseLoginToken marks the login token with the given hash used and returns its email, tokens
+// that are used or expired return sql.ErrNoRows
+func (m *postgresDBRepo) UseLoginToken(tokenHash string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var email string
+	query := `update login_tokens set used_at = $1, updated_at = $1
+		where token_hash = $2 and used_at is null and expires_at > $1
+		returning email`
+
+	err := m.DB.QueryRowContext(ctx, query, time.Now(), tokenHash).Scan(&email)
+	if err != nil {
+		return "", err
+	}
+	return email, nil
+}
+
+// InsertWaitlistEntry puts a guest on the waitlist and returns the id of their entry
+func (m *postgresDBRepo) InsertWaitlistEntry(e models.WaitlistEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	query := `insert into waitlist_entries (property_id, room_id, first_name, last_name, email, phone,
+		adults, children, start_date, end_date, status, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		e.PropertyID, e.RoomID, e.FirstName, e.LastName, e.Email, e.Phone, e.Adults, e.Children,
+		e.StartDate, e.EndDate, e.Status, time.Now(), time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// waitlistColumns are the columns scanWaitlistEntry reads, entries are aliased w and rooms rm
+const waitlistColumns = `w.id, w.property_id, w.room_id, w.first_name, w.last_name, w.email, w.phone, 
+	w.adults, w.children, w.start_date, w.end_date, w.status, w.token_hash, w.offered_at, w.offer_expires_at,
+	coalesce(w.hold_id, 0), coalesce(w.reservation_id, 0), w.created_at, w.updated_at, rm.id, rm.room_name`
+
+// scanWaitlistEntry scans the waitlistColumns
+func scanWaitlistEntry(row interface{ Scan(...any) error }) (models.WaitlistEntry, error) {
+	var e models.WaitlistEntry
+	var offeredAt, expiresAt sql.NullTime
+
+	err := row.Scan(&e.ID, &e.PropertyID, &e.RoomID, &e.FirstName, &e.LastName, &e.Email, &e.Phone,
+		&e.Adults, &e.Children, &e.StartDate, &e.EndDate, &e.Status, &e.TokenHash, &offeredAt, &expiresAt,
+		&e.HoldID, &e.ReservationID, &e.CreatedAt, &e.UpdatedAt, &e.Room.ID, &e.Room.RoomName)
+	if err != nil {
+		return e, err
+	}
+
+	e.OfferedAt = offeredAt.Time
+	e.OfferExpiresAt = expiresAt.Time
+	return e, nil
+}
+
+// listWaitlistEntries runs a query selecting the waitlistColumns
+func (m *postgresDBRepo) listWaitlistEntries(query string, args ...any) ([]models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var entries []models.WaitlistEntry
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanWaitlistEntry(rows)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+	return entries, nil
+}
+
+// AllWaitlistEntries returns the waitlist of a property, the entries still waiting or holding an
+// offer first in the order guests joined, then the rest latest first
+func (m *postgresDBRepo) AllWaitlistEntries(propertyID int) ([]models.WaitlistEntry, error) {
+	return m.listWaitlistEntries(`
+		select `+waitlistColumns+`
+		from 
+			waitlist_entries w left join rooms rm on (w.room_id = rm.id)
+		where 
+			w.property_id = $1
+		order by 
+			w.status in ('waiting', 'offered') desc,
+			case when w.status in ('waiting', 'offered') then w.created_at end asc,
+			w.updated_at desc, w.id`, propertyID)
+}
+
+// GetActiveWaitlist returns the entries of a property still waiting or holding an offer, in the
+// order guests joined the waitlist
+func (m *postgresDBRepo) GetActiveWaitlist(propertyID int) ([]models.WaitlistEntry, error) {
+	return m.listWaitlistEntries(`
+		select `+waitlistColumns+`
+		from 
+			waitlist_entries w left join rooms rm on (w.room_id = rm.id)
+		where 
+			w.property_id = $1 and w.status in ('waiting', 'offered')
+		order by w.created_at, w.id`, propertyID)
+}
+
+// GetWaitlistEntryByID returns a waitlist entry by id
+func (m *postgresDBRepo) GetWaitlistEntryByID(id int) (models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + waitlistColumns + ` 
+		from waitlist_entries w left join rooms rm on (w.room_id = rm.id) where w.id = $1`
+
+	return scanWaitlistEntry(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetWaitlistEntryByToken returns the waitlist entry offered with the token of the given hash
+func (m *postgresDBRepo) GetWaitlistEntryByToken(tokenHash string) (models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + waitlistColumns + ` 
+		from waitlist_entries w left join rooms rm on (w.room_id = rm.id) 
+		where w.token_hash = $1 and w.token_hash <> ''`
+
+	return scanWaitlistEntry(m.DB.QueryRowContext(ctx, query, tokenHash))
+}
+
+// UpdateWaitlistEntry saves the status, offer, hold and reservation of a waitlist entry
+func (m *postgresDBRepo) UpdateWaitlistEntry(e models.WaitlistEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update waitlist_entries set status = $1, token_hash = $2, offered_at = $3, 
+		offer_expires_at = $4, hold_id = nullif($5, 0), reservation_id = nullif($6, 0), updated_at = $7 
+		where id = $8`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		e.Status, e.TokenHash,
+		sql.NullTime{Time: e.OfferedAt, Valid: !e.OfferedAt.IsZero()},
+		sql.NullTime{Time: e.OfferExpiresAt, Valid: !e.OfferExpiresAt.IsZero()},
+		e.HoldID, e.ReservationID, time.Now(), e.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// InsertGroupBooking inserts a group booking with a reservation for each of its stays, giving
+// every stay a free unit of its room. Either all the stays are booked or none are, and
+// repository.ErrUnavailable is returned when a stay can't be given a unit.
+func (m *postgresDBRepo) InsertGroupBooking(g models.GroupBooking) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var groupID int
+	stmt := `insert into group_bookings (property_id, reference, guest_id, first_name, last_name, email, phone, 
+		created_at, updated_at)
+	values ($1, $2, nullif($3, 0), $4, $5, $6, $7, $8, $9) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt, g.PropertyID, g.Reference, g.GuestID, g.FirstName, g.LastName,
+		g.Email, g.Phone, time.Now(), time.Now()).Scan(&groupID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, res := range g.Reservations {
+		// stays booked earlier in the transaction count, so two stays never share a unit
+		var unitID int
+		err = tx.QueryRowContext(ctx, availableUnitQuery, res.RoomID, res.StartDate, res.EndDate,
+			time.Now()).Scan(&unitID)
+		if err == sql.ErrNoRows {
+			return 0, repository.ErrUnavailable
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		// the unit is only free if nobody else claimed it since it was picked
+		err = lockUnit(ctx, tx, unitID)
+		if err != nil {
+			return 0, err
+		}
+
+		res.GroupBookingID = groupID
+		resID, err := insertReservation(ctx, tx, res)
+		if err != nil {
+			return 0, err
+		}
+
+		// the unit of a stay waiting to be paid for is released with it when it runs out
+		_, err = claimUnit(ctx, tx, models.RoomRestriction{
+			StartDate:     res.StartDate,
+			EndDate:       res.EndDate,
+			RoomUnitID:    unitID,
+			ReservationID: resID,
+			RestrictionID: models.RestrictionReservation,
+			ExpiresAt:     res.PendingUntil,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return groupID, tx.Commit()
+}
+
+// groupBookingColumns are the columns scanGroupBooking reads, summing up the stays of the group
+// booking, which queries have to join as r and group by g.id
+const groupBookingColumns = `g.id, g.property_id, g.reference, coalesce(g.guest_id, 0), g.first_name, 
+	g.last_name, g.email, g.phone, g.created_at, g.updated_at, count(r.id), 
+	coalesce(sum(r.total) filter (where r.cancelled_at is null), 0), min(r.start_date), max(r.end_date)`
+
+// scanGroupBooking scans a row of groupBookingColumns
+func scanGroupBooking(row interface{ Scan(...any) error }) (models.GroupBooking, error) {
+	var g models.GroupBooking
+	var startDate, endDate sql.NullTime
+
+	err := row.Scan(&g.ID, &g.PropertyID, &g.Reference, &g.GuestID, &g.FirstName, &g.LastName, &g.Email,
+		&g.Phone, &g.CreatedAt, &g.UpdatedAt, &g.Rooms, &g.Total, &startDate, &endDate)
+	if err != nil {
+		return g, err
+	}
+
+	g.StartDate = startDate.Time
+	g.EndDate = endDate.Time
+	return g, nil
+}
+
+// AllGroupBookings returns the group bookings of a property, latest arrivals first
+func (m *postgresDBRepo) AllGroupBookings(propertyID int) ([]models.GroupBooking, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var groups []models.GroupBooking
+
+	query := `select ` + groupBookingColumns + `
+	from group_bookings g left join reservations r on (r.group_booking_id = g.id)
+	where g.property_id = $1
+	group by g.id
+	order by min(r.start_date) desc nulls last, g.id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyID)
+	if err != nil {
+		return groups, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		g, err := scanGroupBooking(rows)
+		if err != nil {
+			return groups, err
+		}
+		groups = append(groups, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return groups, err
+	}
+
+	return groups, nil
+}
+
+// GetGroupBookingByID returns a group booking with the reservations of its stays
+func (m *postgresDBRepo) GetGroupBookingByID(id int) (models.GroupBooking, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + groupBookingColumns + `
+	from group_bookings g left join reservations r on (r.group_booking_id = g.id)
+	where g.id = $1
+	group by g.id`
+
+	g, err := scanGroupBooking(m.DB.QueryRowContext(ctx, query, id))
+	if err != nil {
+		return g, err
+	}
+
+	query = `
+	select 
+		r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, 
+		r.adults, r.children, r.total, r.processed, r.manage_token, r.cancellation_policy, 
+		r.cancellation_free_days, r.cancellation_fee_percent, r.cancellation_non_refundable, 
+		r.cancelled_at, coalesce(r.guest_id, 0), r.pending_until, rm.id, rm.room_name, rm.property_id, 
+		coalesce(u.id, 0), coalesce(u.name, '')
+	from 
+		reservations r 
+		left join rooms rm on (r.room_id = rm.id)
+		left join room_restrictions rr on (rr.reservation_id = r.id)
+		left join room_units u on (rr.room_unit_id = u.id)
+	where 
+		r.group_booking_id = $1
+	order by r.start_date, r.id
+	`
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return g, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		var cancelledAt, pendingUntil sql.NullTime
+		err := rows.Scan(
+			&i.ID, &i.FirstName, &i.LastName, &i.Email, &i.Phone, &i.StartDate, &i.EndDate, &i.RoomID,
+			&i.Adults, &i.Children, &i.Total, &i.Processed, &i.ManageToken, &i.CancellationPolicy.Name,
+			&i.CancellationPolicy.FreeDays, &i.CancellationPolicy.FeePercent,
+			&i.CancellationPolicy.NonRefundable, &cancelledAt, &i.GuestID, &pendingUntil, &i.Room.ID,
+			&i.Room.RoomName, &i.Room.PropertyID, &i.RoomUnit.ID, &i.RoomUnit.Name,
+		)
+		if err != nil {
+			return g, err
+		}
+		i.CancelledAt = cancelledAt.Time
+		i.PendingUntil = pendingUntil.Time
+		i.RoomUnitID = i.RoomUnit.ID
+		i.GroupBookingID = g.ID
+		g.Reservations = append(g.Reservations, i)
+	}
+	if err = rows.Err(); err != nil {
+		return g, err
+	}
+	return g, nil
+}
+
+// webhookSubscriptionColumns are the columns scanWebhookSubscription reads, subscriptions are
+// aliased s
+const webhookSubscriptionColumns = `s.id, s.property_id, s.url, s.secret, s.events, s.active, 
+	s.created_at, s.updated_at`
+
+// scanWebhookSubscription scans the webhookSubscriptionColumns, the events are stored comma
+// separated
+func scanWebhookSubscription(row interface{ Scan(...any) error }) (models.WebhookSubscription, error) {
+	var s models.WebhookSubscription
+	var events string
+
+	err := row.Scan(&s.ID, &s.PropertyID, &s.URL, &s.Secret, &events, &s.Active, &s.CreatedAt, &s.UpdatedAt)
+	if err != nil {
+		return s, err
+	}
+
+	if events != "" {
+		s.Events = strings.Split(events, ",")
+	}
+	return s, nil
+}
+
+// AllWebhookSubscriptions returns the webhook subscriptions of a property
+func (m *postgresDBRepo) AllWebhookSubscriptions(propertyID int) ([]models.WebhookSubscription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var subscriptions []models.WebhookSubscription
+
+	query := `select ` + webhookSubscriptionColumns + ` from webhook_subscriptions s 
+		where s.property_id = $1 order by s.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyID)
+	if err != nil {
+		return subscriptions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := scanWebhookSubscription(rows)
+		if err != nil {
+			return subscriptions, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	if err = rows.Err(); err != nil {
+		return subscriptions, err
+	}
+	return subscriptions, nil
+}
+
+// GetWebhookSubscriptionByID returns a webhook subscription by id
+func (m *postgresDBRepo) GetWebhookSubscriptionByID(id int) (models.WebhookSubscription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + webhookSubscriptionColumns + ` from webhook_subscriptions s where s.id = $1`
+
+	return scanWebhookSubscription(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// InsertWebhookSubscription inserts a webhook subscription and returns its id
+func (m *postgresDBRepo) InsertWebhookSubscription(s models.WebhookSubscription) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	query := `insert into webhook_subscriptions (property_id, url, secret, events, active, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		s.PropertyID, s.URL, s.Secret, strings.Join(s.Events, ","), s.Active, time.Now(), time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// UpdateWebhookSubscription saves the URL, events and whether a webhook subscription is active
+func (m *postgresDBRepo) UpdateWebhookSubscription(s models.WebhookSubscription) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update webhook_subscriptions set url = $1, events = $2, active = $3, updated_at = $4 
+		where id = $5`
+
+	_, err := m.DB.ExecContext(ctx, query, s.URL, strings.Join(s.Events, ","), s.Active, time.Now(), s.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteWebhookSubscription deletes one of the webhook subscriptions of a property with its
+// deliveries
+func (m *postgresDBRepo) DeleteWebhookSubscription(propertyID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from webhook_subscriptions where property_id = $1 and id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, propertyID, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// InsertWebhookDelivery inserts a delivery of an event to a webhook subscription and returns
+// its id
+func (m *postgresDBRepo) InsertWebhookDelivery(d models.WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	query := `insert into webhook_deliveries (subscription_id, event, payload, next_attempt_at, 
+		created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		d.SubscriptionID, d.Event, d.Payload,
+		sql.NullTime{Time: d.NextAttemptAt, Valid: !d.NextAttemptAt.IsZero()},
+		time.Now(), time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// UpdateWebhookDelivery saves the outcome of the latest attempt at a webhook delivery
+func (m *postgresDBRepo) UpdateWebhookDelivery(d models.WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update webhook_deliveries set attempts = $1, status_code = $2, error = $3, 
+		last_attempt_at = $4, next_attempt_at = $5, delivered_at = $6, updated_at = $7 where id = $8`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		d.Attempts, d.StatusCode, d.Error,
+		sql.NullTime{Time: d.LastAttemptAt, Valid: !d.LastAttemptAt.IsZero()},
+		sql.NullTime{Time: d.NextAttemptAt, Valid: !d.NextAttemptAt.IsZero()},
+		sql.NullTime{Time: d.DeliveredAt, Valid: !d.DeliveredAt.IsZero()},
+		time.Now(), d.ID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ClaimWebhookDelivery claims a delivery that is due at now for one attempt by putting its next
+// attempt off until until. It reports false when the delivery isn't due, because it was
+// delivered or is claimed by another attempt.
+func (m *postgresDBRepo) ClaimWebhookDelivery(id int, now, until time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update webhook_deliveries set next_attempt_at = $1, updated_at = $2 
+		where id = $3 and delivered_at is null and next_attempt_at <= $2`
+
+	result, err := m.DB.ExecContext(ctx, query, until, now, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// webhookDeliveryColumns are the columns scanWebhookDelivery reads, deliveries are aliased d
+// and their subscriptions s
+const webhookDeliveryColumns = `d.id, d.subscription_id, d.event, d.payload, d.attempts, d.status_code, 
+	d.error, d.last_attempt_at, d.next_attempt_at, d.delivered_at, d.created_at, d.updated_at, ` +
+	webhookSubscriptionColumns
+
+// scanWebhookDelivery scans the webhookDeliveryColumns
+func scanWebhookDelivery(row interface{ Scan(...any) error }) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	var lastAttemptAt, nextAttemptAt, deliveredAt sql.NullTime
+	var events string
+
+	err := row.Scan(&d.ID, &d.SubscriptionID, &d.Event, &d.Payload, &d.Attempts, &d.StatusCode,
+		&d.Error, &lastAttemptAt, &nextAttemptAt, &deliveredAt, &d.CreatedAt, &d.UpdatedAt,
+		&d.Subscription.ID, &d.Subscription.PropertyID, &d.Subscription.URL, &d.Subscription.Secret,
+		&events, &d.Subscription.Active, &d.Subscription.CreatedAt, &d.Subscription.UpdatedAt)
+	if err != nil {
+		return d, err
+	}
+
+	d.LastAttemptAt = lastAttemptAt.Time
+	d.NextAttemptAt = nextAttemptAt.Time
+	d.DeliveredAt = deliveredAt.Time
+	if events != "" {
+		d.Subscription.Events = strings.Split(events, ",")
+	}
+	return d, nil
+}
+
+// listWebhookDeliveries runs a query selecting the webhookDeliveryColumns
+func (m *postgresDBRepo) listWebhookDeliveries(query string, args ...any) ([]models.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var deliveries []models.WebhookDelivery
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		return deliveries, err
+	}
+	return deliveries, nil
+}
+
+// GetWebhookDeliveryByID returns a webhook delivery by id with its subscription
+func (m *postgresDBRepo) GetWebhookDeliveryByID(id int) (models.WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select ` + webhookDeliveryColumns + ` 
+		from webhook_deliveries d left join webhook_subscriptions s on (d.subscription_id = s.id) 
+		where d.id = $1`
+
+	return scanWebhookDelivery(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetWebhookDeliveriesForSubscription returns the latest 100 deliveries to a webhook
+// subscription, latest first
+func (m *postgresDBRepo) GetWebhookDeliveriesForSubscription(subscriptionID int) ([]models.WebhookDelivery, error) {
+	return m.listWebhookDeliveries(`
+		select `+webhookDeliveryColumns+`
+		from 
+			webhook_deliveries d left join webhook_subscriptions s on (d.subscription_id = s.id)
+		where 
+			d.subscription_id = $1
+		order by d.created_at desc, d.id desc
+		limit 100`, subscriptionID)
+}
+
+// DueWebhookDeliveries returns the deliveries to active subscriptions that haven't gone through
+// yet and are due an attempt at now, oldest first
+func (m *postgresDBRepo) DueWebhookDeliveries(now time.Time) ([]models.WebhookDelivery, error) {
+	return m.listWebhookDeliveries(`
+		select `+webhookDeliveryColumns+`
+		from 
+			webhook_deliveries d left join webhook_subscriptions s on (d.subscription_id = s.id)
+		where 
+			d.delivered_at is null and d.next_attempt_at <= $1 and s.active
+		order by d.next_attempt_at, d.id`, now)
+}
+
+// GetChannelMappings returns the ids a channel knows the rooms of a property by
+func (m *postgresDBRepo) GetChannelMappings(propertyID int, channel string) ([]models.ChannelMapping, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var mappings []models.ChannelMapping
+
+	query := `select id, property_id, channel, room_id, external_room_id, created_at, updated_at 
+		from channel_room_mappings where property_id = $1 and channel = $2 order by room_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyID, channel)
+	if err != nil {
+		return mappings, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var x models.ChannelMapping
+		err := rows.Scan(&x.ID, &x.PropertyID, &x.Channel, &x.RoomID, &x.ExternalRoomID, &x.CreatedAt, &x.UpdatedAt)
+		if err != nil {
+			return mappings, err
+		}
+		mappings = append(mappings, x)
+	}
+	if err = rows.Err(); err != nil {
+		return mappings, err
+	}
+	return mappings, nil
+}
+
+// GetChannelPropertyIDs returns the ids of the properties with rooms mapped to a channel
+func (m *postgresDBRepo) GetChannelPropertyIDs(channel string) ([]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var ids []int
+
+	rows, err := m.DB.QueryContext(ctx,
+		`select distinct property_id from channel_room_mappings where channel = $1 order by property_id`, channel)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return ids, err
+	}
+	return ids, nil
+}
+
+// SaveChannelMapping sets the id a channel knows a room by
+func (m *postgresDBRepo) SaveChannelMapping(x models.ChannelMapping) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `insert into channel_room_mappings (property_id, channel, room_id, external_room_id, 
+		created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6)
+		on conflict (channel, room_id) do update 
+			set external_room_id = excluded.external_room_id, updated_at = excluded.updated_at`
+
+	_, err := m.DB.ExecContext(ctx, query, x.PropertyID, x.Channel, x.RoomID, x.ExternalRoomID,
+		time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteChannelMapping stops a room of a property being synced with a channel
+func (m *postgresDBRepo) DeleteChannelMapping(propertyID int, channel string, roomID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from channel_room_mappings where property_id = $1 and channel = $2 and room_id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, propertyID, channel, roomID)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetReservationIDByChannelReference returns the id of the reservation imported from a channel
+// booking, or 0 if it hasn't been imported
+func (m *postgresDBRepo) GetReservationIDByChannelReference(channel, reference string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	query := `select id from reservations where channel = $1 and channel_reference = $2 limit 1`
+
+	err := m.DB.QueryRowContext(ctx, query, channel, reference).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// ChannelConflictRecorded reports whether a channel booking has already been logged as a
+// conflict, so staff are only told about it once
+func (m *postgresDBRepo) ChannelConflictRecorded(channel, reference string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var recorded bool
+	query := `select exists (select 1 from channel_sync_logs 
+		where channel = $1 and external_reference = $2 and status = $3)`
+
+	err := m.DB.QueryRowContext(ctx, query, channel, reference, channels.StatusConflict).Scan(&recorded)
+	if err != nil {
+		return false, err
+	}
+	return recorded, nil
+}
+
+// InsertChannelSyncLog adds an entry to the log of syncs with a channel and returns its id
+func (m *postgresDBRepo) InsertChannelSyncLog(l models.ChannelSyncLog) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	query := `insert into channel_sync_logs (property_id, channel, direction, status, message, 
+		external_reference, reservation_id, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, nullif($7, 0), $8, $9) returning id`
+
+	err := m.DB.QueryRowContext(ctx, query,
+		l.PropertyID, l.Channel, l.Direction, l.Status, l.Message, l.ExternalReference, l.ReservationID,
+		time.Now(), time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// GetChannelSyncLogs returns the latest 100 entries of the log of syncs of a property with a
+// channel, latest first
+func (m *postgresDBRepo) GetChannelSyncLogs(propertyID int, channel string) ([]models.ChannelSyncLog, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var logs []models.ChannelSyncLog
+
+	query := `select id, property_id, channel, direction, status, message, external_reference, 
+		coalesce(reservation_id, 0), created_at, updated_at
+		from channel_sync_logs 
+		where property_id = $1 and channel = $2 
+		order by created_at desc, id desc
+		limit 100`
+
+	rows, err := m.DB.QueryContext(ctx, query, propertyID, channel)
+	if err != nil {
+		return logs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l models.ChannelSyncLog
+		err := rows.Scan(&l.ID, &l.PropertyID, &l.Channel, &l.Direction, &l.Status, &l.Message,
+			&l.ExternalReference, &l.ReservationID, &l.CreatedAt, &l.UpdatedAt)
+		if err != nil {
+			return logs, err
+		}
+		logs = append(logs, l)
+	}
+	if err = rows.Err(); err != nil {
+		return logs, err
+	}
+	return logs, nil
+}
+
+// LastChannelPull returns when reservations were last pulled from a channel for a property
+// without an error, which is the zero time if they never were
+func (m *postgresDBRepo) LastChannelPull(propertyID int, channel string) (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var last sql.NullTime
+	query := `select max(created_at) from channel_sync_logs 
+		where property_id = $1 and channel = $2 and direction = 'pull' and status = 'ok' 
+		and external_reference = ''`
+
+	err := m.DB.QueryRowContext(ctx, query, propertyID, channel).Scan(&last)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return last.Time, nil
+}
@@ -1,11 +1,15 @@
 package dbrepo
 
 import (
+	"crypto/sha256"
+	"database/sql"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
 	"github.com/RakhmanovTimur/bookings/internal/models"
+	"github.com/RakhmanovTimur/bookings/internal/repository"
 )
 
 func (m *testDBRepo) AllUsers() bool {
@@ -21,6 +25,16 @@ func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
 	return 1, nil
 }
 
+// InsertReservationWithRestriction inserts a reservation and the room restriction that gives it
+// its unit. Like InsertReservation room 2 can't be saved, and stays from 2055-01-01 lost their
+// unit to someone else.
+func (m *testDBRepo) InsertReservationWithRestriction(res models.Reservation, holdID int) (int, error) {
+	if res.StartDate.Equal(time.Date(2055, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return 0, repository.ErrUnavailable
+	}
+	return m.InsertReservation(res)
+}
+
 // InsertRoomRestrictions inserts a room restriction into the database
 func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	if r.RoomID == 1000 {
@@ -55,8 +69,29 @@ func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(roomID int, start, end ti
 	return true, nil
 }
 
+// SearchAvailabilityByDatesByUnitIDExcludingReservation returns true if the unit is free for the
+// whole stay, ignoring the restriction that belongs to the given reservation
+func (m *testDBRepo) SearchAvailabilityByDatesByUnitIDExcludingReservation(unitID, reservationID int, start, end time.Time) (bool, error) {
+	return m.SearchAvailabilityByDatesByRoomID(unitID, start, end)
+}
+
+// SearchAvailabilityByDatesByUnitIDExcludingRestriction returns true if the unit is free for the
+// whole stay, ignoring the given room restriction
+func (m *testDBRepo) SearchAvailabilityByDatesByUnitIDExcludingRestriction(unitID, restrictionID int, start, end time.Time) (bool, error) {
+	return m.SearchAvailabilityByDatesByRoomID(unitID, start, end)
+}
+
+// FindAvailableUnit returns the id of a unit of roomID that is free for the whole stay, or 0
+func (m *testDBRepo) FindAvailableUnit(roomID int, start, end time.Time) (int, error) {
+	available, err := m.SearchAvailabilityByDatesByRoomID(roomID, start, end)
+	if err != nil || !available {
+		return 0, err
+	}
+	return roomID, nil
+}
+
 // SearchAvailabilityForAllRooms returns a slice of available rooms if any, for given date range
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvailabilityForAllRooms(propertyID, guests int, start, end time.Time) ([]models.Room, error) {
 	var rooms []models.Room
 	var room models.Room
 
@@ -67,7 +102,7 @@ func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mode
 		log.Println(err)
 	}
 
-	if start.After(t) {
+	if start.After(t) || guests > 2 {
 		return rooms, nil
 	}
 
@@ -77,6 +112,27 @@ func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mode
 	return rooms, nil
 }
 
+// GetNightlyAvailability returns the free units per room and night, room 1 has one free unit
+// on every night before 2099-01-05 and none after
+func (m *testDBRepo) GetNightlyAvailability(propertyID, guests int, start, end time.Time) ([]models.RoomNight, error) {
+	var nights []models.RoomNight
+	if guests > 2 {
+		return nights, nil
+	}
+
+	lastFree := time.Date(2099, 1, 5, 0, 0, 0, 0, time.UTC)
+	room := models.Room{ID: 1, RoomName: "Secret HQ", Price: 10000, MaxOccupancy: 2, IncludedGuests: 2}
+
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		x := models.RoomNight{Room: room, Night: d}
+		if d.Before(lastFree) {
+			x.Free = 1
+		}
+		nights = append(nights, x)
+	}
+	return nights, nil
+}
+
 // Gets a room by ID
 func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 	var room models.Room
@@ -85,48 +141,196 @@ func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 		return room, errors.New("can't find a room")
 	}
 
+	room.ID = id
+	room.Price = 10000
+	room.MaxOccupancy = 2
+	room.IncludedGuests = 2
+
 	return room, nil
 
 }
 
+// GetUserByID returns a user by id, user 1 is an admin, user 2 has a guest account and user 4
+// registered with the email of a guest who has stays without confirming it
 func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
-	var u models.User
-	return u, nil
+	switch id {
+	case 1:
+		return models.User{ID: 1, FirstName: "Sad", Email: "sad@me.com", AccessLevel: models.AccessLevelAdmin}, nil
+	case 2:
+		return models.User{ID: 2, FirstName: "John", LastName: "Smith", Email: "guest@me.com", Phone: "555-1234",
+			AccessLevel: models.AccessLevelGuest, EmailVerifiedAt: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)}, nil
+	case 4:
+		return models.User{ID: 4, FirstName: "Eve", LastName: "Smith", Email: "victim@me.com", Phone: "555-6666",
+			AccessLevel: models.AccessLevelGuest}, nil
+	}
+	return models.User{}, sql.ErrNoRows
 }
 
+// UpdateUser updates a user in the database
 func (m *testDBRepo) UpdateUser(u models.User) error {
+	if u.FirstName == "invalid" {
+		return errors.New("invalid name when trying to update user")
+	}
 	return nil
+}
 
+// RemoveUserPassword removes a user's password
+func (m *testDBRepo) RemoveUserPassword(id int) error {
+	return nil
 }
 
 func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	if email == "sad@me.com" {
+	switch email {
+	case "sad@me.com":
 		return 1, "", nil
+	case "guest@me.com":
+		return 2, "", nil
+	case "victim@me.com":
+		return 4, "", nil
 	}
 	return 0, "", errors.New("invalid login information")
 }
 
-func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
+// GetUserByEmail returns a user by email
+func (m *testDBRepo) GetUserByEmail(email string) (models.User, error) {
+	switch email {
+	case "sad@me.com":
+		return m.GetUserByID(1)
+	case "guest@me.com":
+		return m.GetUserByID(2)
+	case "victim@me.com":
+		return m.GetUserByID(4)
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+// InsertUser inserts a user into the database, "nobody@fails.com" can't be saved
+func (m *testDBRepo) InsertUser(u models.User, password string) (int, error) {
+	if u.Email == "nobody@fails.com" {
+		return 0, errors.New("invalid email when trying to insert user")
+	}
+	return 3, nil
+}
+
+// InsertLoginToken saves the hash of a login token, "nobody@fails.com" can't be saved
+func (m *testDBRepo) InsertLoginToken(email, tokenHash string, expires time.Time) error {
+	if email == "nobody@fails.com" {
+		return errors.New("invalid email when trying to insert login token")
+	}
+	return nil
+}
+
+// UseLoginToken returns the email of a login token, "guest-token" logs in guest@me.com,
+// "new-token" an email without an account, "staff-token" a staff member and "victim-token" the
+// unconfirmed account of victim@me.com
+func (m *testDBRepo) UseLoginToken(tokenHash string) (string, error) {
+	for token, email := range map[string]string{
+		"guest-token":  "guest@me.com",
+		"victim-token": "victim@me.com",
+		"new-token":    "new@guest.com",
+		"staff-token":  "sad@me.com",
+	} {
+		if tokenHash == fmt.Sprintf("%x", sha256.Sum256([]byte(token))) {
+			return email, nil
+		}
+	}
+	return "", sql.ErrNoRows
+}
+
+func (m *testDBRepo) AllReservations(propertyID int) ([]models.Reservation, error) {
 
 	var reservations []models.Reservation
+	reservations = append(reservations, models.Reservation{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 6, 11, 0, 0, 0, 0, time.UTC),
+		Adults:    2,
+		Total:     12400,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+	})
 
 	return reservations, nil
 }
 
 // AllNewReservations returns a slice of new reservations
-func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
+func (m *testDBRepo) AllNewReservations(propertyID int) ([]models.Reservation, error) {
 	var reservations []models.Reservation
 
 	return reservations, nil
 }
 
-// GetReservationByID gets reservation by id
+// GetReservationByID gets reservation by id, every reservation is a night in June 2050 by guest 1.
+// Reservation 7 is at property 2 and reservation 8 has been cancelled.
 func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
 	var res models.Reservation
+	res.ID = id
+	res.StartDate = time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC)
+	res.EndDate = time.Date(2050, 6, 11, 0, 0, 0, 0, time.UTC)
+	res.GuestID = 1
+	res.GroupBookingID = 1
+	switch id {
+	case 7:
+		res.Room.PropertyID = 2
+	case 8:
+		res.CancelledAt = time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+		res.CancelledBy = "admin"
+	}
 	return res, nil
 }
 
-// UpdateReservation updates a user in the database
+// GetReservationByToken gets a reservation by the token its guest manages it with, "upcoming" is
+// a stay in June 2050 under a flexible policy, "cancelled" has been cancelled and "arrived" is
+// a past stay
+func (m *testDBRepo) GetReservationByToken(token string) (models.Reservation, error) {
+	res := models.Reservation{
+		ID:          1,
+		FirstName:   "John",
+		LastName:    "Smith",
+		Email:       "john@smith.com",
+		StartDate:   time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:     time.Date(2050, 6, 12, 0, 0, 0, 0, time.UTC),
+		RoomID:      1,
+		Total:       10000,
+		ManageToken: token,
+		CancellationPolicy: models.CancellationPolicy{
+			Name:       "Flexible",
+			FreeDays:   7,
+			FeePercent: 50,
+		},
+	}
+	res.Room.ID = 1
+
+	switch token {
+	case "upcoming":
+	case "cancelled":
+		res.CancelledAt = time.Date(2049, 1, 1, 0, 0, 0, 0, time.UTC)
+		res.CancelledBy = "guest"
+	case "arrived":
+		res.StartDate = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		res.EndDate = time.Date(2000, 1, 3, 0, 0, 0, 0, time.UTC)
+	default:
+		return models.Reservation{}, errors.New("can't find reservation")
+	}
+	return res, nil
+}
+
+// CancelReservation records the cancellation of a reservation and frees its room
+func (m *testDBRepo) CancelReservation(res models.Reservation) error {
+	if res.ID == 1000 {
+		return errors.New("invalid reservation id when trying to cancel reservation")
+	}
+	return nil
+}
+
+// ConfirmReservation confirms a reservation waiting to be paid for, reservation 4 ran out first
+func (m *testDBRepo) ConfirmReservation(id int, now time.Time) (bool, error) {
+	return id != 4, nil
+}
+
+// UpdateReservation updates a reservation and its room restriction in the database
 func (m *testDBRepo) UpdateReservation(u models.Reservation) error {
 	return nil
 }
@@ -142,7 +346,7 @@ func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
 }
 
 // AllRooms returns all rooms in the database
-func (m *testDBRepo) AllRooms() ([]models.Room, error) {
+func (m *testDBRepo) AllRooms(propertyID int) ([]models.Room, error) {
 
 	var rooms []models.Room
 
@@ -150,15 +354,16 @@ func (m *testDBRepo) AllRooms() ([]models.Room, error) {
 
 }
 
-func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+// GetRestrictionsForUnitByDate returns restrictions for a room unit by date range
+func (m *testDBRepo) GetRestrictionsForUnitByDate(unitID int, start, end time.Time) ([]models.RoomRestriction, error) {
 	var restrictions []models.RoomRestriction
 	return restrictions, nil
 }
 
-// InsertBlockForRoom inserts a room restriction for block
-func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
-
-	return nil
+// InsertBlockForUnit inserts a room restriction for a one night block of a room unit and returns
+// its id
+func (m *testDBRepo) InsertBlockForUnit(unitID int, startDate time.Time) (int, error) {
+	return 1, nil
 }
 
 // DeleteBlockByID inserts a room restriction for block
@@ -166,3 +371,790 @@ func (m *testDBRepo) DeleteBlockByID(id int) error {
 
 	return nil
 }
+
+// GetRestrictionsByDate returns restrictions for all rooms by date range
+func (m *testDBRepo) GetRestrictionsByDate(propertyID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	var restrictions []models.RoomRestriction
+	return restrictions, nil
+}
+
+// GetRoomRestrictionByID returns a room restriction by id
+func (m *testDBRepo) GetRoomRestrictionByID(id int) (models.RoomRestriction, error) {
+	var r models.RoomRestriction
+	if id == 10 || id == 11 {
+		// a hold of room 1 from 2050-06-10 to 2050-06-12, run out when the id is 11
+		r.ID = id
+		r.RoomID = 1
+		r.RoomUnitID = 1
+		r.RestrictionID = models.RestrictionHold
+		r.StartDate = time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC)
+		r.EndDate = time.Date(2050, 6, 12, 0, 0, 0, 0, time.UTC)
+		r.ExpiresAt = time.Now().Add(10 * time.Minute)
+		if id == 11 {
+			r.ExpiresAt = time.Now().Add(-time.Minute)
+		}
+		return r, nil
+	}
+	if id > 2 {
+		return r, errors.New("can't find restriction")
+	}
+	r.ID = id
+	r.RoomID = 1
+	r.RoomUnitID = 1
+	r.RestrictionID = id
+	if id == 1 {
+		r.ReservationID = 1
+	}
+	return r, nil
+}
+
+// InsertBlockForUnitByDates inserts a room restriction for a block of a room unit spanning the given dates
+func (m *testDBRepo) InsertBlockForUnitByDates(unitID int, start, end time.Time) (int, error) {
+	return 1, nil
+}
+
+// UpdateBlockByID moves or resizes a block
+func (m *testDBRepo) UpdateBlockByID(id, unitID int, start, end time.Time) error {
+	return nil
+}
+
+// InsertHold inserts a hold on a room unit and returns its id, holds from 2055-01-01 lost their
+// unit to someone else
+func (m *testDBRepo) InsertHold(h models.RoomRestriction) (int, error) {
+	if h.StartDate.Equal(time.Date(2055, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		return 0, repository.ErrUnavailable
+	}
+	return 10, nil
+}
+
+// ReleaseHold removes a hold
+func (m *testDBRepo) ReleaseHold(id int) error {
+	return nil
+}
+
+// ReleaseExpiredHolds removes the holds that have run out at now
+func (m *testDBRepo) ReleaseExpiredHolds(now time.Time) ([]int, error) {
+	return []int{1}, nil
+}
+
+// GetUnitsByRoomID returns the units of a room type
+func (m *testDBRepo) GetUnitsByRoomID(roomID int) ([]models.RoomUnit, error) {
+	var units []models.RoomUnit
+	units = append(units, models.RoomUnit{ID: roomID, RoomID: roomID, Name: "1"})
+	return units, nil
+}
+
+// GetRoomUnitByID returns a room unit together with its room type
+func (m *testDBRepo) GetRoomUnitByID(id int) (models.RoomUnit, error) {
+	var u models.RoomUnit
+	if id > 2 {
+		return u, errors.New("can't find room unit")
+	}
+	u.ID = id
+	u.RoomID = id
+	u.Room.ID = id
+	return u, nil
+}
+
+// GetStayRulesForArrival returns the stay rules covering an arrival date, room 1 needs stays
+// of at least three nights arriving in March 2055
+func (m *testDBRepo) GetStayRulesForArrival(propertyID int, arrival time.Time) ([]models.StayRule, error) {
+	var rules []models.StayRule
+
+	rule := models.StayRule{
+		ID:        1,
+		RoomID:    1,
+		StartDate: time.Date(2055, 3, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2055, 4, 1, 0, 0, 0, 0, time.UTC),
+		MinNights: 3,
+	}
+	if !arrival.Before(rule.StartDate) && arrival.Before(rule.EndDate) {
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// AllStayRules returns the stay rules of all rooms of a property
+func (m *testDBRepo) AllStayRules(propertyID int) ([]models.StayRule, error) {
+	var rules []models.StayRule
+	return rules, nil
+}
+
+// InsertStayRule inserts a stay rule into the database
+func (m *testDBRepo) InsertStayRule(s models.StayRule) (int, error) {
+	if s.RoomID > 2 {
+		return 0, errors.New("invalid room id when trying to insert stay rule")
+	}
+	return 1, nil
+}
+
+// DeleteStayRule deletes a stay rule of one of the rooms of a property
+func (m *testDBRepo) DeleteStayRule(propertyID, id int) error {
+	return nil
+}
+
+// AllCancellationPolicies returns the cancellation policies of a property
+func (m *testDBRepo) AllCancellationPolicies(propertyID int) ([]models.CancellationPolicy, error) {
+	var policies []models.CancellationPolicy
+	policies = append(policies, models.CancellationPolicy{ID: 1, Name: "Flexible", FreeDays: 7, FeePercent: 50})
+	return policies, nil
+}
+
+// InsertCancellationPolicy inserts a cancellation policy into the database
+func (m *testDBRepo) InsertCancellationPolicy(c models.CancellationPolicy) (int, error) {
+	if c.Name == "invalid" {
+		return 0, errors.New("invalid name when trying to insert cancellation policy")
+	}
+	return 1, nil
+}
+
+// DeleteCancellationPolicy deletes one of the cancellation policies of a property
+func (m *testDBRepo) DeleteCancellationPolicy(propertyID, id int) error {
+	return nil
+}
+
+// SetRoomCancellationPolicy attaches a cancellation policy to a room, a policyID of 0 removes it
+func (m *testDBRepo) SetRoomCancellationPolicy(roomID, policyID int) error {
+	if roomID > 2 || policyID > 1 {
+		return errors.New("can't find room or cancellation policy")
+	}
+	return nil
+}
+
+// AllTaxFees returns the taxes and fees of a property, a city tax per guest per night applies
+// to stays from 2060 on
+func (m *testDBRepo) AllTaxFees(propertyID int) ([]models.TaxFee, error) {
+	var taxes []models.TaxFee
+	taxes = append(taxes, models.TaxFee{
+		ID:        1,
+		Name:      "City tax",
+		Kind:      "per_guest_night",
+		Amount:    250,
+		StartDate: time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	return taxes, nil
+}
+
+// InsertTaxFee inserts a tax or fee into the database
+func (m *testDBRepo) InsertTaxFee(t models.TaxFee) (int, error) {
+	if t.Name == "invalid" {
+		return 0, errors.New("invalid name when trying to insert tax")
+	}
+	return 1, nil
+}
+
+// DeleteTaxFee deletes one of the taxes and fees of a property
+func (m *testDBRepo) DeleteTaxFee(propertyID, id int) error {
+	return nil
+}
+
+// GetPromoCode returns a promo code of a property by its code, "SAVE10" takes 10% off any stay,
+// "USEDUP" has been used as often as it may and "ROOM2" is only for room 2
+func (m *testDBRepo) GetPromoCode(propertyID int, code string) (models.PromoCode, error) {
+	switch code {
+	case "SAVE10":
+		return models.PromoCode{ID: 1, Code: code, Kind: "percent", Amount: 1000}, nil
+	case "USEDUP":
+		return models.PromoCode{ID: 2, Code: code, Kind: "fixed", Amount: 2000, MaxUses: 5}, nil
+	case "ROOM2":
+		return models.PromoCode{ID: 3, Code: code, Kind: "fixed", Amount: 2000, RoomID: 2}, nil
+	}
+	return models.PromoCode{}, sql.ErrNoRows
+}
+
+// PromoCodeUses returns how many reservations were made with a promo code, in all and with the
+// given email address, promo code 2 has been used 5 times
+func (m *testDBRepo) PromoCodeUses(promoCodeID int, email string) (int, int, error) {
+	if promoCodeID == 2 {
+		return 5, 0, nil
+	}
+	return 0, 0, nil
+}
+
+// AllPromoCodes returns the promo codes of a property
+func (m *testDBRepo) AllPromoCodes(propertyID int) ([]models.PromoCode, error) {
+	var codes []models.PromoCode
+	codes = append(codes, models.PromoCode{ID: 1, Code: "SAVE10", Kind: "percent", Amount: 1000, Uses: 3, Discounted: 6900})
+	return codes, nil
+}
+
+// InsertPromoCode inserts a promo code into the database
+func (m *testDBRepo) InsertPromoCode(p models.PromoCode) (int, error) {
+	if p.Code == "INVALID" {
+		return 0, errors.New("invalid code when trying to insert promo code")
+	}
+	return 1, nil
+}
+
+// DeletePromoCode deletes one of the promo codes of a property
+func (m *testDBRepo) DeletePromoCode(propertyID, id int) error {
+	return nil
+}
+
+// AllExtras returns the extras a property sells, parking per night with 2 spaces and breakfast
+// per guest per night
+func (m *testDBRepo) AllExtras(propertyID int) ([]models.Extra, error) {
+	var list []models.Extra
+	list = append(list,
+		models.Extra{ID: 1, Name: "Parking", Pricing: "per_night", Price: 1500, Stock: 2},
+		models.Extra{ID: 2, Name: "Breakfast", Pricing: "per_guest_night", Price: 1200},
+	)
+	return list, nil
+}
+
+// InsertExtra inserts an extra into the database
+func (m *testDBRepo) InsertExtra(e models.Extra) (int, error) {
+	if e.Name == "invalid" {
+		return 0, errors.New("invalid name when trying to insert extra")
+	}
+	return 1, nil
+}
+
+// DeleteExtra deletes one of the extras of a property
+func (m *testDBRepo) DeleteExtra(propertyID, id int) error {
+	return nil
+}
+
+// ExtraBooked returns how many of an extra are booked on the busiest night of a stay, one of
+// the parking spaces is always taken
+func (m *testDBRepo) ExtraBooked(extraID int, start, end time.Time, excludeReservationID int) (int, error) {
+	if extraID == 1 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// GetExtrasForReservation returns the extras booked on a reservation
+func (m *testDBRepo) GetExtrasForReservation(reservationID int) ([]models.ReservationExtra, error) {
+	var items []models.ReservationExtra
+	return items, nil
+}
+
+// AllReservationExtras returns the extras booked on every reservation of a property, reservation
+// 1 has breakfast for 2 guests
+func (m *testDBRepo) AllReservationExtras(propertyID int) ([]models.ReservationExtra, error) {
+	var items []models.ReservationExtra
+	items = append(items, models.ReservationExtra{
+		ID: 1, ReservationID: 1, ExtraID: 2, Name: "Breakfast", Pricing: "per_guest_night",
+		Quantity: 2, Nights: 1, UnitPrice: 1200, Amount: 2400,
+	})
+	return items, nil
+}
+
+// SetReservationExtras replaces the extras booked on a reservation and sets its total
+func (m *testDBRepo) SetReservationExtras(reservationID, total int, items []models.ReservationExtra) error {
+	if reservationID == 1000 {
+		return errors.New("invalid reservation id when trying to set extras")
+	}
+	return nil
+}
+
+// UpsertGuest returns the id of the guest with the email address of g, "nobody@fails.com" can't be saved
+func (m *testDBRepo) UpsertGuest(g models.Guest) (int, error) {
+	if g.Email == "nobody@fails.com" {
+		return 0, errors.New("invalid email when trying to upsert guest")
+	}
+	return 1, nil
+}
+
+// AllGuests returns the guests of a property
+func (m *testDBRepo) AllGuests(propertyID int, search string) ([]models.Guest, error) {
+	var list []models.Guest
+	list = append(list, models.Guest{ID: 1, FirstName: "John", LastName: "Smith", Email: "john@smith.com",
+		Tags: []string{"VIP"}, Stays: 2, Spend: 46000})
+	return list, nil
+}
+
+// GetGuestByID returns a guest by id, guest 1 is a VIP and guest 2 is the same person booked
+// under another email, there are no others
+func (m *testDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	switch id {
+	case 1:
+		return models.Guest{ID: 1, FirstName: "John", LastName: "Smith", Email: "john@smith.com",
+			Notes: "Likes the quiet room", Tags: []string{"VIP"}}, nil
+	case 2:
+		return models.Guest{ID: 2, FirstName: "John", LastName: "Smith", Email: "jsmith@work.com",
+			Notes: "Allergic to nuts", Tags: []string{"Regular"}}, nil
+	}
+	return models.Guest{}, sql.ErrNoRows
+}
+
+// GetGuestByEmail returns the guest of a property with an email address, guest@me.com is guest 1
+// and victim@me.com guest 2
+func (m *testDBRepo) GetGuestByEmail(propertyID int, email string) (models.Guest, error) {
+	switch email {
+	case "guest@me.com":
+		return m.GetGuestByID(1)
+	case "victim@me.com":
+		return m.GetGuestByID(2)
+	}
+	return models.Guest{}, sql.ErrNoRows
+}
+
+// GetReservationsForGuest returns every reservation of a guest
+func (m *testDBRepo) GetReservationsForGuest(guestID int) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	reservations = append(reservations, models.Reservation{ID: 1, GuestID: guestID, RoomID: 1, Total: 23000,
+		StartDate: time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 6, 11, 0, 0, 0, 0, time.UTC)})
+	return reservations, nil
+}
+
+// UpdateGuest updates the details, notes and tags of a guest
+func (m *testDBRepo) UpdateGuest(g models.Guest) error {
+	if g.FirstName == "invalid" {
+		return errors.New("invalid name when trying to update guest")
+	}
+	return nil
+}
+
+// MergeGuests moves the reservations of guest mergeID over to keep and deletes the merged guest
+func (m *testDBRepo) MergeGuests(keep models.Guest, mergeID int) error {
+	return nil
+}
+
+// InsertWaitlistEntry puts a guest on the waitlist, "nobody@fails.com" can't be saved
+func (m *testDBRepo) InsertWaitlistEntry(e models.WaitlistEntry) (int, error) {
+	if e.Email == "nobody@fails.com" {
+		return 0, errors.New("invalid email when trying to insert waitlist entry")
+	}
+	return 1, nil
+}
+
+// testWaitlistEntry returns a guest waiting for room 1 from 2050-06-10 to 2050-06-12
+func testWaitlistEntry(id int, status string) models.WaitlistEntry {
+	return models.WaitlistEntry{
+		ID: id, RoomID: 1, FirstName: "John", LastName: "Smith", Email: "john@smith.com", Adults: 2,
+		StartDate: time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 6, 12, 0, 0, 0, 0, time.UTC),
+		Status:    status,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
+}
+
+// AllWaitlistEntries returns the waitlist of a property
+func (m *testDBRepo) AllWaitlistEntries(propertyID int) ([]models.WaitlistEntry, error) {
+	var entries []models.WaitlistEntry
+	entries = append(entries, testWaitlistEntry(1, "waiting"), testWaitlistEntry(3, "booked"))
+	return entries, nil
+}
+
+// GetActiveWaitlist returns the entries of a property still waiting or holding an offer
+func (m *testDBRepo) GetActiveWaitlist(propertyID int) ([]models.WaitlistEntry, error) {
+	var entries []models.WaitlistEntry
+	entries = append(entries, testWaitlistEntry(1, "waiting"))
+	return entries, nil
+}
+
+// GetWaitlistEntryByID returns a waitlist entry by id, entry 1 is waiting, entry 2 waits for
+// nights that are booked up, entry 3 has booked and entry 6 holds an offer
+func (m *testDBRepo) GetWaitlistEntryByID(id int) (models.WaitlistEntry, error) {
+	switch id {
+	case 1:
+		return testWaitlistEntry(1, "waiting"), nil
+	case 2:
+		e := testWaitlistEntry(2, "waiting")
+		e.StartDate = time.Date(2070, 6, 10, 0, 0, 0, 0, time.UTC)
+		e.EndDate = time.Date(2070, 6, 12, 0, 0, 0, 0, time.UTC)
+		return e, nil
+	case 3:
+		return testWaitlistEntry(3, "booked"), nil
+	case 6:
+		e := testWaitlistEntry(6, "offered")
+		e.HoldID = 10
+		e.OfferExpiresAt = time.Now().Add(time.Hour)
+		return e, nil
+	}
+	return models.WaitlistEntry{}, sql.ErrNoRows
+}
+
+// GetWaitlistEntryByToken returns the waitlist entry offered with a token, "offer-token" is a live
+// offer holding hold 10, "expired-token" one that ran out and "booked-token" one that was booked
+func (m *testDBRepo) GetWaitlistEntryByToken(tokenHash string) (models.WaitlistEntry, error) {
+	for token, status := range map[string]string{
+		"offer-token":   "offered",
+		"expired-token": "offered",
+		"booked-token":  "booked",
+	} {
+		if tokenHash == fmt.Sprintf("%x", sha256.Sum256([]byte(token))) {
+			e := testWaitlistEntry(4, status)
+			e.HoldID = 10
+			e.OfferExpiresAt = time.Now().Add(time.Hour)
+			if token == "expired-token" {
+				e.OfferExpiresAt = time.Now().Add(-time.Hour)
+			}
+			return e, nil
+		}
+	}
+	return models.WaitlistEntry{}, sql.ErrNoRows
+}
+
+// UpdateWaitlistEntry saves the status, offer, hold and reservation of a waitlist entry, an
+// offer always holds its nights and nothing else does
+func (m *testDBRepo) UpdateWaitlistEntry(e models.WaitlistEntry) error {
+	if (e.Status == "offered") != (e.HoldID != 0) {
+		return errors.New("waitlist entry saved with the wrong hold")
+	}
+	return nil
+}
+
+// InsertGroupBooking inserts a group booking as group 2, failing with repository.ErrUnavailable
+// when a stay has no availability
+func (m *testDBRepo) InsertGroupBooking(g models.GroupBooking) (int, error) {
+	for _, res := range g.Reservations {
+		available, err := m.SearchAvailabilityByDatesByRoomID(res.RoomID, res.StartDate, res.EndDate)
+		if err != nil {
+			return 0, err
+		}
+		if !available {
+			return 0, repository.ErrUnavailable
+		}
+	}
+	return 2, nil
+}
+
+// AllGroupBookings returns the group bookings of a property
+func (m *testDBRepo) AllGroupBookings(propertyID int) ([]models.GroupBooking, error) {
+	g, _ := m.GetGroupBookingByID(1)
+	return []models.GroupBooking{g}, nil
+}
+
+// GetGroupBookingByID returns a group booking of two stays in June 2050. The stays of group 1 are
+// reservations 1 and 2 and have been paid for, group 2 has reservations 3 and 4 and group 3 has
+// reservation 1000, which payments can't be saved for.
+func (m *testDBRepo) GetGroupBookingByID(id int) (models.GroupBooking, error) {
+	var resIDs []int
+	switch id {
+	case 1:
+		resIDs = []int{1, 2}
+	case 2:
+		resIDs = []int{3, 4}
+	case 3:
+		resIDs = []int{1000}
+	default:
+		return models.GroupBooking{}, sql.ErrNoRows
+	}
+
+	g := models.GroupBooking{
+		ID:        id,
+		Reference: fmt.Sprintf("G-TEST%04d", id),
+		GuestID:   1,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 6, 12, 0, 0, 0, 0, time.UTC),
+	}
+	for _, resID := range resIDs {
+		res := models.Reservation{
+			ID:             resID,
+			FirstName:      g.FirstName,
+			LastName:       g.LastName,
+			Email:          g.Email,
+			StartDate:      g.StartDate,
+			EndDate:        g.EndDate,
+			RoomID:         1,
+			Adults:         2,
+			Total:          10000,
+			ManageToken:    fmt.Sprintf("group-%d", resID),
+			GuestID:        1,
+			GroupBookingID: id,
+		}
+		res.Room.ID = 1
+		res.Room.RoomName = "General's Quarters"
+		g.Reservations = append(g.Reservations, res)
+		g.Rooms++
+		g.Total += res.Total
+	}
+	return g, nil
+}
+
+// ConfirmGroupBooking confirms the stays of a group booking waiting to be paid for
+func (m *testDBRepo) ConfirmGroupBooking(id int, now time.Time) (bool, error) {
+	return true, nil
+}
+
+// InsertPayment inserts a payment into the database
+func (m *testDBRepo) InsertPayment(p models.Payment) (int, error) {
+	if p.ReservationID == 1000 {
+		return 0, errors.New("invalid reservation id when trying to insert payment")
+	}
+	return 1, nil
+}
+
+// StartPayment records a payment about to be taken as pending, reservation 5 already has a
+// payment in progress and payments can't be saved for reservation 1000
+func (m *testDBRepo) StartPayment(p models.Payment) (int, error) {
+	switch p.ReservationID {
+	case 5:
+		return 0, repository.ErrPaymentInProgress
+	case 1000:
+		return 0, errors.New("invalid reservation id when trying to insert payment")
+	}
+	return 1, nil
+}
+
+// UpdatePayment records how taking a payment went, a payment is never left authorized
+func (m *testDBRepo) UpdatePayment(p models.Payment) error {
+	if p.Status == "authorized" {
+		return errors.New("payment left authorized")
+	}
+	return nil
+}
+
+// GetPaymentsForReservation returns the payments made towards a reservation, reservation 1 has
+// a captured deposit
+func (m *testDBRepo) GetPaymentsForReservation(reservationID int) ([]models.Payment, error) {
+	var payments []models.Payment
+	if reservationID == 1 {
+		payments = append(payments, models.Payment{
+			ID:            1,
+			ReservationID: 1,
+			Kind:          "deposit",
+			Amount:        3000,
+			Status:        "captured",
+			Gateway:       "fake",
+			Reference:     "fake_1",
+		})
+	}
+	return payments, nil
+}
+
+// UpdatePaymentStatusByReference sets the status of the payment a gateway knows by reference,
+// only fake_1 exists
+func (m *testDBRepo) UpdatePaymentStatusByReference(gateway, reference, status string) (bool, error) {
+	return reference == "fake_1", nil
+}
+
+// GetInvoiceForReservation returns the invoice in force for a reservation, reservation 1 has one
+// for its total and reservation 3 one issued before its total changed
+func (m *testDBRepo) GetInvoiceForReservation(reservationID int) (models.Invoice, error) {
+	switch reservationID {
+	case 1:
+		return models.Invoice{ID: 1, ReservationID: 1, Number: 1, Total: 0, PDF: []byte("%PDF-1.4")}, nil
+	case 3:
+		return models.Invoice{ID: 1, ReservationID: 3, Number: 1, Total: 10000, PDF: []byte("%PDF-1.4")}, nil
+	}
+	return models.Invoice{}, sql.ErrNoRows
+}
+
+// InsertInvoice stores an invoice as number 2 of its property
+func (m *testDBRepo) InsertInvoice(inv models.Invoice, render func(number int) []byte) (models.Invoice, error) {
+	if inv.ReservationID == 1000 {
+		return inv, errors.New("invalid reservation id when trying to insert invoice")
+	}
+	inv.ID = 2
+	inv.Number = 2
+	inv.PDF = render(inv.Number)
+	return inv, nil
+}
+
+// ReissueInvoice stores the credit note as number 3 and the invoice issued in its place as number 4
+func (m *testDBRepo) ReissueInvoice(credit models.Invoice, renderCredit func(number int) []byte, inv models.Invoice, render func(number int) []byte) (models.Invoice, models.Invoice, error) {
+	if credit.CreditsInvoiceID == 0 || credit.Total >= 0 {
+		return credit, inv, errors.New("a credit note must cancel an invoice")
+	}
+	credit.ID = 3
+	credit.Number = 3
+	credit.PDF = renderCredit(credit.Number)
+	inv.ID = 4
+	inv.Number = 4
+	inv.PDF = render(inv.Number)
+	return credit, inv, nil
+}
+
+// GetPropertyByID returns a property by id
+func (m *testDBRepo) GetPropertyByID(id int) (models.Property, error) {
+	var p models.Property
+	if id != 1 {
+		return p, errors.New("can't find property")
+	}
+	p.ID = 1
+	p.Name = "Golden Tavern"
+	p.Slug = "golden-tavern"
+	p.Hostname = "localhost"
+	return p, nil
+}
+
+// GetPropertyBySlug returns a property by its url slug
+func (m *testDBRepo) GetPropertyBySlug(slug string) (models.Property, error) {
+	if slug != "golden-tavern" {
+		return models.Property{}, errors.New("can't find property")
+	}
+	return m.GetPropertyByID(1)
+}
+
+// GetPropertyByHostname returns a property by the hostname it is served on
+func (m *testDBRepo) GetPropertyByHostname(hostname string) (models.Property, error) {
+	if hostname != "localhost" {
+		return models.Property{}, errors.New("can't find property")
+	}
+	return m.GetPropertyByID(1)
+}
+
+// GetDefaultProperty returns the first property, used when a request matches no other property
+func (m *testDBRepo) GetDefaultProperty() (models.Property, error) {
+	return m.GetPropertyByID(1)
+}
+
+// GetPropertiesForUser returns the properties a user has been granted access to
+func (m *testDBRepo) GetPropertiesForUser(userID int) ([]models.Property, error) {
+	var properties []models.Property
+	if userID != 1 {
+		return properties, nil
+	}
+	p, _ := m.GetPropertyByID(1)
+	properties = append(properties, p)
+	return properties, nil
+}
+
+// webhookSubscription is the one webhook subscription of the test property
+var webhookSubscription = models.WebhookSubscription{
+	ID:     1,
+	URL:    "https://example.com/hooks",
+	Secret: "secret",
+	Events: []string{"reservation.created", "reservation.cancelled"},
+	Active: true,
+}
+
+// AllWebhookSubscriptions returns the webhook subscriptions of a property
+func (m *testDBRepo) AllWebhookSubscriptions(propertyID int) ([]models.WebhookSubscription, error) {
+	return []models.WebhookSubscription{webhookSubscription}, nil
+}
+
+// GetWebhookSubscriptionByID returns a webhook subscription by id, only subscription 1 exists
+func (m *testDBRepo) GetWebhookSubscriptionByID(id int) (models.WebhookSubscription, error) {
+	if id != 1 {
+		return models.WebhookSubscription{}, sql.ErrNoRows
+	}
+	return webhookSubscription, nil
+}
+
+// InsertWebhookSubscription inserts a webhook subscription and returns its id
+func (m *testDBRepo) InsertWebhookSubscription(s models.WebhookSubscription) (int, error) {
+	return 2, nil
+}
+
+// UpdateWebhookSubscription saves a webhook subscription
+func (m *testDBRepo) UpdateWebhookSubscription(s models.WebhookSubscription) error {
+	return nil
+}
+
+// DeleteWebhookSubscription deletes one of the webhook subscriptions of a property
+func (m *testDBRepo) DeleteWebhookSubscription(propertyID, id int) error {
+	return nil
+}
+
+// InsertWebhookDelivery inserts a webhook delivery and returns its id
+func (m *testDBRepo) InsertWebhookDelivery(d models.WebhookDelivery) (int, error) {
+	return 2, nil
+}
+
+// UpdateWebhookDelivery saves the outcome of an attempt at a webhook delivery
+func (m *testDBRepo) UpdateWebhookDelivery(d models.WebhookDelivery) error {
+	return nil
+}
+
+// ClaimWebhookDelivery claims a delivery for one attempt, delivery 3 is claimed by another
+// attempt already
+func (m *testDBRepo) ClaimWebhookDelivery(id int, now, until time.Time) (bool, error) {
+	return id != 3, nil
+}
+
+// GetWebhookDeliveryByID returns a webhook delivery by id, delivery 1 is a reservation that was
+// created and failed to be delivered to subscription 1
+func (m *testDBRepo) GetWebhookDeliveryByID(id int) (models.WebhookDelivery, error) {
+	if id != 1 {
+		return models.WebhookDelivery{}, sql.ErrNoRows
+	}
+	return models.WebhookDelivery{
+		ID:             1,
+		SubscriptionID: 1,
+		Event:          "reservation.created",
+		Payload:        `{"event":"reservation.created","data":{"id":1}}`,
+		Attempts:       7,
+		StatusCode:     500,
+		Error:          "endpoint responded 500 Internal Server Error",
+		LastAttemptAt:  time.Date(2050, 6, 1, 12, 0, 0, 0, time.UTC),
+		Subscription:   webhookSubscription,
+	}, nil
+}
+
+// GetWebhookDeliveriesForSubscription returns the deliveries to a webhook subscription
+func (m *testDBRepo) GetWebhookDeliveriesForSubscription(subscriptionID int) ([]models.WebhookDelivery, error) {
+	d, err := m.GetWebhookDeliveryByID(1)
+	if err != nil {
+		return nil, err
+	}
+	return []models.WebhookDelivery{d}, nil
+}
+
+// DueWebhookDeliveries returns the webhook deliveries due an attempt, there are none
+func (m *testDBRepo) DueWebhookDeliveries(now time.Time) ([]models.WebhookDelivery, error) {
+	return nil, nil
+}
+
+// GetChannelMappings returns the ids a channel knows the rooms of a property by, the "fake"
+// channel knows room 1 as ext-1
+func (m *testDBRepo) GetChannelMappings(propertyID int, channel string) ([]models.ChannelMapping, error) {
+	if channel != "fake" {
+		return nil, nil
+	}
+	return []models.ChannelMapping{{ID: 1, PropertyID: propertyID, Channel: channel, RoomID: 1, ExternalRoomID: "ext-1"}}, nil
+}
+
+// GetChannelPropertyIDs returns the ids of the properties with rooms mapped to a channel
+func (m *testDBRepo) GetChannelPropertyIDs(channel string) ([]int, error) {
+	if channel != "fake" {
+		return nil, nil
+	}
+	return []int{1}, nil
+}
+
+// SaveChannelMapping sets the id a channel knows a room by
+func (m *testDBRepo) SaveChannelMapping(x models.ChannelMapping) error {
+	return nil
+}
+
+// DeleteChannelMapping stops a room being synced with a channel
+func (m *testDBRepo) DeleteChannelMapping(propertyID int, channel string, roomID int) error {
+	return nil
+}
+
+// GetReservationIDByChannelReference returns the id of the reservation imported from a channel
+// booking, only BK-IMPORTED has been imported, as reservation 1
+func (m *testDBRepo) GetReservationIDByChannelReference(channel, reference string) (int, error) {
+	if reference == "BK-IMPORTED" {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// ChannelConflictRecorded reports whether a channel booking has already been logged as a
+// conflict, only BK-CONFLICTED has
+func (m *testDBRepo) ChannelConflictRecorded(channel, reference string) (bool, error) {
+	return reference == "BK-CONFLICTED", nil
+}
+
+// InsertChannelSyncLog adds an entry to the log of syncs with a channel
+func (m *testDBRepo) InsertChannelSyncLog(l models.ChannelSyncLog) (int, error) {
+	return 1, nil
+}
+
+// GetChannelSyncLogs returns the log of syncs of a property with a channel, a conflict
+func (m *testDBRepo) GetChannelSyncLogs(propertyID int, channel string) ([]models.ChannelSyncLog, error) {
+	return []models.ChannelSyncLog{{
+		ID:                1,
+		PropertyID:        propertyID,
+		Channel:           channel,
+		Direction:         "pull",
+		Status:            "conflict",
+		Message:           "Secret HQ is already booked",
+		ExternalReference: "BK-1",
+		CreatedAt:         time.Date(2050, 6, 1, 12, 0, 0, 0, time.UTC),
+	}}, nil
+}
+
+// LastChannelPull returns when reservations were last pulled from a channel, they never were
+func (m *testDBRepo) LastChannelPull(propertyID int, channel string) (time.Time, error) {
+	return time.Time{}, nil
+}
@@ -1,30 +1,146 @@
 package repository
 
 import (
+	"errors"
 	"time"
 
 	"github.com/RakhmanovTimur/bookings/internal/models"
 )
 
+// ErrUnavailable is returned when a stay being booked can't be given a free unit of its room
+var ErrUnavailable = errors.New("no unit of the room is free for the stay")
+
+// ErrPaymentInProgress is returned when a reservation already has a payment that is being taken or
+// has been taken
+var ErrPaymentInProgress = errors.New("a payment for the reservation is already in progress")
+
 type DatabaseRepo interface {
 	AllUsers() bool
 
 	InsertReservation(res models.Reservation) (int, error)
+	InsertReservationWithRestriction(res models.Reservation, holdID int) (int, error)
 	InsertRoomRestriction(r models.RoomRestriction) error
 	SearchAvailabilityByDatesByRoomID(roomID int, start, end time.Time) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
+	SearchAvailabilityByDatesByUnitIDExcludingReservation(unitID, reservationID int, start, end time.Time) (bool, error)
+	SearchAvailabilityByDatesByUnitIDExcludingRestriction(unitID, restrictionID int, start, end time.Time) (bool, error)
+	FindAvailableUnit(roomID int, start, end time.Time) (int, error)
+	GetNightlyAvailability(propertyID, guests int, start, end time.Time) ([]models.RoomNight, error)
+	SearchAvailabilityForAllRooms(propertyID, guests int, start, end time.Time) ([]models.Room, error)
 	GetRoomByID(id int) (models.Room, error)
 	GetUserByID(id int) (models.User, error)
 	UpdateUser(u models.User) error
+	RemoveUserPassword(id int) error
 	Authenticate(email, testPassword string) (int, string, error)
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
+	GetUserByEmail(email string) (models.User, error)
+	InsertUser(u models.User, password string) (int, error)
+	InsertLoginToken(email, tokenHash string, expires time.Time) error
+	UseLoginToken(tokenHash string) (string, error)
+	AllReservations(propertyID int) ([]models.Reservation, error)
+	AllNewReservations(propertyID int) ([]models.Reservation, error)
 	GetReservationByID(id int) (models.Reservation, error)
+	GetReservationByToken(token string) (models.Reservation, error)
+	CancelReservation(res models.Reservation) error
+	ConfirmReservation(id int, now time.Time) (bool, error)
 	UpdateReservation(u models.Reservation) error
 	DeleteReservation(id int) error
 	UpdateProcessedForReservation(id, processed int) error
-	AllRooms() ([]models.Room, error)
-	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(id int, startDate time.Time) error
+	AllRooms(propertyID int) ([]models.Room, error)
+	GetRestrictionsForUnitByDate(unitID int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlockForUnit(unitID int, startDate time.Time) (int, error)
 	DeleteBlockByID(id int) error
+	GetRestrictionsByDate(propertyID int, start, end time.Time) ([]models.RoomRestriction, error)
+	GetRoomRestrictionByID(id int) (models.RoomRestriction, error)
+	InsertBlockForUnitByDates(unitID int, start, end time.Time) (int, error)
+	UpdateBlockByID(id, unitID int, start, end time.Time) error
+	InsertHold(h models.RoomRestriction) (int, error)
+	ReleaseHold(id int) error
+	ReleaseExpiredHolds(now time.Time) ([]int, error)
+	GetUnitsByRoomID(roomID int) ([]models.RoomUnit, error)
+	GetRoomUnitByID(id int) (models.RoomUnit, error)
+
+	GetStayRulesForArrival(propertyID int, arrival time.Time) ([]models.StayRule, error)
+	AllStayRules(propertyID int) ([]models.StayRule, error)
+	InsertStayRule(s models.StayRule) (int, error)
+	DeleteStayRule(propertyID, id int) error
+
+	AllCancellationPolicies(propertyID int) ([]models.CancellationPolicy, error)
+	InsertCancellationPolicy(c models.CancellationPolicy) (int, error)
+	DeleteCancellationPolicy(propertyID, id int) error
+	SetRoomCancellationPolicy(roomID, policyID int) error
+
+	AllTaxFees(propertyID int) ([]models.TaxFee, error)
+	InsertTaxFee(t models.TaxFee) (int, error)
+	DeleteTaxFee(propertyID, id int) error
+
+	GetPromoCode(propertyID int, code string) (models.PromoCode, error)
+	PromoCodeUses(promoCodeID int, email string) (int, int, error)
+	AllPromoCodes(propertyID int) ([]models.PromoCode, error)
+	InsertPromoCode(p models.PromoCode) (int, error)
+	DeletePromoCode(propertyID, id int) error
+
+	AllExtras(propertyID int) ([]models.Extra, error)
+	InsertExtra(e models.Extra) (int, error)
+	DeleteExtra(propertyID, id int) error
+	ExtraBooked(extraID int, start, end time.Time, excludeReservationID int) (int, error)
+	GetExtrasForReservation(reservationID int) ([]models.ReservationExtra, error)
+	AllReservationExtras(propertyID int) ([]models.ReservationExtra, error)
+	SetReservationExtras(reservationID, total int, items []models.ReservationExtra) error
+
+	UpsertGuest(g models.Guest) (int, error)
+	AllGuests(propertyID int, search string) ([]models.Guest, error)
+	GetGuestByID(id int) (models.Guest, error)
+	GetGuestByEmail(propertyID int, email string) (models.Guest, error)
+	GetReservationsForGuest(guestID int) ([]models.Reservation, error)
+	UpdateGuest(g models.Guest) error
+	MergeGuests(keep models.Guest, mergeID int) error
+
+	InsertWaitlistEntry(e models.WaitlistEntry) (int, error)
+	AllWaitlistEntries(propertyID int) ([]models.WaitlistEntry, error)
+	GetActiveWaitlist(propertyID int) ([]models.WaitlistEntry, error)
+	GetWaitlistEntryByID(id int) (models.WaitlistEntry, error)
+	GetWaitlistEntryByToken(tokenHash string) (models.WaitlistEntry, error)
+	UpdateWaitlistEntry(e models.WaitlistEntry) error
+
+	InsertGroupBooking(g models.GroupBooking) (int, error)
+	AllGroupBookings(propertyID int) ([]models.GroupBooking, error)
+	GetGroupBookingByID(id int) (models.GroupBooking, error)
+	ConfirmGroupBooking(id int, now time.Time) (bool, error)
+
+	AllWebhookSubscriptions(propertyID int) ([]models.WebhookSubscription, error)
+	GetWebhookSubscriptionByID(id int) (models.WebhookSubscription, error)
+	InsertWebhookSubscription(s models.WebhookSubscription) (int, error)
+	UpdateWebhookSubscription(s models.WebhookSubscription) error
+	DeleteWebhookSubscription(propertyID, id int) error
+	InsertWebhookDelivery(d models.WebhookDelivery) (int, error)
+	UpdateWebhookDelivery(d models.WebhookDelivery) error
+	ClaimWebhookDelivery(id int, now, until time.Time) (bool, error)
+	GetWebhookDeliveryByID(id int) (models.WebhookDelivery, error)
+	GetWebhookDeliveriesForSubscription(subscriptionID int) ([]models.WebhookDelivery, error)
+	DueWebhookDeliveries(now time.Time) ([]models.WebhookDelivery, error)
+
+	GetChannelMappings(propertyID int, channel string) ([]models.ChannelMapping, error)
+	GetChannelPropertyIDs(channel string) ([]int, error)
+	SaveChannelMapping(m models.ChannelMapping) error
+	DeleteChannelMapping(propertyID int, channel string, roomID int) error
+	GetReservationIDByChannelReference(channel, reference string) (int, error)
+	ChannelConflictRecorded(channel, reference string) (bool, error)
+	InsertChannelSyncLog(l models.ChannelSyncLog) (int, error)
+	GetChannelSyncLogs(propertyID int, channel string) ([]models.ChannelSyncLog, error)
+	LastChannelPull(propertyID int, channel string) (time.Time, error)
+
+	InsertPayment(p models.Payment) (int, error)
+	StartPayment(p models.Payment) (int, error)
+	UpdatePayment(p models.Payment) error
+	GetPaymentsForReservation(reservationID int) ([]models.Payment, error)
+	UpdatePaymentStatusByReference(gateway, reference, status string) (bool, error)
+
+	GetInvoiceForReservation(reservationID int) (models.Invoice, error)
+	InsertInvoice(inv models.Invoice, render func(number int) []byte) (models.Invoice, error)
+	ReissueInvoice(credit models.Invoice, renderCredit func(number int) []byte, inv models.Invoice, render func(number int) []byte) (models.Invoice, models.Invoice, error)
+
+	GetPropertyByID(id int) (models.Property, error)
+	GetPropertyBySlug(slug string) (models.Property, error)
+	GetPropertyByHostname(hostname string) (models.Property, error)
+	GetDefaultProperty() (models.Property, error)
+	GetPropertiesForUser(userID int) ([]models.Property, error)
 }
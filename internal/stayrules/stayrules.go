@@ -0,0 +1,128 @@
+package stayrules
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/dates"
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+// Error is a stay that breaks a rule, Field is the form field the message belongs to
+type Error struct {
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Weekdays is a set of days of the week, bit n is set for time.Weekday(n)
+type Weekdays int
+
+// WeekdaysOf returns the set of the given days
+func WeekdaysOf(days ...time.Weekday) Weekdays {
+	var w Weekdays
+	for _, d := range days {
+		w |= 1 << uint(d)
+	}
+	return w
+}
+
+// Has returns true if the set holds d, an empty set holds every day
+func (w Weekdays) Has(d time.Weekday) bool {
+	return w == 0 || w&(1<<uint(d)) != 0
+}
+
+// String lists the days of the set, e.g. "Friday or Saturday"
+func (w Weekdays) String() string {
+	var names []string
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if w&(1<<uint(d)) != 0 {
+			names = append(names, d.String())
+		}
+	}
+
+	switch len(names) {
+	case 0, 7:
+		return "any day"
+	case 1:
+		return names[0]
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " or " + names[len(names)-1]
+}
+
+// Applies returns true if the rule covers stays arriving on arrival
+func Applies(rule models.StayRule, arrival time.Time) bool {
+	return !arrival.Before(rule.StartDate) && arrival.Before(rule.EndDate)
+}
+
+// ForRoom returns the rules of a room
+func ForRoom(rules []models.StayRule, roomID int) []models.StayRule {
+	var out []models.StayRule
+	for _, rule := range rules {
+		if rule.RoomID == roomID {
+			out = append(out, rule)
+		}
+	}
+	return out
+}
+
+// Check returns an *Error for the first rule the stay from start to end breaks, today is the
+// current date at the property, rules that don't cover the arrival date are ignored
+func Check(rules []models.StayRule, start, end, today time.Time) error {
+	if !end.After(start) {
+		return &Error{Field: "end_date", Message: "Departure date must be after the arrival date"}
+	}
+	if start.Before(today) {
+		return &Error{Field: "start_date", Message: "Arrival date can't be in the past"}
+	}
+
+	nights := dates.Nights(start, end)
+	advance := dates.Nights(today, start)
+	arrival := start.Format(dates.Layout)
+
+	for _, rule := range rules {
+		if !Applies(rule, start) {
+			continue
+		}
+
+		if rule.ClosedToArrival {
+			return &Error{Field: "start_date", Message: fmt.Sprintf("Arrivals are not possible on %s", arrival)}
+		}
+		if days := Weekdays(rule.ArrivalDays); !days.Has(start.Weekday()) {
+			return &Error{Field: "start_date", Message: fmt.Sprintf("Arrival must be on a %s", days)}
+		}
+		if days := Weekdays(rule.DepartureDays); !days.Has(end.Weekday()) {
+			return &Error{Field: "end_date", Message: fmt.Sprintf("Departure must be on a %s", days)}
+		}
+		if rule.MinNights > 0 && nights < rule.MinNights {
+			return &Error{
+				Field:   "end_date",
+				Message: fmt.Sprintf("Stays arriving on %s must be at least %d nights", arrival, rule.MinNights),
+			}
+		}
+		if rule.MaxNights > 0 && nights > rule.MaxNights {
+			return &Error{
+				Field:   "end_date",
+				Message: fmt.Sprintf("Stays arriving on %s can be at most %d nights", arrival, rule.MaxNights),
+			}
+		}
+		if rule.MinAdvanceDays > 0 && advance < rule.MinAdvanceDays {
+			return &Error{
+				Field:   "start_date",
+				Message: fmt.Sprintf("Stays arriving on %s must be booked at least %d days in advance", arrival, rule.MinAdvanceDays),
+			}
+		}
+		if rule.MaxAdvanceDays > 0 && advance > rule.MaxAdvanceDays {
+			return &Error{
+				Field:   "start_date",
+				Message: fmt.Sprintf("Stays can be booked at most %d days in advance", rule.MaxAdvanceDays),
+			}
+		}
+	}
+
+	return nil
+}
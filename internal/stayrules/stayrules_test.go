@@ -0,0 +1,91 @@
+package stayrules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/dates"
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+func day(s string) time.Time {
+	d, _ := dates.Parse(s)
+	return d
+}
+
+// every rule covers arrivals in June 2050, today is 2050-05-01
+var today = day("2050-05-01")
+
+func rule(r models.StayRule) models.StayRule {
+	r.RoomID = 1
+	r.StartDate = day("2050-06-01")
+	r.EndDate = day("2050-07-01")
+	return r
+}
+
+var checkTests = []struct {
+	name          string
+	rules         []models.StayRule
+	start         string
+	end           string
+	expectedField string
+}{
+	{"no-rules", nil, "2050-06-10", "2050-06-12", ""},
+	{"reversed", nil, "2050-06-12", "2050-06-10", "end_date"},
+	{"no-nights", nil, "2050-06-10", "2050-06-10", "end_date"},
+	{"past", nil, "2050-04-30", "2050-05-02", "start_date"},
+	{"today", nil, "2050-05-01", "2050-05-02", ""},
+	{"min-nights", []models.StayRule{rule(models.StayRule{MinNights: 3})}, "2050-06-10", "2050-06-12", "end_date"},
+	{"min-nights-met", []models.StayRule{rule(models.StayRule{MinNights: 3})}, "2050-06-10", "2050-06-13", ""},
+	{"max-nights", []models.StayRule{rule(models.StayRule{MaxNights: 7})}, "2050-06-10", "2050-06-18", "end_date"},
+	// 2050-06-10 is a Friday
+	{"arrival-day", []models.StayRule{rule(models.StayRule{ArrivalDays: int(WeekdaysOf(time.Saturday))})}, "2050-06-10", "2050-06-12", "start_date"},
+	{"arrival-day-met", []models.StayRule{rule(models.StayRule{ArrivalDays: int(WeekdaysOf(time.Friday, time.Saturday))})}, "2050-06-10", "2050-06-12", ""},
+	{"departure-day", []models.StayRule{rule(models.StayRule{DepartureDays: int(WeekdaysOf(time.Saturday))})}, "2050-06-10", "2050-06-12", "end_date"},
+	{"closed-to-arrival", []models.StayRule{rule(models.StayRule{ClosedToArrival: true})}, "2050-06-10", "2050-06-12", "start_date"},
+	{"departing-into-closed", []models.StayRule{rule(models.StayRule{ClosedToArrival: true})}, "2050-05-30", "2050-06-02", ""},
+	{"min-advance", []models.StayRule{rule(models.StayRule{MinAdvanceDays: 60})}, "2050-06-10", "2050-06-12", "start_date"},
+	{"max-advance", []models.StayRule{rule(models.StayRule{MaxAdvanceDays: 30})}, "2050-06-10", "2050-06-12", "start_date"},
+	{"advance-met", []models.StayRule{rule(models.StayRule{MinAdvanceDays: 30, MaxAdvanceDays: 60})}, "2050-06-10", "2050-06-12", ""},
+}
+
+func TestCheck(t *testing.T) {
+	for _, e := range checkTests {
+		err := Check(e.rules, day(e.start), day(e.end), today)
+		if e.expectedField == "" {
+			if err != nil {
+				t.Errorf("failed %s: expected no error, got %s", e.name, err)
+			}
+			continue
+		}
+
+		ruleErr, ok := err.(*Error)
+		if !ok {
+			t.Errorf("failed %s: expected a rule error, got %v", e.name, err)
+			continue
+		}
+		if ruleErr.Field != e.expectedField {
+			t.Errorf("failed %s: expected an error on %s, got %s (%s)", e.name, e.expectedField, ruleErr.Field, ruleErr.Message)
+		}
+	}
+}
+
+func TestForRoom(t *testing.T) {
+	rules := []models.StayRule{{ID: 1, RoomID: 1}, {ID: 2, RoomID: 2}, {ID: 3, RoomID: 1}}
+	if got := ForRoom(rules, 1); len(got) != 2 || got[0].ID != 1 || got[1].ID != 3 {
+		t.Errorf("expected rules 1 and 3 for room 1, got %v", got)
+	}
+}
+
+func TestWeekdays_String(t *testing.T) {
+	for w, expected := range map[Weekdays]string{
+		0:                                    "any day",
+		WeekdaysOf(time.Saturday):            "Saturday",
+		WeekdaysOf(time.Friday, time.Sunday): "Sunday or Friday",
+		WeekdaysOf(time.Monday, time.Tuesday, time.Wednesday): "Monday, Tuesday or Wednesday",
+	} {
+		if w.String() != expected {
+			t.Errorf("expected %q, got %q", expected, w.String())
+		}
+	}
+}
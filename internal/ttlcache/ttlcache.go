@@ -0,0 +1,64 @@
+package ttlcache
+
+import (
+	"sync"
+	"time"
+)
+
+type item struct {
+	value   interface{}
+	expires time.Time
+}
+
+// Cache is a small in-memory key/value cache whose entries expire after a fixed time
+type Cache struct {
+	mu    sync.RWMutex
+	ttl   time.Duration
+	items map[string]item
+	now   func() time.Time
+}
+
+// New creates a cache keeping entries for ttl
+func New(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:   ttl,
+		items: make(map[string]item),
+		now:   time.Now,
+	}
+}
+
+// Get returns the value stored under key, if it has not expired
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	x, ok := c.items[key]
+	if !ok || !c.now().Before(x.expires) {
+		return nil, false
+	}
+	return x.value, true
+}
+
+// Set stores value under key
+func (c *Cache) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// drop expired entries so keys that are never read again don't pile up
+	now := c.now()
+	for k, x := range c.items {
+		if !now.Before(x.expires) {
+			delete(c.items, k)
+		}
+	}
+
+	c.items[key] = item{value: value, expires: now.Add(c.ttl)}
+}
+
+// Flush removes every entry
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[string]item)
+}
@@ -0,0 +1,37 @@
+package ttlcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Date(2050, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := New(time.Minute)
+	c.now = func() time.Time { return now }
+
+	if _, ok := c.Get("a"); ok {
+		t.Error("got a value from an empty cache")
+	}
+
+	c.Set("a", 1)
+	x, ok := c.Get("a")
+	if !ok || x.(int) != 1 {
+		t.Errorf("expected 1, got %v", x)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.Get("a"); ok {
+		t.Error("got an expired value")
+	}
+
+	c.Set("b", 2)
+	if len(c.items) != 1 {
+		t.Errorf("expected expired entries to be dropped, got %d entries", len(c.items))
+	}
+
+	c.Flush()
+	if _, ok := c.Get("b"); ok {
+		t.Error("got a value after flush")
+	}
+}
@@ -0,0 +1,73 @@
+package waitlist
+
+import (
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+// Statuses of waitlist entries. Guests wait until the nights they want free up, then get an
+// offer they can book with until it expires.
+const (
+	StatusWaiting = "waiting"
+	StatusOffered = "offered"
+	StatusBooked  = "booked"
+	StatusExpired = "expired"
+	StatusRemoved = "removed"
+)
+
+// OfferValidFor is how long guests have to book once they are offered their nights
+const OfferValidFor = 24 * time.Hour
+
+// Active reports whether an entry is still waiting for, or holding, an offer
+func Active(e models.WaitlistEntry) bool {
+	return e.Status == StatusWaiting || e.Status == StatusOffered
+}
+
+// Expired reports whether the offer made to an entry has run out at now
+func Expired(e models.WaitlistEntry, now time.Time) bool {
+	return e.Status == StatusOffered && !now.Before(e.OfferExpiresAt)
+}
+
+// overlaps reports whether two entries want any of the same nights of the same room
+func overlaps(a, b models.WaitlistEntry) bool {
+	return a.RoomID == b.RoomID && a.StartDate.Before(b.EndDate) && b.StartDate.Before(a.EndDate)
+}
+
+// Next works out which entries, in the order guests joined the waitlist, have had their offer
+// run out at now and which should be offered their nights. An entry is offered when available
+// says its nights are free and no guest ahead of it holds a live offer, or is about to be
+// offered, for any of the same nights, so freed nights go to one guest at a time.
+func Next(entries []models.WaitlistEntry, now time.Time, available func(models.WaitlistEntry) bool) (expired, offer []models.WaitlistEntry) {
+	var holding []models.WaitlistEntry
+
+	for _, e := range entries {
+		switch {
+		case Expired(e, now):
+			expired = append(expired, e)
+		case e.Status == StatusOffered:
+			holding = append(holding, e)
+		}
+	}
+
+	for _, e := range entries {
+		if e.Status != StatusWaiting {
+			continue
+		}
+
+		blocked := false
+		for _, h := range holding {
+			if overlaps(e, h) {
+				blocked = true
+				break
+			}
+		}
+		if blocked || !available(e) {
+			continue
+		}
+
+		offer = append(offer, e)
+		holding = append(holding, e)
+	}
+	return expired, offer
+}
@@ -0,0 +1,76 @@
+package waitlist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+var now = time.Date(2050, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func entry(id, roomID int, start, end int, status string) models.WaitlistEntry {
+	return models.WaitlistEntry{
+		ID:        id,
+		RoomID:    roomID,
+		StartDate: time.Date(2050, 6, start, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 6, end, 0, 0, 0, 0, time.UTC),
+		Status:    status,
+	}
+}
+
+func ids(entries []models.WaitlistEntry) []int {
+	var list []int
+	for _, e := range entries {
+		list = append(list, e.ID)
+	}
+	return list
+}
+
+func TestNext(t *testing.T) {
+	expiredOffer := entry(1, 1, 10, 12, StatusOffered)
+	expiredOffer.OfferExpiresAt = now.Add(-time.Minute)
+	liveOffer := entry(2, 2, 10, 12, StatusOffered)
+	liveOffer.OfferExpiresAt = now.Add(time.Hour)
+
+	entries := []models.WaitlistEntry{
+		expiredOffer,
+		liveOffer,
+		entry(3, 1, 11, 13, StatusWaiting), // overlaps 1, whose offer ran out
+		entry(4, 1, 12, 14, StatusWaiting), // overlaps 3, which is offered first
+		entry(5, 2, 11, 12, StatusWaiting), // overlaps the live offer 2
+		entry(6, 1, 20, 22, StatusWaiting), // still booked up
+		entry(7, 1, 14, 15, StatusWaiting),
+	}
+
+	// every night is free but those of entry 6
+	expired, offer := Next(entries, now, func(e models.WaitlistEntry) bool { return e.ID != 6 })
+
+	if got := ids(expired); len(got) != 1 || got[0] != 1 {
+		t.Errorf("expected entry 1 to expire, got %v", got)
+	}
+	if got := ids(offer); len(got) != 2 || got[0] != 3 || got[1] != 7 {
+		t.Errorf("expected entries 3 and 7 to be offered, got %v", got)
+	}
+}
+
+func TestActive(t *testing.T) {
+	for status, active := range map[string]bool{
+		StatusWaiting: true, StatusOffered: true, StatusBooked: false, StatusExpired: false, StatusRemoved: false,
+	} {
+		if Active(models.WaitlistEntry{Status: status}) != active {
+			t.Errorf("expected %s active to be %t", status, active)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	e := models.WaitlistEntry{Status: StatusOffered, OfferExpiresAt: now}
+	if !Expired(e, now) || Expired(e, now.Add(-time.Second)) {
+		t.Error("expected the offer to expire when it runs out")
+	}
+	e.Status = StatusBooked
+	if Expired(e, now.Add(time.Hour)) {
+		t.Error("expected a booked entry never to expire")
+	}
+}
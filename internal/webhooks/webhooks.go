@@ -0,0 +1,234 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/dates"
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+// Events subscriptions can be sent
+const (
+	EventReservationCreated   = "reservation.created"
+	EventReservationUpdated   = "reservation.updated"
+	EventReservationCancelled = "reservation.cancelled"
+	EventBlockCreated         = "block.created"
+	EventBlockUpdated         = "block.updated"
+	EventBlockDeleted         = "block.deleted"
+)
+
+// Events lists every event in the order admins pick them
+var Events = []string{
+	EventReservationCreated,
+	EventReservationUpdated,
+	EventReservationCancelled,
+	EventBlockCreated,
+	EventBlockUpdated,
+	EventBlockDeleted,
+}
+
+// Headers sent with every delivery. The signature is the hex encoded HMAC-SHA256 of the body
+// keyed with the secret of the subscription.
+const (
+	SignatureHeader = "X-Bookings-Signature"
+	EventHeader     = "X-Bookings-Event"
+	DeliveryHeader  = "X-Bookings-Delivery"
+)
+
+// retryAfter is how long to wait before attempting a failed delivery again, by the number of
+// attempts made so far
+var retryAfter = [...]time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	30 * time.Minute,
+	2 * time.Hour,
+	6 * time.Hour,
+	12 * time.Hour,
+}
+
+// MaxAttempts is how many times a delivery is attempted before it is given up on
+const MaxAttempts = len(retryAfter) + 1
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// ClaimFor is how long an attempt at a delivery has before the delivery is due again, so one
+// lost with the process that was sending it is still retried. It is well over the time a send
+// can take.
+const ClaimFor = time.Minute
+
+// ValidEvent reports whether event is one subscriptions can be sent
+func ValidEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidURL reports whether s is an absolute http or https URL deliveries can be posted to
+func ValidURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Subscribed reports whether an active subscription wants to be sent event
+func Subscribed(s models.WebhookSubscription, event string) bool {
+	if !s.Active {
+		return false
+	}
+	for _, e := range s.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// NewSecret returns a random secret to sign the deliveries of a subscription with
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Sign returns the signature of body for a subscription with the given secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the one of body for the given secret
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// NextAttempt returns when to attempt a delivery again after it failed for the attempts-th
+// time at now, or false once it has been attempted MaxAttempts times
+func NextAttempt(attempts int, now time.Time) (time.Time, bool) {
+	if attempts < 1 || attempts > len(retryAfter) {
+		return time.Time{}, false
+	}
+	return now.Add(retryAfter[attempts-1]), true
+}
+
+// Payload returns the JSON body sent for an event that happened at now
+func Payload(event string, data interface{}, now time.Time) ([]byte, error) {
+	return json.Marshal(struct {
+		Event     string      `json:"event"`
+		CreatedAt time.Time   `json:"created_at"`
+		Data      interface{} `json:"data"`
+	}{event, now.UTC(), data})
+}
+
+// Reservation is what deliveries say about a reservation. Amounts are in cents.
+type Reservation struct {
+	ID             int        `json:"id"`
+	RoomID         int        `json:"room_id"`
+	RoomName       string     `json:"room_name"`
+	RoomUnitID     int        `json:"room_unit_id,omitempty"`
+	RoomUnitName   string     `json:"room_unit_name,omitempty"`
+	StartDate      string     `json:"start_date"`
+	EndDate        string     `json:"end_date"`
+	FirstName      string     `json:"first_name"`
+	LastName       string     `json:"last_name"`
+	Email          string     `json:"email"`
+	Phone          string     `json:"phone"`
+	Adults         int        `json:"adults"`
+	Children       int        `json:"children"`
+	Total          int        `json:"total"`
+	GroupBookingID int        `json:"group_booking_id,omitempty"`
+	CancelledAt    *time.Time `json:"cancelled_at,omitempty"`
+	CancelledBy    string     `json:"cancelled_by,omitempty"`
+	Refund         int        `json:"refund,omitempty"`
+}
+
+// ReservationData returns what deliveries say about res
+func ReservationData(res models.Reservation) Reservation {
+	data := Reservation{
+		ID:             res.ID,
+		RoomID:         res.RoomID,
+		RoomName:       res.Room.RoomName,
+		RoomUnitID:     res.RoomUnitID,
+		RoomUnitName:   res.RoomUnit.Name,
+		StartDate:      res.StartDate.Format(dates.Layout),
+		EndDate:        res.EndDate.Format(dates.Layout),
+		FirstName:      res.FirstName,
+		LastName:       res.LastName,
+		Email:          res.Email,
+		Phone:          res.Phone,
+		Adults:         res.Adults,
+		Children:       res.Children,
+		Total:          res.Total,
+		GroupBookingID: res.GroupBookingID,
+	}
+	if !res.CancelledAt.IsZero() {
+		cancelledAt := res.CancelledAt.UTC()
+		data.CancelledAt = &cancelledAt
+		data.CancelledBy = res.CancelledBy
+		data.Refund = res.Refund
+	}
+	return data
+}
+
+// Block is what deliveries say about a room unit blocked by the property
+type Block struct {
+	ID         int    `json:"id,omitempty"`
+	RoomUnitID int    `json:"room_unit_id"`
+	StartDate  string `json:"start_date"`
+	EndDate    string `json:"end_date"`
+}
+
+// BlockData returns what deliveries say about a block of a unit from start to end
+func BlockData(id, unitID int, start, end time.Time) Block {
+	return Block{
+		ID:         id,
+		RoomUnitID: unitID,
+		StartDate:  start.Format(dates.Layout),
+		EndDate:    end.Format(dates.Layout),
+	}
+}
+
+// Send posts a delivery to the URL of its subscription and returns the status code the endpoint
+// responded with, which is 0 when it couldn't be reached. Anything but a 2xx response is an error.
+func Send(d models.WebhookDelivery) (int, error) {
+	body := []byte(d.Payload)
+
+	req, err := http.NewRequest("POST", d.Subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(d.ID))
+	req.Header.Set(SignatureHeader, Sign(d.Subscription.Secret, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// read a little of the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
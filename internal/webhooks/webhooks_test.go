@@ -0,0 +1,164 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RakhmanovTimur/bookings/internal/models"
+)
+
+var now = time.Date(2050, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"event":"reservation.created"}`)
+	signature := Sign("secret", body)
+
+	if !Verify("secret", body, signature) {
+		t.Error("expected the signature to verify")
+	}
+	if Verify("other", body, signature) {
+		t.Error("expected a signature made with another secret not to verify")
+	}
+	if Verify("secret", []byte(`{"event":"reservation.cancelled"}`), signature) {
+		t.Error("expected the signature of another body not to verify")
+	}
+}
+
+func TestSubscribed(t *testing.T) {
+	s := models.WebhookSubscription{Active: true, Events: []string{EventReservationCreated, EventBlockCreated}}
+
+	if !Subscribed(s, EventBlockCreated) {
+		t.Error("expected the subscription to want blocks")
+	}
+	if Subscribed(s, EventReservationCancelled) {
+		t.Error("expected the subscription not to want cancellations")
+	}
+
+	s.Active = false
+	if Subscribed(s, EventBlockCreated) {
+		t.Error("expected an inactive subscription not to want anything")
+	}
+}
+
+func TestNextAttempt(t *testing.T) {
+	next, ok := NextAttempt(1, now)
+	if !ok || !next.Equal(now.Add(time.Minute)) {
+		t.Errorf("expected a first retry a minute later, got %v %v", next, ok)
+	}
+
+	previous := now
+	for attempts := 1; attempts < MaxAttempts; attempts++ {
+		next, ok := NextAttempt(attempts, now)
+		if !ok {
+			t.Fatalf("expected a retry after %d attempts", attempts)
+		}
+		if !next.After(previous) {
+			t.Errorf("expected retries to back off, attempt %d is due %v", attempts+1, next)
+		}
+		previous = next
+	}
+
+	if _, ok := NextAttempt(MaxAttempts, now); ok {
+		t.Errorf("expected no retry after %d attempts", MaxAttempts)
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, e := range Events {
+		if !ValidEvent(e) {
+			t.Errorf("expected %s to be valid", e)
+		}
+	}
+	if ValidEvent("reservation.deleted") {
+		t.Error("expected reservation.deleted not to be valid")
+	}
+
+	for u, valid := range map[string]bool{
+		"https://example.com/hooks": true,
+		"http://localhost:9000":     true,
+		"ftp://example.com":         false,
+		"example.com/hooks":         false,
+		"":                          false,
+	} {
+		if ValidURL(u) != valid {
+			t.Errorf("expected ValidURL(%q) to be %v", u, valid)
+		}
+	}
+}
+
+func TestPayload(t *testing.T) {
+	res := models.Reservation{
+		ID:          7,
+		RoomID:      1,
+		StartDate:   time.Date(2050, 6, 10, 0, 0, 0, 0, time.UTC),
+		EndDate:     time.Date(2050, 6, 12, 0, 0, 0, 0, time.UTC),
+		Total:       20000,
+		CancelledAt: now,
+		CancelledBy: "guest",
+	}
+
+	body, err := Payload(EventReservationCancelled, ReservationData(res), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		Event string
+		Data  map[string]interface{}
+	}
+	err = json.Unmarshal(body, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Event != EventReservationCancelled || got.Data["id"] != float64(7) ||
+		got.Data["start_date"] != "2050-06-10" || got.Data["cancelled_by"] != "guest" {
+		t.Errorf("unexpected payload %s", body)
+	}
+}
+
+func TestSend(t *testing.T) {
+	status := http.StatusOK
+	var received *http.Request
+	var receivedBody []byte
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		receivedBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	d := models.WebhookDelivery{
+		ID:           3,
+		Event:        EventBlockCreated,
+		Payload:      `{"event":"block.created"}`,
+		Subscription: models.WebhookSubscription{URL: srv.URL, Secret: "secret"},
+	}
+
+	code, err := Send(d)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("expected the delivery to succeed, got %d %v", code, err)
+	}
+	if received.Header.Get(EventHeader) != EventBlockCreated || received.Header.Get(DeliveryHeader) != "3" {
+		t.Errorf("unexpected headers %v", received.Header)
+	}
+	if !Verify("secret", receivedBody, received.Header.Get(SignatureHeader)) {
+		t.Error("expected the delivery to be signed")
+	}
+
+	status = http.StatusInternalServerError
+	code, err = Send(d)
+	if err == nil || code != http.StatusInternalServerError {
+		t.Errorf("expected a failed delivery with the status of the endpoint, got %d %v", code, err)
+	}
+
+	srv.Close()
+	code, err = Send(d)
+	if err == nil || code != 0 {
+		t.Errorf("expected a failed delivery to an unreachable endpoint, got %d %v", code, err)
+	}
+}
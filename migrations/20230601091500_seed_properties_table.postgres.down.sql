@@ -0,0 +1,3 @@
+delete from user_properties;
+update rooms set property_id = null;
+delete from properties;
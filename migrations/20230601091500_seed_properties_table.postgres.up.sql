@@ -0,0 +1,12 @@
+INSERT INTO public.properties (name,slug,hostname,email,sender_email,notification_email,timezone,tagline,description,address,created_at,updated_at) VALUES
+	 ('Golden Tavern','golden-tavern','localhost','guild_supporter@mag.com','reservationservice@sc.com','owner@sc.com','UTC','A Mysterious Place for Rookies!',
+	 'Here you can find friends, enemies, and advantures!
+Don''t forget to bring enough coins!
+The best and the only tavern in Kazery!',
+	 'Imaginary Island
+Mythril Forest, Rookie Camp
+Kazery','2023-06-01 00:00:00.000','2023-06-01 00:00:00.000');
+UPDATE public.rooms SET property_id = (SELECT id FROM public.properties WHERE slug = 'golden-tavern');
+INSERT INTO public.user_properties (user_id,property_id,created_at,updated_at)
+	SELECT u.id, p.id, '2023-06-01 00:00:00.000', '2023-06-01 00:00:00.000' FROM public.users u, public.properties p
+	WHERE p.slug = 'golden-tavern' AND u.access_level = 3;
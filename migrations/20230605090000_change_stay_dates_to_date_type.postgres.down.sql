@@ -0,0 +1,4 @@
+ALTER TABLE public.reservations ALTER COLUMN start_date TYPE varchar(255) USING to_char(start_date, 'YYYY-MM-DD');
+ALTER TABLE public.reservations ALTER COLUMN end_date TYPE varchar(255) USING to_char(end_date, 'YYYY-MM-DD');
+ALTER TABLE public.room_restrictions ALTER COLUMN start_date TYPE varchar(255) USING to_char(start_date, 'YYYY-MM-DD');
+ALTER TABLE public.room_restrictions ALTER COLUMN end_date TYPE varchar(255) USING to_char(end_date, 'YYYY-MM-DD');
@@ -0,0 +1,4 @@
+ALTER TABLE public.reservations ALTER COLUMN start_date TYPE date USING start_date::date;
+ALTER TABLE public.reservations ALTER COLUMN end_date TYPE date USING end_date::date;
+ALTER TABLE public.room_restrictions ALTER COLUMN start_date TYPE date USING start_date::date;
+ALTER TABLE public.room_restrictions ALTER COLUMN end_date TYPE date USING end_date::date;
@@ -0,0 +1 @@
+update rooms set price = 0, max_occupancy = 2, included_guests = 2, extra_guest_price = 0;
@@ -0,0 +1,2 @@
+UPDATE public.rooms SET price = 8900, max_occupancy = 2, included_guests = 2, extra_guest_price = 0 WHERE room_name = 'Secret HQ';
+UPDATE public.rooms SET price = 12000, max_occupancy = 4, included_guests = 2, extra_guest_price = 1500 WHERE room_name = 'New World';
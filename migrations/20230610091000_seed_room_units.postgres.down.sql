@@ -0,0 +1,2 @@
+update room_restrictions set room_unit_id = null;
+delete from room_units;
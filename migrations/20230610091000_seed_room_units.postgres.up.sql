@@ -0,0 +1,3 @@
+INSERT INTO public.room_units (room_id,name,created_at,updated_at)
+	SELECT r.id, r.room_name || ' 1', '2023-06-10 00:00:00.000', '2023-06-10 00:00:00.000' FROM public.rooms r;
+UPDATE public.room_restrictions rr SET room_unit_id = (SELECT min(u.id) FROM public.room_units u WHERE u.room_id = rr.room_id);